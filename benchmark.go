@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//	Licensed under the Apache License, Version 2.0 (the "License"); you may
+//	not use this file except in compliance with the License. You may obtain
+//	a copy of the License at
+//
+//	     http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//	WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//	License for the specific language governing permissions and limitations
+//	under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+)
+
+// runBenchmark launches instances as fast as the configured concurrency
+// allows and writes a JSON report of the throughput and latencies to stdout.
+func runBenchmark(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	image := flags.String("image", "", "the image to launch; may be an SSM reference")
+	flavor := flags.String("flavor", "t3.nano", "the instance type to launch")
+	count := flags.Int("count", 10, "the number of instances to launch")
+	concurrency := flags.Int("concurrency", 5, "the number of launches in flight at any time")
+	live := flags.Bool("live", false, "launch real instances and terminate them afterwards, instead of dry run launches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+	if *image == "" {
+		return fmt.Errorf("missing -image")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	awsCli, err := client.NewAwsCli(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS CLI: %w", err)
+	}
+
+	report, benchErr := awsCli.Benchmark(ctx, client.BenchmarkOptions{
+		Count:       *count,
+		Concurrency: *concurrency,
+		Image:       *image,
+		Flavor:      *flavor,
+		Live:        *live,
+	})
+	// An interrupted run still has a useful partial report.
+	if report.Requested > 0 {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	if benchErr != nil {
+		return fmt.Errorf("failed to run benchmark: %w", benchErr)
+	}
+	return nil
+}
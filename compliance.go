@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//	Licensed under the Apache License, Version 2.0 (the "License"); you may
+//	not use this file except in compliance with the License. You may obtain
+//	a copy of the License at
+//
+//	     http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//	WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//	License for the specific language governing permissions and limitations
+//	under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+)
+
+// runCompliance checks all instances of a controller against the compliance
+// policy in the config and writes a JSON report to stdout.
+func runCompliance(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("compliance", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	controllerID := flags.String("controller-id", "", "the ID of the GARM controller whose instances are checked")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+	if *controllerID == "" {
+		return fmt.Errorf("missing -controller-id")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	awsCli, err := client.NewAwsCli(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS CLI: %w", err)
+	}
+
+	report, err := awsCli.CheckCompliance(ctx, *controllerID)
+	if err != nil {
+		return fmt.Errorf("failed to check compliance: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -17,12 +17,27 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type AWSCredentialType string
@@ -30,6 +45,34 @@ type AWSCredentialType string
 const (
 	AWSCredentialTypeStatic AWSCredentialType = "static"
 	AWSCredentialTypeRole   AWSCredentialType = "role"
+	// AWSCredentialTypeRolesAnywhere gets temporary credentials through IAM
+	// Roles Anywhere, using an X.509 certificate.
+	AWSCredentialTypeRolesAnywhere AWSCredentialType = "roles_anywhere"
+	// AWSCredentialTypeProfile loads a profile from the shared config and
+	// credentials files, as used by the AWS CLI.
+	AWSCredentialTypeProfile AWSCredentialType = "profile"
+	// AWSCredentialTypeInstanceRole only uses the role of the EC2 instance
+	// GARM runs on, as served by the instance metadata service.
+	AWSCredentialTypeInstanceRole AWSCredentialType = "instance_role"
+)
+
+// NameResolution is the strategy used to find the instance GARM refers to by
+// name.
+type NameResolution string
+
+const (
+	// NameResolutionTags finds instances by their Name tag alone.
+	NameResolutionTags NameResolution = "tags"
+	// NameResolutionController finds instances by their Name tag among the
+	// instances of the controller. This is the default.
+	NameResolutionController NameResolution = "controller"
+	// NameResolutionStateFile looks up the instance ID recorded in the state
+	// directory when the instance was created, and falls back to
+	// NameResolutionController for instances it has no record of.
+	NameResolutionStateFile NameResolution = "state_file"
+	// NameResolutionStrict only uses the state directory, and never
+	// resolves names through tags.
+	NameResolutionStrict NameResolution = "strict"
 )
 
 // NewConfig returns a new Config
@@ -47,25 +90,657 @@ func NewConfig(cfgFile string) (*Config, error) {
 
 type Config struct {
 	Credentials Credentials `toml:"credentials"`
-	SubnetID    string      `toml:"subnet_id"`
-	Region      string      `toml:"region"`
+	// SubnetID is the default subnet in which instances are created. Values
+	// prefixed with "ssm:" are read from SSM Parameter Store at create time.
+	SubnetID string `toml:"subnet_id"`
+	// FallbackSubnetIDs are tried in order if EC2 reports insufficient
+	// capacity in SubnetID, or doesn't offer the instance type in its
+	// availability zone. Spreading them across availability zones keeps
+	// pools usable when a single zone runs out of capacity.
+	FallbackSubnetIDs []string `toml:"fallback_subnet_ids"`
+	// SecurityGroupIDs is the default list of security groups attached to
+	// new instances. Like SubnetID, entries may be SSM parameter references.
+	SecurityGroupIDs []string `toml:"security_group_ids"`
+	Region           string   `toml:"region"`
+	// AllowUnknownRegions accepts regions that the AWS SDK the provider was
+	// built with doesn't know about, as long as they follow the naming
+	// scheme of a partition, so that new regions can be used before the
+	// provider is updated.
+	AllowUnknownRegions bool `toml:"allow_unknown_regions"`
+	// EstimateCost enables looking up the on-demand price of the instance
+	// type via the AWS Pricing API. The price is attached to new instances
+	// as an EstimatedHourlyCost tag.
+	EstimateCost bool `toml:"estimate_cost"`
+	// HibernateOnStop makes the provider hibernate, rather than stop,
+	// instances that were launched with hibernation configured. Hibernated
+	// instances resume with their memory intact, which is considerably
+	// faster than a cold boot, while only EBS storage is billed.
+	HibernateOnStop bool `toml:"hibernate_on_stop"`
+	// DisableTerminationProtection makes the provider turn off termination
+	// protection of instances GARM deletes, when it prevents terminating
+	// them.
+	DisableTerminationProtection bool `toml:"disable_termination_protection"`
+	// TerminateDuplicates makes deleting an instance by name terminate every
+	// instance of the controller with that name, instead of failing when
+	// there is more than one.
+	TerminateDuplicates bool `toml:"terminate_duplicates"`
+	// PreflightDryRun makes the provider launch every instance with DryRun
+	// set first, so that missing permissions fail the create with a
+	// distinct error before anything is launched.
+	PreflightDryRun bool `toml:"preflight_dry_run"`
+	// EnableSerialConsoleAccess allows the provider to turn on EC2 serial
+	// console access for the account in the region, when a pool with
+	// serial_console needs it. Access is an account wide setting.
+	EnableSerialConsoleAccess bool `toml:"enable_serial_console_access"`
+	// DeletionGracePeriod defers the termination of instances GARM deletes
+	// by this long, as a Go duration string, so that accidental scale
+	// downs can be undone. Instances are terminated right away if unset.
+	DeletionGracePeriod string `toml:"deletion_grace_period"`
+	// ReapOnList makes ListInstances terminate the instances whose
+	// keep_on_failure TTL or deletion grace period ran out, or that
+	// exceeded their max_runtime. Otherwise only the gc command does.
+	ReapOnList bool `toml:"reap_on_list"`
+	// InstanceMetadataTags exposes the tags of new instances, including the
+	// GARM metadata tags, through the instance metadata service, so that
+	// scripts on the runner can read them from a single source of truth.
+	InstanceMetadataTags bool `toml:"instance_metadata_tags"`
+	// AuditLogFile is the path of a file to which a JSON line is appended
+	// for every instance the provider starts, stops or terminates.
+	AuditLogFile string `toml:"audit_log_file"`
+	// LifecycleWebhook is an optional endpoint that is notified whenever
+	// the provider creates or terminates an instance.
+	LifecycleWebhook LifecycleWebhook `toml:"lifecycle_webhook"`
+	// PrivateOnly asserts that the VPC instances are created in has no
+	// internet access. Instances are only created if the VPC has endpoints
+	// for the AWS services runners need, and user data that relies on
+	// public package mirrors is not generated.
+	PrivateOnly bool `toml:"private_only"`
+	// NameResolution is how instance names are resolved to instances.
+	// Defaults to NameResolutionController.
+	NameResolution NameResolution `toml:"name_resolution"`
+	// StateDir is the directory in which the ID of every instance created
+	// by the provider is recorded. Required by the state_file and strict
+	// name resolution strategies.
+	StateDir string `toml:"state_dir"`
+	// SSHKeyDir is the directory the private keys of ephemeral key pairs
+	// are written to. If unset, private keys are discarded.
+	SSHKeyDir string `toml:"ssh_key_dir"`
+	// CheckCallbackReachability makes the provider check, before creating
+	// an instance, that the route table of its subnet has a usable route
+	// to the GARM callback and metadata URLs.
+	CheckCallbackReachability bool `toml:"check_callback_reachability"`
+	// Compliance holds the policy the compliance subcommand checks the
+	// fleet against.
+	Compliance Compliance `toml:"compliance"`
+	// CreateSpreading spaces out the launches of instances that GARM
+	// creates in a burst.
+	CreateSpreading CreateSpreading `toml:"create_spreading"`
+	// ImageCacheFile is the path of a file in which the images that SSM
+	// parameter references resolve to are remembered per pool. This keeps
+	// the resolved image stable for ImageCacheTTL and makes it possible to
+	// notice when a pool rolls to a new image.
+	ImageCacheFile string `toml:"image_cache_file"`
+	// ImageCacheTTL is how long a resolved image is reused, as a Go
+	// duration string. Defaults to 1h.
+	ImageCacheTTL string `toml:"image_cache_ttl"`
+	// RequiredRequestTags are the tag keys every launch request must set,
+	// usually because IAM policies require them through aws:RequestTag
+	// conditions.
+	RequiredRequestTags []string `toml:"required_request_tags"`
+	// Tags are set on every instance the provider creates, as well as on
+	// its volumes and network interfaces, whatever the pool. Tags the
+	// provider sets itself can't be overridden.
+	Tags map[string]string `toml:"tags"`
+	// ImageAliases maps image alias names to the image to use in each
+	// region. Pools may use an alias as their image. Images may be AMI IDs
+	// or SSM references.
+	ImageAliases map[string]map[string]string `toml:"image_aliases"`
+	// Substitutions maps partition IDs, like "aws-cn", to the rewrites
+	// applied to tool download URLs and images of instances created in
+	// that partition.
+	Substitutions map[string]Substitutions `toml:"substitutions"`
+	// EntityQuotas limit the number of instances that may exist at the
+	// same time for a GitHub entity, so that a single busy repository
+	// can't use up a pool shared by many.
+	EntityQuotas []EntityQuota `toml:"entity_quotas"`
+	// UserDataOffload moves user data that is too large for EC2 to an S3
+	// bucket, from which the instance downloads it at boot.
+	UserDataOffload UserDataOffload `toml:"user_data_offload"`
+	// BootstrapScripts are run on every instance, whatever the pool, for
+	// example to set up a proxy or install a monitoring agent.
+	BootstrapScripts BootstrapScripts `toml:"bootstrap_scripts"`
+	// CABundle is the path of a PEM file with certificates of CAs that are
+	// trusted when calling AWS, in addition to the CAs of the system.
+	CABundle string `toml:"ca_bundle"`
+	// ProxyURL is the proxy AWS is called through. Defaults to the proxy
+	// set in the environment, if any.
+	ProxyURL string `toml:"proxy_url"`
+	// ConnectTimeout is how long connecting to AWS may take, as a Go
+	// duration string. Defaults to 30s.
+	ConnectTimeout string `toml:"connect_timeout"`
+	// RequestTimeout is how long a single attempt of a call to AWS may
+	// take, including reading the response, as a Go duration string.
+	// Attempts don't time out if unset.
+	RequestTimeout string `toml:"request_timeout"`
+	// Retry configures how calls to AWS are retried.
+	Retry Retry `toml:"retry"`
+	// Logging configures the logs written to stderr.
+	Logging Logging `toml:"logging"`
+	// Metrics configures where operational metrics are emitted.
+	Metrics Metrics `toml:"metrics"`
+	// EndpointURL overrides the endpoint of every AWS service the provider
+	// calls, for example to test against LocalStack.
+	EndpointURL string `toml:"endpoint_url"`
+	// EndpointURLs override the endpoint of individual services, keyed by
+	// service (ec2, sts, ssm, s3 or pricing), for example to use the DNS
+	// names of VPC interface endpoints. They take precedence over
+	// EndpointURL.
+	EndpointURLs map[string]string `toml:"endpoint_urls"`
+	// Environments are named sets of region, subnets and credentials that
+	// pools can select, so that a single provider can create instances in
+	// several regions or accounts.
+	Environments map[string]Environment `toml:"environment"`
+	// WaitForRunning makes CreateInstance wait, for up to this long, until
+	// new instances are running, as a Go duration string. Instances that
+	// aren't running in time fail to be created. CreateInstance returns as
+	// soon as the instance is launched if unset.
+	WaitForRunning string `toml:"wait_for_running"`
+	// WaitForStopped makes Stop wait, for up to this long, until the
+	// instance is stopped, as a Go duration string.
+	WaitForStopped string `toml:"wait_for_stopped"`
+	// WaitForTerminated makes DeleteInstance wait, for up to this long,
+	// until the instance is terminated, as a Go duration string.
+	WaitForTerminated string `toml:"wait_for_terminated"`
+	// DefaultExtraSpecs are extra specs applied to every pool. Extra specs
+	// of the pool take precedence over them, key by key.
+	DefaultExtraSpecs map[string]any `toml:"default_extra_specs"`
+}
+
+// BootstrapScripts holds the scripts run before and after the runner is
+// installed, per OS type.
+type BootstrapScripts struct {
+	Linux   OSBootstrapScripts `toml:"linux"`
+	Windows OSBootstrapScripts `toml:"windows"`
+}
+
+// OSBootstrapScripts are the bootstrap scripts of one OS type.
+type OSBootstrapScripts struct {
+	// Pre runs before the runner is installed. On Linux, it runs along
+	// with the pre_install_scripts of the pool, and needs a shebang.
+	Pre string `toml:"pre"`
+	// Post runs after the runner is installed. On Linux, it needs a
+	// shebang.
+	Post string `toml:"post"`
+}
+
+func (s BootstrapScripts) Validate() error {
+	for _, script := range []struct {
+		name, value string
+	}{
+		{"linux.pre", s.Linux.Pre},
+		{"linux.post", s.Linux.Post},
+	} {
+		// Scripts are run as executables.
+		if script.value != "" && !strings.HasPrefix(script.value, "#!") {
+			return fmt.Errorf("%s must start with a shebang, like #!/bin/sh", script.name)
+		}
+	}
+	return nil
+}
+
+// UserDataOffload configures the S3 bucket that user data over the EC2 limit
+// is uploaded to. Instances get a small bootstrap script instead, which
+// downloads the user data with the credentials of their instance profile.
+type UserDataOffload struct {
+	// Bucket is the name of the S3 bucket. Offloading is disabled if it is
+	// empty.
+	Bucket string `toml:"bucket"`
+	// Prefix is prepended to the keys of the uploaded objects.
+	Prefix string `toml:"prefix"`
+	// InstanceProfile is the name or ARN of the instance profile attached
+	// to instances whose user data is offloaded. It must allow
+	// s3:GetObject on the uploaded objects.
+	InstanceProfile string `toml:"instance_profile"`
+}
+
+func (o UserDataOffload) Validate() error {
+	if o.Bucket == "" {
+		if o.Prefix != "" || o.InstanceProfile != "" {
+			return fmt.Errorf("missing bucket")
+		}
+		return nil
+	}
+	if len(o.Bucket) < 3 || len(o.Bucket) > 63 || strings.Trim(o.Bucket, "abcdefghijklmnopqrstuvwxyz0123456789.-") != "" {
+		return fmt.Errorf("invalid bucket name %q", o.Bucket)
+	}
+	if strings.HasPrefix(o.Prefix, "/") {
+		return fmt.Errorf("prefix must not start with /")
+	}
+	if o.InstanceProfile == "" {
+		return fmt.Errorf("missing instance_profile")
+	}
+	return nil
+}
+
+// Enabled returns true if user data may be offloaded to S3.
+func (o UserDataOffload) Enabled() bool {
+	return o.Bucket != ""
 }
 
+// EntityQuota limits the number of instances of the GitHub entities that
+// match Entity.
+type EntityQuota struct {
+	// Entity is the path of a repository ("owner/repo"), organization
+	// ("owner") or enterprise ("enterprises/name"), and may contain
+	// wildcards as supported by path.Match. Matching is case insensitive.
+	Entity string `toml:"entity"`
+	// MaxInstances is the number of instances each matching entity may
+	// have.
+	MaxInstances int `toml:"max_instances"`
+}
+
+func (q EntityQuota) Validate() error {
+	if q.Entity == "" {
+		return fmt.Errorf("missing entity")
+	}
+	if _, err := path.Match(q.Entity, ""); err != nil {
+		return fmt.Errorf("invalid entity %q: %w", q.Entity, err)
+	}
+	if q.MaxInstances <= 0 {
+		return fmt.Errorf("max_instances of %s must be positive", q.Entity)
+	}
+	return nil
+}
+
+// GetEntityQuota returns the number of instances entity may have, from the
+// first entity quota that matches it. It returns false if no quota applies.
+func (c *Config) GetEntityQuota(entity string) (int, bool) {
+	if entity == "" {
+		return 0, false
+	}
+	for _, quota := range c.EntityQuotas {
+		// Validated when loading the config.
+		if ok, _ := path.Match(strings.ToLower(quota.Entity), entity); ok {
+			return quota.MaxInstances, true
+		}
+	}
+	return 0, false
+}
+
+// DefaultImageCacheTTL is used when image_cache_ttl is not set.
+const DefaultImageCacheTTL = time.Hour
+
+// GetDeletionGracePeriod returns the configured deletion grace period, or
+// zero if termination isn't deferred.
+func (c *Config) GetDeletionGracePeriod() time.Duration {
+	// Validated when loading the config.
+	grace, _ := time.ParseDuration(c.DeletionGracePeriod)
+	return grace
+}
+
+// GetImageCacheTTL returns the configured image cache TTL, or the default.
+func (c *Config) GetImageCacheTTL() time.Duration {
+	if c.ImageCacheTTL == "" {
+		return DefaultImageCacheTTL
+	}
+	// Validated when loading the config.
+	ttl, _ := time.ParseDuration(c.ImageCacheTTL)
+	return ttl
+}
+
+// Validate checks the whole config and returns all the problems it finds,
+// rather than only the first one.
 func (c *Config) Validate() error {
-	if err := c.Credentials.Validate(); err != nil {
-		return fmt.Errorf("failed to validate credentials: %w", err)
+	var errs []error
+	if err := c.validateCredentials(c.Credentials); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate credentials: %w", err))
 	}
 
 	if c.SubnetID == "" {
-		return fmt.Errorf("missing subnet_id")
+		errs = append(errs, fmt.Errorf("missing subnet_id"))
 	}
 
 	if c.Region == "" {
-		return fmt.Errorf("missing region")
+		errs = append(errs, fmt.Errorf("missing region"))
+	} else if err := ValidateRegion(c.Region, c.AllowUnknownRegions); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateResourceIDs(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.ImageCacheTTL != "" {
+		ttl, err := time.ParseDuration(c.ImageCacheTTL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid image_cache_ttl: %w", err))
+		} else if ttl < 0 {
+			errs = append(errs, fmt.Errorf("image_cache_ttl must not be negative"))
+		}
+	}
+
+	if c.DeletionGracePeriod != "" {
+		grace, err := time.ParseDuration(c.DeletionGracePeriod)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid deletion_grace_period: %w", err))
+		} else if grace < 0 {
+			errs = append(errs, fmt.Errorf("deletion_grace_period must not be negative"))
+		}
+	}
+
+	if slices.ContainsFunc(c.RequiredRequestTags, func(key string) bool { return strings.TrimSpace(key) == "" }) {
+		errs = append(errs, fmt.Errorf("required_request_tags must not contain empty keys"))
+	}
+
+	for _, validate := range []func() error{
+		c.validateTags,
+		c.validateImageAliases,
+		c.validateSubstitutions,
+		c.validateEndpointURLs,
+		c.validateTransport,
+		c.validateEnvironments,
+		c.validateWaiters,
+	} {
+		if err := validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate retry: %w", err))
+	}
+
+	if err := c.Logging.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate logging: %w", err))
+	}
+
+	if err := c.Metrics.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate metrics: %w", err))
+	}
+
+	for _, quota := range c.EntityQuotas {
+		if err := quota.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to validate entity_quotas: %w", err))
+		}
+	}
+
+	switch c.NameResolution {
+	case "", NameResolutionTags, NameResolutionController:
+	case NameResolutionStateFile, NameResolutionStrict:
+		if c.StateDir == "" {
+			errs = append(errs, fmt.Errorf("name_resolution %s requires state_dir", c.NameResolution))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown name_resolution: %s", c.NameResolution))
+	}
+
+	if err := c.LifecycleWebhook.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate lifecycle_webhook: %w", err))
+	}
+
+	if err := c.CreateSpreading.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate create_spreading: %w", err))
+	}
+
+	if err := c.BootstrapScripts.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate bootstrap_scripts: %w", err))
+	}
+
+	if err := c.UserDataOffload.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to validate user_data_offload: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	// SubnetIDPattern matches the IDs of subnets, in both the legacy 8 and
+	// the current 17 hex digit forms. AWS only issues lower case IDs.
+	SubnetIDPattern = `^subnet-([0-9a-f]{8}|[0-9a-f]{17})$`
+	// SubnetRefPattern matches the values accepted wherever a subnet can be
+	// set: a subnet ID, or a reference to an SSM parameter holding one.
+	SubnetRefPattern = `^(subnet-([0-9a-f]{8}|[0-9a-f]{17})|ssm:.+)$`
+)
+
+var (
+	subnetIDRe        = regexp.MustCompile(SubnetIDPattern)
+	securityGroupIDRe = regexp.MustCompile(`^sg-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	// roleARNRe matches the ARNs of IAM roles, in any partition.
+	roleARNRe = regexp.MustCompile(`^arn:aws(-[a-z]+)*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+	// rolesAnywhereARNRe matches the ARNs of Roles Anywhere resources of
+	// the given type, in any partition.
+	rolesAnywhereARNRe = regexp.MustCompile(`^arn:aws(-[a-z]+)*:rolesanywhere:[a-z0-9-]+:\d{12}:(trust-anchor|profile)/[\w-]+$`)
+)
+
+// validateResourceIDs checks the format of the IDs of the subnets and
+// security groups, so that typos are reported before the first launch.
+// SSM parameter references are resolved at create time, and are not checked.
+func (c *Config) validateResourceIDs() error {
+	var errs []error
+	for _, subnet := range slices.Concat([]string{c.SubnetID}, c.FallbackSubnetIDs) {
+		if subnet != "" && !strings.HasPrefix(subnet, "ssm:") && !subnetIDRe.MatchString(subnet) {
+			errs = append(errs, fmt.Errorf("invalid subnet ID %q: must look like subnet-0123456789abcdef0", subnet))
+		}
+	}
+	for _, group := range c.SecurityGroupIDs {
+		if !strings.HasPrefix(group, "ssm:") && !securityGroupIDRe.MatchString(group) {
+			errs = append(errs, fmt.Errorf("invalid security group ID %q: must look like sg-0123456789abcdef0", group))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sortedKeys returns the keys of m in order, to report problems
+// deterministically.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// isProviderTag returns true for the tags the provider sets on instances.
+func isProviderTag(key string) bool {
+	return slices.Contains(DefaultRequiredTags, key) || key == "EstimatedHourlyCost" ||
+		strings.HasPrefix(key, "GARM_") || strings.HasPrefix(key, "garm:")
+}
+
+func (c *Config) validateTags() error {
+	for _, key := range sortedKeys(c.Tags) {
+		switch {
+		case strings.TrimSpace(key) == "":
+			return fmt.Errorf("tags must not contain empty keys")
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			return fmt.Errorf("invalid tag %s: the aws: prefix is reserved", key)
+		case isProviderTag(key):
+			return fmt.Errorf("invalid tag %s: the tag is set by the provider", key)
+		case len(key) > 128:
+			return fmt.Errorf("invalid tag %s: keys can't be longer than 128 characters", key)
+		case len(c.Tags[key]) > 256:
+			return fmt.Errorf("invalid tag %s: values can't be longer than 256 characters", key)
+		}
 	}
 	return nil
 }
 
+func (c *Config) validateImageAliases() error {
+	for _, alias := range sortedKeys(c.ImageAliases) {
+		if strings.HasPrefix(alias, "ami-") || strings.HasPrefix(alias, "ssm:") {
+			return fmt.Errorf("invalid image alias %s: aliases can't look like AMI IDs or SSM references", alias)
+		}
+		regions := c.ImageAliases[alias]
+		for _, region := range sortedKeys(regions) {
+			if err := ValidateRegion(region, c.AllowUnknownRegions); err != nil {
+				return fmt.Errorf("invalid region for image alias %s: %w", alias, err)
+			}
+			image := regions[region]
+			if !strings.HasPrefix(image, "ami-") && !strings.HasPrefix(image, "ssm:") {
+				return fmt.Errorf("invalid image %q for image alias %s in %s: must be an AMI ID or an SSM reference", image, alias, region)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveImageAlias returns the image the alias maps to in the configured
+// region. Images that are not aliases are returned unchanged.
+func (c *Config) ResolveImageAlias(image string) (string, error) {
+	regions, ok := c.ImageAliases[image]
+	if !ok {
+		return image, nil
+	}
+	resolved, ok := regions[c.Region]
+	if !ok {
+		return "", fmt.Errorf("image alias %s has no image for region %s", image, c.Region)
+	}
+	return resolved, nil
+}
+
+// GetNameResolution returns the configured name resolution strategy, or the
+// default.
+func (c *Config) GetNameResolution() NameResolution {
+	if c.NameResolution == "" {
+		return NameResolutionController
+	}
+	return c.NameResolution
+}
+
+// DefaultRequiredTags are the tags the provider sets on every instance it
+// creates.
+var DefaultRequiredTags = []string{"Name", "GARM_POOL_ID", "GARM_CONTROLLER_ID", "OSType", "OSArch"}
+
+type Compliance struct {
+	// RequiredTags are the tag keys every instance must have. Defaults to
+	// DefaultRequiredTags.
+	RequiredTags []string `toml:"required_tags"`
+	// ApprovedImageOwners are the AWS account IDs (or aliases like
+	// "amazon") allowed to own the images instances are launched from.
+	// Image ownership is not checked if this is empty.
+	ApprovedImageOwners []string `toml:"approved_image_owners"`
+}
+
+// GetRequiredTags returns the configured required tags, or the default.
+func (c Compliance) GetRequiredTags() []string {
+	if len(c.RequiredTags) == 0 {
+		return DefaultRequiredTags
+	}
+	return c.RequiredTags
+}
+
+// DefaultCreateSpreadingMaxDelay is used when max_delay is not set.
+const DefaultCreateSpreadingMaxDelay = 2 * time.Minute
+
+type CreateSpreading struct {
+	// Spacing is the time reserved for every launch, as a Go duration.
+	// Concurrent creates take turns, so at most one instance is launched
+	// per Spacing. Requires SlotDir.
+	Spacing string `toml:"spacing"`
+	// Jitter is the longest random delay added before every launch, as a
+	// Go duration.
+	Jitter string `toml:"jitter"`
+	// SlotDir is the directory in which concurrent provider processes
+	// reserve their turn.
+	SlotDir string `toml:"slot_dir"`
+	// MaxDelay is the longest a create waits for its turn, as a Go
+	// duration. Defaults to DefaultCreateSpreadingMaxDelay.
+	MaxDelay string `toml:"max_delay"`
+}
+
+func (s CreateSpreading) Validate() error {
+	for _, d := range []struct {
+		name, value string
+	}{
+		{"spacing", s.Spacing},
+		{"jitter", s.Jitter},
+		{"max_delay", s.MaxDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		if duration < 0 {
+			return fmt.Errorf("%s must not be negative", d.name)
+		}
+	}
+
+	if s.Spacing != "" && s.SlotDir == "" {
+		return fmt.Errorf("spacing requires slot_dir")
+	}
+	return nil
+}
+
+// GetSpacing returns the configured spacing, or 0 if launches are not
+// spaced out.
+func (s CreateSpreading) GetSpacing() time.Duration {
+	// Validated when loading the config.
+	spacing, _ := time.ParseDuration(s.Spacing)
+	return spacing
+}
+
+// GetJitter returns the configured jitter, or 0.
+func (s CreateSpreading) GetJitter() time.Duration {
+	jitter, _ := time.ParseDuration(s.Jitter)
+	return jitter
+}
+
+// GetMaxDelay returns the configured max delay, or the default.
+func (s CreateSpreading) GetMaxDelay() time.Duration {
+	if s.MaxDelay == "" {
+		return DefaultCreateSpreadingMaxDelay
+	}
+	maxDelay, _ := time.ParseDuration(s.MaxDelay)
+	return maxDelay
+}
+
+// DefaultWebhookMaxRetries is the number of times a failed lifecycle webhook
+// call is retried when max_retries is not set.
+const DefaultWebhookMaxRetries = 3
+
+type LifecycleWebhook struct {
+	// URL is the endpoint events are POSTed to. Leaving it empty disables
+	// the webhook.
+	URL string `toml:"url"`
+	// Secret is the key used to sign the request body with HMAC-SHA256.
+	Secret string `toml:"secret"`
+	// MaxRetries is the number of times a failed call is retried.
+	MaxRetries *int `toml:"max_retries"`
+}
+
+func (w LifecycleWebhook) Validate() error {
+	if w.URL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q: must be an absolute http or https URL", w.URL)
+	}
+
+	if w.Secret == "" {
+		return fmt.Errorf("missing secret")
+	}
+
+	if w.MaxRetries != nil && *w.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	return nil
+}
+
+// GetMaxRetries returns the configured number of retries, or the default.
+func (w LifecycleWebhook) GetMaxRetries() int {
+	if w.MaxRetries == nil {
+		return DefaultWebhookMaxRetries
+	}
+	return *w.MaxRetries
+}
+
 type StaticCredentials struct {
 	// AWS Access key ID
 	AccessKeyID string `toml:"access_key_id"`
@@ -75,29 +750,385 @@ type StaticCredentials struct {
 
 	// AWS Session Token
 	SessionToken string `toml:"session_token"`
+
+	// Files the secrets above are read from instead, for example when
+	// they are mounted from a Kubernetes secret or passed as systemd
+	// credentials. Surrounding whitespace is ignored.
+	AccessKeyIDFile     string `toml:"access_key_id_file"`
+	SecretAccessKeyFile string `toml:"secret_access_key_file"`
+	SessionTokenFile    string `toml:"session_token_file"`
 }
 
 func (c StaticCredentials) Validate() error {
-	if c.AccessKeyID == "" {
-		return fmt.Errorf("missing access_key_id")
+	for _, secret := range []struct {
+		name, value, file string
+		required          bool
+	}{
+		{"access_key_id", c.AccessKeyID, c.AccessKeyIDFile, true},
+		{"secret_access_key", c.SecretAccessKey, c.SecretAccessKeyFile, true},
+		{"session_token", c.SessionToken, c.SessionTokenFile, false},
+	} {
+		if secret.value != "" && secret.file != "" {
+			return fmt.Errorf("%[1]s and %[1]s_file are mutually exclusive", secret.name)
+		}
+		if secret.required && secret.value == "" && secret.file == "" {
+			return fmt.Errorf("missing %s", secret.name)
+		}
+		// The provider is run by GARM, so relative paths would depend on
+		// where GARM was started.
+		if secret.file != "" && !path.IsAbs(secret.file) {
+			return fmt.Errorf("%s_file %q is not an absolute path", secret.name, secret.file)
+		}
 	}
-	if c.SecretAccessKey == "" {
-		return fmt.Errorf("missing secret_access_key")
+
+	return nil
+}
+
+// readSecret returns value, or the content of file if it is set.
+func readSecret(name, value, file string) (string, error) {
+	if file == "" {
+		return value, nil
 	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_file: %w", name, err)
+	}
+	value = strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%s_file %s is empty", name, file)
+	}
+	return value, nil
+}
 
+// resolve returns the credentials with the secrets that are set as files
+// read from them.
+func (c StaticCredentials) resolve() (StaticCredentials, error) {
+	var resolved StaticCredentials
+	var err error
+	if resolved.AccessKeyID, err = readSecret("access_key_id", c.AccessKeyID, c.AccessKeyIDFile); err != nil {
+		return StaticCredentials{}, err
+	}
+	if resolved.SecretAccessKey, err = readSecret("secret_access_key", c.SecretAccessKey, c.SecretAccessKeyFile); err != nil {
+		return StaticCredentials{}, err
+	}
+	if resolved.SessionToken, err = readSecret("session_token", c.SessionToken, c.SessionTokenFile); err != nil {
+		return StaticCredentials{}, err
+	}
+	return resolved, nil
+}
+
+// DefaultSigningHelper is the name of the IAM Roles Anywhere credential
+// helper, looked up in PATH when signing_helper is not set.
+const DefaultSigningHelper = "aws_signing_helper"
+
+type RolesAnywhereCredentials struct {
+	// Certificate is the path to the PEM encoded X.509 certificate.
+	Certificate string `toml:"certificate"`
+	// PrivateKey is the path to the private key of the certificate.
+	PrivateKey string `toml:"private_key"`
+	// TrustAnchorARN is the ARN of the trust anchor that issued the
+	// certificate.
+	TrustAnchorARN string `toml:"trust_anchor_arn"`
+	// ProfileARN is the ARN of the Roles Anywhere profile.
+	ProfileARN string `toml:"profile_arn"`
+	// RoleARN is the ARN of the role to assume. The role must be part of
+	// the profile.
+	RoleARN string `toml:"role_arn"`
+	// SessionDuration is the lifetime of the credentials, as a Go duration
+	// string. Defaults to the lifetime set in the profile.
+	SessionDuration string `toml:"session_duration"`
+	// SigningHelper is the path to the aws_signing_helper binary.
+	SigningHelper string `toml:"signing_helper"`
+}
+
+func (c RolesAnywhereCredentials) Validate() error {
+	if c.Certificate == "" {
+		return fmt.Errorf("missing certificate")
+	}
+	if c.PrivateKey == "" {
+		return fmt.Errorf("missing private_key")
+	}
+
+	for _, arn := range []struct{ name, value string }{
+		{"trust_anchor_arn", c.TrustAnchorARN},
+		{"profile_arn", c.ProfileARN},
+		{"role_arn", c.RoleARN},
+	} {
+		if arn.value == "" {
+			return fmt.Errorf("missing %s", arn.name)
+		}
+		if !strings.HasPrefix(arn.value, "arn:") {
+			return fmt.Errorf("invalid %s %q: not an ARN", arn.name, arn.value)
+		}
+		if arn.name == "role_arn" && !roleARNRe.MatchString(arn.value) {
+			return fmt.Errorf("invalid role_arn %q: not a role ARN", arn.value)
+		}
+		if arn.name != "role_arn" && !rolesAnywhereARNRe.MatchString(arn.value) {
+			return fmt.Errorf("invalid %s %q: not a Roles Anywhere ARN", arn.name, arn.value)
+		}
+	}
+
+	if c.SessionDuration != "" {
+		// Roles Anywhere sessions last between 15 minutes and 12 hours.
+		duration, err := time.ParseDuration(c.SessionDuration)
+		if err != nil {
+			return fmt.Errorf("invalid session_duration: %w", err)
+		}
+		if duration < 15*time.Minute || duration > 12*time.Hour {
+			return fmt.Errorf("session_duration must be between 15m and 12h")
+		}
+	}
 	return nil
 }
 
+// CredentialProcessArgs returns the command line that makes the signing
+// helper print a set of temporary credentials, as expected from a
+// credential_process.
+func (c RolesAnywhereCredentials) CredentialProcessArgs() []string {
+	helper := c.SigningHelper
+	if helper == "" {
+		helper = DefaultSigningHelper
+	}
+
+	args := []string{
+		helper, "credential-process",
+		"--certificate", c.Certificate,
+		"--private-key", c.PrivateKey,
+		"--trust-anchor-arn", c.TrustAnchorARN,
+		"--profile-arn", c.ProfileARN,
+		"--role-arn", c.RoleARN,
+	}
+	if c.SessionDuration != "" {
+		// Validated when loading the config.
+		duration, _ := time.ParseDuration(c.SessionDuration)
+		args = append(args, "--session-duration", strconv.Itoa(int(duration.Seconds())))
+	}
+	return args
+}
+
+// ProfileCredentials selects a profile of the shared AWS config files.
+type ProfileCredentials struct {
+	// Name is the name of the profile.
+	Name string `toml:"name"`
+	// ConfigFiles are the shared config files the profile is looked up
+	// in. Defaults to ~/.aws/config.
+	ConfigFiles []string `toml:"config_files"`
+	// CredentialsFiles are the shared credentials files the profile is
+	// looked up in. Defaults to ~/.aws/credentials.
+	CredentialsFiles []string `toml:"credentials_files"`
+}
+
+func (c ProfileCredentials) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	// The provider is run by GARM, so relative paths would depend on
+	// where GARM was started.
+	for _, file := range slices.Concat(c.ConfigFiles, c.CredentialsFiles) {
+		if !path.IsAbs(file) {
+			return fmt.Errorf("shared config file %q is not an absolute path", file)
+		}
+	}
+	return nil
+}
+
+// loadOptions returns the options that make LoadDefaultConfig use the
+// profile.
+func (c ProfileCredentials) loadOptions() []func(*config.LoadOptions) error {
+	opts := []func(*config.LoadOptions) error{
+		config.WithSharedConfigProfile(c.Name),
+	}
+	if len(c.ConfigFiles) > 0 {
+		opts = append(opts, config.WithSharedConfigFiles(c.ConfigFiles))
+	}
+	if len(c.CredentialsFiles) > 0 {
+		opts = append(opts, config.WithSharedCredentialsFiles(c.CredentialsFiles))
+	}
+	return opts
+}
+
+// Endpoint modes of the instance metadata service.
+const (
+	IMDSEndpointModeIPv4 = "ipv4"
+	IMDSEndpointModeIPv6 = "ipv6"
+)
+
+// InstanceRoleCredentials configures how the credentials of the role of the
+// EC2 instance GARM runs on are fetched.
+type InstanceRoleCredentials struct {
+	// Endpoint is the URL of the instance metadata service. Overrides
+	// EndpointMode.
+	Endpoint string `toml:"endpoint"`
+	// EndpointMode selects the IPv4 or IPv6 endpoint of the instance
+	// metadata service. Defaults to ipv4.
+	EndpointMode string `toml:"endpoint_mode"`
+	// DisableIMDSv1Fallback makes fetching credentials fail if no IMDSv2
+	// session token can be had, instead of falling back to IMDSv1.
+	DisableIMDSv1Fallback bool `toml:"disable_imdsv1_fallback"`
+}
+
+func (c InstanceRoleCredentials) Validate() error {
+	if c.Endpoint != "" {
+		if err := validateEndpointURL(c.Endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint: %w", err)
+		}
+	}
+	switch c.EndpointMode {
+	case "", IMDSEndpointModeIPv4, IMDSEndpointModeIPv6:
+	default:
+		return fmt.Errorf("unknown endpoint_mode: %s", c.EndpointMode)
+	}
+	return nil
+}
+
+// provider returns the provider of the credentials of the instance role.
+func (c InstanceRoleCredentials) provider() aws.CredentialsProvider {
+	opts := imds.Options{
+		Endpoint: c.Endpoint,
+	}
+	switch c.EndpointMode {
+	case IMDSEndpointModeIPv4:
+		opts.EndpointMode = imds.EndpointModeStateIPv4
+	case IMDSEndpointModeIPv6:
+		opts.EndpointMode = imds.EndpointModeStateIPv6
+	}
+	if c.DisableIMDSv1Fallback {
+		opts.EnableFallback = aws.FalseTernary
+	}
+	return ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = imds.New(opts)
+	})
+}
+
+// DefaultAssumeRoleSessionName is the session name of assumed roles when
+// session_name is not set.
+const DefaultAssumeRoleSessionName = "garm-provider-aws"
+
+var (
+	sessionNameRe = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	externalIDRe  = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+)
+
+// AssumeRoleCredentials is a role assumed with the credentials of the
+// credential type, for example to create instances in another account.
+type AssumeRoleCredentials struct {
+	// RoleARN is the ARN of the role to assume.
+	RoleARN string `toml:"role_arn"`
+	// ExternalID is passed to AWS when assuming the role, if the trust
+	// policy of the role requires one.
+	ExternalID string `toml:"external_id"`
+	// SessionName identifies the session in CloudTrail. Defaults to
+	// garm-provider-aws.
+	SessionName string `toml:"session_name"`
+	// Duration is the lifetime of the credentials, as a Go duration
+	// string. Defaults to 15 minutes.
+	Duration string `toml:"duration"`
+	// STSRegion is the region of the STS endpoint the role is assumed
+	// through. Defaults to the region of the provider.
+	STSRegion string `toml:"sts_region"`
+}
+
+// Enabled returns true if a role to assume is configured.
+func (c AssumeRoleCredentials) Enabled() bool {
+	return c != AssumeRoleCredentials{}
+}
+
+func (c AssumeRoleCredentials) Validate() error {
+	if c.RoleARN == "" {
+		return fmt.Errorf("missing role_arn")
+	}
+	if !roleARNRe.MatchString(c.RoleARN) {
+		return fmt.Errorf("invalid role_arn %q: not a role ARN", c.RoleARN)
+	}
+	if c.ExternalID != "" && (len(c.ExternalID) < 2 || len(c.ExternalID) > 1224 || !externalIDRe.MatchString(c.ExternalID)) {
+		return fmt.Errorf("invalid external_id")
+	}
+	if c.SessionName != "" && !sessionNameRe.MatchString(c.SessionName) {
+		return fmt.Errorf("invalid session_name %q", c.SessionName)
+	}
+	if c.Duration != "" {
+		// Sessions last between 15 minutes and the maximum session
+		// duration of the role, which is at most 12 hours.
+		duration, err := time.ParseDuration(c.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		if duration < 15*time.Minute || duration > 12*time.Hour {
+			return fmt.Errorf("duration must be between 15m and 12h")
+		}
+	}
+	return nil
+}
+
+// provider returns the provider of the credentials of the role, assumed
+// with the given STS client.
+func (c AssumeRoleCredentials) provider(client stscreds.AssumeRoleAPIClient) aws.CredentialsProvider {
+	return stscreds.NewAssumeRoleProvider(client, c.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = c.SessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = DefaultAssumeRoleSessionName
+		}
+		if c.ExternalID != "" {
+			o.ExternalID = aws.String(c.ExternalID)
+		}
+		if c.Duration != "" {
+			// Validated when loading the config.
+			o.Duration, _ = time.ParseDuration(c.Duration)
+		}
+	})
+}
+
 type Credentials struct {
 	CredentialType    AWSCredentialType `toml:"credential_type"`
 	StaticCredentials StaticCredentials `toml:"static"`
+	// SecondaryStaticCredentials are used once AWS rejects the static
+	// credentials, for example because they were rotated.
+	SecondaryStaticCredentials StaticCredentials        `toml:"static_secondary"`
+	RolesAnywhereCredentials   RolesAnywhereCredentials `toml:"roles_anywhere"`
+	ProfileCredentials         ProfileCredentials       `toml:"profile"`
+	InstanceRoleCredentials    InstanceRoleCredentials  `toml:"instance_role"`
+	// AssumeRole is assumed with the credentials of the credential type,
+	// if set.
+	AssumeRole AssumeRoleCredentials `toml:"role"`
+}
+
+// HasSecondary returns true if secondary static credentials are configured.
+func (c Credentials) HasSecondary() bool {
+	return c.SecondaryStaticCredentials != StaticCredentials{}
 }
 
 func (c Credentials) Validate() error {
+	if c.HasSecondary() && c.CredentialType != AWSCredentialTypeStatic {
+		return fmt.Errorf("static_secondary credentials require the static credential type")
+	}
+
+	if c.AssumeRole.Enabled() {
+		if err := c.AssumeRole.Validate(); err != nil {
+			return fmt.Errorf("invalid role credentials: %w", err)
+		}
+	}
+
 	switch c.CredentialType {
 	case AWSCredentialTypeStatic:
-		return c.StaticCredentials.Validate()
+		if err := c.StaticCredentials.Validate(); err != nil {
+			return err
+		}
+		if c.HasSecondary() {
+			if err := c.SecondaryStaticCredentials.Validate(); err != nil {
+				return fmt.Errorf("invalid static_secondary credentials: %w", err)
+			}
+		}
 	case AWSCredentialTypeRole:
+	case AWSCredentialTypeRolesAnywhere:
+		return c.RolesAnywhereCredentials.Validate()
+	case AWSCredentialTypeProfile:
+		if err := c.ProfileCredentials.Validate(); err != nil {
+			return fmt.Errorf("invalid profile credentials: %w", err)
+		}
+	case AWSCredentialTypeInstanceRole:
+		if err := c.InstanceRoleCredentials.Validate(); err != nil {
+			return fmt.Errorf("invalid instance_role credentials: %w", err)
+		}
 	case "":
 		return fmt.Errorf("missing credential_type")
 	default:
@@ -106,30 +1137,108 @@ func (c Credentials) Validate() error {
 	return nil
 }
 
+// validateCredentials validates creds, including the region of the STS
+// endpoint roles are assumed through, which is subject to
+// AllowUnknownRegions like every other region.
+func (c *Config) validateCredentials(creds Credentials) error {
+	if err := creds.Validate(); err != nil {
+		return err
+	}
+	if creds.AssumeRole.Enabled() && creds.AssumeRole.STSRegion != "" {
+		if err := ValidateRegion(creds.AssumeRole.STSRegion, c.AllowUnknownRegions); err != nil {
+			return fmt.Errorf("invalid role credentials: invalid sts_region: %w", err)
+		}
+	}
+	return nil
+}
+
 func (c Config) GetAWSConfig(ctx context.Context) (aws.Config, error) {
 	if err := c.Credentials.Validate(); err != nil {
 		return aws.Config{}, fmt.Errorf("failed to validate credentials: %w", err)
 	}
 
+	opts, err := c.loadOptions()
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to get aws config: %w", err)
+	}
+
 	var cfg aws.Config
-	var err error
+	var failover *FailoverCredentials
 	switch c.Credentials.CredentialType {
 	case AWSCredentialTypeStatic:
-		cfg, err = config.LoadDefaultConfig(ctx,
+		var primary StaticCredentials
+		primary, err = c.Credentials.StaticCredentials.resolve()
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to get static credentials: %w", err)
+		}
+		cfg, err = config.LoadDefaultConfig(ctx, append(opts,
 			config.WithCredentialsProvider(
 				credentials.NewStaticCredentialsProvider(
-					c.Credentials.StaticCredentials.AccessKeyID,
-					c.Credentials.StaticCredentials.SecretAccessKey,
-					c.Credentials.StaticCredentials.SessionToken)),
-			config.WithRegion(c.Region),
-		)
+					primary.AccessKeyID,
+					primary.SecretAccessKey,
+					primary.SessionToken)),
+		)...)
+		if err == nil && c.Credentials.HasSecondary() {
+			secondary, err := c.Credentials.SecondaryStaticCredentials.resolve()
+			if err != nil {
+				return aws.Config{}, fmt.Errorf("failed to get static_secondary credentials: %w", err)
+			}
+			// Set after loading the config, as LoadDefaultConfig would
+			// cache the credentials and never see the switch.
+			failover = newFailoverCredentials(primary, secondary)
+			cfg.Credentials = failover
+		}
 	case AWSCredentialTypeRole:
-		cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(c.Region))
+		cfg, err = config.LoadDefaultConfig(ctx, opts...)
+	case AWSCredentialTypeRolesAnywhere:
+		args := c.Credentials.RolesAnywhereCredentials.CredentialProcessArgs()
+		// The helper is executed directly rather than through a shell, and
+		// must not inherit stdin, which GARM uses to pass the bootstrap
+		// params to the provider.
+		provider := processcreds.NewProviderCommand(processcreds.NewCommandBuilderFunc(
+			func(ctx context.Context) (*exec.Cmd, error) {
+				cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+				cmd.Env = os.Environ()
+				cmd.Stderr = os.Stderr
+				return cmd, nil
+			}))
+		cfg, err = config.LoadDefaultConfig(ctx, append(opts, config.WithCredentialsProvider(provider))...)
+	case AWSCredentialTypeProfile:
+		// The region of the provider config takes precedence over the
+		// region of the profile.
+		cfg, err = config.LoadDefaultConfig(ctx, append(opts, c.Credentials.ProfileCredentials.loadOptions()...)...)
+	case AWSCredentialTypeInstanceRole:
+		// Unlike the role credential type, credentials in the environment
+		// or in the shared config files are never used.
+		cfg, err = config.LoadDefaultConfig(ctx,
+			append(opts, config.WithCredentialsProvider(c.Credentials.InstanceRoleCredentials.provider()))...)
 	default:
 		return aws.Config{}, fmt.Errorf("unknown credential type: %s", c.Credentials.CredentialType)
 	}
 	if err != nil {
 		return aws.Config{}, fmt.Errorf("failed to get aws config: %w", err)
 	}
+	if c.EndpointURL != "" {
+		cfg.BaseEndpoint = aws.String(c.EndpointURL)
+	}
+
+	if c.Credentials.AssumeRole.Enabled() {
+		// Only the calls that assume the role are made with the
+		// credentials of the credential type, so only they fail over.
+		stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+			// STS endpoints are regional, so the credentials can be had
+			// without calling the global endpoint in us-east-1.
+			if c.Credentials.AssumeRole.STSRegion != "" {
+				o.Region = c.Credentials.AssumeRole.STSRegion
+			}
+			o.BaseEndpoint = c.BaseEndpoint(ServiceSTS, o.BaseEndpoint)
+			if failover != nil {
+				o.APIOptions = append(o.APIOptions, withFailover(failover))
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(c.Credentials.AssumeRole.provider(stsClient))
+	} else if failover != nil {
+		cfg.APIOptions = append(cfg.APIOptions, withFailover(failover))
+	}
 	return cfg, nil
 }
@@ -16,9 +16,22 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/require"
 )
 
@@ -39,8 +52,8 @@ func TestConfigValidate(t *testing.T) {
 						SessionToken:    "session_token",
 					},
 				},
-				SubnetID: "subnet_id",
-				Region:   "region",
+				SubnetID: "subnet-0123456789abcdef0",
+				Region:   "us-east-1",
 			},
 			errString: "",
 		},
@@ -55,7 +68,7 @@ func TestConfigValidate(t *testing.T) {
 						SessionToken:    "session_token",
 					},
 				},
-				Region: "region",
+				Region: "us-east-1",
 			},
 			errString: "missing subnet_id",
 		},
@@ -70,34 +83,849 @@ func TestConfigValidate(t *testing.T) {
 						SessionToken:    "session_token",
 					},
 				},
-				SubnetID: "subnet_id",
+				SubnetID: "subnet-0123456789abcdef0",
 			},
 			errString: "missing region",
 		},
+		{
+			name: "unknown region",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID: "subnet-0123456789abcdef0",
+				Region:   "us-east1",
+			},
+			errString: "unknown region \"us-east1\", did you mean \"us-east-1\"?",
+		},
+		{
+			name: "region unknown to the sdk",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID: "subnet-0123456789abcdef0",
+				Region:   "us-eats-1",
+			},
+			errString: "unknown region \"us-eats-1\", did you mean \"us-east-1\"? (set allow_unknown_regions = true if the region is newer than the provider)",
+		},
+		{
+			name: "region unknown to the sdk with allow_unknown_regions",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID:            "subnet-0123456789abcdef0",
+				Region:              "ap-southeast-9",
+				AllowUnknownRegions: true,
+			},
+			errString: "",
+		},
+		{
+			name: "unknown sts_region",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+					AssumeRole: AssumeRoleCredentials{
+						RoleARN:   "arn:aws:iam::123456789012:role/garm",
+						STSRegion: "eu-central",
+					},
+				},
+				SubnetID: "subnet-0123456789abcdef0",
+				Region:   "us-east-1",
+			},
+			errString: `failed to validate credentials: invalid role credentials: invalid sts_region: unknown region "eu-central", did you mean "eu-central-1"?`,
+		},
+		{
+			name: "strict name_resolution without state_dir",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID:       "subnet-0123456789abcdef0",
+				Region:         "us-east-1",
+				NameResolution: NameResolutionStrict,
+			},
+			errString: "name_resolution strict requires state_dir",
+		},
+		{
+			name: "unknown name_resolution",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID:       "subnet-0123456789abcdef0",
+				Region:         "us-east-1",
+				NameResolution: NameResolution("name"),
+			},
+			errString: "unknown name_resolution: name",
+		},
+		{
+			name: "invalid image_cache_ttl",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID:      "subnet-0123456789abcdef0",
+				Region:        "us-east-1",
+				ImageCacheTTL: "1 hour",
+			},
+			errString: "invalid image_cache_ttl: time: unknown unit \" hour\" in duration \"1 hour\"",
+		},
+		{
+			name: "negative deletion_grace_period",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID:            "subnet-0123456789abcdef0",
+				Region:              "us-east-1",
+				DeletionGracePeriod: "-15m",
+			},
+			errString: "deletion_grace_period must not be negative",
+		},
 		{
 			name: "missing credential type",
 			c: &Config{
-				SubnetID: "subnet_id",
-				Region:   "region",
+				SubnetID: "subnet-0123456789abcdef0",
+				Region:   "us-east-1",
+			},
+			errString: "failed to validate credentials: missing credential_type",
+		},
+		{
+			name: "invalid credential type",
+			c: &Config{
+				SubnetID: "subnet-0123456789abcdef0",
+				Region:   "us-east-1",
+				Credentials: Credentials{
+					CredentialType: AWSCredentialType("bogus"),
+				},
+			},
+			errString: "failed to validate credentials: unknown credential type: bogus",
+		},
+		{
+			name: "invalid subnet and security group IDs",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+				},
+				SubnetID:          "subnet-0123456789abcdef0",
+				FallbackSubnetIDs: []string{"ssm:/garm/subnet", "subnet_1"},
+				SecurityGroupIDs:  []string{"sg-01234567", "default"},
+				Region:            "us-east-1",
+			},
+			errString: "invalid subnet ID \"subnet_1\": must look like subnet-0123456789abcdef0\n" +
+				"invalid security group ID \"default\": must look like sg-0123456789abcdef0",
+		},
+		{
+			name: "all problems are reported",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+					AssumeRole: AssumeRoleCredentials{
+						RoleARN: "arn:aws:iam::garm:role/garm",
+					},
+				},
+				Region:         "us-east1",
+				ImageCacheTTL:  "1 hour",
+				NameResolution: NameResolutionStrict,
+			},
+			errString: "failed to validate credentials: invalid role credentials: invalid role_arn \"arn:aws:iam::garm:role/garm\": not a role ARN\n" +
+				"missing subnet_id\n" +
+				"unknown region \"us-east1\", did you mean \"us-east-1\"?\n" +
+				"invalid image_cache_ttl: time: unknown unit \" hour\" in duration \"1 hour\"\n" +
+				"name_resolution strict requires state_dir",
+		},
+		{
+			name: "empty required request tag",
+			c: &Config{
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeStatic,
+					StaticCredentials: StaticCredentials{
+						AccessKeyID:     "access_key_id",
+						SecretAccessKey: "secret_access_key",
+						SessionToken:    "session_token",
+					},
+				},
+				SubnetID:            "subnet-0123456789abcdef0",
+				Region:              "us-east-1",
+				RequiredRequestTags: []string{"CostCenter", " "},
+			},
+			errString: "required_request_tags must not contain empty keys",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      map[string]string
+		errString string
+	}{
+		{
+			name: "valid tags",
+			tags: map[string]string{"CostCenter": "1234", "Team": ""},
+		},
+		{
+			name:      "empty key",
+			tags:      map[string]string{" ": "1234"},
+			errString: "tags must not contain empty keys",
+		},
+		{
+			name:      "reserved prefix",
+			tags:      map[string]string{"AWS:CostCenter": "1234"},
+			errString: "invalid tag AWS:CostCenter: the aws: prefix is reserved",
+		},
+		{
+			name:      "provider tag",
+			tags:      map[string]string{"GARM_POOL_ID": "pool"},
+			errString: "invalid tag GARM_POOL_ID: the tag is set by the provider",
+		},
+		{
+			name:      "value too long",
+			tags:      map[string]string{"CostCenter": strings.Repeat("1", 257)},
+			errString: "invalid tag CostCenter: values can't be longer than 256 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Tags: tt.tags}
+			err := c.validateTags()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestValidateImageAliases(t *testing.T) {
+	tests := []struct {
+		name      string
+		aliases   map[string]map[string]string
+		errString string
+	}{
+		{
+			name: "valid aliases",
+			aliases: map[string]map[string]string{
+				"ubuntu-22.04": {
+					"us-east-1":    "ami-0123456789abcdef0",
+					"eu-central-1": "ssm:/aws/service/canonical/ubuntu/server/22.04/stable/current/amd64/hvm/ebs-gp2/ami-id",
+				},
+			},
+		},
+		{
+			name: "alias looks like an AMI ID",
+			aliases: map[string]map[string]string{
+				"ami-ubuntu": {"us-east-1": "ami-0123456789abcdef0"},
+			},
+			errString: "invalid image alias ami-ubuntu: aliases can't look like AMI IDs or SSM references",
+		},
+		{
+			name: "invalid region",
+			aliases: map[string]map[string]string{
+				"ubuntu-22.04": {"us-east1": "ami-0123456789abcdef0"},
+			},
+			errString: `invalid region for image alias ubuntu-22.04: unknown region "us-east1", did you mean "us-east-1"?`,
+		},
+		{
+			name: "invalid image",
+			aliases: map[string]map[string]string{
+				"ubuntu-22.04": {"us-east-1": "ubuntu"},
+			},
+			errString: `invalid image "ubuntu" for image alias ubuntu-22.04 in us-east-1: must be an AMI ID or an SSM reference`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{ImageAliases: tt.aliases}
+			err := c.validateImageAliases()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestResolveImageAlias(t *testing.T) {
+	c := &Config{
+		Region: "us-east-1",
+		ImageAliases: map[string]map[string]string{
+			"ubuntu-22.04": {"us-east-1": "ami-0123456789abcdef0"},
+			"ubuntu-24.04": {"eu-central-1": "ami-0123456789abcdef1"},
+		},
+	}
+
+	image, err := c.ResolveImageAlias("ubuntu-22.04")
+	require.NoError(t, err)
+	require.Equal(t, "ami-0123456789abcdef0", image)
+
+	image, err = c.ResolveImageAlias("ami-0fedcba9876543210")
+	require.NoError(t, err)
+	require.Equal(t, "ami-0fedcba9876543210", image)
+
+	_, err = c.ResolveImageAlias("ubuntu-24.04")
+	require.EqualError(t, err, "image alias ubuntu-24.04 has no image for region us-east-1")
+}
+
+func TestValidateSubstitutions(t *testing.T) {
+	tests := []struct {
+		name          string
+		substitutions map[string]Substitutions
+		errString     string
+	}{
+		{
+			name: "valid substitutions",
+			substitutions: map[string]Substitutions{
+				"aws-cn": {
+					ToolURLs: []Substitution{{Prefix: "https://github.com/", Replacement: "https://mirror.example.cn/github/"}},
+					Images:   []Substitution{{Prefix: "ssm:/aws/service/canonical/", Replacement: "ssm:/mirror/canonical/"}},
+				},
+			},
+		},
+		{
+			name:          "unknown partition",
+			substitutions: map[string]Substitutions{"aws-china": {}},
+			errString:     `unknown partition "aws-china"`,
+		},
+		{
+			name: "missing prefix",
+			substitutions: map[string]Substitutions{
+				"aws-cn": {ToolURLs: []Substitution{{Replacement: "https://mirror.example.cn/"}}},
+			},
+			errString: "invalid substitutions for partition aws-cn: tool_urls: missing prefix",
+		},
+		{
+			name: "invalid tool URL",
+			substitutions: map[string]Substitutions{
+				"aws-cn": {ToolURLs: []Substitution{{Prefix: "https://github.com/", Replacement: "mirror.example.cn/github/"}}},
+			},
+			errString: "invalid substitutions for partition aws-cn: tool_urls: replacement for https://github.com/ must be an http or https URL",
+		},
+		{
+			name: "invalid image",
+			substitutions: map[string]Substitutions{
+				"aws-us-gov": {Images: []Substitution{{Prefix: "ubuntu", Replacement: "mirror-ubuntu"}}},
+			},
+			errString: `invalid substitutions for partition aws-us-gov: images: replacement "mirror-ubuntu" for ubuntu must be an AMI ID or an SSM reference`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Substitutions: tt.substitutions}
+			err := c.validateSubstitutions()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestValidateEndpointURLs(t *testing.T) {
+	tests := []struct {
+		name         string
+		endpointURL  string
+		endpointURLs map[string]string
+		errString    string
+	}{
+		{
+			name:        "valid endpoint URLs",
+			endpointURL: "http://localhost:4566",
+			endpointURLs: map[string]string{
+				"ec2": "https://vpce-0123456789abcdef0-abcdefgh.ec2.us-east-1.vpce.amazonaws.com",
+				"sts": "https://sts.us-east-1.amazonaws.com",
+			},
+		},
+		{
+			name:        "invalid endpoint_url",
+			endpointURL: "localhost:4566",
+			errString:   `invalid endpoint_url: "localhost:4566" must be an absolute http or https URL`,
+		},
+		{
+			name:         "unknown service",
+			endpointURLs: map[string]string{"lambda": "https://lambda.us-east-1.amazonaws.com"},
+			errString:    "invalid endpoint_urls: unknown service lambda",
+		},
+		{
+			name:         "invalid service endpoint",
+			endpointURLs: map[string]string{"sts": "sts.us-east-1.amazonaws.com"},
+			errString:    `invalid endpoint_urls for sts: "sts.us-east-1.amazonaws.com" must be an absolute http or https URL`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{EndpointURL: tt.endpointURL, EndpointURLs: tt.endpointURLs}
+			err := c.validateEndpointURLs()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestGetAWSConfigEndpointURLs(t *testing.T) {
+	var calls []string
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			calls = append(calls, name+" "+r.Form.Get("Action"))
+			if r.Form.Get("Action") == "AssumeRole" {
+				fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials><AccessKeyId>assumed</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+				return
+			}
+			fmt.Fprint(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet/></DescribeInstancesResponse>`)
+		}))
+	}
+	endpoint := newServer("endpoint_url")
+	defer endpoint.Close()
+	stsEndpoint := newServer("sts")
+	defer stsEndpoint.Close()
+
+	cfg := Config{
+		Region:       "us-east-1",
+		EndpointURL:  endpoint.URL,
+		EndpointURLs: map[string]string{ServiceSTS: stsEndpoint.URL},
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeStatic,
+			StaticCredentials: StaticCredentials{
+				AccessKeyID:     "static",
+				SecretAccessKey: "secret",
+			},
+			AssumeRole: AssumeRoleCredentials{
+				RoleARN: "arn:aws:iam::123456789012:role/garm",
+			},
+		},
+	}
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+
+	client := ec2.NewFromConfig(awsCfg, func(o *ec2.Options) {
+		o.BaseEndpoint = cfg.BaseEndpoint(ServiceEC2, o.BaseEndpoint)
+	})
+	_, err = client.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"sts AssumeRole", "endpoint_url DescribeInstances"}, calls)
+}
+
+func TestForEnvironment(t *testing.T) {
+	c := &Config{
+		Region:            "us-east-1",
+		SubnetID:          "subnet-0123456789abcdef0",
+		FallbackSubnetIDs: []string{"subnet-0123456789abcdef1"},
+		SecurityGroupIDs:  []string{"sg-0123456789abcdef0"},
+		Credentials:       Credentials{CredentialType: AWSCredentialTypeRole},
+		Environments: map[string]Environment{
+			"eu": {
+				Region:   "eu-west-1",
+				SubnetID: "subnet-0123456789abcdef2",
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeProfile,
+					ProfileCredentials: ProfileCredentials{
+						Name: "eu",
+					},
+				},
+			},
+			"ci": {
+				Credentials: Credentials{
+					CredentialType: AWSCredentialTypeRole,
+					AssumeRole: AssumeRoleCredentials{
+						RoleARN: "arn:aws:iam::123456789012:role/garm",
+					},
+				},
+			},
+		},
+	}
+	require.Equal(t, []string{"ci", "eu"}, c.EnvironmentNames())
+
+	eu, err := c.ForEnvironment("eu")
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", eu.Region)
+	require.Equal(t, "subnet-0123456789abcdef2", eu.SubnetID)
+	require.Empty(t, eu.FallbackSubnetIDs)
+	require.Empty(t, eu.SecurityGroupIDs)
+	require.Equal(t, AWSCredentialTypeProfile, eu.Credentials.CredentialType)
+	require.Nil(t, eu.Environments)
+
+	ci, err := c.ForEnvironment("ci")
+	require.NoError(t, err)
+	require.Equal(t, "us-east-1", ci.Region)
+	require.Equal(t, c.SubnetID, ci.SubnetID)
+	require.Equal(t, c.SecurityGroupIDs, ci.SecurityGroupIDs)
+	require.Equal(t, "arn:aws:iam::123456789012:role/garm", ci.Credentials.AssumeRole.RoleARN)
+
+	_, err = c.ForEnvironment("us")
+	require.EqualError(t, err, `unknown environment "us"`)
+}
+
+func TestValidateEnvironments(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment Environment
+		envName     string
+		errString   string
+	}{
+		{
+			name:        "valid environment",
+			environment: Environment{Region: "eu-west-1", SubnetID: "subnet-0123456789abcdef0"},
+		},
+		{
+			name:        "invalid name",
+			envName:     "eu west",
+			environment: Environment{SubnetID: "subnet-0123456789abcdef0"},
+			errString:   `invalid environment name "eu west"`,
+		},
+		{
+			name:        "other region without subnet",
+			environment: Environment{Region: "eu-west-1"},
+			errString:   "environment eu: a region other than the one of the provider config requires subnet_id",
+		},
+		{
+			name:        "security groups without subnet",
+			environment: Environment{SecurityGroupIDs: []string{"sg-0123456789abcdef0"}},
+			errString:   "environment eu: fallback_subnet_ids and security_group_ids require subnet_id",
+		},
+		{
+			name:        "invalid subnet",
+			environment: Environment{SubnetID: "subnet_eu"},
+			errString:   `environment eu: invalid subnet ID "subnet_eu": must look like subnet-0123456789abcdef0`,
+		},
+		{
+			name: "invalid credentials",
+			environment: Environment{
+				Credentials: Credentials{CredentialType: AWSCredentialTypeProfile},
+			},
+			errString: "environment eu: failed to validate credentials: invalid profile credentials: missing name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := tt.envName
+			if name == "" {
+				name = "eu"
+			}
+			c := &Config{
+				Region:       "us-east-1",
+				SubnetID:     "subnet-0123456789abcdef0",
+				Environments: map[string]Environment{name: tt.environment},
+			}
+			err := c.validateEnvironments()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	c := &Config{
+		Region: "cn-north-1",
+		Substitutions: map[string]Substitutions{
+			"aws-cn": {
+				ToolURLs: []Substitution{
+					{Prefix: "https://github.com/actions/runner/", Replacement: "https://mirror.example.cn/runner/"},
+					{Prefix: "https://github.com/", Replacement: "https://mirror.example.cn/github/"},
+				},
+				Images: []Substitution{{Prefix: "ssm:/aws/service/canonical/", Replacement: "ssm:/mirror/canonical/"}},
+			},
+			"aws-us-gov": {
+				ToolURLs: []Substitution{{Prefix: "https://github.com/", Replacement: "https://mirror.example.com/"}},
+			},
+		},
+	}
+
+	require.Equal(t,
+		"https://mirror.example.cn/runner/releases/download/v2.317.0/actions-runner-linux-x64-2.317.0.tar.gz",
+		c.SubstituteToolURL("https://github.com/actions/runner/releases/download/v2.317.0/actions-runner-linux-x64-2.317.0.tar.gz"))
+	require.Equal(t, "https://mirror.example.cn/github/owner/repo", c.SubstituteToolURL("https://github.com/owner/repo"))
+	require.Equal(t, "https://ghes.example.com/runner.tar.gz", c.SubstituteToolURL("https://ghes.example.com/runner.tar.gz"))
+	require.Equal(t, "ssm:/mirror/canonical/ubuntu/ami-id", c.SubstituteImage("ssm:/aws/service/canonical/ubuntu/ami-id"))
+	require.Equal(t, "ami-0123456789abcdef0", c.SubstituteImage("ami-0123456789abcdef0"))
+
+	// Substitutions of other partitions are not applied.
+	c.Region = "us-east-1"
+	require.Equal(t, "https://github.com/owner/repo", c.SubstituteToolURL("https://github.com/owner/repo"))
+}
+
+func TestEntityQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		quota     EntityQuota
+		errString string
+	}{
+		{name: "valid quota", quota: EntityQuota{Entity: "owner/*", MaxInstances: 5}},
+		{name: "missing entity", quota: EntityQuota{MaxInstances: 5}, errString: "missing entity"},
+		{name: "invalid pattern", quota: EntityQuota{Entity: "owner/[", MaxInstances: 5}, errString: `invalid entity "owner/[": syntax error in pattern`},
+		{name: "no instances", quota: EntityQuota{Entity: "owner/repo"}, errString: "max_instances of owner/repo must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quota.Validate()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+
+	c := &Config{
+		EntityQuotas: []EntityQuota{
+			{Entity: "Owner/Noisy", MaxInstances: 2},
+			{Entity: "owner/*", MaxInstances: 10},
+		},
+	}
+	quota, ok := c.GetEntityQuota("owner/noisy")
+	require.True(t, ok)
+	require.Equal(t, 2, quota)
+	quota, ok = c.GetEntityQuota("owner/quiet")
+	require.True(t, ok)
+	require.Equal(t, 10, quota)
+	_, ok = c.GetEntityQuota("owner")
+	require.False(t, ok)
+}
+
+func TestLifecycleWebhookValidate(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name      string
+		w         LifecycleWebhook
+		errString string
+	}{
+		{
+			name:      "disabled",
+			w:         LifecycleWebhook{},
+			errString: "",
+		},
+		{
+			name: "valid webhook",
+			w: LifecycleWebhook{
+				URL:    "https://cmdb.example.com/hooks/garm",
+				Secret: "secret",
+			},
+			errString: "",
+		},
+		{
+			name: "relative url",
+			w: LifecycleWebhook{
+				URL:    "cmdb.example.com/hooks/garm",
+				Secret: "secret",
+			},
+			errString: "invalid url \"cmdb.example.com/hooks/garm\": must be an absolute http or https URL",
+		},
+		{
+			name: "missing secret",
+			w: LifecycleWebhook{
+				URL: "https://cmdb.example.com/hooks/garm",
+			},
+			errString: "missing secret",
+		},
+		{
+			name: "negative max_retries",
+			w: LifecycleWebhook{
+				URL:        "https://cmdb.example.com/hooks/garm",
+				Secret:     "secret",
+				MaxRetries: &negative,
+			},
+			errString: "max_retries must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.w.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestCreateSpreadingValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         CreateSpreading
+		errString string
+	}{
+		{
+			name:      "disabled",
+			s:         CreateSpreading{},
+			errString: "",
+		},
+		{
+			name: "jitter only",
+			s: CreateSpreading{
+				Jitter: "10s",
+			},
+			errString: "",
+		},
+		{
+			name: "spacing",
+			s: CreateSpreading{
+				Spacing:  "2s",
+				SlotDir:  "/var/lib/garm-provider-aws",
+				MaxDelay: "5m",
+			},
+			errString: "",
+		},
+		{
+			name: "spacing without slot_dir",
+			s: CreateSpreading{
+				Spacing: "2s",
+			},
+			errString: "spacing requires slot_dir",
+		},
+		{
+			name: "invalid jitter",
+			s: CreateSpreading{
+				Jitter: "ten seconds",
+			},
+			errString: "invalid jitter: time: invalid duration \"ten seconds\"",
+		},
+		{
+			name: "negative max_delay",
+			s: CreateSpreading{
+				MaxDelay: "-1m",
+			},
+			errString: "max_delay must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.s.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestUserDataOffloadValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		o         UserDataOffload
+		errString string
+	}{
+		{
+			name:      "disabled",
+			o:         UserDataOffload{},
+			errString: "",
+		},
+		{
+			name: "valid",
+			o: UserDataOffload{
+				Bucket:          "garm-user-data",
+				Prefix:          "runners/",
+				InstanceProfile: "garm-runner",
+			},
+			errString: "",
+		},
+		{
+			name: "instance profile without bucket",
+			o: UserDataOffload{
+				InstanceProfile: "garm-runner",
+			},
+			errString: "missing bucket",
+		},
+		{
+			name: "invalid bucket",
+			o: UserDataOffload{
+				Bucket:          "Garm_User_Data",
+				InstanceProfile: "garm-runner",
+			},
+			errString: "invalid bucket name \"Garm_User_Data\"",
+		},
+		{
+			name: "absolute prefix",
+			o: UserDataOffload{
+				Bucket:          "garm-user-data",
+				Prefix:          "/runners",
+				InstanceProfile: "garm-runner",
+			},
+			errString: "prefix must not start with /",
+		},
+		{
+			name: "missing instance profile",
+			o: UserDataOffload{
+				Bucket: "garm-user-data",
+			},
+			errString: "missing instance_profile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.o.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestBootstrapScriptsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         BootstrapScripts
+		errString string
+	}{
+		{
+			name:      "empty",
+			s:         BootstrapScripts{},
+			errString: "",
+		},
+		{
+			name: "valid",
+			s: BootstrapScripts{
+				Linux:   OSBootstrapScripts{Pre: "#!/bin/sh\necho pre", Post: "#!/bin/bash\necho post"},
+				Windows: OSBootstrapScripts{Pre: "Write-Host pre"},
 			},
-			errString: "failed to validate credentials: missing credential_type",
+			errString: "",
 		},
 		{
-			name: "invalid credential type",
-			c: &Config{
-				SubnetID: "subnet_id",
-				Region:   "region",
-				Credentials: Credentials{
-					CredentialType: AWSCredentialType("bogus"),
-				},
+			name: "linux script without shebang",
+			s: BootstrapScripts{
+				Linux: OSBootstrapScripts{Post: "echo post"},
 			},
-			errString: "failed to validate credentials: unknown credential type: bogus",
+			errString: "linux.post must start with a shebang, like #!/bin/sh",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.c.Validate()
+			err := tt.s.Validate()
 			if tt.errString == "" {
 				require.Nil(t, err)
 			} else {
@@ -107,6 +935,167 @@ func TestConfigValidate(t *testing.T) {
 	}
 }
 
+func TestRetryValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		retry     Retry
+		errString string
+	}{
+		{
+			name:  "valid retry",
+			retry: Retry{Mode: RetryModeAdaptive, MaxAttempts: 10, MaxBackoff: "1m"},
+		},
+		{
+			name:      "unknown mode",
+			retry:     Retry{Mode: "legacy"},
+			errString: "unknown mode: legacy",
+		},
+		{
+			name:      "negative max_attempts",
+			retry:     Retry{MaxAttempts: -1},
+			errString: "max_attempts must not be negative",
+		},
+		{
+			name:      "invalid max_backoff",
+			retry:     Retry{MaxBackoff: "1"},
+			errString: `invalid max_backoff: time: missing unit in duration "1"`,
+		},
+		{
+			name:      "zero max_backoff",
+			retry:     Retry{MaxBackoff: "0s"},
+			errString: "max_backoff must be positive",
+		},
+		{
+			name:      "negative throttle_max_attempts",
+			retry:     Retry{ThrottleMaxAttempts: -1},
+			errString: "throttle_max_attempts must not be negative",
+		},
+		{
+			name:      "invalid throttle_max_backoff",
+			retry:     Retry{ThrottleMaxBackoff: "1"},
+			errString: `invalid throttle_max_backoff: time: missing unit in duration "1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.retry.Validate()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestGetAWSConfigRetry(t *testing.T) {
+	cfg := staticConfig()
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+	// Clients use the retryer of the SDK.
+	require.Nil(t, awsCfg.Retryer)
+
+	cfg.Retry = Retry{Mode: RetryModeAdaptive, MaxAttempts: 10}
+	awsCfg, err = cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+	retryer := awsCfg.Retryer()
+	require.IsType(t, &retry.AdaptiveMode{}, retryer)
+	require.Equal(t, 10, retryer.MaxAttempts())
+	// Clients don't share their retryer.
+	require.NotSame(t, retryer, awsCfg.Retryer())
+}
+
+func TestThrottleRetryer(t *testing.T) {
+	retryer := Retry{MaxAttempts: 2, ThrottleMaxAttempts: 5, ThrottleMaxBackoff: "2s"}.retryer()
+	require.Equal(t, 5, retryer.MaxAttempts())
+
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+	for attempt := 1; attempt < 5; attempt++ {
+		delay, err := retryer.RetryDelay(attempt, throttled)
+		require.NoError(t, err)
+		require.LessOrEqual(t, delay, 2*time.Second)
+	}
+	_, err := retryer.RetryDelay(5, throttled)
+	require.Equal(t, throttled, err)
+
+	unavailable := &smithy.GenericAPIError{Code: "ServiceUnavailable"}
+	_, err = retryer.RetryDelay(1, unavailable)
+	require.NoError(t, err)
+	_, err = retryer.RetryDelay(2, unavailable)
+	require.Equal(t, unavailable, err)
+}
+
+func TestValidateRegion(t *testing.T) {
+	tests := []struct {
+		region       string
+		allowUnknown bool
+		warning      string
+		errString    string
+	}{
+		{region: "eu-central-1"},
+		{region: "us-gov-west-1"},
+		{region: "cn-northwest-1"},
+		// Not in the SDK metadata yet, but a valid name in the aws partition.
+		{region: "ap-southeast-9", errString: "unknown region \"ap-southeast-9\", did you mean \"ap-southeast-1\"? (set allow_unknown_regions = true if the region is newer than the provider)"},
+		{region: "ap-southeast-9", allowUnknown: true, warning: `region=ap-southeast-9 partition=aws suggestion=ap-southeast-1`},
+		{region: "us-eats-1", errString: "unknown region \"us-eats-1\", did you mean \"us-east-1\"? (set allow_unknown_regions = true if the region is newer than the provider)"},
+		{region: "eucentral-1", errString: "unknown region \"eucentral-1\", did you mean \"eu-central-1\"?"},
+		{region: "us-west2", allowUnknown: true, errString: "unknown region \"us-west2\", did you mean \"us-west-2\"?"},
+		{region: "US-EAST-1", errString: "unknown region \"US-EAST-1\""},
+		{region: "frankfurt", errString: "unknown region \"frankfurt\""},
+		// Pseudo regions of the SDK can't be used with EC2.
+		{region: "aws-global", errString: "unknown region \"aws-global\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			var logs bytes.Buffer
+			defaultLogger := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+			err := ValidateRegion(tt.region, tt.allowUnknown)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+			if tt.warning == "" {
+				require.Empty(t, logs.String())
+			} else {
+				require.Contains(t, logs.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestPartitionsMatchSDK(t *testing.T) {
+	// regions_generated.go must be regenerated with go generate when the
+	// SDK is updated.
+	data, err := os.ReadFile("../vendor/github.com/aws/aws-sdk-go-v2/internal/endpoints/awsrulesfn/partitions.json")
+	require.NoError(t, err)
+	var metadata struct {
+		Partitions []struct {
+			ID          string              `json:"id"`
+			RegionRegex string              `json:"regionRegex"`
+			Regions     map[string]struct{} `json:"regions"`
+		} `json:"partitions"`
+	}
+	require.NoError(t, json.Unmarshal(data, &metadata))
+	require.Len(t, partitions, len(metadata.Partitions))
+
+	for idx, expected := range metadata.Partitions {
+		require.Equal(t, expected.ID, partitions[idx].id)
+		require.Equal(t, expected.RegionRegex, partitions[idx].regionRegex.String())
+		for region := range expected.Regions {
+			if partitions[idx].regionRegex.MatchString(region) {
+				require.Contains(t, partitions[idx].regions, region)
+			}
+		}
+	}
+}
+
 func TestCredentialsValidate(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -161,6 +1150,267 @@ func TestCredentialsValidate(t *testing.T) {
 			},
 			errString: "",
 		},
+		{
+			name: "static credentials from files",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeStatic,
+				StaticCredentials: StaticCredentials{
+					AccessKeyIDFile:     "/run/secrets/access_key_id",
+					SecretAccessKeyFile: "/run/secrets/secret_access_key",
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "access_key_id and access_key_id_file",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeStatic,
+				StaticCredentials: StaticCredentials{
+					AccessKeyID:         "access_key_id",
+					AccessKeyIDFile:     "/run/secrets/access_key_id",
+					SecretAccessKeyFile: "/run/secrets/secret_access_key",
+				},
+			},
+			errString: "access_key_id and access_key_id_file are mutually exclusive",
+		},
+		{
+			name: "relative session_token_file",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeStatic,
+				StaticCredentials: StaticCredentials{
+					AccessKeyID:      "access_key_id",
+					SecretAccessKey:  "secret_access_key",
+					SessionTokenFile: "session_token",
+				},
+			},
+			errString: "session_token_file \"session_token\" is not an absolute path",
+		},
+		{
+			name: "valid roles anywhere credentials",
+			c: Credentials{
+				CredentialType:           AWSCredentialTypeRolesAnywhere,
+				RolesAnywhereCredentials: rolesAnywhereCredentials(),
+			},
+			errString: "",
+		},
+		{
+			name: "roles anywhere missing private_key",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRolesAnywhere,
+				RolesAnywhereCredentials: func() RolesAnywhereCredentials {
+					c := rolesAnywhereCredentials()
+					c.PrivateKey = ""
+					return c
+				}(),
+			},
+			errString: "missing private_key",
+		},
+		{
+			name: "roles anywhere invalid profile_arn",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRolesAnywhere,
+				RolesAnywhereCredentials: func() RolesAnywhereCredentials {
+					c := rolesAnywhereCredentials()
+					c.ProfileARN = "profile-id"
+					return c
+				}(),
+			},
+			errString: "invalid profile_arn \"profile-id\": not an ARN",
+		},
+		{
+			name: "secondary static credentials",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeStatic,
+				StaticCredentials: StaticCredentials{
+					AccessKeyID:     "access_key_id",
+					SecretAccessKey: "secret_access_key",
+				},
+				SecondaryStaticCredentials: StaticCredentials{
+					AccessKeyID:     "secondary_access_key_id",
+					SecretAccessKey: "secondary_secret_access_key",
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "incomplete secondary static credentials",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeStatic,
+				StaticCredentials: StaticCredentials{
+					AccessKeyID:     "access_key_id",
+					SecretAccessKey: "secret_access_key",
+				},
+				SecondaryStaticCredentials: StaticCredentials{
+					AccessKeyID: "secondary_access_key_id",
+				},
+			},
+			errString: "invalid static_secondary credentials: missing secret_access_key",
+		},
+		{
+			name: "secondary static credentials with role",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRole,
+				SecondaryStaticCredentials: StaticCredentials{
+					AccessKeyID:     "secondary_access_key_id",
+					SecretAccessKey: "secondary_secret_access_key",
+				},
+			},
+			errString: "static_secondary credentials require the static credential type",
+		},
+		{
+			name: "assume role with static credentials",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeStatic,
+				StaticCredentials: StaticCredentials{
+					AccessKeyID:     "access_key_id",
+					SecretAccessKey: "secret_access_key",
+				},
+				AssumeRole: AssumeRoleCredentials{
+					RoleARN:     "arn:aws:iam::123456789012:role/garm",
+					ExternalID:  "sample-external-id",
+					SessionName: "garm-eu",
+					Duration:    "1h",
+					STSRegion:   "eu-central-1",
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "assume role without role_arn",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRole,
+				AssumeRole: AssumeRoleCredentials{
+					ExternalID: "sample-external-id",
+				},
+			},
+			errString: "invalid role credentials: missing role_arn",
+		},
+		{
+			name: "assume role with a user ARN",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRole,
+				AssumeRole: AssumeRoleCredentials{
+					RoleARN: "arn:aws:iam::123456789012:user/garm",
+				},
+			},
+			errString: "invalid role credentials: invalid role_arn \"arn:aws:iam::123456789012:user/garm\": not a role ARN",
+		},
+		{
+			name: "assume role with an invalid session_name",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRole,
+				AssumeRole: AssumeRoleCredentials{
+					RoleARN:     "arn:aws:iam::123456789012:role/garm",
+					SessionName: "garm provider",
+				},
+			},
+			errString: "invalid role credentials: invalid session_name \"garm provider\"",
+		},
+		{
+			name: "assume role duration too short",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRole,
+				AssumeRole: AssumeRoleCredentials{
+					RoleARN:  "arn:aws:iam::123456789012:role/garm",
+					Duration: "5m",
+				},
+			},
+			errString: "invalid role credentials: duration must be between 15m and 12h",
+		},
+		{
+			name: "profile credentials",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeProfile,
+				ProfileCredentials: ProfileCredentials{
+					Name:        "garm",
+					ConfigFiles: []string{"/etc/garm/aws/config"},
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "profile credentials without name",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeProfile,
+			},
+			errString: "invalid profile credentials: missing name",
+		},
+		{
+			name: "profile credentials with relative file",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeProfile,
+				ProfileCredentials: ProfileCredentials{
+					Name:             "garm",
+					CredentialsFiles: []string{".aws/credentials"},
+				},
+			},
+			errString: "invalid profile credentials: shared config file \".aws/credentials\" is not an absolute path",
+		},
+		{
+			name: "instance role credentials",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeInstanceRole,
+				InstanceRoleCredentials: InstanceRoleCredentials{
+					EndpointMode:          IMDSEndpointModeIPv6,
+					DisableIMDSv1Fallback: true,
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "instance role credentials with invalid endpoint",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeInstanceRole,
+				InstanceRoleCredentials: InstanceRoleCredentials{
+					Endpoint: "169.254.169.254",
+				},
+			},
+			errString: "invalid instance_role credentials: invalid endpoint: \"169.254.169.254\" must be an absolute http or https URL",
+		},
+		{
+			name: "instance role credentials with unknown endpoint_mode",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeInstanceRole,
+				InstanceRoleCredentials: InstanceRoleCredentials{
+					EndpointMode: "ipv5",
+				},
+			},
+			errString: "invalid instance_role credentials: unknown endpoint_mode: ipv5",
+		},
+		{
+			name: "roles anywhere trust_anchor_arn without ID",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRolesAnywhere,
+				RolesAnywhereCredentials: func() RolesAnywhereCredentials {
+					c := rolesAnywhereCredentials()
+					c.TrustAnchorARN = "arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor"
+					return c
+				}(),
+			},
+			errString: "invalid trust_anchor_arn \"arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor\": not a Roles Anywhere ARN",
+		},
+		{
+			name: "assume role in another partition",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRole,
+				AssumeRole: AssumeRoleCredentials{
+					RoleARN: "arn:aws-us-gov:iam::123456789012:role/ci/garm",
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "roles anywhere session_duration too long",
+			c: Credentials{
+				CredentialType: AWSCredentialTypeRolesAnywhere,
+				RolesAnywhereCredentials: func() RolesAnywhereCredentials {
+					c := rolesAnywhereCredentials()
+					c.SessionDuration = "24h"
+					return c
+				}(),
+			},
+			errString: "session_duration must be between 15m and 12h",
+		},
 	}
 
 	for _, tt := range tests {
@@ -175,6 +1425,137 @@ func TestCredentialsValidate(t *testing.T) {
 	}
 }
 
+func TestStaticCredentialsResolve(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret_access_key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret\n"), 0o600))
+	emptyFile := filepath.Join(dir, "session_token")
+	require.NoError(t, os.WriteFile(emptyFile, nil, 0o600))
+
+	creds, err := StaticCredentials{
+		AccessKeyID:         "access_key_id",
+		SecretAccessKeyFile: secretFile,
+	}.resolve()
+	require.NoError(t, err)
+	require.Equal(t, StaticCredentials{
+		AccessKeyID:     "access_key_id",
+		SecretAccessKey: "secret",
+	}, creds)
+
+	_, err = StaticCredentials{
+		AccessKeyID:      "access_key_id",
+		SecretAccessKey:  "secret",
+		SessionTokenFile: emptyFile,
+	}.resolve()
+	require.EqualError(t, err, fmt.Sprintf("session_token_file %s is empty", emptyFile))
+
+	_, err = StaticCredentials{
+		AccessKeyIDFile: filepath.Join(dir, "missing"),
+		SecretAccessKey: "secret",
+	}.resolve()
+	require.ErrorContains(t, err, "failed to read access_key_id_file")
+}
+
+func TestGetAWSConfigProfile(t *testing.T) {
+	// Credentials in the environment would take precedence.
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	require.NoError(t, os.WriteFile(configFile, []byte("[profile garm]\nregion = us-west-2\n"), 0o600))
+	require.NoError(t, os.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = default\naws_secret_access_key = secret\n\n[garm]\naws_access_key_id = garm\naws_secret_access_key = secret\n"), 0o600))
+
+	cfg := Config{
+		Region: "eu-central-1",
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeProfile,
+			ProfileCredentials: ProfileCredentials{
+				Name:             "garm",
+				ConfigFiles:      []string{configFile},
+				CredentialsFiles: []string{credentialsFile},
+			},
+		},
+	}
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "eu-central-1", awsCfg.Region)
+
+	creds, err := awsCfg.Credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "garm", creds.AccessKeyID)
+}
+
+func TestGetAWSConfigInstanceRole(t *testing.T) {
+	// Unlike with the role credential type, these must be ignored.
+	t.Setenv("AWS_ACCESS_KEY_ID", "environment")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			fmt.Fprint(w, "token")
+		case r.Header.Get("X-Aws-Ec2-Metadata-Token") != "token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "garm")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/garm":
+			fmt.Fprintf(w, `{"Code": "Success", "AccessKeyId": "instance", "SecretAccessKey": "secret", "Token": "token", "Expiration": %q}`,
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := Config{
+		Region: "eu-central-1",
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeInstanceRole,
+			InstanceRoleCredentials: InstanceRoleCredentials{
+				Endpoint:              server.URL,
+				DisableIMDSv1Fallback: true,
+			},
+		},
+	}
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+
+	creds, err := awsCfg.Credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "instance", creds.AccessKeyID)
+}
+
+func rolesAnywhereCredentials() RolesAnywhereCredentials {
+	return RolesAnywhereCredentials{
+		Certificate:    "/etc/garm/aws/cert.pem",
+		PrivateKey:     "/etc/garm/aws/key.pem",
+		TrustAnchorARN: "arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/a1b2c3",
+		ProfileARN:     "arn:aws:rolesanywhere:us-east-1:123456789012:profile/d4e5f6",
+		RoleARN:        "arn:aws:iam::123456789012:role/garm",
+	}
+}
+
+func TestCredentialProcessArgs(t *testing.T) {
+	c := rolesAnywhereCredentials()
+	require.Equal(t, []string{
+		"aws_signing_helper", "credential-process",
+		"--certificate", "/etc/garm/aws/cert.pem",
+		"--private-key", "/etc/garm/aws/key.pem",
+		"--trust-anchor-arn", "arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/a1b2c3",
+		"--profile-arn", "arn:aws:rolesanywhere:us-east-1:123456789012:profile/d4e5f6",
+		"--role-arn", "arn:aws:iam::123456789012:role/garm",
+	}, c.CredentialProcessArgs())
+
+	c.SigningHelper = "/usr/local/bin/aws_signing_helper"
+	c.SessionDuration = "1h"
+	args := c.CredentialProcessArgs()
+	require.Equal(t, "/usr/local/bin/aws_signing_helper", args[0])
+	require.Equal(t, []string{"--session-duration", "3600"}, args[len(args)-2:])
+}
+
 func TestNewConfig(t *testing.T) {
 	// Create a temporary file
 	tempFile, err := os.CreateTemp("", "test.toml")
@@ -183,8 +1564,8 @@ func TestNewConfig(t *testing.T) {
 
 	// Write some dummy TOML data to the temp file
 	dummyTOML := `
-		region = "region"
-		subnet_id = "subnet_id"
+		region = "us-east-1"
+		subnet_id = "subnet-0123456789abcdef0"
 		[credentials]
 			credential_type = "static"
 			[credentials.static]
@@ -212,8 +1593,8 @@ func TestNewConfig(t *testing.T) {
 					SessionToken:    "token",
 				},
 			},
-			SubnetID: "subnet_id",
-			Region:   "region",
+			SubnetID: "subnet-0123456789abcdef0",
+			Region:   "us-east-1",
 		}, got, "NewConfig() returned unexpected content")
 	})
 
@@ -242,3 +1623,24 @@ func TestNewConfig(t *testing.T) {
 		require.Error(t, err, "NewConfig() expected an error, got none")
 	})
 }
+
+func TestJSONSchema(t *testing.T) {
+	schema, err := JSONSchema()
+	require.NoError(t, err)
+
+	var decoded struct {
+		Ref         string `json:"$ref"`
+		Definitions map[string]struct {
+			Properties map[string]any `json:"properties"`
+			Required   []string       `json:"required"`
+		} `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(schema), &decoded))
+	require.Equal(t, "#/$defs/Config", decoded.Ref)
+	config := decoded.Definitions["Config"]
+	require.Contains(t, config.Properties, "region")
+	require.Contains(t, config.Properties, "subnet_id")
+	require.Contains(t, config.Properties, "wait_for_running")
+	require.Empty(t, config.Required)
+	require.Contains(t, decoded.Definitions["Credentials"].Properties, "credential_type")
+}
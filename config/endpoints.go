@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Services of which the endpoint can be overridden, as used in the keys of
+// endpoint_urls.
+const (
+	ServiceEC2     = "ec2"
+	ServiceSTS     = "sts"
+	ServiceSSM     = "ssm"
+	ServiceS3      = "s3"
+	ServicePricing = "pricing"
+)
+
+var services = []string{ServiceEC2, ServiceSTS, ServiceSSM, ServiceS3, ServicePricing}
+
+func validateEndpointURL(endpointURL string) error {
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an absolute http or https URL", endpointURL)
+	}
+	return nil
+}
+
+func (c *Config) validateEndpointURLs() error {
+	if c.EndpointURL != "" {
+		if err := validateEndpointURL(c.EndpointURL); err != nil {
+			return fmt.Errorf("invalid endpoint_url: %w", err)
+		}
+	}
+	for _, service := range sortedKeys(c.EndpointURLs) {
+		if !slices.Contains(services, service) {
+			return fmt.Errorf("invalid endpoint_urls: unknown service %s", service)
+		}
+		if err := validateEndpointURL(c.EndpointURLs[service]); err != nil {
+			return fmt.Errorf("invalid endpoint_urls for %s: %w", service, err)
+		}
+	}
+	return nil
+}
+
+// BaseEndpoint returns the endpoint URL the client of the service should
+// use. resolved is the endpoint the SDK resolved for the client, which
+// already accounts for endpoint_url, and is returned if the endpoint of the
+// service isn't overridden.
+func (c *Config) BaseEndpoint(service string, resolved *string) *string {
+	if endpointURL, ok := c.EndpointURLs[service]; ok {
+		return aws.String(endpointURL)
+	}
+	return resolved
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var environmentNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Environment overrides where, and with which credentials, the instances of
+// the pools that select it through the environment extra spec are created.
+// Settings that are not set are inherited from the provider config.
+type Environment struct {
+	Region string `toml:"region"`
+	// SubnetID is the default subnet of the environment. Setting it also
+	// replaces FallbackSubnetIDs and SecurityGroupIDs of the provider
+	// config, which belong to its VPC.
+	SubnetID          string   `toml:"subnet_id"`
+	FallbackSubnetIDs []string `toml:"fallback_subnet_ids"`
+	SecurityGroupIDs  []string `toml:"security_group_ids"`
+	// Credentials are used instead of the credentials of the provider
+	// config if the credential type is set.
+	Credentials Credentials `toml:"credentials"`
+}
+
+// EnvironmentNames returns the names of the environments, in order.
+func (c *Config) EnvironmentNames() []string {
+	return sortedKeys(c.Environments)
+}
+
+// ForEnvironment returns the config of the named environment: a copy of the
+// config with the settings of the environment applied.
+func (c *Config) ForEnvironment(name string) (*Config, error) {
+	env, ok := c.Environments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment %q", name)
+	}
+
+	cfg := *c
+	cfg.Environments = nil
+	if env.Region != "" {
+		cfg.Region = env.Region
+	}
+	if env.SubnetID != "" {
+		cfg.SubnetID = env.SubnetID
+		cfg.FallbackSubnetIDs = env.FallbackSubnetIDs
+		cfg.SecurityGroupIDs = env.SecurityGroupIDs
+	}
+	if env.Credentials.CredentialType != "" {
+		cfg.Credentials = env.Credentials
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validateEnvironments() error {
+	var errs []error
+	for _, name := range c.EnvironmentNames() {
+		if !environmentNameRe.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid environment name %q", name))
+			continue
+		}
+		env := c.Environments[name]
+		if env.SubnetID == "" && (len(env.FallbackSubnetIDs) > 0 || len(env.SecurityGroupIDs) > 0) {
+			errs = append(errs, fmt.Errorf("environment %s: fallback_subnet_ids and security_group_ids require subnet_id", name))
+		}
+		// Subnets belong to a region.
+		if env.Region != "" && env.Region != c.Region && env.SubnetID == "" {
+			errs = append(errs, fmt.Errorf("environment %s: a region other than the one of the provider config requires subnet_id", name))
+		}
+		if env.Region != "" {
+			if err := ValidateRegion(env.Region, c.AllowUnknownRegions); err != nil {
+				errs = append(errs, fmt.Errorf("environment %s: %w", name, err))
+			}
+		}
+		if env.Credentials.CredentialType != "" {
+			if err := c.validateCredentials(env.Credentials); err != nil {
+				errs = append(errs, fmt.Errorf("environment %s: failed to validate credentials: %w", name, err))
+			}
+		}
+		// Validated when the environment is loaded.
+		cfg, _ := c.ForEnvironment(name)
+		if err := cfg.validateResourceIDs(); err != nil {
+			errs = append(errs, fmt.Errorf("environment %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
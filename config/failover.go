@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	CredentialsPrimary   = "primary"
+	CredentialsSecondary = "secondary"
+)
+
+// authErrorCodes are the error codes AWS services return when they reject
+// the credentials a request was signed with, as opposed to the permissions
+// of the identity.
+var authErrorCodes = map[string]bool{
+	"AuthFailure":                 true,
+	"ExpiredToken":                true,
+	"InvalidAccessKeyId":          true,
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+func isAuthErr(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && authErrorCodes[apiErr.ErrorCode()]
+}
+
+// FailoverCredentials provides the primary static credentials until AWS
+// rejects them, and the secondary ones from then on. This allows rotating
+// keys one at a time, without a window in which the provider has none that
+// work.
+type FailoverCredentials struct {
+	primary    aws.Credentials
+	secondary  aws.Credentials
+	failedOver atomic.Bool
+}
+
+func newFailoverCredentials(primary, secondary StaticCredentials) *FailoverCredentials {
+	toCredentials := func(c StaticCredentials) aws.Credentials {
+		return aws.Credentials{
+			AccessKeyID:     c.AccessKeyID,
+			SecretAccessKey: c.SecretAccessKey,
+			SessionToken:    c.SessionToken,
+			Source:          "StaticFailoverCredentials",
+		}
+	}
+	return &FailoverCredentials{
+		primary:   toCredentials(primary),
+		secondary: toCredentials(secondary),
+	}
+}
+
+func (f *FailoverCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if f.failedOver.Load() {
+		return f.secondary, nil
+	}
+	return f.primary, nil
+}
+
+// Active returns which of the credential sets is in use.
+func (f *FailoverCredentials) Active() string {
+	if f.failedOver.Load() {
+		return CredentialsSecondary
+	}
+	return CredentialsPrimary
+}
+
+// failover switches to the secondary credentials.
+func (f *FailoverCredentials) failover(err error) {
+	if f.failedOver.CompareAndSwap(false, true) {
+		slog.Warn("primary credentials were rejected, using the secondary credentials", "error", err)
+	}
+}
+
+// failoverMiddleware repeats operations that were rejected because of the
+// primary credentials, with the secondary ones. It runs before the request
+// is serialized and signed, so the repeated operation is signed with the
+// credentials retrieved again. The SDK retryer can not do this, as the
+// credentials are only retrieved once, before its attempts.
+type failoverMiddleware struct {
+	credentials *FailoverCredentials
+}
+
+func (m failoverMiddleware) ID() string {
+	return "CredentialsFailover"
+}
+
+func (m failoverMiddleware) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+	middleware.InitializeOutput, middleware.Metadata, error,
+) {
+	active := m.credentials.Active()
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	if err == nil || active != CredentialsPrimary || !isAuthErr(err) {
+		return out, metadata, err
+	}
+	m.credentials.failover(err)
+	return next.HandleInitialize(ctx, in)
+}
+
+// withFailover returns the API option adding the failover middleware to
+// every client created from the config.
+func withFailover(credentials *FailoverCredentials) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(failoverMiddleware{credentials: credentials}, middleware.Before)
+	}
+}
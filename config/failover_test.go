@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAWSConfigFailsOverToSecondaryCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The access key ID is part of the Credential field of the
+		// signature.
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Contains(auth, "Credential=primary/") {
+			keys = append(keys, "primary")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>AuthFailure</Code><Message>AWS was not able to validate the provided access credentials</Message></Error></Errors></Response>`)
+			return
+		}
+		keys = append(keys, "secondary")
+		fmt.Fprint(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet/></DescribeInstancesResponse>`)
+	}))
+	defer server.Close()
+
+	cfg := Config{
+		Region: "us-east-1",
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeStatic,
+			StaticCredentials: StaticCredentials{
+				AccessKeyID:     "primary",
+				SecretAccessKey: "secret",
+			},
+			SecondaryStaticCredentials: StaticCredentials{
+				AccessKeyID:     "secondary",
+				SecretAccessKey: "secret",
+			},
+		},
+	}
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+
+	client := ec2.NewFromConfig(awsCfg, func(o *ec2.Options) {
+		o.BaseEndpoint = aws.String(server.URL)
+	})
+	_, err = client.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"primary", "secondary"}, keys)
+
+	failover, ok := awsCfg.Credentials.(*FailoverCredentials)
+	require.True(t, ok)
+	require.Equal(t, CredentialsSecondary, failover.Active())
+}
+
+func TestGetAWSConfigAssumesRole(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Form.Get("Action") == "AssumeRole" {
+			// Assumed with the static credentials.
+			calls = append(calls, fmt.Sprintf("AssumeRole %s %s %s", r.Form.Get("RoleArn"), r.Form.Get("ExternalId"), r.Form.Get("RoleSessionName")))
+			require.Contains(t, auth, "Credential=static/")
+			fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials><AccessKeyId>assumed</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+			return
+		}
+		require.Contains(t, auth, "Credential=assumed/")
+		calls = append(calls, r.Form.Get("Action"))
+		fmt.Fprint(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet/></DescribeInstancesResponse>`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+
+	cfg := Config{
+		Region: "us-east-1",
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeStatic,
+			StaticCredentials: StaticCredentials{
+				AccessKeyID:     "static",
+				SecretAccessKey: "secret",
+			},
+			AssumeRole: AssumeRoleCredentials{
+				RoleARN:    "arn:aws:iam::123456789012:role/garm",
+				ExternalID: "sample-external-id",
+			},
+		},
+	}
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+
+	client := ec2.NewFromConfig(awsCfg)
+	for range 2 {
+		_, err = client.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+		require.NoError(t, err)
+	}
+	// The credentials of the role are cached until they expire.
+	require.Equal(t, []string{
+		"AssumeRole arn:aws:iam::123456789012:role/garm sample-external-id garm-provider-aws",
+		"DescribeInstances",
+		"DescribeInstances",
+	}, calls)
+}
+
+func TestGetAWSConfigAssumesRoleInSTSRegion(t *testing.T) {
+	var mu sync.Mutex
+	var regions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		// The region is part of the Credential field of the signature.
+		credential := strings.Split(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/")
+		mu.Lock()
+		defer mu.Unlock()
+		regions = append(regions, r.Form.Get("Action")+" "+credential[2])
+		if r.Form.Get("Action") == "AssumeRole" {
+			fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials><AccessKeyId>assumed</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet/></DescribeInstancesResponse>`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+
+	cfg := Config{
+		Region: "us-east-1",
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeStatic,
+			StaticCredentials: StaticCredentials{
+				AccessKeyID:     "static",
+				SecretAccessKey: "secret",
+			},
+			AssumeRole: AssumeRoleCredentials{
+				RoleARN:   "arn:aws:iam::123456789012:role/garm",
+				STSRegion: "eu-west-1",
+			},
+		},
+	}
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+
+	_, err = ec2.NewFromConfig(awsCfg).DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"AssumeRole eu-west-1", "DescribeInstances us-east-1"}, regions)
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+//go:build ignore
+
+// gen_regions writes regions_generated.go from the partition metadata of the
+// vendored AWS SDK. Run it with go generate after updating the SDK.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+)
+
+const partitionsFile = "../vendor/github.com/aws/aws-sdk-go-v2/internal/endpoints/awsrulesfn/partitions.json"
+
+type partitions struct {
+	Partitions []struct {
+		ID          string              `json:"id"`
+		RegionRegex string              `json:"regionRegex"`
+		Regions     map[string]struct{} `json:"regions"`
+	} `json:"partitions"`
+}
+
+func main() {
+	data, err := os.ReadFile(partitionsFile)
+	if err != nil {
+		log.Fatalf("failed to read partitions: %v", err)
+	}
+	var metadata partitions
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		log.Fatalf("failed to decode partitions: %v", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen_regions.go from the partition metadata of the AWS SDK. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package config\n\nimport \"regexp\"\n\nvar partitions = []partition{\n")
+	for _, p := range metadata.Partitions {
+		regionRegex := regexp.MustCompile(p.RegionRegex)
+		// Pseudo regions like aws-global don't follow the naming scheme
+		// of the partition, and can't be used with EC2.
+		var regions []string
+		for region := range p.Regions {
+			if regionRegex.MatchString(region) {
+				regions = append(regions, region)
+			}
+		}
+		slices.Sort(regions)
+		fmt.Fprintf(&buf, "{\nid: %q,\nregionRegex: regexp.MustCompile(`%s`),\n", p.ID, p.RegionRegex)
+		if len(regions) > 0 {
+			fmt.Fprintf(&buf, "regions: []string{\n")
+			for _, region := range regions {
+				fmt.Fprintf(&buf, "%q,\n", region)
+			}
+			fmt.Fprintf(&buf, "},\n")
+		}
+		fmt.Fprintf(&buf, "},\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("failed to format regions: %v", err)
+	}
+	if err := os.WriteFile("regions_generated.go", src, 0o644); err != nil {
+		log.Fatalf("failed to write regions: %v", err)
+	}
+}
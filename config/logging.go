@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Environment variables that override the logging settings of the config.
+// They also apply before the config is loaded, and to the subcommands.
+const (
+	LogLevelEnvVar  = "GARM_PROVIDER_AWS_LOG_LEVEL"
+	LogFormatEnvVar = "GARM_PROVIDER_AWS_LOG_FORMAT"
+)
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Logging configures the logs the provider writes to stderr. GARM captures
+// the stderr of the provider when a call fails.
+type Logging struct {
+	// Level is one of debug, info, warn or error. Defaults to info. At the
+	// debug level, every call to AWS is logged with its request ID and
+	// latency.
+	Level string `toml:"level"`
+	// Format is either text or json. Defaults to text.
+	Format string `toml:"format"`
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", value)
+	}
+	return level, nil
+}
+
+func (l Logging) Validate() error {
+	if l.Level != "" {
+		if _, err := parseLogLevel(l.Level); err != nil {
+			return err
+		}
+	}
+	switch l.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown format: %s", l.Format)
+	}
+	return nil
+}
+
+// withEnv returns the settings with the ones set in the environment applied.
+func (l Logging) withEnv() Logging {
+	if level := os.Getenv(LogLevelEnvVar); level != "" {
+		l.Level = level
+	}
+	if format := os.Getenv(LogFormatEnvVar); format != "" {
+		l.Format = format
+	}
+	return l
+}
+
+// NewLogger returns a logger writing to w, with the settings in the
+// environment taking precedence. Invalid settings fall back to the default.
+func (l Logging) NewLogger(w io.Writer) *slog.Logger {
+	l = l.withEnv()
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	var levelErr error
+	if l.Level != "" {
+		var level slog.Level
+		if level, levelErr = parseLogLevel(l.Level); levelErr == nil {
+			opts.Level = level
+		}
+	}
+	logger := slog.New(slog.NewTextHandler(w, opts))
+	if l.Format == LogFormatJSON {
+		logger = slog.New(slog.NewJSONHandler(w, opts))
+	}
+	if levelErr != nil {
+		logger.Warn("using the info log level", "error", levelErr)
+	}
+	return logger
+}
+
+// callLoggingMiddleware logs every call to AWS at the debug level, once all
+// its attempts are done.
+type callLoggingMiddleware struct{}
+
+func (m callLoggingMiddleware) ID() string {
+	return "CallLogging"
+}
+
+func (m callLoggingMiddleware) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+	middleware.InitializeOutput, middleware.Metadata, error,
+) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return next.HandleInitialize(ctx, in)
+	}
+
+	start := time.Now()
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	attrs := []any{
+		"service", awsmiddleware.GetServiceID(ctx),
+		"operation", awsmiddleware.GetOperationName(ctx),
+		"latency", time.Since(start),
+	}
+	if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+		attrs = append(attrs, "request_id", requestID)
+	}
+	if err != nil {
+		if code := ErrorCode(err); code != "" {
+			attrs = append(attrs, "error_code", code)
+		}
+		attrs = append(attrs, "error", err)
+	}
+	slog.DebugContext(ctx, "aws call", attrs...)
+	return out, metadata, err
+}
+
+// withCallLogging adds the call logging middleware to every client created
+// from the config.
+func withCallLogging(stack *middleware.Stack) error {
+	return stack.Initialize.Add(callLoggingMiddleware{}, middleware.After)
+}
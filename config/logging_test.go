@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggingValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		logging   Logging
+		errString string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:    "valid",
+			logging: Logging{Level: "debug", Format: LogFormatJSON},
+		},
+		{
+			name:      "invalid level",
+			logging:   Logging{Level: "verbose"},
+			errString: `invalid log level "verbose"`,
+		},
+		{
+			name:      "invalid format",
+			logging:   Logging{Format: "xml"},
+			errString: "unknown format: xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.logging.Validate()
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoggingNewLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		logging  Logging
+		envLevel string
+		debug    bool
+		warn     bool
+		output   string
+	}{
+		{
+			name:   "defaults",
+			output: `level=INFO msg=message key=value`,
+		},
+		{
+			name:    "config",
+			logging: Logging{Level: "debug", Format: LogFormatJSON},
+			debug:   true,
+			output:  `"level":"INFO","msg":"message","key":"value"`,
+		},
+		{
+			name:     "environment takes precedence",
+			logging:  Logging{Level: "error"},
+			envLevel: "DEBUG",
+			debug:    true,
+			output:   `level=INFO msg=message key=value`,
+		},
+		{
+			name:     "invalid environment",
+			envLevel: "verbose",
+			warn:     true,
+			output:   `level=INFO msg=message key=value`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(LogLevelEnvVar, tt.envLevel)
+			t.Setenv(LogFormatEnvVar, "")
+
+			var out bytes.Buffer
+			logger := tt.logging.NewLogger(&out)
+			require.Equal(t, tt.debug, logger.Enabled(context.Background(), slog.LevelDebug))
+			require.Equal(t, tt.warn, bytes.Contains(out.Bytes(), []byte("using the info log level")))
+
+			logger.Info("message", "key", "value")
+			require.Contains(t, out.String(), tt.output)
+		})
+	}
+}
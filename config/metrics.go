@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/cloudbase/garm-provider-aws/internal/metrics"
+)
+
+// DefaultMetricsNamespace is the namespace of metrics if none is configured.
+const DefaultMetricsNamespace = "garm_provider_aws"
+
+// Metrics configures where the provider emits its operational metrics.
+// Metrics are only emitted if at least one destination is set.
+type Metrics struct {
+	// Namespace prefixes the names of statsd metrics, and is the namespace
+	// of EMF metrics. Defaults to garm_provider_aws.
+	Namespace string `toml:"namespace"`
+	// StatsdAddress is the host:port of a statsd server. Metrics are sent
+	// over UDP, with DogStatsD tags.
+	StatsdAddress string `toml:"statsd_address"`
+	// EMFFile is a file to which metrics are appended in the CloudWatch
+	// embedded metric format, for the CloudWatch agent to pick up.
+	EMFFile string `toml:"emf_file"`
+	// Diagnostics logs how long each operation took, and the AWS calls it
+	// made, to stderr.
+	Diagnostics bool `toml:"diagnostics"`
+}
+
+func (m Metrics) Validate() error {
+	if m.StatsdAddress != "" {
+		if _, _, err := net.SplitHostPort(m.StatsdAddress); err != nil {
+			return fmt.Errorf("invalid statsd_address: %w", err)
+		}
+	}
+	// The provider is run by GARM, so relative paths would depend on where
+	// GARM was started.
+	if m.EMFFile != "" && !filepath.IsAbs(m.EMFFile) {
+		return fmt.Errorf("emf_file %q is not an absolute path", m.EMFFile)
+	}
+	return nil
+}
+
+// NewRecorder returns a recorder emitting metrics where the config says.
+func (m Metrics) NewRecorder() (*metrics.Recorder, error) {
+	namespace := m.Namespace
+	if namespace == "" {
+		namespace = DefaultMetricsNamespace
+	}
+	return metrics.New(metrics.Options{
+		Namespace:     namespace,
+		StatsdAddress: m.StatsdAddress,
+		EMFFile:       m.EMFFile,
+	})
+}
+
+// ErrorCode returns the AWS error code of err, or an empty string if it did
+// not come from AWS.
+func ErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// callMetricsMiddleware counts every call to AWS, by outcome and error code,
+// and records its latency, once all its attempts are done. Calls are also
+// added to the diagnostics of the operation.
+type callMetricsMiddleware struct{}
+
+func (m callMetricsMiddleware) ID() string {
+	return "CallMetrics"
+}
+
+func (m callMetricsMiddleware) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+	middleware.InitializeOutput, middleware.Metadata, error,
+) {
+	start := time.Now()
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	tags := map[string]string{
+		"service": awsmiddleware.GetServiceID(ctx),
+		"api":     awsmiddleware.GetOperationName(ctx),
+		"outcome": "success",
+	}
+	if err != nil {
+		tags["outcome"] = "failure"
+		if code := ErrorCode(err); code != "" {
+			tags["error_code"] = code
+		}
+	}
+	latency := time.Since(start)
+	metrics.Count("aws_calls", 1, tags)
+	metrics.Timing("aws_call_latency", latency, tags)
+	metrics.RecordCall(tags["service"], tags["api"], latency, err != nil)
+	return out, metadata, err
+}
+
+// withCallMetrics adds the call metrics middleware to every client created
+// from the config.
+func withCallMetrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(callMetricsMiddleware{}, middleware.After)
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		metrics   Metrics
+		errString string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:    "valid",
+			metrics: Metrics{StatsdAddress: "127.0.0.1:8125", EMFFile: "/var/log/garm/metrics.json"},
+		},
+		{
+			name:      "statsd address without port",
+			metrics:   Metrics{StatsdAddress: "127.0.0.1"},
+			errString: "invalid statsd_address: address 127.0.0.1: missing port in address",
+		},
+		{
+			name:      "relative emf file",
+			metrics:   Metrics{EMFFile: "metrics.json"},
+			errString: `emf_file "metrics.json" is not an absolute path`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.metrics.Validate()
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
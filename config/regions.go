@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+)
+
+//go:generate go run gen_regions.go
+
+// partition holds the partition metadata of the AWS SDK
+// (internal/endpoints/awsrulesfn/partitions.json), which is not importable.
+// The table in regions_generated.go is generated from the vendored SDK.
+type partition struct {
+	id          string
+	regionRegex *regexp.Regexp
+	regions     []string
+}
+
+// maxSuggestionDistance is the largest edit distance at which a known region
+// is still suggested for an unknown one.
+const maxSuggestionDistance = 3
+
+// ValidateRegion checks that region is known to the AWS SDK. Regions that
+// are not known yet, but follow the naming scheme of a partition, are only
+// accepted if allowUnknown is set, with a warning, so that new regions can
+// be used before the SDK is updated, while typos like us-eats-1 are rejected.
+func ValidateRegion(region string, allowUnknown bool) error {
+	for _, partition := range partitions {
+		if slices.Contains(partition.regions, region) {
+			return nil
+		}
+	}
+
+	suggestion := suggestRegion(region)
+	err := fmt.Errorf("unknown region %q", region)
+	if suggestion != "" {
+		err = fmt.Errorf("unknown region %q, did you mean %q?", region, suggestion)
+	}
+	for _, partition := range partitions {
+		if !partition.regionRegex.MatchString(region) {
+			continue
+		}
+		if !allowUnknown {
+			return fmt.Errorf("%w (set allow_unknown_regions = true if the region is newer than the provider)", err)
+		}
+		args := []any{"region", region, "partition", partition.id}
+		if suggestion != "" {
+			args = append(args, "suggestion", suggestion)
+		}
+		slog.Warn("region is not known to the provider, assuming it is new", args...)
+		return nil
+	}
+	return err
+}
+
+// suggestRegion returns the known region closest to region, or an empty
+// string if none is within maxSuggestionDistance.
+func suggestRegion(region string) string {
+	var suggestion string
+	distance := maxSuggestionDistance + 1
+	for _, partition := range partitions {
+		for _, known := range partition.regions {
+			if d := levenshtein(region, known); d < distance {
+				suggestion, distance = known, d
+			}
+		}
+	}
+	return suggestion
+}
+
+// PartitionID returns the ID of the partition region belongs to, like
+// "aws-cn", or an empty string if it belongs to none.
+func PartitionID(region string) string {
+	for _, partition := range partitions {
+		if partition.regionRegex.MatchString(region) {
+			return partition.id
+		}
+	}
+	return ""
+}
+
+func isPartitionID(id string) bool {
+	for _, partition := range partitions {
+		if partition.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
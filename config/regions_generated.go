@@ -0,0 +1,85 @@
+// Code generated by gen_regions.go from the partition metadata of the AWS SDK. DO NOT EDIT.
+
+package config
+
+import "regexp"
+
+var partitions = []partition{
+	{
+		id:          "aws",
+		regionRegex: regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il)\-\w+\-\d+$`),
+		regions: []string{
+			"af-south-1",
+			"ap-east-1",
+			"ap-northeast-1",
+			"ap-northeast-2",
+			"ap-northeast-3",
+			"ap-south-1",
+			"ap-south-2",
+			"ap-southeast-1",
+			"ap-southeast-2",
+			"ap-southeast-3",
+			"ap-southeast-4",
+			"ca-central-1",
+			"ca-west-1",
+			"eu-central-1",
+			"eu-central-2",
+			"eu-north-1",
+			"eu-south-1",
+			"eu-south-2",
+			"eu-west-1",
+			"eu-west-2",
+			"eu-west-3",
+			"il-central-1",
+			"me-central-1",
+			"me-south-1",
+			"sa-east-1",
+			"us-east-1",
+			"us-east-2",
+			"us-west-1",
+			"us-west-2",
+		},
+	},
+	{
+		id:          "aws-cn",
+		regionRegex: regexp.MustCompile(`^cn\-\w+\-\d+$`),
+		regions: []string{
+			"cn-north-1",
+			"cn-northwest-1",
+		},
+	},
+	{
+		id:          "aws-us-gov",
+		regionRegex: regexp.MustCompile(`^us\-gov\-\w+\-\d+$`),
+		regions: []string{
+			"us-gov-east-1",
+			"us-gov-west-1",
+		},
+	},
+	{
+		id:          "aws-iso",
+		regionRegex: regexp.MustCompile(`^us\-iso\-\w+\-\d+$`),
+		regions: []string{
+			"us-iso-east-1",
+			"us-iso-west-1",
+		},
+	},
+	{
+		id:          "aws-iso-b",
+		regionRegex: regexp.MustCompile(`^us\-isob\-\w+\-\d+$`),
+		regions: []string{
+			"us-isob-east-1",
+		},
+	},
+	{
+		id:          "aws-iso-e",
+		regionRegex: regexp.MustCompile(`^eu\-isoe\-\w+\-\d+$`),
+		regions: []string{
+			"eu-isoe-west-1",
+		},
+	},
+	{
+		id:          "aws-iso-f",
+		regionRegex: regexp.MustCompile(`^us\-isof\-\w+\-\d+$`),
+	},
+}
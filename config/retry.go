@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// Retry modes of the AWS SDK.
+const (
+	// RetryModeStandard retries failed calls with exponential backoff.
+	// This is the default.
+	RetryModeStandard = "standard"
+	// RetryModeAdaptive also slows down the calls it makes once AWS starts
+	// throttling them.
+	RetryModeAdaptive = "adaptive"
+)
+
+// Retry configures how calls to AWS that fail with retryable errors, like
+// throttling, are retried.
+type Retry struct {
+	// Mode is either standard or adaptive. Defaults to standard.
+	Mode string `toml:"mode"`
+	// MaxAttempts is the number of times a call is attempted, including
+	// the first attempt. Defaults to 3.
+	MaxAttempts int `toml:"max_attempts"`
+	// MaxBackoff is the longest delay between two attempts, as a Go
+	// duration string. Defaults to 20s.
+	MaxBackoff string `toml:"max_backoff"`
+	// ThrottleMaxAttempts is the number of times a call that AWS keeps
+	// throttling, for example with RequestLimitExceeded, is attempted.
+	// Defaults to MaxAttempts.
+	ThrottleMaxAttempts int `toml:"throttle_max_attempts"`
+	// ThrottleMaxBackoff is the longest delay between two attempts of a
+	// throttled call, as a Go duration string. Defaults to MaxBackoff.
+	ThrottleMaxBackoff string `toml:"throttle_max_backoff"`
+}
+
+func (r Retry) Validate() error {
+	switch r.Mode {
+	case "", RetryModeStandard, RetryModeAdaptive:
+	default:
+		return fmt.Errorf("unknown mode: %s", r.Mode)
+	}
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	if r.ThrottleMaxAttempts < 0 {
+		return fmt.Errorf("throttle_max_attempts must not be negative")
+	}
+	for name, value := range map[string]string{
+		"max_backoff":          r.MaxBackoff,
+		"throttle_max_backoff": r.ThrottleMaxBackoff,
+	} {
+		if value == "" {
+			continue
+		}
+		backoff, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if backoff <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// retryer returns a new retryer, as configured.
+func (r Retry) retryer() aws.Retryer {
+	standardOptions := func(o *retry.StandardOptions) {
+		if r.MaxAttempts > 0 {
+			o.MaxAttempts = r.MaxAttempts
+		}
+		if r.MaxBackoff != "" {
+			// Validated when loading the config.
+			o.MaxBackoff, _ = time.ParseDuration(r.MaxBackoff)
+		}
+	}
+	var retryer aws.RetryerV2
+	if r.Mode == RetryModeAdaptive {
+		retryer = retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standardOptions)
+		})
+	} else {
+		retryer = retry.NewStandard(standardOptions)
+	}
+	if r.ThrottleMaxAttempts == 0 && r.ThrottleMaxBackoff == "" {
+		return retryer
+	}
+
+	throttled := &throttleRetryer{
+		RetryerV2:           retryer,
+		maxAttempts:         retryer.MaxAttempts(),
+		throttleMaxAttempts: retryer.MaxAttempts(),
+		backoff:             retry.NewExponentialJitterBackoff(retry.DefaultMaxBackoff),
+	}
+	if r.ThrottleMaxAttempts > 0 {
+		throttled.throttleMaxAttempts = r.ThrottleMaxAttempts
+	}
+	if r.ThrottleMaxBackoff != "" {
+		// Validated when loading the config.
+		maxBackoff, _ := time.ParseDuration(r.ThrottleMaxBackoff)
+		throttled.backoff = retry.NewExponentialJitterBackoff(maxBackoff)
+	} else if r.MaxBackoff != "" {
+		maxBackoff, _ := time.ParseDuration(r.MaxBackoff)
+		throttled.backoff = retry.NewExponentialJitterBackoff(maxBackoff)
+	}
+	return throttled
+}
+
+// throttleErrors tells throttling errors, like RequestLimitExceeded, apart.
+var throttleErrors = retry.IsErrorThrottles{
+	retry.ThrottleErrorCode{Codes: retry.DefaultThrottleErrorCodes},
+}
+
+// throttleRetryer retries throttled calls with their own number of attempts
+// and backoff, and other failures as the retryer it wraps does.
+type throttleRetryer struct {
+	aws.RetryerV2
+
+	maxAttempts         int
+	throttleMaxAttempts int
+	backoff             retry.BackoffDelayer
+}
+
+func (t *throttleRetryer) MaxAttempts() int {
+	return max(t.maxAttempts, t.throttleMaxAttempts)
+}
+
+// RetryDelay returns how long to wait before the next attempt. Returning the
+// error of the attempt stops retrying, with that error.
+func (t *throttleRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	if throttleErrors.IsErrorThrottle(err) == aws.TrueTernary {
+		if attempt >= t.throttleMaxAttempts {
+			return 0, err
+		}
+		return t.backoff.BackoffDelay(attempt, err)
+	}
+	if attempt >= t.maxAttempts {
+		return 0, err
+	}
+	return t.RetryerV2.RetryDelay(attempt, err)
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/invopop/jsonschema"
+)
+
+// JSONSchema returns the JSON schema of the provider config. Settings are
+// named after their TOML keys, and none are required by the schema, as
+// which are depends on the credential type.
+func JSONSchema() (string, error) {
+	reflector := jsonschema.Reflector{
+		FieldNameTag:               "toml",
+		RequiredFromJSONSchemaTags: true,
+	}
+	schema, err := json.MarshalIndent(reflector.Reflect(Config{}), "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode config schema: %w", err)
+	}
+	return string(schema), nil
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Substitution replaces Prefix with Replacement at the start of a value.
+type Substitution struct {
+	Prefix      string `toml:"prefix"`
+	Replacement string `toml:"replacement"`
+}
+
+// Substitutions holds the rewrites applied to instances created in a
+// partition, for example to use mirrors in the China partition, where
+// github.com is not reliably reachable.
+type Substitutions struct {
+	// ToolURLs rewrite the URL the runner tools are downloaded from. The
+	// checksum reported by GARM is still verified, so mirrors must serve
+	// identical archives.
+	ToolURLs []Substitution `toml:"tool_urls"`
+	// Images rewrite the image of pools, after image aliases are resolved.
+	// The result must be an AMI ID or an SSM reference.
+	Images []Substitution `toml:"images"`
+}
+
+// substitute applies the first rule whose prefix matches value. Values no
+// rule matches are returned unchanged.
+func substitute(rules []Substitution, value string) string {
+	for _, rule := range rules {
+		if rest, ok := strings.CutPrefix(value, rule.Prefix); ok {
+			return rule.Replacement + rest
+		}
+	}
+	return value
+}
+
+func (s Substitutions) Validate() error {
+	for _, rule := range s.ToolURLs {
+		if rule.Prefix == "" {
+			return fmt.Errorf("tool_urls: missing prefix")
+		}
+		u, err := url.Parse(rule.Replacement)
+		if err != nil {
+			return fmt.Errorf("tool_urls: invalid replacement for %s: %w", rule.Prefix, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("tool_urls: replacement for %s must be an http or https URL", rule.Prefix)
+		}
+	}
+	for _, rule := range s.Images {
+		if rule.Prefix == "" {
+			return fmt.Errorf("images: missing prefix")
+		}
+		if !strings.HasPrefix(rule.Replacement, "ami-") && !strings.HasPrefix(rule.Replacement, "ssm:") {
+			return fmt.Errorf("images: replacement %q for %s must be an AMI ID or an SSM reference", rule.Replacement, rule.Prefix)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateSubstitutions() error {
+	for _, id := range sortedKeys(c.Substitutions) {
+		if !isPartitionID(id) {
+			return fmt.Errorf("unknown partition %q", id)
+		}
+		if err := c.Substitutions[id].Validate(); err != nil {
+			return fmt.Errorf("invalid substitutions for partition %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// SubstituteToolURL applies the tool URL substitutions of the partition of
+// the configured region to toolURL.
+func (c *Config) SubstituteToolURL(toolURL string) string {
+	return substitute(c.Substitutions[PartitionID(c.Region)].ToolURLs, toolURL)
+}
+
+// SubstituteImage applies the image substitutions of the partition of the
+// configured region to image.
+func (c *Config) SubstituteImage(image string) string {
+	return substitute(c.Substitutions[PartitionID(c.Region)].Images, image)
+}
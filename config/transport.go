@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func (c *Config) validateTransport() error {
+	// The provider is run by GARM, so relative paths would depend on where
+	// GARM was started.
+	if c.CABundle != "" && !path.IsAbs(c.CABundle) {
+		return fmt.Errorf("ca_bundle %q is not an absolute path", c.CABundle)
+	}
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy_url: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid proxy_url %q: must be an http, https or socks5 URL", c.ProxyURL)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("invalid proxy_url %q: missing host", c.ProxyURL)
+		}
+	}
+	for _, timeout := range []struct{ name, value string }{
+		{"connect_timeout", c.ConnectTimeout},
+		{"request_timeout", c.RequestTimeout},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(timeout.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", timeout.name, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("%s must be positive", timeout.name)
+		}
+	}
+	return nil
+}
+
+// GetConnectTimeout returns the configured connect timeout, or zero to use
+// the default of the SDK.
+func (c Config) GetConnectTimeout() time.Duration {
+	// Validated when loading the config.
+	timeout, _ := time.ParseDuration(c.ConnectTimeout)
+	return timeout
+}
+
+// GetRequestTimeout returns the configured request timeout, or zero if
+// requests don't time out.
+func (c Config) GetRequestTimeout() time.Duration {
+	// Validated when loading the config.
+	timeout, _ := time.ParseDuration(c.RequestTimeout)
+	return timeout
+}
+
+// isLocalHost returns true for the hosts that are never reached through the
+// proxy: the instance metadata service and container credential endpoints,
+// which are link-local, and the loopback addresses.
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.Equal(imdsIPv6))
+}
+
+// imdsIPv6 is the IPv6 address of the instance metadata service, which is
+// not link-local.
+var imdsIPv6 = net.ParseIP("fd00:ec2::254")
+
+// rootCAs returns the system certificate pool with the certificates of the
+// CA bundle added, or nil if no CA bundle is configured.
+func (c Config) rootCAs() (*x509.CertPool, error) {
+	if c.CABundle == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(c.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca_bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ca_bundle %s", c.CABundle)
+	}
+	return pool, nil
+}
+
+// httpClient returns the client AWS is called with, or nil to use the
+// default client of the SDK.
+func (c Config) httpClient() (aws.HTTPClient, error) {
+	if c.CABundle == "" && c.ProxyURL == "" && c.ConnectTimeout == "" && c.RequestTimeout == "" {
+		return nil, nil
+	}
+
+	rootCAs, err := c.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+	var proxy func(*http.Request) (*url.URL, error)
+	if c.ProxyURL != "" {
+		// Validated when loading the config.
+		proxyURL, _ := url.Parse(c.ProxyURL)
+		proxy = func(r *http.Request) (*url.URL, error) {
+			if isLocalHost(r.URL.Hostname()) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+
+	client := awshttp.NewBuildableClient()
+	if timeout := c.GetConnectTimeout(); timeout > 0 {
+		client = client.WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = timeout
+		})
+	}
+	if timeout := c.GetRequestTimeout(); timeout > 0 {
+		// Every attempt of a call times out on its own.
+		client = client.WithTimeout(timeout)
+	}
+
+	return client.WithTransportOptions(func(tr *http.Transport) {
+		if rootCAs != nil {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.RootCAs = rootCAs
+		}
+		if proxy != nil {
+			tr.Proxy = proxy
+		}
+	}), nil
+}
+
+// HTTPClient returns the client HTTP calls the provider makes outside of the
+// SDK, like those to the lifecycle webhook, are made with, so that they
+// honour ca_bundle, proxy_url and the timeouts as well.
+func (c Config) HTTPClient() (aws.HTTPClient, error) {
+	client, err := c.httpClient()
+	if err != nil || client != nil {
+		return client, err
+	}
+	return awshttp.NewBuildableClient(), nil
+}
+
+// loadOptions returns the options LoadDefaultConfig is called with,
+// whatever the credential type.
+func (c Config) loadOptions() ([]func(*config.LoadOptions) error, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(c.Region),
+		config.WithAPIOptions([]func(*middleware.Stack) error{withCallLogging, withCallMetrics}),
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		opts = append(opts, config.WithHTTPClient(client))
+	}
+	if c.Retry != (Retry{}) {
+		// Every client gets its own retryer, so clients don't share their
+		// retry quota.
+		opts = append(opts, config.WithRetryer(c.Retry.retryer))
+	}
+	return opts, nil
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		caBundle  string
+		proxyURL  string
+		timeouts  [2]string
+		errString string
+	}{
+		{
+			name:     "valid transport",
+			caBundle: "/etc/garm/ca.pem",
+			proxyURL: "http://proxy.example.com:3128",
+			timeouts: [2]string{"5s", "1m"},
+		},
+		{
+			name:      "invalid connect_timeout",
+			timeouts:  [2]string{"5"},
+			errString: `invalid connect_timeout: time: missing unit in duration "5"`,
+		},
+		{
+			name:      "negative request_timeout",
+			timeouts:  [2]string{"5s", "-1m"},
+			errString: "request_timeout must be positive",
+		},
+		{
+			name:      "relative ca_bundle",
+			caBundle:  "ca.pem",
+			errString: `ca_bundle "ca.pem" is not an absolute path`,
+		},
+		{
+			name:      "unsupported proxy scheme",
+			proxyURL:  "ftp://proxy.example.com",
+			errString: `invalid proxy_url "ftp://proxy.example.com": must be an http, https or socks5 URL`,
+		},
+		{
+			name:      "proxy without host",
+			proxyURL:  "socks5://",
+			errString: `invalid proxy_url "socks5://": missing host`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				CABundle:       tt.caBundle,
+				ProxyURL:       tt.proxyURL,
+				ConnectTimeout: tt.timeouts[0],
+				RequestTimeout: tt.timeouts[1],
+			}
+			err := c.validateTransport()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestIsLocalHost(t *testing.T) {
+	for host, expected := range map[string]bool{
+		"localhost":                   true,
+		"127.0.0.1":                   true,
+		"169.254.169.254":             true,
+		"169.254.170.2":               true,
+		"fd00:ec2::254":               true,
+		"ec2.us-east-1.amazonaws.com": false,
+		"10.0.0.1":                    false,
+	} {
+		require.Equal(t, expected, isLocalHost(host), host)
+	}
+}
+
+func describeInstancesHandler(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprint(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet/></DescribeInstancesResponse>`)
+}
+
+func staticConfig() Config {
+	return Config{
+		Region: "us-east-1",
+		Credentials: Credentials{
+			CredentialType: AWSCredentialTypeStatic,
+			StaticCredentials: StaticCredentials{
+				AccessKeyID:     "static",
+				SecretAccessKey: "secret",
+			},
+		},
+	}
+}
+
+func TestGetAWSConfigCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(describeInstancesHandler))
+	defer server.Close()
+
+	cfg := staticConfig()
+	cfg.EndpointURL = server.URL
+	// Certificate errors are retried.
+	cfg.Retry.MaxAttempts = 1
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+	_, err = ec2.NewFromConfig(awsCfg).DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.ErrorContains(t, err, "certificate")
+
+	cfg.CABundle = filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(cfg.CABundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+	awsCfg, err = cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+	_, err = ec2.NewFromConfig(awsCfg).DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(cfg.CABundle, []byte("not a certificate"), 0o600))
+	_, err = cfg.GetAWSConfig(context.Background())
+	require.EqualError(t, err, fmt.Sprintf("failed to get aws config: no certificates found in ca_bundle %s", cfg.CABundle))
+}
+
+func TestGetAWSConfigProxy(t *testing.T) {
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		describeInstancesHandler(w, r)
+	}))
+	defer proxy.Close()
+
+	cfg := staticConfig()
+	cfg.EndpointURL = "http://ec2.example.invalid"
+	cfg.ProxyURL = proxy.URL
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+	_, err = ec2.NewFromConfig(awsCfg).DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ec2.example.invalid"}, hosts)
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+	}))
+	defer proxy.Close()
+
+	cfg := staticConfig()
+	client, err := cfg.HTTPClient()
+	require.NoError(t, err)
+	require.NotNil(t, client)
+
+	cfg.ProxyURL = proxy.URL
+	client, err = cfg.HTTPClient()
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "http://webhook.example.invalid/events", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{"webhook.example.invalid"}, hosts)
+}
+
+func TestGetAWSConfigRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hangs until the test is over.
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	cfg := staticConfig()
+	cfg.EndpointURL = server.URL
+	cfg.RequestTimeout = "50ms"
+	cfg.Retry.MaxAttempts = 1
+	awsCfg, err := cfg.GetAWSConfig(context.Background())
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = ec2.NewFromConfig(awsCfg).DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	require.ErrorContains(t, err, "Client.Timeout exceeded")
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// validateWaiters checks that the waiter timeouts are positive durations.
+func (c *Config) validateWaiters() error {
+	for _, waiter := range []struct{ name, value string }{
+		{"wait_for_running", c.WaitForRunning},
+		{"wait_for_stopped", c.WaitForStopped},
+		{"wait_for_terminated", c.WaitForTerminated},
+	} {
+		if waiter.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(waiter.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", waiter.name, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("%s must be positive", waiter.name)
+		}
+	}
+	return nil
+}
+
+// GetWaitForRunning returns how long CreateInstance waits for new instances
+// to be running, or zero if it doesn't wait.
+func (c *Config) GetWaitForRunning() time.Duration {
+	// Validated when loading the config.
+	timeout, _ := time.ParseDuration(c.WaitForRunning)
+	return timeout
+}
+
+// GetWaitForStopped returns how long Stop waits for instances to be stopped,
+// or zero if it doesn't wait.
+func (c *Config) GetWaitForStopped() time.Duration {
+	// Validated when loading the config.
+	timeout, _ := time.ParseDuration(c.WaitForStopped)
+	return timeout
+}
+
+// GetWaitForTerminated returns how long DeleteInstance waits for instances to
+// be terminated, or zero if it doesn't wait.
+func (c *Config) GetWaitForTerminated() time.Duration {
+	// Validated when loading the config.
+	timeout, _ := time.ParseDuration(c.WaitForTerminated)
+	return timeout
+}
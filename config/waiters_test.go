@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWaiters(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		errString string
+	}{
+		{
+			name: "no waiters",
+		},
+		{
+			name:   "valid waiters",
+			config: Config{WaitForRunning: "5m", WaitForStopped: "2m", WaitForTerminated: "90s"},
+		},
+		{
+			name:      "invalid wait_for_running",
+			config:    Config{WaitForRunning: "5"},
+			errString: `invalid wait_for_running: time: missing unit in duration "5"`,
+		},
+		{
+			name:      "zero wait_for_running",
+			config:    Config{WaitForRunning: "0s"},
+			errString: "wait_for_running must be positive",
+		},
+		{
+			name:      "negative wait_for_terminated",
+			config:    Config{WaitForTerminated: "-1m"},
+			errString: "wait_for_terminated must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateWaiters()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestGetWaiters(t *testing.T) {
+	require.Zero(t, (&Config{}).GetWaitForRunning())
+	require.Zero(t, (&Config{}).GetWaitForStopped())
+	require.Zero(t, (&Config{}).GetWaitForTerminated())
+
+	cfg := &Config{WaitForRunning: "5m", WaitForStopped: "2m", WaitForTerminated: "90s"}
+	require.Equal(t, 5*time.Minute, cfg.GetWaitForRunning())
+	require.Equal(t, 2*time.Minute, cfg.GetWaitForStopped())
+	require.Equal(t, 90*time.Second, cfg.GetWaitForTerminated())
+}
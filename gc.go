@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+)
+
+// runGC terminates the orphaned, expired and failed instances of a
+// controller, and writes them to stdout as JSON.
+func runGC(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	controllerID := flags.String("controller-id", "", "the ID of the GARM controller whose instances are collected")
+	pools := flags.String("pools", "", "comma separated IDs of the pools the controller still has")
+	maxAge := flags.Duration("max-age", 0, "terminate instances older than this")
+	expired := flags.Bool("expired", true, "terminate instances whose keep_on_failure TTL or deletion grace period ran out, or that exceeded their max_runtime")
+	failedGracePeriod := flags.Duration("failed-grace-period", time.Hour, "terminate instances that failed to bootstrap longer ago than this, or 0 to keep them")
+	dryRun := flags.Bool("dry-run", false, "only report orphaned instances")
+	environment := flags.String("environment", "", "the environment to collect instances in, instead of the provider config")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+	if *controllerID == "" {
+		return fmt.Errorf("missing -controller-id")
+	}
+	if *maxAge < 0 {
+		return fmt.Errorf("-max-age must not be negative")
+	}
+	if *failedGracePeriod < 0 {
+		return fmt.Errorf("-failed-grace-period must not be negative")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	if *environment != "" {
+		if conf, err = conf.ForEnvironment(*environment); err != nil {
+			return err
+		}
+	}
+	awsCli, err := client.NewAwsCli(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS CLI: %w", err)
+	}
+
+	opts := client.GCOptions{
+		MaxAge:            *maxAge,
+		Expired:           *expired,
+		FailedGracePeriod: *failedGracePeriod,
+		DryRun:            *dryRun,
+	}
+	for _, pool := range strings.Split(*pools, ",") {
+		if pool = strings.TrimSpace(pool); pool != "" {
+			opts.Pools = append(opts.Pools, pool)
+		}
+	}
+
+	orphans, gcErr := awsCli.CollectGarbage(ctx, *controllerID, opts)
+	if orphans != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(orphans); err != nil {
+			return fmt.Errorf("failed to write orphans: %w", err)
+		}
+	}
+	if gcErr != nil {
+		return fmt.Errorf("failed to collect garbage: %w", gcErr)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+)
+
+// runHealthcheck checks that the provider can talk to AWS and is allowed to
+// make the calls it needs, and writes a report to stdout. It fails if any
+// check fails.
+func runHealthcheck(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	image := flags.String("image", "", "an image to check launches with; may be an SSM reference or an image alias")
+	flavor := flags.String("flavor", "t3.nano", "the instance type to check launches with")
+	jsonOutput := flags.Bool("json", false, "write the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	awsCli, err := client.NewAwsCli(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS CLI: %w", err)
+	}
+
+	report := awsCli.HealthCheck(ctx, client.HealthCheckOptions{
+		Image:  *image,
+		Flavor: *flavor,
+	})
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	} else {
+		fmt.Printf("region: %s\n", report.Region)
+		for _, check := range report.Checks {
+			status := "OK"
+			if !check.Healthy {
+				status = "FAIL"
+			}
+			fmt.Printf("%-4s %s: %s\n", status, check.Name, check.Detail)
+		}
+	}
+
+	if !report.Healthy {
+		return fmt.Errorf("health check failed")
+	}
+	return nil
+}
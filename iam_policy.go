@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//	Licensed under the Apache License, Version 2.0 (the "License"); you may
+//	not use this file except in compliance with the License. You may obtain
+//	a copy of the License at
+//
+//	     http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//	WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//	License for the specific language governing permissions and limitations
+//	under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+)
+
+// runIAMPolicy writes the IAM policy needed by the given config to stdout.
+// No calls are made to AWS.
+func runIAMPolicy(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("iam-policy", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	controllerID := flags.String("controller-id", "", "only allow managing instances tagged with this GARM controller ID")
+	ssmParameters := flags.Bool("ssm-parameters", false, "pools use ssm: references for images, subnets or security groups")
+	ssmDocuments := flags.Bool("ssm-documents", false, "pools use the ssm_documents extra spec")
+	securityGroupLookup := flags.Bool("security-group-lookup", false, "pools use the security_group_names or security_group_tags extra specs")
+	sharedVolumes := flags.Bool("shared-volumes", false, "pools use the shared_volume extra spec")
+	cacheVolumes := flags.Bool("cache-volumes", false, "pools use the cache_pool_size extra spec")
+	fleet := flags.Bool("fleet", false, "pools set the launch_mode extra spec to fleet")
+	ephemeralSSHKeys := flags.Bool("ephemeral-ssh-keys", false, "pools use the ephemeral_ssh_key extra spec")
+	serialConsole := flags.Bool("serial-console", false, "pools use the serial_console extra spec")
+	kmsKeys := flags.String("kms-keys", "", "comma separated ARNs of the customer managed keys pools encrypt volumes with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	opts := client.PolicyOptions{
+		ControllerID:        *controllerID,
+		SSMParameters:       *ssmParameters,
+		SSMDocuments:        *ssmDocuments,
+		SecurityGroupLookup: *securityGroupLookup,
+		SharedVolumes:       *sharedVolumes,
+		CacheVolumes:        *cacheVolumes,
+		Fleet:               *fleet,
+		EphemeralSSHKeys:    *ephemeralSSHKeys,
+		SerialConsole:       *serialConsole,
+	}
+	for _, arn := range strings.Split(*kmsKeys, ",") {
+		if arn = strings.TrimSpace(arn); arn != "" {
+			if !strings.HasPrefix(arn, "arn:") {
+				return fmt.Errorf("invalid kms key ARN %q", arn)
+			}
+			opts.KMSKeyARNs = append(opts.KMSKeyARNs, arn)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(client.IAMPolicy(conf, opts)); err != nil {
+		return fmt.Errorf("failed to write policy: %w", err)
+	}
+	return nil
+}
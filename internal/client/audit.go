@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type STSClientInterface interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+type auditEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Caller     string    `json:"caller,omitempty"`
+	Action     string    `json:"action"`
+	InstanceID string    `json:"instance_id"`
+	Reason     string    `json:"reason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	// Credentials is the static credential set that was in use, if a
+	// secondary one is configured.
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// callerIdentity returns the ARN of the identity the provider uses to talk to
+// AWS. The result is cached for the lifetime of the client.
+func (a *AwsCli) callerIdentity(ctx context.Context) (string, error) {
+	if a.callerARN != "" {
+		return a.callerARN, nil
+	}
+
+	if a.sts == nil {
+		return "", fmt.Errorf("sts client is not initialized")
+	}
+
+	resp, err := a.sts.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	if resp.Arn != nil {
+		a.callerARN = *resp.Arn
+	}
+	if resp.Account != nil {
+		a.callerAccount = *resp.Account
+	}
+	return a.callerARN, nil
+}
+
+// callerAccountID returns the ID of the account the provider uses.
+func (a *AwsCli) callerAccountID(ctx context.Context) (string, error) {
+	if _, err := a.callerIdentity(ctx); err != nil {
+		return "", err
+	}
+	if a.callerAccount == "" {
+		return "", fmt.Errorf("failed to determine caller account")
+	}
+	return a.callerAccount, nil
+}
+
+// audit appends an entry for a start, stop or terminate operation to the
+// audit log, if one is configured. The outcome of the operation is recorded
+// along with it. Failing to write the audit log does not fail the operation.
+func (a *AwsCli) audit(ctx context.Context, action, instanceID, reason string, opErr error) {
+	if a.cfg.AuditLogFile == "" {
+		return
+	}
+
+	entry := auditEntry{
+		Timestamp:  time.Now().UTC(),
+		Action:     action,
+		InstanceID: instanceID,
+		Reason:     reason,
+	}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+
+	caller, err := a.callerIdentity(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to determine caller identity for audit log", "error", err)
+	}
+	entry.Caller = caller
+	if a.credentials != nil {
+		entry.Credentials = a.credentials.Active()
+	}
+
+	if err := writeAuditEntry(a.cfg.AuditLogFile, entry); err != nil {
+		slog.WarnContext(ctx, "failed to write audit log", "error", err)
+	}
+}
+
+func writeAuditEntry(path string, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	// A single write of a whole line keeps entries from concurrent provider
+	// invocations from being interleaved.
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
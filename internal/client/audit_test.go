@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry auditEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	instanceID := "i-1234567890abcdef0"
+	callerARN := "arn:aws:sts::123456789012:assumed-role/garm/provider"
+
+	mockClient := new(MockComputeClient)
+	mockSTS := new(MockSTSClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:       "us-west-2",
+			SubnetID:     "subnet-1234567890abcdef0",
+			AuditLogFile: auditLog,
+		},
+		client: mockClient,
+		sts:    mockSTS,
+	}
+
+	mockSTS.On("GetCallerIdentity", ctx, mock.Anything, mock.Anything).Return(&sts.GetCallerIdentityOutput{
+		Arn: aws.String(callerARN),
+	}, nil).Once()
+	mockClient.On("StopInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.StopInstancesOutput{}, nil)
+	mockClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, fmt.Errorf("access denied"))
+
+	require.NoError(t, awsCli.StopInstance(ctx, instanceID, "test reason"))
+	require.Error(t, awsCli.TerminateInstance(ctx, instanceID, "test reason"))
+
+	entries := readAuditLog(t, auditLog)
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "stop", entries[0].Action)
+	require.Equal(t, instanceID, entries[0].InstanceID)
+	require.Equal(t, callerARN, entries[0].Caller)
+	require.Equal(t, "test reason", entries[0].Reason)
+	require.Empty(t, entries[0].Error)
+	require.False(t, entries[0].Timestamp.IsZero())
+
+	require.Equal(t, "terminate", entries[1].Action)
+	require.Equal(t, callerARN, entries[1].Caller)
+	require.Equal(t, "access denied", entries[1].Error)
+
+	// The caller identity is looked up only once.
+	mockSTS.AssertExpectations(t)
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	mockSTS := new(MockSTSClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+		sts:    mockSTS,
+	}
+	mockClient.On("StartInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.StartInstancesOutput{}, nil)
+
+	require.NoError(t, awsCli.StartInstance(ctx, "i-1234567890abcdef0", "test reason"))
+	mockSTS.AssertNotCalled(t, "GetCallerIdentity", mock.Anything, mock.Anything, mock.Anything)
+}
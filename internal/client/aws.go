@@ -18,30 +18,74 @@ package client
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/cloudbase/garm-provider-aws/config"
 	"github.com/cloudbase/garm-provider-aws/internal/spec"
 	"github.com/cloudbase/garm-provider-aws/internal/util"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/cloudbase/garm-provider-common/errors"
 )
 
+// instanceRunningTimeout is the maximum time to wait for a new instance to
+// reach the running state.
+const instanceRunningTimeout = 5 * time.Minute
+
 func NewAwsCli(ctx context.Context, cfg *config.Config) (*AwsCli, error) {
 	cliCfg, err := cfg.GetAWSConfig(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get AWS cli context: %w", err)
 	}
 
-	client := ec2.NewFromConfig(cliCfg)
+	client := ec2.NewFromConfig(cliCfg, func(o *ec2.Options) {
+		o.BaseEndpoint = cfg.BaseEndpoint(config.ServiceEC2, o.BaseEndpoint)
+	})
 	awsCli := &AwsCli{
 		cfg:    cfg,
 		client: client,
+		ssm: ssm.NewFromConfig(cliCfg, func(o *ssm.Options) {
+			o.BaseEndpoint = cfg.BaseEndpoint(config.ServiceSSM, o.BaseEndpoint)
+		}),
+		// Offloaded user data is deleted along with the instance, even if
+		// offloading was disabled since.
+		s3: s3.NewFromConfig(cliCfg, func(o *s3.Options) {
+			o.BaseEndpoint = cfg.BaseEndpoint(config.ServiceS3, o.BaseEndpoint)
+		}),
+	}
+
+	if cfg.EstimateCost {
+		if endpoint, ok := pricingEndpointFor(cfg.Region); ok {
+			awsCli.pricing = pricing.NewFromConfig(cliCfg, func(o *pricing.Options) {
+				o.Region = endpoint.region
+				o.BaseEndpoint = cfg.BaseEndpoint(config.ServicePricing, o.BaseEndpoint)
+			})
+		} else {
+			slog.WarnContext(ctx, "the pricing API is not available in the partition of the region, not estimating costs", "region", cfg.Region, "partition", config.PartitionID(cfg.Region))
+		}
 	}
 
+	if failover, ok := cliCfg.Credentials.(*config.FailoverCredentials); ok {
+		awsCli.credentials = failover
+	}
+
+	// The caller identity is used for the audit log and to detect subnets
+	// shared from other accounts. GetCallerIdentity needs no permissions.
+	awsCli.sts = sts.NewFromConfig(cliCfg, func(o *sts.Options) {
+		o.BaseEndpoint = cfg.BaseEndpoint(config.ServiceSTS, o.BaseEndpoint)
+	})
+
 	return awsCli, nil
 }
 
@@ -51,12 +95,51 @@ type ClientInterface interface {
 	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
 	TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error)
 	RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+	DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+	DescribeInstanceTypeOfferings(ctx context.Context, params *ec2.DescribeInstanceTypeOfferingsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error)
+	DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error)
+	DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+	CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DescribeVpcEndpoints(ctx context.Context, params *ec2.DescribeVpcEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcEndpointsOutput, error)
+	DescribeRouteTables(ctx context.Context, params *ec2.DescribeRouteTablesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error)
+	DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error)
+	DescribeVolumeStatus(ctx context.Context, params *ec2.DescribeVolumeStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumeStatusOutput, error)
+	AttachVolume(ctx context.Context, params *ec2.AttachVolumeInput, optFns ...func(*ec2.Options)) (*ec2.AttachVolumeOutput, error)
+	CreateVolume(ctx context.Context, params *ec2.CreateVolumeInput, optFns ...func(*ec2.Options)) (*ec2.CreateVolumeOutput, error)
+	DeleteVolume(ctx context.Context, params *ec2.DeleteVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error)
+	CreateFleet(ctx context.Context, params *ec2.CreateFleetInput, optFns ...func(*ec2.Options)) (*ec2.CreateFleetOutput, error)
+	CreateLaunchTemplate(ctx context.Context, params *ec2.CreateLaunchTemplateInput, optFns ...func(*ec2.Options)) (*ec2.CreateLaunchTemplateOutput, error)
+	DeleteLaunchTemplate(ctx context.Context, params *ec2.DeleteLaunchTemplateInput, optFns ...func(*ec2.Options)) (*ec2.DeleteLaunchTemplateOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+	ImportKeyPair(ctx context.Context, params *ec2.ImportKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.ImportKeyPairOutput, error)
+	DeleteKeyPair(ctx context.Context, params *ec2.DeleteKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.DeleteKeyPairOutput, error)
+	DescribeInstanceConnectEndpoints(ctx context.Context, params *ec2.DescribeInstanceConnectEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceConnectEndpointsOutput, error)
+	CreateInstanceConnectEndpoint(ctx context.Context, params *ec2.CreateInstanceConnectEndpointInput, optFns ...func(*ec2.Options)) (*ec2.CreateInstanceConnectEndpointOutput, error)
+	GetSerialConsoleAccessStatus(ctx context.Context, params *ec2.GetSerialConsoleAccessStatusInput, optFns ...func(*ec2.Options)) (*ec2.GetSerialConsoleAccessStatusOutput, error)
+	EnableSerialConsoleAccess(ctx context.Context, params *ec2.EnableSerialConsoleAccessInput, optFns ...func(*ec2.Options)) (*ec2.EnableSerialConsoleAccessOutput, error)
 }
 
 type AwsCli struct {
 	cfg *config.Config
 
-	client ClientInterface
+	client  ClientInterface
+	pricing PricingClientInterface
+	ssm     SSMClientInterface
+	s3      S3ClientInterface
+	sts     STSClientInterface
+
+	// callerARN caches the identity recorded in audit log entries.
+	callerARN string
+	// callerAccount caches the account of that identity.
+	callerAccount string
+	// credentials is set when secondary static credentials are
+	// configured, and tells which set is in use.
+	credentials *config.FailoverCredentials
+
+	// lookups deduplicates concurrent DescribeInstances calls for the same
+	// instance within a single invocation of the provider.
+	lookups singleflight.Group
 }
 
 func (a *AwsCli) Config() *config.Config {
@@ -75,10 +158,23 @@ func (a *AwsCli) SetClient(client ClientInterface) {
 	a.client = client
 }
 
-func (a *AwsCli) StartInstance(ctx context.Context, vmName string) error {
+func (a *AwsCli) SetPricingClient(client PricingClientInterface) {
+	a.pricing = client
+}
+
+func (a *AwsCli) SetSSMClient(client SSMClientInterface) {
+	a.ssm = client
+}
+
+func (a *AwsCli) SetSTSClient(client STSClientInterface) {
+	a.sts = client
+}
+
+func (a *AwsCli) StartInstance(ctx context.Context, vmName, reason string) error {
 	_, err := a.client.StartInstances(ctx, &ec2.StartInstancesInput{
 		InstanceIds: []string{vmName},
 	})
+	a.audit(ctx, "start", vmName, reason, err)
 	if err != nil {
 		return fmt.Errorf("failed to start instance: %w", err)
 	}
@@ -86,10 +182,21 @@ func (a *AwsCli) StartInstance(ctx context.Context, vmName string) error {
 	return nil
 }
 
-func (a *AwsCli) StopInstance(ctx context.Context, vmName string) error {
-	_, err := a.client.StopInstances(ctx, &ec2.StopInstancesInput{
+func (a *AwsCli) StopInstance(ctx context.Context, vmName, reason string) error {
+	input := &ec2.StopInstancesInput{
 		InstanceIds: []string{vmName},
-	})
+	}
+
+	if a.cfg.HibernateOnStop {
+		hibernate, err := a.canHibernate(ctx, vmName)
+		if err != nil {
+			return fmt.Errorf("failed to determine hibernation support: %w", err)
+		}
+		input.Hibernate = aws.Bool(hibernate)
+	}
+
+	_, err := a.client.StopInstances(ctx, input)
+	a.audit(ctx, "stop", vmName, reason, err)
 	if err != nil {
 		return fmt.Errorf("failed to stop instance: %w", err)
 	}
@@ -97,24 +204,57 @@ func (a *AwsCli) StopInstance(ctx context.Context, vmName string) error {
 	return nil
 }
 
+// canHibernate reports whether the instance can be hibernated. Hibernation
+// can only be enabled at launch time, and only running instances can be
+// hibernated.
+func (a *AwsCli) canHibernate(ctx context.Context, instanceID string) (bool, error) {
+	instance, err := a.GetInstance(ctx, instanceID)
+	if err != nil {
+		return false, err
+	}
+
+	if instance.HibernationOptions == nil || !aws.ToBool(instance.HibernationOptions.Configured) {
+		return false, nil
+	}
+
+	return instance.State != nil && instance.State.Name == types.InstanceStateNameRunning, nil
+}
+
 func (a *AwsCli) FindInstances(ctx context.Context, controllerID, instanceName string) ([]types.Instance, error) {
-	resp, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
-			{
-				Name:   aws.String("tag:GARM_CONTROLLER_ID"),
-				Values: []string{controllerID},
-			},
-			{
-				Name:   aws.String("tag:Name"),
-				Values: []string{instanceName},
-			},
-			{
-				//   - instance-state-name - The state of the instance ( pending | running |
-				//   shutting-down | terminated | stopping | stopped ).
-				Name:   aws.String("instance-state-name"),
-				Values: []string{"pending", "running", "stopping", "stopped"},
-			},
+	key := fmt.Sprintf("name:%s:%s", controllerID, instanceName)
+	ret, err, _ := a.lookups.Do(key, func() (interface{}, error) {
+		return a.findInstances(ctx, controllerID, instanceName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.([]types.Instance), nil
+}
+
+// findInstances looks up instances by their Name tag. If controllerID is
+// empty, instances of any controller are returned.
+func (a *AwsCli) findInstances(ctx context.Context, controllerID, instanceName string) ([]types.Instance, error) {
+	var filters []types.Filter
+	if controllerID != "" {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("tag:GARM_CONTROLLER_ID"),
+			Values: []string{controllerID},
+		})
+	}
+	filters = append(filters,
+		types.Filter{
+			Name:   aws.String("tag:Name"),
+			Values: []string{instanceName},
+		},
+		types.Filter{
+			//   - instance-state-name - The state of the instance ( pending | running |
+			//   shutting-down | terminated | stopping | stopped ).
+			Name:   aws.String("instance-state-name"),
+			Values: []string{"pending", "running", "stopping", "stopped"},
 		},
+	)
+	resp, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: filters,
 	})
 
 	if err != nil {
@@ -137,13 +277,13 @@ func (a *AwsCli) FindOneInstance(ctx context.Context, controllerID, instanceName
 		}
 		return resp, nil
 	}
-	resp, err := a.FindInstances(ctx, controllerID, instanceName)
+	resp, err := a.findInstancesByName(ctx, controllerID, instanceName)
 	if err != nil {
 		return types.Instance{}, fmt.Errorf("failed to find instance %s: %w", instanceName, errors.ErrNotFound)
 	}
 
 	if len(resp) > 1 {
-		return types.Instance{}, fmt.Errorf("found more than one instance with name %s", instanceName)
+		return types.Instance{}, fmt.Errorf("found more than one instance with name %s: %w", instanceName, ErrDuplicateInstances)
 	}
 
 	if len(resp) == 0 {
@@ -159,6 +299,16 @@ func (a *AwsCli) FindOneInstance(ctx context.Context, controllerID, instanceName
 // specify filters, the output includes information for only those instances that
 // meet the filter criteria.
 func (a *AwsCli) GetInstance(ctx context.Context, instance string) (types.Instance, error) {
+	ret, err, _ := a.lookups.Do("id:"+instance, func() (interface{}, error) {
+		return a.getInstance(ctx, instance)
+	})
+	if err != nil {
+		return types.Instance{}, err
+	}
+	return ret.(types.Instance), nil
+}
+
+func (a *AwsCli) getInstance(ctx context.Context, instance string) (types.Instance, error) {
 	resp, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: []string{instance},
 		Filters: []types.Filter{
@@ -191,10 +341,25 @@ func (a *AwsCli) GetInstance(ctx context.Context, instance string) (types.Instan
 // the root device and any other devices attached to the instance persist. When you terminate an instance,
 // any attached EBS volumes with the DeleteOnTermination block device mapping parameter set to true are
 // automatically deleted.
-func (a *AwsCli) TerminateInstance(ctx context.Context, vmName string) error {
-	_, err := a.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+//
+// The reason is only used for the audit log.
+func (a *AwsCli) TerminateInstance(ctx context.Context, vmName, reason string) error {
+	// The instance details are only needed for the lifecycle webhook, and
+	// are gone once the instance is terminated.
+	instance := types.Instance{InstanceId: aws.String(vmName)}
+	if a.cfg.LifecycleWebhook.URL != "" {
+		if details, err := a.GetInstance(ctx, vmName); err == nil {
+			instance = details
+		}
+	}
+
+	resp, err := a.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
 		InstanceIds: []string{vmName},
 	})
+	if err != nil && util.IsTerminationProtectedErr(err) && a.cfg.DisableTerminationProtection {
+		resp, err = a.terminateProtectedInstance(ctx, vmName, reason)
+	}
+	a.audit(ctx, "terminate", vmName, reason, err)
 	if err != nil {
 		if util.IsEC2NotFoundErr(err) {
 			return nil
@@ -202,6 +367,131 @@ func (a *AwsCli) TerminateInstance(ctx context.Context, vmName string) error {
 		return fmt.Errorf("failed to terminate instance: %w", err)
 	}
 
+	a.instanceTerminated(ctx, instance, wasTerminating(resp, vmName))
+	return nil
+}
+
+// instanceTerminated reports the termination of the instance to the lifecycle
+// webhook and forgets about the instance.
+func (a *AwsCli) instanceTerminated(ctx context.Context, instance types.Instance, wasTerminating bool) {
+	// GARM retries deletes. Instances an earlier attempt already terminated
+	// have been reported as deleted back then.
+	if !wasTerminating {
+		a.notifyLifecycle(ctx, lifecycleEventDelete, instance)
+	}
+
+	if a.usesStateDir() {
+		instanceID := aws.ToString(instance.InstanceId)
+		if err := a.forgetInstance(instanceID); err != nil {
+			slog.WarnContext(ctx, "failed to remove instance from state dir", "instance_id", instanceID, "error", err)
+		}
+	}
+}
+
+// terminateProtectedInstance turns off termination protection of the
+// instance, which something other than the provider turned on, and
+// terminates it.
+func (a *AwsCli) terminateProtectedInstance(ctx context.Context, instanceID, reason string) (*ec2.TerminateInstancesOutput, error) {
+	_, err := a.client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceID),
+		DisableApiTermination: &types.AttributeBooleanValue{
+			Value: aws.Bool(false),
+		},
+	})
+	a.audit(ctx, "unprotect", instanceID, reason, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to disable termination protection: %w", err)
+	}
+	slog.InfoContext(ctx, "disabled termination protection", "instance_id", instanceID)
+
+	return a.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+}
+
+// wasTerminating returns true if the instance was already shutting down or
+// terminated before it was asked to terminate.
+func wasTerminating(resp *ec2.TerminateInstancesOutput, instanceID string) bool {
+	if resp == nil {
+		return false
+	}
+	for _, change := range resp.TerminatingInstances {
+		if aws.ToString(change.InstanceId) != instanceID || change.PreviousState == nil {
+			continue
+		}
+		return change.PreviousState.Name == types.InstanceStateNameShuttingDown ||
+			change.PreviousState.Name == types.InstanceStateNameTerminated
+	}
+	return false
+}
+
+// WaitForRunning blocks until the instance reaches the running state, or
+// until maxWait elapses.
+func (a *AwsCli) WaitForRunning(ctx context.Context, instanceID string, maxWait time.Duration) error {
+	waiter := ec2.NewInstanceRunningWaiter(a.client)
+	err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, maxWait)
+	if err != nil {
+		return waitError(ctx, err, instanceID, "run")
+	}
+	return nil
+}
+
+// WaitForStopped blocks until the instance is stopped, or until maxWait
+// elapses.
+func (a *AwsCli) WaitForStopped(ctx context.Context, instanceID string, maxWait time.Duration) error {
+	waiter := ec2.NewInstanceStoppedWaiter(a.client)
+	err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, maxWait)
+	if err != nil {
+		return waitError(ctx, err, instanceID, "stop")
+	}
+	return nil
+}
+
+// WaitForTerminated blocks until the instance is terminated, or until
+// maxWait elapses. Instances EC2 no longer knows about are terminated.
+func (a *AwsCli) WaitForTerminated(ctx context.Context, instanceID string, maxWait time.Duration) error {
+	waiter := ec2.NewInstanceTerminatedWaiter(a.client, func(o *ec2.InstanceTerminatedWaiterOptions) {
+		retryable := o.Retryable
+		o.Retryable = func(ctx context.Context, input *ec2.DescribeInstancesInput, output *ec2.DescribeInstancesOutput, err error) (bool, error) {
+			if util.IsEC2NotFoundErr(err) {
+				return false, nil
+			}
+			return retryable(ctx, input, output, err)
+		}
+	})
+	err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, maxWait)
+	if err != nil {
+		return waitError(ctx, err, instanceID, "terminate")
+	}
+	return nil
+}
+
+// MarkBootstrapFailed tags the instance as having failed to bootstrap, which
+// makes GARM see it in the error state, along with the time it failed, which
+// the gc command counts its grace period from.
+func (a *AwsCli) MarkBootstrapFailed(ctx context.Context, instanceID string) error {
+	_, err := a.client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{instanceID},
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(util.BootstrapStatusTag),
+				Value: aws.String(util.BootstrapStatusFailed),
+			},
+			{
+				Key:   aws.String(util.BootstrapFailedAtTag),
+				Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag instance %s: %w", instanceID, err)
+	}
 	return nil
 }
 
@@ -232,56 +522,628 @@ func (a *AwsCli) ListDescribedInstances(ctx context.Context, poolID string) ([]t
 	return instances, nil
 }
 
+// reconcileExistingInstances looks for instances left behind by a previous
+// attempt to create an instance with the same name. GARM may retry a create
+// after a failure that happened after RunInstances succeeded. A pending or
+// running instance from such an attempt is reused, and its ID is returned.
+// Any other instance with the same name is terminated, so that names remain
+// unique.
+func (a *AwsCli) reconcileExistingInstances(ctx context.Context, spec *spec.RunnerSpec) (string, error) {
+	instances, err := a.findInstancesByName(ctx, spec.ControllerID, spec.BootstrapParams.Name)
+	if err != nil {
+		return "", err
+	}
+
+	var reuse string
+	for _, instance := range instances {
+		if instance.InstanceId == nil {
+			continue
+		}
+		if reuse == "" && instance.State != nil && !util.IsBootstrapFailed(instance) &&
+			(instance.State.Name == types.InstanceStateNamePending || instance.State.Name == types.InstanceStateNameRunning) {
+			slog.InfoContext(ctx, "reusing instance created by a previous attempt", "instance_id", *instance.InstanceId, "name", spec.BootstrapParams.Name)
+			reuse = *instance.InstanceId
+			continue
+		}
+
+		slog.InfoContext(ctx, "terminating stale instance", "instance_id", *instance.InstanceId, "name", spec.BootstrapParams.Name)
+		if err := a.TerminateInstance(ctx, *instance.InstanceId, "replaced by a new create request with the same name"); err != nil {
+			return "", err
+		}
+		// Frees the name of the ephemeral key pair, and the key of the
+		// offloaded user data, for the new instance.
+		a.DeleteEphemeralKeyPair(ctx, instance)
+		a.DeleteUserDataObject(ctx, instance)
+	}
+
+	return reuse, nil
+}
+
+// CreateRunningInstance launches an instance for the spec, and returns its
+// ID. If the instance was launched, but couldn't be set up, its ID is returned
+// along with the error.
 func (a *AwsCli) CreateRunningInstance(ctx context.Context, spec *spec.RunnerSpec) (string, error) {
 
 	if spec == nil {
 		return "", fmt.Errorf("invalid nil runner spec")
 	}
 
-	udata, err := spec.ComposeUserData()
+	instanceID, err := a.reconcileExistingInstances(ctx, spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to check for existing instances: %w", err)
+	}
+	if instanceID != "" {
+		return instanceID, nil
+	}
+
+	if err := a.checkEntityQuota(ctx, spec); err != nil {
+		return "", err
+	}
+
+	imageReference := spec.BootstrapParams.Image
+	if err := a.resolveSSMReferences(ctx, spec); err != nil {
+		return "", fmt.Errorf("failed to resolve ssm parameters: %w", err)
+	}
+
+	if err := a.resolveSecurityGroups(ctx, spec); err != nil {
+		return "", fmt.Errorf("failed to resolve security groups: %w", err)
+	}
+
+	if spec.SharedVolume != nil {
+		if err := a.checkSharedVolume(ctx, spec); err != nil {
+			return "", fmt.Errorf("failed to check shared volume: %w", err)
+		}
+	}
+
+	if err := a.checkSubnets(ctx, spec); err != nil {
+		return "", err
+	}
+
+	if err := a.checkImageCompatibility(ctx, spec.BootstrapParams.Image, spec.BootstrapParams.Flavor, spec.BootstrapParams.OSType, spec.BootstrapParams.OSArch); err != nil {
+		return "", fmt.Errorf("image %s can not be used with %s: %w", spec.BootstrapParams.Image, spec.BootstrapParams.Flavor, err)
+	}
+
+	if spec.EnableHibernation {
+		typeInfo, err := a.GetInstanceType(ctx, spec.BootstrapParams.Flavor)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping hibernation support check", "error", err)
+		} else if err := checkHibernationSupport(typeInfo); err != nil {
+			return "", err
+		}
+	}
+
+	// Whether the instance, and with it its ephemeral key pair and
+	// offloaded user data, is kept.
+	launched := false
+	udata, userDataObject, err := a.composeUserData(ctx, spec)
 	if err != nil {
 		return "", fmt.Errorf("failed to compose user data: %w", err)
 	}
+	if userDataObject != "" {
+		defer func() {
+			if !launched {
+				a.deleteUserDataObject(ctx, userDataObject)
+			}
+		}()
+	}
 
-	resp, err := a.client.RunInstances(ctx, &ec2.RunInstancesInput{
-		ImageId:      aws.String(spec.BootstrapParams.Image),
-		InstanceType: types.InstanceType(spec.BootstrapParams.Flavor),
-		MaxCount:     aws.Int32(1),
-		MinCount:     aws.Int32(1),
-		SubnetId:     aws.String(spec.SubnetID),
-		UserData:     aws.String(udata),
-		KeyName:      spec.SSHKeyName,
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeInstance,
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(spec.BootstrapParams.Name),
-					},
-					{
-						Key:   aws.String("GARM_POOL_ID"),
-						Value: aws.String(spec.BootstrapParams.PoolID),
-					},
-					{
-						Key:   aws.String("OSType"),
-						Value: aws.String(string(spec.BootstrapParams.OSType)),
-					},
-					{
-						Key:   aws.String("OSArch"),
-						Value: aws.String(string(spec.BootstrapParams.OSArch)),
-					},
-					{
-						Key:   aws.String("GARM_CONTROLLER_ID"),
-						Value: aws.String(spec.ControllerID),
-					},
-				},
-			},
+	tags := []types.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(spec.BootstrapParams.Name),
 		},
-	})
+		{
+			Key:   aws.String("GARM_POOL_ID"),
+			Value: aws.String(spec.BootstrapParams.PoolID),
+		},
+		{
+			Key:   aws.String("OSType"),
+			Value: aws.String(string(spec.BootstrapParams.OSType)),
+		},
+		{
+			Key:   aws.String("OSArch"),
+			Value: aws.String(string(spec.BootstrapParams.OSArch)),
+		},
+		{
+			Key:   aws.String("GARM_CONTROLLER_ID"),
+			Value: aws.String(spec.ControllerID),
+		},
+	}
+
+	tags = append(tags, configTags(a.cfg.Tags)...)
+
+	if imageReference != spec.BootstrapParams.Image {
+		// Make it possible to tell which image a pool was on at the time
+		// the instance was created.
+		tags = append(tags, types.Tag{
+			Key:   aws.String("GARM_IMAGE_REFERENCE"),
+			Value: aws.String(imageReference),
+		}, types.Tag{
+			Key:   aws.String("GARM_RESOLVED_IMAGE_ID"),
+			Value: aws.String(spec.BootstrapParams.Image),
+		})
+	}
+
+	if entity := util.Entity(spec.BootstrapParams.RepoURL); entity != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(util.EntityTag),
+			Value: aws.String(entity),
+		})
+	}
+
+	if spec.KeepOnFailure {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(util.KeepOnFailureTag),
+			Value: aws.String(spec.GetKeepOnFailureTTL().String()),
+		})
+	}
+
+	if spec.EphemeralSSHKey {
+		// The key pair is named after the instance.
+		tags = append(tags, types.Tag{
+			Key:   aws.String(util.EphemeralKeyTag),
+			Value: aws.String(spec.BootstrapParams.Name),
+		})
+	}
+
+	if userDataObject != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(util.UserDataObjectTag),
+			Value: aws.String(userDataObject),
+		})
+	}
+
+	if spec.SerialConsole {
+		a.ensureSerialConsole(ctx, spec.BootstrapParams.Flavor)
+		tags = append(tags, types.Tag{
+			Key:   aws.String(util.SerialConsoleTag),
+			Value: aws.String(SerialConsoleHost(a.cfg.Region)),
+		})
+	}
+
+	if spec.MaxRuntime != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(util.MaxRuntimeTag),
+			Value: aws.String(spec.MaxRuntime),
+		})
+	}
+
+	if a.cfg.EstimateCost && a.pricing != nil {
+		// A missing price should never prevent a runner from being created.
+		price, currency, err := a.GetHourlyPrice(ctx, spec.BootstrapParams.Flavor, spec.BootstrapParams.OSType, types.Tenancy(spec.Tenancy))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to estimate hourly cost", "flavor", spec.BootstrapParams.Flavor, "error", err)
+		} else {
+			slog.InfoContext(ctx, "estimated hourly cost", "name", spec.BootstrapParams.Name, "flavor", spec.BootstrapParams.Flavor, "price", price, "currency", currency)
+			// The currency depends on the partition, so it is part of the
+			// value, like 0.0116 USD.
+			tags = append(tags, types.Tag{
+				Key:   aws.String("EstimatedHourlyCost"),
+				Value: aws.String(strconv.FormatFloat(price, 'f', -1, 64) + " " + currency),
+			})
+		}
+	}
+
+	if err := checkRequiredTags(tags, a.cfg.RequiredRequestTags); err != nil {
+		return "", err
+	}
+
+	input := &ec2.RunInstancesInput{
+		ImageId:           aws.String(spec.BootstrapParams.Image),
+		InstanceType:      types.InstanceType(spec.BootstrapParams.Flavor),
+		MaxCount:          aws.Int32(1),
+		MinCount:          aws.Int32(1),
+		SecurityGroupIds:  spec.SecurityGroupIDs,
+		KeyName:           spec.SSHKeyName,
+		TagSpecifications: launchTagSpecifications(tags),
+	}
+
+	// Pools that disable user data may launch instances without any.
+	if udata != "" {
+		input.UserData = aws.String(udata)
+	}
+
+	if userDataObject != "" {
+		// The instance profile grants access to the offloaded user data.
+		profile := a.cfg.UserDataOffload.InstanceProfile
+		input.IamInstanceProfile = &types.IamInstanceProfileSpecification{}
+		if strings.HasPrefix(profile, "arn:") {
+			input.IamInstanceProfile.Arn = aws.String(profile)
+		} else {
+			input.IamInstanceProfile.Name = aws.String(profile)
+		}
+	}
+
+	if spec.Tenancy != "" {
+		input.Placement = &types.Placement{
+			Tenancy: types.Tenancy(spec.Tenancy),
+		}
+		if spec.HostID != "" {
+			input.Placement.HostId = aws.String(spec.HostID)
+		}
+		if spec.HostResourceGroupARN != "" {
+			input.Placement.HostResourceGroupArn = aws.String(spec.HostResourceGroupARN)
+		}
+	}
+
+	for _, mapping := range spec.BlockDeviceMappings {
+		ebs := &types.EbsBlockDevice{
+			VolumeSize:          mapping.VolumeSize,
+			VolumeType:          types.VolumeTypeGp3,
+			Iops:                mapping.Iops,
+			Throughput:          mapping.Throughput,
+			Encrypted:           mapping.Encrypted,
+			DeleteOnTermination: aws.Bool(true),
+		}
+		if mapping.VolumeType != nil {
+			ebs.VolumeType = types.VolumeType(*mapping.VolumeType)
+		}
+		if mapping.DeleteOnTermination != nil {
+			ebs.DeleteOnTermination = mapping.DeleteOnTermination
+		}
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, types.BlockDeviceMapping{
+			DeviceName: aws.String(mapping.DeviceName),
+			Ebs:        ebs,
+		})
+	}
+
+	for _, volume := range spec.InstanceStoreVolumes {
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, types.BlockDeviceMapping{
+			DeviceName:  aws.String(volume.DeviceName),
+			VirtualName: aws.String(volume.VirtualName),
+		})
+	}
+
+	if spec.CacheSnapshotID != "" && spec.CachePoolSize == 0 {
+		// Pooled cache volumes are attached once the instance runs.
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, types.BlockDeviceMapping{
+			DeviceName: aws.String(spec.CacheDeviceName),
+			Ebs: &types.EbsBlockDevice{
+				SnapshotId:          aws.String(spec.CacheSnapshotID),
+				VolumeType:          types.VolumeTypeGp3,
+				DeleteOnTermination: aws.Bool(true),
+			},
+		})
+	}
+
+	if len(spec.SuppressDevices) > 0 {
+		if err := a.suppressDevices(ctx, spec.BootstrapParams.Image, spec.SuppressDevices, input); err != nil {
+			return "", fmt.Errorf("failed to suppress devices: %w", err)
+		}
+	}
+
+	for _, arn := range spec.LicenseSpecifications {
+		input.LicenseSpecifications = append(input.LicenseSpecifications, types.LicenseConfigurationRequest{
+			LicenseConfigurationArn: aws.String(arn),
+		})
+	}
+
+	if spec.SnapshotID != "" {
+		if err := a.useRootSnapshot(ctx, spec.BootstrapParams.Image, spec.SnapshotID, input); err != nil {
+			return "", fmt.Errorf("failed to configure root volume: %w", err)
+		}
+	}
+
+	if spec.Encrypted {
+		if err := a.encryptVolumes(ctx, spec.BootstrapParams.Image, spec.KMSKeyID, input); err != nil {
+			return "", fmt.Errorf("failed to configure volume encryption: %w", err)
+		}
+	}
+
+	if spec.EnableHibernation {
+		input.HibernationOptions = &types.HibernationOptionsRequest{
+			Configured: aws.Bool(true),
+		}
+	}
+
+	if spec.Ipv6AddressCount > 0 {
+		input.Ipv6AddressCount = aws.Int32(spec.Ipv6AddressCount)
+		// On IPv6-only subnets the link-local IPv4 metadata endpoint is not
+		// reachable, so cloud-init needs the IPv6 one to fetch the user data.
+		input.MetadataOptions = &types.InstanceMetadataOptionsRequest{
+			HttpProtocolIpv6: types.InstanceMetadataProtocolStateEnabled,
+		}
+	}
+
+	if a.cfg.InstanceMetadataTags {
+		if input.MetadataOptions == nil {
+			input.MetadataOptions = &types.InstanceMetadataOptionsRequest{}
+		}
+		input.MetadataOptions.InstanceMetadataTags = types.InstanceMetadataTagsStateEnabled
+	}
+
+	if opts := spec.MetadataOptions; opts != nil {
+		if input.MetadataOptions == nil {
+			input.MetadataOptions = &types.InstanceMetadataOptionsRequest{}
+		}
+		if opts.HttpTokens != nil {
+			input.MetadataOptions.HttpTokens = types.HttpTokensState(*opts.HttpTokens)
+		}
+		if opts.HttpEndpoint != nil {
+			input.MetadataOptions.HttpEndpoint = types.InstanceMetadataEndpointState(*opts.HttpEndpoint)
+		}
+		input.MetadataOptions.HttpPutResponseHopLimit = opts.HttpPutResponseHopLimit
+	}
+
+	if err := a.waitForLaunchSlot(ctx, spec.BootstrapParams.Name); err != nil {
+		return "", err
+	}
+
+	if spec.EphemeralSSHKey {
+		keyName, err := a.importEphemeralKeyPair(ctx, spec)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if !launched {
+				a.deleteKeyPair(ctx, keyName)
+			}
+		}()
+		input.KeyName = aws.String(keyName)
+	}
+
+	input.SubnetId = aws.String(spec.SubnetID)
+	dumpLaunch(ctx, spec.BootstrapParams.Name, input)
+
+	if a.cfg.PreflightDryRun {
+		if err := a.preflightLaunch(ctx, input); err != nil {
+			return "", fmt.Errorf("failed to create instance: %w", err)
+		}
+	}
+
+	subnets := append([]string{spec.SubnetID}, spec.FallbackSubnetIDs...)
+	var instance types.Instance
+	if launchesFleet(spec) {
+		instance, err = a.launchFleet(ctx, spec.BootstrapParams.Name, input, subnets)
+		if err != nil {
+			return "", fmt.Errorf("failed to create instance: %w", err)
+		}
+		if instance.SubnetId != nil {
+			spec.SubnetID = *instance.SubnetId
+		}
+	} else {
+		var resp *ec2.RunInstancesOutput
+		for idx, subnet := range subnets {
+			input.SubnetId = aws.String(subnet)
+			resp, err = a.client.RunInstances(ctx, input)
+			if err == nil {
+				spec.SubnetID = subnet
+				break
+			}
+			if !util.IsEC2CapacityErr(err) && !util.IsEC2UnsupportedInZoneErr(err) || idx == len(subnets)-1 {
+				return "", fmt.Errorf("failed to create instance: %w", a.explainSharedSubnetErr(ctx, subnet, input, err))
+			}
+			slog.WarnContext(ctx, "subnet can't host the instance, retrying in the next subnet", "subnet_id", subnet, "next_subnet_id", subnets[idx+1], "error", err)
+		}
+
+		// Never report an instance to GARM that EC2 did not confirm launching.
+		if len(resp.Instances) == 0 || resp.Instances[0].InstanceId == nil {
+			return "", fmt.Errorf("failed to create instance: no instance was launched in subnet %s", spec.SubnetID)
+		}
+		instance = resp.Instances[0]
+	}
+	instanceID = *instance.InstanceId
+	a.notifyLifecycle(ctx, lifecycleEventCreate, instance)
+
+	if a.usesStateDir() {
+		// Without a record the instance can't be found by name, so don't
+		// hand it to GARM.
+		if err := a.rememberInstance(spec.BootstrapParams.Name, instanceID); err != nil {
+			if termErr := a.TerminateInstance(ctx, instanceID, "failed to record instance in state dir"); termErr != nil {
+				slog.WarnContext(ctx, "failed to terminate instance", "instance_id", instanceID, "error", termErr)
+			}
+			return "", fmt.Errorf("failed to record instance %s: %w", instanceID, err)
+		}
+	}
+	launched = true
+
+	if spec.SharedVolume != nil {
+		if err := a.attachSharedVolume(ctx, instanceID, *spec.SharedVolume); err != nil {
+			// Without the volume the instance never finishes booting.
+			a.failLaunchedInstance(ctx, instanceID)
+			return instanceID, fmt.Errorf("failed to attach shared volume to %s: %w", instanceID, err)
+		}
+	}
+
+	if spec.CachePoolSize > 0 {
+		volumeID, err := a.attachCacheVolume(ctx, instance, spec)
+		if err != nil {
+			a.failLaunchedInstance(ctx, instanceID)
+			return instanceID, fmt.Errorf("failed to attach cache volume to %s: %w", instanceID, err)
+		}
+		slog.DebugContext(ctx, "attached cache volume", "instance_id", instanceID, "volume_id", volumeID)
+	}
+
+	if len(spec.SSMDocuments) > 0 {
+		if err := a.runPostCreateDocuments(ctx, instanceID, spec.SSMDocuments); err != nil {
+			a.failLaunchedInstance(ctx, instanceID)
+			return instanceID, fmt.Errorf("failed to run ssm documents on %s: %w", instanceID, err)
+		}
+	}
+
+	return instanceID, nil
+}
+
+// failLaunchedInstance marks an instance that was launched, but couldn't be
+// set up, as having failed to bootstrap, so that it is neither used nor reused
+// if GARM retries the create. The tag is set even if ctx was cancelled.
+func (a *AwsCli) failLaunchedInstance(ctx context.Context, instanceID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lastKnownInstanceTimeout)
+	defer cancel()
+	if err := a.MarkBootstrapFailed(ctx, instanceID); err != nil {
+		slog.WarnContext(ctx, "failed to mark instance as failed", "instance_id", instanceID, "error", err)
+	}
+}
+
+// checkSubnets runs the network checks enabled in the config against the
+// subnet and every fallback subnet, and limits the subnets instances are
+// created in to those that pass them, so that falling back never launches
+// into a subnet that wasn't checked. If none passes, the error of the first
+// subnet is returned.
+func (a *AwsCli) checkSubnets(ctx context.Context, spec *spec.RunnerSpec) error {
+	if !a.cfg.PrivateOnly && !a.cfg.CheckCallbackReachability {
+		return nil
+	}
+
+	// Fallback subnets are usually in the same VPC.
+	vpcErrs := map[string]error{}
+	check := func(subnetID string) error {
+		if a.cfg.PrivateOnly {
+			subnet, err := a.describeSubnet(ctx, subnetID)
+			if err != nil {
+				return fmt.Errorf("failed to determine vpc: %w", err)
+			}
+			owner, err := a.sharedSubnetOwner(ctx, subnet)
+			if err != nil {
+				return err
+			}
+			if owner != "" {
+				// Endpoints of a shared VPC belong to its owner and can't
+				// be seen by participants.
+				slog.InfoContext(ctx, "not checking vpc endpoints of shared subnet", "subnet_id", subnetID, "owner", owner)
+			} else {
+				vpcID := aws.ToString(subnet.VpcId)
+				vpcErr, ok := vpcErrs[vpcID]
+				if !ok {
+					vpcErr = a.checkVPCEndpoints(ctx, vpcID)
+					vpcErrs[vpcID] = vpcErr
+				}
+				if vpcErr != nil {
+					return vpcErr
+				}
+			}
+		}
+		if a.cfg.CheckCallbackReachability {
+			return a.checkCallbackReachability(ctx, subnetID, spec.BootstrapParams.CallbackURL, spec.BootstrapParams.MetadataURL)
+		}
+		return nil
+	}
+
+	subnetIDs := append([]string{spec.SubnetID}, spec.FallbackSubnetIDs...)
+	var usable []string
+	var firstErr error
+	for _, subnetID := range subnetIDs {
+		if err := check(subnetID); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			slog.WarnContext(ctx, "not using subnet that failed its checks", "subnet_id", subnetID, "error", err)
+			continue
+		}
+		usable = append(usable, subnetID)
+	}
+	if len(usable) == 0 {
+		return firstErr
+	}
+	spec.SubnetID = usable[0]
+	spec.FallbackSubnetIDs = usable[1:]
+	return nil
+}
+
+// encryptVolumes encrypts the root volume, and any additional volume that does
+// not explicitly set encryption, with the given KMS key. An empty key selects
+// the AWS managed key. The root volume is overridden through a block device
+// mapping for the root device of the image, so the image needs to be looked
+// up.
+func (a *AwsCli) encryptVolumes(ctx context.Context, imageID, kmsKeyID string, input *ec2.RunInstancesInput) error {
+	image, err := a.GetImage(ctx, imageID)
 	if err != nil {
-		return "", fmt.Errorf("failed to create instance: %w", err)
+		return err
+	}
+	if image.RootDeviceName == nil {
+		return fmt.Errorf("image %s has no root device", imageID)
+	}
+
+	var kmsKey *string
+	if kmsKeyID != "" {
+		kmsKey = aws.String(kmsKeyID)
 	}
 
-	return *resp.Instances[0].InstanceId, nil
+	hasRoot := false
+	for idx, mapping := range input.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == *image.RootDeviceName {
+			hasRoot = true
+		}
+		if mapping.Ebs == nil || mapping.Ebs.Encrypted != nil {
+			continue
+		}
+		input.BlockDeviceMappings[idx].Ebs.Encrypted = aws.Bool(true)
+		input.BlockDeviceMappings[idx].Ebs.KmsKeyId = kmsKey
+	}
+
+	if !hasRoot {
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, types.BlockDeviceMapping{
+			DeviceName: image.RootDeviceName,
+			Ebs: &types.EbsBlockDevice{
+				Encrypted: aws.Bool(true),
+				KmsKeyId:  kmsKey,
+			},
+		})
+	}
+	return nil
+}
+
+// suppressDevices leaves the block device mappings of the image with the
+// given device names out, so that the volumes they define are not created.
+// The root device can't be left out, so the image needs to be looked up.
+func (a *AwsCli) suppressDevices(ctx context.Context, imageID string, devices []string, input *ec2.RunInstancesInput) error {
+	image, err := a.GetImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if device == aws.ToString(image.RootDeviceName) {
+			return fmt.Errorf("%s is the root device of image %s", device, imageID)
+		}
+		if !slices.ContainsFunc(image.BlockDeviceMappings, func(m types.BlockDeviceMapping) bool {
+			return aws.ToString(m.DeviceName) == device
+		}) {
+			slog.WarnContext(ctx, "image has no block device mapping for device", "image_id", imageID, "device", device)
+		}
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, types.BlockDeviceMapping{
+			DeviceName: aws.String(device),
+			// An empty string tells EC2 to leave the mapping out.
+			NoDevice: aws.String(""),
+		})
+	}
+	return nil
+}
+
+// useRootSnapshot makes EC2 create the root volume of the instance from the
+// given snapshot, instead of from the root snapshot of the image.
+func (a *AwsCli) useRootSnapshot(ctx context.Context, imageID, snapshotID string, input *ec2.RunInstancesInput) error {
+	image, err := a.GetImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+	if image.RootDeviceName == nil {
+		return fmt.Errorf("image %s has no root device", imageID)
+	}
+
+	for idx, mapping := range input.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) != *image.RootDeviceName {
+			continue
+		}
+		if mapping.Ebs == nil {
+			input.BlockDeviceMappings[idx].Ebs = &types.EbsBlockDevice{}
+		}
+		input.BlockDeviceMappings[idx].Ebs.SnapshotId = aws.String(snapshotID)
+		return nil
+	}
+
+	input.BlockDeviceMappings = append(input.BlockDeviceMappings, types.BlockDeviceMapping{
+		DeviceName: image.RootDeviceName,
+		Ebs: &types.EbsBlockDevice{
+			SnapshotId: aws.String(snapshotID),
+		},
+	})
+	return nil
+}
+
+func (a *AwsCli) runPostCreateDocuments(ctx context.Context, instanceID string, documents []spec.SSMDocument) error {
+	if err := a.WaitForRunning(ctx, instanceID, instanceRunningTimeout); err != nil {
+		return err
+	}
+	return a.RunSSMDocuments(ctx, instanceID, documents)
 }
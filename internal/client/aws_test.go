@@ -17,11 +17,15 @@ package client
 
 import (
 	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/smithy-go"
 	"github.com/cloudbase/garm-provider-aws/config"
 	"github.com/cloudbase/garm-provider-aws/internal/spec"
 	"github.com/cloudbase/garm-provider-common/params"
@@ -29,6 +33,34 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// mockCreateLookups sets up the lookups done before an instance is launched.
+// No instance with the same name exists, and both the image and the instance
+// type support ENA.
+func mockCreateLookups(m *MockComputeClient) {
+	m.On("DescribeInstances", mock.Anything, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) == 0
+	}), mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+	m.On("DescribeImages", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:    aws.String("ami-12345678"),
+				EnaSupport: aws.Bool(true),
+				State:      types.ImageStateAvailable,
+			},
+		},
+	}, nil)
+	m.On("DescribeInstanceTypes", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []types.InstanceTypeInfo{
+			{
+				InstanceType: types.InstanceTypeT2Micro,
+				NetworkInfo: &types.NetworkInfo{
+					EnaSupport: types.EnaSupportSupported,
+				},
+			},
+		},
+	}, nil)
+}
+
 func TestStartInstance(t *testing.T) {
 	ctx := context.Background()
 	cfg := &config.Config{
@@ -53,7 +85,7 @@ func TestStartInstance(t *testing.T) {
 		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == instanceId
 	}), mock.Anything).Return(&ec2.StartInstancesOutput{}, nil)
 
-	err := awsCli.StartInstance(ctx, instanceId)
+	err := awsCli.StartInstance(ctx, instanceId, "")
 	require.NoError(t, err)
 
 	mockClient.AssertExpectations(t)
@@ -83,12 +115,87 @@ func TestStopInstance(t *testing.T) {
 		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == instanceId
 	}), mock.Anything).Return(&ec2.StopInstancesOutput{}, nil)
 
-	err := awsCli.StopInstance(ctx, instanceId)
+	err := awsCli.StopInstance(ctx, instanceId, "")
 	require.NoError(t, err)
 
 	mockClient.AssertExpectations(t)
 }
 
+func TestStopInstanceHibernate(t *testing.T) {
+	ctx := context.Background()
+	instanceId := "i-1234567890abcdef0"
+
+	tests := []struct {
+		name       string
+		options    *types.HibernationOptions
+		state      types.InstanceStateName
+		hibernates bool
+	}{
+		{
+			name:       "hibernation configured",
+			options:    &types.HibernationOptions{Configured: aws.Bool(true)},
+			state:      types.InstanceStateNameRunning,
+			hibernates: true,
+		},
+		{
+			name:       "hibernation not configured",
+			options:    &types.HibernationOptions{Configured: aws.Bool(false)},
+			state:      types.InstanceStateNameRunning,
+			hibernates: false,
+		},
+		{
+			name:       "no hibernation options",
+			state:      types.InstanceStateNameRunning,
+			hibernates: false,
+		},
+		{
+			name:       "instance not running",
+			options:    &types.HibernationOptions{Configured: aws.Bool(true)},
+			state:      types.InstanceStateNamePending,
+			hibernates: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:          "us-west-2",
+					SubnetID:        "subnet-1234567890abcdef0",
+					HibernateOnStop: true,
+				},
+				client: mockClient,
+			}
+			mockClient.On("DescribeInstances", ctx, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+				return len(input.InstanceIds) == 1 && input.InstanceIds[0] == instanceId
+			}), mock.Anything).Return(&ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{
+					{
+						Instances: []types.Instance{
+							{
+								InstanceId:         aws.String(instanceId),
+								HibernationOptions: tt.options,
+								State: &types.InstanceState{
+									Name: tt.state,
+								},
+							},
+						},
+					},
+				},
+			}, nil)
+			mockClient.On("StopInstances", ctx, mock.MatchedBy(func(input *ec2.StopInstancesInput) bool {
+				return input.InstanceIds[0] == instanceId && aws.ToBool(input.Hibernate) == tt.hibernates
+			}), mock.Anything).Return(&ec2.StopInstancesOutput{}, nil)
+
+			err := awsCli.StopInstance(ctx, instanceId, "")
+			require.NoError(t, err)
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
 func TestFindInstances(t *testing.T) {
 	ctx := context.Background()
 	cfg := &config.Config{
@@ -326,10 +433,66 @@ func TestTerminateInstance(t *testing.T) {
 		return len(input.InstanceIds) == 1
 	}), mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
 
-	err := awsCli.TerminateInstance(ctx, poolID)
+	err := awsCli.TerminateInstance(ctx, poolID, "")
 	require.NoError(t, err)
 }
 
+func TestTerminateProtectedInstance(t *testing.T) {
+	protectedErr := &smithy.GenericAPIError{
+		Code:    "OperationNotPermitted",
+		Message: "The instance 'i-1234567890abcdef0' may not be terminated. Modify its 'disableApiTermination' instance attribute and try again.",
+	}
+
+	tests := []struct {
+		name      string
+		disable   bool
+		errString string
+	}{
+		{
+			name:    "protection disabled and retried",
+			disable: true,
+		},
+		{
+			name:      "protection left alone",
+			errString: "may not be terminated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:                       "us-west-2",
+					DisableTerminationProtection: tt.disable,
+				},
+				client: mockClient,
+			}
+			input := &ec2.TerminateInstancesInput{
+				InstanceIds: []string{"i-1234567890abcdef0"},
+			}
+			mockClient.On("TerminateInstances", ctx, input, mock.Anything).Return((*ec2.TerminateInstancesOutput)(nil), protectedErr).Once()
+			mockClient.On("ModifyInstanceAttribute", ctx, &ec2.ModifyInstanceAttributeInput{
+				InstanceId: aws.String("i-1234567890abcdef0"),
+				DisableApiTermination: &types.AttributeBooleanValue{
+					Value: aws.Bool(false),
+				},
+			}, mock.Anything).Return(&ec2.ModifyInstanceAttributeOutput{}, nil).Once()
+			mockClient.On("TerminateInstances", ctx, input, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil).Once()
+
+			err := awsCli.TerminateInstance(ctx, "i-1234567890abcdef0", "")
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				mockClient.AssertNotCalled(t, "ModifyInstanceAttribute", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
 func TestCreateRunningInstance(t *testing.T) {
 	ctx := context.Background()
 	cfg := &config.Config{
@@ -377,6 +540,7 @@ func TestCreateRunningInstance(t *testing.T) {
 		SSHKeyName:   aws.String("SSHKeyName"),
 		ControllerID: "controllerID",
 	}
+	mockCreateLookups(mockClient)
 	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
 		Instances: []types.Instance{
 			{
@@ -390,3 +554,1382 @@ func TestCreateRunningInstance(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, instanceID, instance)
 }
+
+func TestCreateRunningInstanceWithIPv6(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		}, nil
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:         "subnet-1234567890abcdef0",
+		Ipv6AddressCount: 1,
+		ControllerID:     "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return aws.ToInt32(input.Ipv6AddressCount) == 1 &&
+			input.MetadataOptions != nil &&
+			input.MetadataOptions.HttpProtocolIpv6 == types.InstanceMetadataProtocolStateEnabled
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithTenancy(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		}, nil
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		Tenancy:      "dedicated",
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return input.Placement != nil && input.Placement.Tenancy == types.TenancyDedicated
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceOnDedicatedHost(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		}, nil
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		Tenancy:      "host",
+		HostID:       "h-0a0a0a0a0a0a0a0a0",
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return input.Placement != nil &&
+			input.Placement.Tenancy == types.TenancyHost &&
+			aws.ToString(input.Placement.HostId) == "h-0a0a0a0a0a0a0a0a0"
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithCacheVolume(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		}, nil
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:        "subnet-1234567890abcdef0",
+		CacheSnapshotID: "snap-0a0a0a0a0a0a0a0a0",
+		CacheDeviceName: "/dev/sdf",
+		ControllerID:    "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		if len(input.BlockDeviceMappings) != 1 {
+			return false
+		}
+		mapping := input.BlockDeviceMappings[0]
+		return aws.ToString(mapping.DeviceName) == "/dev/sdf" &&
+			aws.ToString(mapping.Ebs.SnapshotId) == "snap-0a0a0a0a0a0a0a0a0" &&
+			aws.ToBool(mapping.Ebs.DeleteOnTermination)
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithBlockDeviceMappings(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID: "subnet-1234567890abcdef0",
+		BlockDeviceMappings: []spec.BlockDeviceMapping{
+			{
+				DeviceName: "/dev/sdg",
+				VolumeSize: aws.Int32(200),
+				Iops:       aws.Int32(6000),
+				Encrypted:  aws.Bool(true),
+			},
+			{
+				DeviceName:          "/dev/sdh",
+				VolumeType:          aws.String("st1"),
+				DeleteOnTermination: aws.Bool(false),
+			},
+		},
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		if len(input.BlockDeviceMappings) != 2 {
+			return false
+		}
+		scratch, cold := input.BlockDeviceMappings[0], input.BlockDeviceMappings[1]
+		return aws.ToString(scratch.DeviceName) == "/dev/sdg" &&
+			scratch.Ebs.VolumeType == types.VolumeTypeGp3 &&
+			aws.ToInt32(scratch.Ebs.VolumeSize) == 200 &&
+			aws.ToInt32(scratch.Ebs.Iops) == 6000 &&
+			aws.ToBool(scratch.Ebs.Encrypted) &&
+			aws.ToBool(scratch.Ebs.DeleteOnTermination) &&
+			aws.ToString(cold.DeviceName) == "/dev/sdh" &&
+			cold.Ebs.VolumeType == types.VolumeTypeSt1 &&
+			!aws.ToBool(cold.Ebs.DeleteOnTermination)
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithoutLaunchedInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{}, nil)
+
+	_, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.EqualError(t, err, "failed to create instance: no instance was launched in subnet subnet-1234567890abcdef0")
+}
+
+func TestCreateRunningInstanceWithInstanceStoreVolumes(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "i4i.xlarge",
+			PoolID: "poolID",
+		},
+		SubnetID: "subnet-1234567890abcdef0",
+		InstanceStoreVolumes: []spec.InstanceStoreVolume{
+			{
+				VirtualName: "ephemeral0",
+				DeviceName:  "/dev/sdb",
+				MountPoint:  "/mnt/scratch",
+			},
+		},
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		if len(input.BlockDeviceMappings) != 1 {
+			return false
+		}
+		mapping := input.BlockDeviceMappings[0]
+		return aws.ToString(mapping.DeviceName) == "/dev/sdb" &&
+			aws.ToString(mapping.VirtualName) == "ephemeral0" &&
+			mapping.Ebs == nil
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithEncryptedVolumes(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	kmsKeyID := "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:  "subnet-1234567890abcdef0",
+		Encrypted: true,
+		KMSKeyID:  kmsKeyID,
+		BlockDeviceMappings: []spec.BlockDeviceMapping{
+			{DeviceName: "/dev/sdg"},
+			{DeviceName: "/dev/sdh", Encrypted: aws.Bool(false)},
+		},
+		ControllerID: "controllerID",
+	}
+	mockClient.On("DescribeImages", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:        aws.String("ami-12345678"),
+				EnaSupport:     aws.Bool(true),
+				RootDeviceName: aws.String("/dev/xvda"),
+				State:          types.ImageStateAvailable,
+			},
+		},
+	}, nil)
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		if len(input.BlockDeviceMappings) != 3 {
+			return false
+		}
+		scratch, plain, root := input.BlockDeviceMappings[0], input.BlockDeviceMappings[1], input.BlockDeviceMappings[2]
+		return aws.ToBool(scratch.Ebs.Encrypted) && aws.ToString(scratch.Ebs.KmsKeyId) == kmsKeyID &&
+			!aws.ToBool(plain.Ebs.Encrypted) && plain.Ebs.KmsKeyId == nil &&
+			aws.ToString(root.DeviceName) == "/dev/xvda" &&
+			aws.ToBool(root.Ebs.Encrypted) && aws.ToString(root.Ebs.KmsKeyId) == kmsKeyID &&
+			root.Ebs.VolumeSize == nil
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithInstanceMetadataTags(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:               "us-west-2",
+			SubnetID:             "subnet-1234567890abcdef0",
+			InstanceMetadataTags: true,
+		},
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:         "subnet-1234567890abcdef0",
+		Ipv6AddressCount: 1,
+		ControllerID:     "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return input.MetadataOptions != nil &&
+			input.MetadataOptions.InstanceMetadataTags == types.InstanceMetadataTagsStateEnabled &&
+			input.MetadataOptions.HttpProtocolIpv6 == types.InstanceMetadataProtocolStateEnabled
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithMetadataOptions(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:               "us-west-2",
+			SubnetID:             "subnet-1234567890abcdef0",
+			InstanceMetadataTags: true,
+		},
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:         "subnet-1234567890abcdef0",
+		Ipv6AddressCount: 1,
+		MetadataOptions: &spec.MetadataOptions{
+			HttpTokens:              aws.String("required"),
+			HttpPutResponseHopLimit: aws.Int32(2),
+		},
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return input.MetadataOptions != nil &&
+			input.MetadataOptions.InstanceMetadataTags == types.InstanceMetadataTagsStateEnabled &&
+			input.MetadataOptions.HttpProtocolIpv6 == types.InstanceMetadataProtocolStateEnabled &&
+			input.MetadataOptions.HttpTokens == types.HttpTokensStateRequired &&
+			aws.ToInt32(input.MetadataOptions.HttpPutResponseHopLimit) == 2 &&
+			input.MetadataOptions.HttpEndpoint == ""
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithHibernation(t *testing.T) {
+	tests := []struct {
+		name      string
+		supported bool
+		errString string
+	}{
+		{
+			name:      "supported",
+			supported: true,
+		},
+		{
+			name:      "not supported by the instance type",
+			errString: "instance type t2.micro does not support hibernation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:   "us-west-2",
+					SubnetID: "subnet-1234567890abcdef0",
+				},
+				client: mockClient,
+			}
+			instanceID := "i-1234567890abcdef0"
+			spec := &spec.RunnerSpec{
+				Region: "us-west-2",
+				Tools: params.RunnerApplicationDownload{
+					OS:           aws.String("linux"),
+					Architecture: aws.String("amd64"),
+					DownloadURL:  aws.String("MockURL"),
+					Filename:     aws.String("garm-runner"),
+				},
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "instance-name",
+					OSType: "linux",
+					Image:  "ami-12345678",
+					Flavor: "t2.micro",
+					PoolID: "poolID",
+				},
+				SubnetID:          "subnet-1234567890abcdef0",
+				Encrypted:         true,
+				EnableHibernation: true,
+				ControllerID:      "controllerID",
+			}
+			mockClient.On("DescribeInstances", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+			mockClient.On("DescribeImages", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+				Images: []types.Image{
+					{
+						ImageId:        aws.String("ami-12345678"),
+						State:          types.ImageStateAvailable,
+						RootDeviceName: aws.String("/dev/xvda"),
+					},
+				},
+			}, nil)
+			mockClient.On("DescribeInstanceTypes", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+				InstanceTypes: []types.InstanceTypeInfo{
+					{
+						InstanceType:         types.InstanceTypeT2Micro,
+						HibernationSupported: aws.Bool(tt.supported),
+					},
+				},
+			}, nil)
+			mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+				return input.HibernationOptions != nil &&
+					aws.ToBool(input.HibernationOptions.Configured) &&
+					len(input.BlockDeviceMappings) == 1 &&
+					aws.ToBool(input.BlockDeviceMappings[0].Ebs.Encrypted)
+			}), mock.Anything).Return(&ec2.RunInstancesOutput{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+					},
+				},
+			}, nil)
+
+			instance, err := awsCli.CreateRunningInstance(ctx, spec)
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				mockClient.AssertNotCalled(t, "RunInstances", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, instanceID, instance)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateRunningInstanceSuppressDevices(t *testing.T) {
+	tests := []struct {
+		name      string
+		devices   []string
+		errString string
+	}{
+		{
+			name:    "secondary devices",
+			devices: []string{"/dev/sdb", "/dev/sdc"},
+		},
+		{
+			name:      "root device",
+			devices:   []string{"/dev/xvda"},
+			errString: "/dev/xvda is the root device of image ami-12345678",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:   "us-west-2",
+					SubnetID: "subnet-1234567890abcdef0",
+				},
+				client: mockClient,
+			}
+			spec := &spec.RunnerSpec{
+				Region: "us-west-2",
+				Tools: params.RunnerApplicationDownload{
+					OS:           aws.String("linux"),
+					Architecture: aws.String("amd64"),
+					DownloadURL:  aws.String("MockURL"),
+					Filename:     aws.String("garm-runner"),
+				},
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "instance-name",
+					OSType: "linux",
+					Image:  "ami-12345678",
+					Flavor: "t2.micro",
+					PoolID: "poolID",
+				},
+				SubnetID:        "subnet-1234567890abcdef0",
+				SuppressDevices: tt.devices,
+				ControllerID:    "controllerID",
+			}
+			mockClient.On("DescribeInstances", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+			mockClient.On("DescribeImages", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+				Images: []types.Image{
+					{
+						ImageId:        aws.String("ami-12345678"),
+						State:          types.ImageStateAvailable,
+						RootDeviceName: aws.String("/dev/xvda"),
+						BlockDeviceMappings: []types.BlockDeviceMapping{
+							{DeviceName: aws.String("/dev/xvda")},
+							{DeviceName: aws.String("/dev/sdb")},
+						},
+					},
+				},
+			}, nil)
+			mockClient.On("DescribeInstanceTypes", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{}, nil)
+			mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+				return len(input.BlockDeviceMappings) == 2 &&
+					aws.ToString(input.BlockDeviceMappings[0].DeviceName) == "/dev/sdb" &&
+					input.BlockDeviceMappings[0].NoDevice != nil &&
+					aws.ToString(input.BlockDeviceMappings[1].DeviceName) == "/dev/sdc" &&
+					input.BlockDeviceMappings[1].NoDevice != nil
+			}), mock.Anything).Return(&ec2.RunInstancesOutput{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String("i-1234567890abcdef0"),
+					},
+				},
+			}, nil)
+
+			_, err := awsCli.CreateRunningInstance(ctx, spec)
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				mockClient.AssertNotCalled(t, "RunInstances", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateRunningInstanceWithLicenseSpecifications(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	licenseARN := "arn:aws:license-manager:us-west-2:123456789012:license-configuration:lic-0123456789abcdef0123456789abcdef"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:              "subnet-1234567890abcdef0",
+		LicenseSpecifications: []string{licenseARN},
+		ControllerID:          "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return len(input.LicenseSpecifications) == 1 &&
+			aws.ToString(input.LicenseSpecifications[0].LicenseConfigurationArn) == licenseARN
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String("i-1234567890abcdef0"),
+			},
+		},
+	}, nil)
+
+	instanceID, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, "i-1234567890abcdef0", instanceID)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceWithRootSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:   "subnet-1234567890abcdef0",
+		SnapshotID: "snap-0b0b0b0b0b0b0b0b0",
+		Encrypted:  true,
+		BlockDeviceMappings: []spec.BlockDeviceMapping{
+			{DeviceName: "/dev/xvda", VolumeSize: aws.Int32(100)},
+		},
+		ControllerID: "controllerID",
+	}
+	mockClient.On("DescribeImages", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:        aws.String("ami-12345678"),
+				EnaSupport:     aws.Bool(true),
+				RootDeviceName: aws.String("/dev/xvda"),
+				State:          types.ImageStateAvailable,
+			},
+		},
+	}, nil)
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		if len(input.BlockDeviceMappings) != 1 {
+			return false
+		}
+		root := input.BlockDeviceMappings[0]
+		return aws.ToString(root.DeviceName) == "/dev/xvda" &&
+			aws.ToString(root.Ebs.SnapshotId) == "snap-0b0b0b0b0b0b0b0b0" &&
+			aws.ToInt32(root.Ebs.VolumeSize) == 100 &&
+			aws.ToBool(root.Ebs.Encrypted)
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestUseRootSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+	mockClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:        aws.String("ami-12345678"),
+				RootDeviceName: aws.String("/dev/sda1"),
+			},
+		},
+	}, nil)
+
+	input := &ec2.RunInstancesInput{
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sdg"), Ebs: &types.EbsBlockDevice{}},
+		},
+	}
+	err := awsCli.useRootSnapshot(ctx, "ami-12345678", "snap-0b0b0b0b0b0b0b0b0", input)
+	require.NoError(t, err)
+	require.Len(t, input.BlockDeviceMappings, 2)
+	require.Nil(t, input.BlockDeviceMappings[0].Ebs.SnapshotId)
+	require.Equal(t, "/dev/sda1", aws.ToString(input.BlockDeviceMappings[1].DeviceName))
+	require.Equal(t, "snap-0b0b0b0b0b0b0b0b0", aws.ToString(input.BlockDeviceMappings[1].Ebs.SnapshotId))
+}
+
+func TestCreateRunningInstanceWithCostEstimate(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:       "us-west-2",
+		SubnetID:     "subnet-1234567890abcdef0",
+		EstimateCost: true,
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	mockPricing := new(MockPricingClient)
+	awsCli := &AwsCli{
+		cfg:     cfg,
+		client:  mockClient,
+		pricing: mockPricing,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		ControllerID: "controllerID",
+	}
+	mockPricing.On("GetProducts", ctx, mock.Anything, mock.Anything).Return(&pricing.GetProductsOutput{
+		PriceList: []string{t2MicroPriceList},
+	}, nil)
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		for _, tag := range input.TagSpecifications[0].Tags {
+			if *tag.Key == "EstimatedHourlyCost" {
+				return *tag.Value == "0.0116 USD"
+			}
+		}
+		return false
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+
+	mockClient.AssertExpectations(t)
+	mockPricing.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceCapacityFailover(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:          "subnet-1234567890abcdef0",
+		FallbackSubnetIDs: []string{"subnet-1234567890abcdef1"},
+		ControllerID:      "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return *input.SubnetId == "subnet-1234567890abcdef0"
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{}, &smithy.GenericAPIError{
+		Code: "InsufficientInstanceCapacity",
+	}).Once()
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return *input.SubnetId == "subnet-1234567890abcdef1"
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil).Once()
+
+	instance, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	require.Equal(t, "subnet-1234567890abcdef1", spec.SubnetID)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceCapacityExhausted(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Region:   "us-west-2",
+		SubnetID: "subnet-1234567890abcdef0",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    cfg,
+		client: mockClient,
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:          "subnet-1234567890abcdef0",
+		FallbackSubnetIDs: []string{"subnet-1234567890abcdef1"},
+		ControllerID:      "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{}, &smithy.GenericAPIError{
+		Code: "InsufficientInstanceCapacity",
+	}).Twice()
+
+	_, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.ErrorContains(t, err, "InsufficientInstanceCapacity")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestGetInstanceDeduplicatesConcurrentLookups(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	release := make(chan struct{})
+	mockClient.On("DescribeInstances", ctx, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == instanceID
+	}), mock.Anything).Run(func(_ mock.Arguments) {
+		<-release
+	}).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+					},
+				},
+			},
+		},
+	}, nil).Once()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := awsCli.GetInstance(ctx, instanceID)
+			require.NoError(t, err)
+			require.Equal(t, instanceID, *instance.InstanceId)
+		}()
+	}
+	// Give all goroutines a chance to join the in-flight lookup.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mockClient.AssertNumberOfCalls(t, "DescribeInstances", 1)
+}
+
+func TestCreateRunningInstanceReusesPendingInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	runnerSpec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		ControllerID: "controllerID",
+	}
+	mockClient.On("DescribeInstances", ctx, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.Filters) == 3 && input.Filters[0].Values[0] == "controllerID" && input.Filters[1].Values[0] == "instance-name"
+	}), mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String("i-0a0a0a0a0a0a0a0a0"),
+						State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+					},
+					{
+						InstanceId: aws.String("i-0b0b0b0b0b0b0b0b0"),
+						State:      &types.InstanceState{Name: types.InstanceStateNamePending},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockClient.On("TerminateInstances", ctx, mock.MatchedBy(func(input *ec2.TerminateInstancesInput) bool {
+		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == "i-0a0a0a0a0a0a0a0a0"
+	}), mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, "i-0b0b0b0b0b0b0b0b0", instance)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "RunInstances", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateRunningInstanceReplacesStoppedInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		}, nil
+	}
+	runnerSpec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		ControllerID: "controllerID",
+	}
+	mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String("i-0a0a0a0a0a0a0a0a0"),
+						State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String("i-0b0b0b0b0b0b0b0b0"),
+			},
+		},
+	}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, "i-0b0b0b0b0b0b0b0b0", instance)
+	mockClient.AssertExpectations(t)
+}
+
+func TestWaitForTerminated(t *testing.T) {
+	instanceID := "i-1234567890abcdef0"
+	describeOutput := func(state types.InstanceStateName) *ec2.DescribeInstancesOutput {
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []types.Reservation{
+				{
+					Instances: []types.Instance{
+						{
+							InstanceId: aws.String(instanceID),
+							State:      &types.InstanceState{Name: state},
+						},
+					},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		output    *ec2.DescribeInstancesOutput
+		err       error
+		errString string
+	}{
+		{
+			name:   "terminated",
+			output: describeOutput(types.InstanceStateNameTerminated),
+		},
+		{
+			name:   "gone",
+			output: &ec2.DescribeInstancesOutput{},
+			err: &smithy.GenericAPIError{
+				Code: "InvalidInstanceID.NotFound",
+			},
+		},
+		{
+			name:      "still shutting down",
+			output:    describeOutput(types.InstanceStateNameShuttingDown),
+			errString: "failed waiting for instance i-1234567890abcdef0 to terminate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{},
+				client: mockClient,
+			}
+			mockClient.On("DescribeInstances", mock.Anything, &ec2.DescribeInstancesInput{
+				InstanceIds: []string{instanceID},
+			}, mock.Anything).Return(tt.output, tt.err)
+
+			err := awsCli.WaitForTerminated(context.Background(), instanceID, time.Second)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestWaitForStopped(t *testing.T) {
+	instanceID := "i-1234567890abcdef0"
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{},
+		client: mockClient,
+	}
+	mockClient.On("DescribeInstances", mock.Anything, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+						State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+					},
+				},
+			},
+		},
+	}, nil)
+
+	err := awsCli.WaitForStopped(context.Background(), instanceID, time.Second)
+	require.NoError(t, err)
+}
@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+)
+
+const (
+	// benchmarkMaxAttempts is how often a throttled launch is attempted
+	// before it counts as failed.
+	benchmarkMaxAttempts = 5
+	// maxTerminateBatch is the most instances TerminateInstances accepts in
+	// a single call.
+	maxTerminateBatch = 1000
+)
+
+// benchmarkBackoff is the delay before retrying the first throttled launch.
+// It doubles with every further attempt.
+var benchmarkBackoff = time.Second
+
+// BenchmarkOptions configures a benchmark run.
+type BenchmarkOptions struct {
+	// Count is the number of instances to launch.
+	Count int
+	// Concurrency is the number of launches in flight at any time.
+	Concurrency int
+	// Image is the image to launch. It may be an SSM reference.
+	Image string
+	// Flavor is the instance type to launch.
+	Flavor string
+	// Live launches real instances, which are terminated once the run
+	// finishes. Otherwise launches are made with DryRun set, which checks
+	// permissions and parameters without creating anything.
+	Live bool
+}
+
+// LatencyReport holds launch latencies, in milliseconds.
+type LatencyReport struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// BenchmarkReport is the result of a benchmark run.
+type BenchmarkReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Region      string    `json:"region"`
+	DryRun      bool      `json:"dry_run"`
+	Flavor      string    `json:"flavor"`
+	Requested   int       `json:"requested"`
+	Concurrency int       `json:"concurrency"`
+	Succeeded   int       `json:"succeeded"`
+	Failed      int       `json:"failed"`
+	// Throttled counts the launch attempts EC2 rejected because of API
+	// rate limits, including those that succeeded when retried.
+	Throttled         int     `json:"throttled_requests"`
+	DurationSeconds   float64 `json:"duration_seconds"`
+	LaunchesPerSecond float64 `json:"launches_per_second"`
+	// Latency is measured over successful launches and includes the time
+	// spent waiting to retry throttled attempts.
+	Latency    LatencyReport  `json:"latency_ms"`
+	Errors     map[string]int `json:"errors,omitempty"`
+	Terminated int            `json:"terminated,omitempty"`
+}
+
+type launchResult struct {
+	instanceID string
+	latency    time.Duration
+	throttled  int
+	err        error
+}
+
+func isThrottleErr(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// errorCode returns the EC2 error code of err, or its message if it did not
+// come from EC2.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return err.Error()
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	idx = max(0, min(idx, len(sorted)-1))
+	return float64(sorted[idx]) / float64(time.Millisecond)
+}
+
+// benchmarkLaunch launches a single instance, retrying throttled attempts
+// with an exponential backoff. SDK retries are disabled, so every throttled
+// attempt is counted.
+func (a *AwsCli) benchmarkLaunch(ctx context.Context, input ec2.RunInstancesInput) launchResult {
+	var result launchResult
+	start := time.Now()
+	delay := benchmarkBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := a.client.RunInstances(ctx, &input, func(o *ec2.Options) {
+			o.RetryMaxAttempts = 1
+		})
+		if err == nil {
+			if len(resp.Instances) == 0 || resp.Instances[0].InstanceId == nil {
+				result.err = fmt.Errorf("no instance was launched")
+				return result
+			}
+			result.instanceID = *resp.Instances[0].InstanceId
+			result.latency = time.Since(start)
+			return result
+		}
+		if aws.ToBool(input.DryRun) && errorCode(err) == "DryRunOperation" {
+			// The request would have succeeded.
+			result.latency = time.Since(start)
+			return result
+		}
+		if !isThrottleErr(err) {
+			result.err = err
+			return result
+		}
+		result.throttled++
+		if attempt == benchmarkMaxAttempts {
+			result.err = err
+			return result
+		}
+		select {
+		case <-ctx.Done():
+			result.err = ctx.Err()
+			return result
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// terminateBenchmarkInstances terminates the instances launched by a live
+// benchmark run and returns how many were terminated.
+func (a *AwsCli) terminateBenchmarkInstances(ctx context.Context, instanceIDs []string) (int, error) {
+	var terminated int
+	for len(instanceIDs) > 0 {
+		batch := instanceIDs[:min(len(instanceIDs), maxTerminateBatch)]
+		if _, err := a.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: batch,
+		}); err != nil {
+			return terminated, fmt.Errorf("failed to terminate benchmark instances: %w", err)
+		}
+		terminated += len(batch)
+		instanceIDs = instanceIDs[len(batch):]
+	}
+	return terminated, nil
+}
+
+// Benchmark launches opts.Count instances in the default subnet, using the
+// default security groups, and reports the throughput, throttling and
+// latencies of the launches. Instances created by a live run are tagged with
+// GARM_BENCHMARK and terminated before returning, even if ctx is canceled.
+func (a *AwsCli) Benchmark(ctx context.Context, opts BenchmarkOptions) (BenchmarkReport, error) {
+	if opts.Count < 1 {
+		return BenchmarkReport{}, fmt.Errorf("count must be at least 1")
+	}
+	if opts.Concurrency < 1 {
+		return BenchmarkReport{}, fmt.Errorf("concurrency must be at least 1")
+	}
+
+	target := &spec.RunnerSpec{
+		SubnetID:         a.cfg.SubnetID,
+		SecurityGroupIDs: a.cfg.SecurityGroupIDs,
+	}
+	target.BootstrapParams.Image = opts.Image
+	if err := a.resolveSSMReferences(ctx, target); err != nil {
+		return BenchmarkReport{}, fmt.Errorf("failed to resolve ssm parameters: %w", err)
+	}
+
+	runID := time.Now().UTC().Format("20060102T150405Z")
+	input := ec2.RunInstancesInput{
+		ImageId:          aws.String(target.BootstrapParams.Image),
+		InstanceType:     types.InstanceType(opts.Flavor),
+		MinCount:         aws.Int32(1),
+		MaxCount:         aws.Int32(1),
+		SubnetId:         aws.String(target.SubnetID),
+		SecurityGroupIds: target.SecurityGroupIDs,
+		DryRun:           aws.Bool(!opts.Live),
+		TagSpecifications: launchTagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("garm-benchmark-" + runID)},
+			{Key: aws.String("GARM_BENCHMARK"), Value: aws.String(runID)},
+		}),
+	}
+
+	report := BenchmarkReport{
+		GeneratedAt: time.Now().UTC(),
+		Region:      a.cfg.Region,
+		DryRun:      !opts.Live,
+		Flavor:      opts.Flavor,
+		Requested:   opts.Count,
+		Concurrency: opts.Concurrency,
+		Errors:      map[string]int{},
+	}
+
+	jobs := make(chan struct{})
+	results := make(chan launchResult)
+	var wg sync.WaitGroup
+	for range min(opts.Concurrency, opts.Count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				results <- a.benchmarkLaunch(ctx, input)
+			}
+		}()
+	}
+
+	start := time.Now()
+	go func() {
+		defer close(jobs)
+		for range opts.Count {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- struct{}{}:
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var latencies []time.Duration
+	var instanceIDs []string
+	for result := range results {
+		report.Throttled += result.throttled
+		if result.err != nil {
+			report.Failed++
+			report.Errors[errorCode(result.err)]++
+			continue
+		}
+		report.Succeeded++
+		latencies = append(latencies, result.latency)
+		if result.instanceID != "" {
+			instanceIDs = append(instanceIDs, result.instanceID)
+		}
+	}
+	elapsed := time.Since(start)
+
+	report.DurationSeconds = elapsed.Seconds()
+	if elapsed > 0 {
+		report.LaunchesPerSecond = float64(report.Succeeded) / elapsed.Seconds()
+	}
+	slices.Sort(latencies)
+	report.Latency = LatencyReport{
+		Min: percentile(latencies, 0),
+		P50: percentile(latencies, 50),
+		P95: percentile(latencies, 95),
+		P99: percentile(latencies, 99),
+		Max: percentile(latencies, 100),
+	}
+
+	if len(instanceIDs) > 0 {
+		slog.InfoContext(ctx, "terminating benchmark instances", "count", len(instanceIDs))
+		terminated, err := a.terminateBenchmarkInstances(context.WithoutCancel(ctx), instanceIDs)
+		report.Terminated = terminated
+		if err != nil {
+			return report, fmt.Errorf("%w (instances are tagged with GARM_BENCHMARK=%s)", err, runID)
+		}
+	}
+	return report, ctx.Err()
+}
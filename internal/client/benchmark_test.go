@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 20; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	require.Equal(t, float64(1), percentile(latencies, 0))
+	require.Equal(t, float64(10), percentile(latencies, 50))
+	require.Equal(t, float64(19), percentile(latencies, 95))
+	require.Equal(t, float64(20), percentile(latencies, 100))
+	require.Equal(t, float64(0), percentile(nil, 95))
+}
+
+func TestBenchmarkDryRun(t *testing.T) {
+	defaultBackoff := benchmarkBackoff
+	defer func() { benchmarkBackoff = defaultBackoff }()
+	benchmarkBackoff = time.Millisecond
+
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:           "us-west-2",
+			SubnetID:         "subnet-1234567890abcdef0",
+			SecurityGroupIDs: []string{"sg-1234567890abcdef0"},
+		},
+		client: mockClient,
+	}
+
+	isDryRun := mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return aws.ToBool(input.DryRun) &&
+			aws.ToString(input.ImageId) == "ami-12345678" &&
+			aws.ToString(input.SubnetId) == "subnet-1234567890abcdef0" &&
+			input.InstanceType == types.InstanceTypeT3Nano
+	})
+	mockClient.On("RunInstances", ctx, isDryRun, mock.Anything).Return((*ec2.RunInstancesOutput)(nil), &smithy.GenericAPIError{
+		Code: "RequestLimitExceeded",
+	}).Twice()
+	mockClient.On("RunInstances", ctx, isDryRun, mock.Anything).Return((*ec2.RunInstancesOutput)(nil), &smithy.GenericAPIError{
+		Code: "UnauthorizedOperation",
+	}).Once()
+	mockClient.On("RunInstances", ctx, isDryRun, mock.Anything).Return((*ec2.RunInstancesOutput)(nil), &smithy.GenericAPIError{
+		Code: "DryRunOperation",
+	})
+
+	report, err := awsCli.Benchmark(ctx, BenchmarkOptions{
+		Count:       4,
+		Concurrency: 1,
+		Image:       "ami-12345678",
+		Flavor:      "t3.nano",
+	})
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, 4, report.Requested)
+	require.Equal(t, 3, report.Succeeded)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, 2, report.Throttled)
+	require.Equal(t, map[string]int{"UnauthorizedOperation": 1}, report.Errors)
+	require.LessOrEqual(t, report.Latency.P50, report.Latency.P95)
+	mockClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBenchmarkLive(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return !aws.ToBool(input.DryRun) &&
+			aws.ToString(input.TagSpecifications[0].Tags[1].Key) == "GARM_BENCHMARK"
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{InstanceId: aws.String("i-1234567890abcdef0")},
+		},
+	}, nil).Times(3)
+	mockClient.On("TerminateInstances", mock.Anything, mock.MatchedBy(func(input *ec2.TerminateInstancesInput) bool {
+		return len(input.InstanceIds) == 3
+	}), mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil).Once()
+
+	report, err := awsCli.Benchmark(ctx, BenchmarkOptions{
+		Count:       3,
+		Concurrency: 2,
+		Image:       "ami-12345678",
+		Flavor:      "t3.nano",
+		Live:        true,
+	})
+	require.NoError(t, err)
+	require.False(t, report.DryRun)
+	require.Equal(t, 3, report.Succeeded)
+	require.Equal(t, 3, report.Terminated)
+	mockClient.AssertExpectations(t)
+}
+
+func TestBenchmarkInvalidOptions(t *testing.T) {
+	awsCli := &AwsCli{cfg: &config.Config{Region: "us-west-2"}}
+
+	_, err := awsCli.Benchmark(context.Background(), BenchmarkOptions{Concurrency: 1})
+	require.EqualError(t, err, "count must be at least 1")
+	_, err = awsCli.Benchmark(context.Background(), BenchmarkOptions{Count: 1})
+	require.EqualError(t, err, "concurrency must be at least 1")
+}
@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// cacheVolumeTimeout is the maximum time to wait for a new cache volume to
+// become available.
+const cacheVolumeTimeout = 5 * time.Minute
+
+// cacheVolumeTags returns the tags that make a volume a cache volume of the
+// pool of the spec.
+func cacheVolumeTags(spec *spec.RunnerSpec) []types.Tag {
+	return []types.Tag{
+		{
+			Key:   aws.String("GARM_CONTROLLER_ID"),
+			Value: aws.String(spec.ControllerID),
+		},
+		{
+			Key:   aws.String("GARM_POOL_ID"),
+			Value: aws.String(spec.BootstrapParams.PoolID),
+		},
+		{
+			Key:   aws.String(util.CacheSnapshotTag),
+			Value: aws.String(spec.CacheSnapshotID),
+		},
+	}
+}
+
+// idleCacheVolumes returns the IDs of the cache volumes of the pool in the
+// availability zone that are not attached to any instance.
+func (a *AwsCli) idleCacheVolumes(ctx context.Context, spec *spec.RunnerSpec, zone string) ([]string, error) {
+	filters := []types.Filter{
+		{
+			Name:   aws.String("availability-zone"),
+			Values: []string{zone},
+		},
+		{
+			Name:   aws.String("status"),
+			Values: []string{string(types.VolumeStateAvailable)},
+		},
+	}
+	for _, tag := range cacheVolumeTags(spec) {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("tag:" + aws.ToString(tag.Key)),
+			Values: []string{aws.ToString(tag.Value)},
+		})
+	}
+
+	var volumeIDs []string
+	paginator := ec2.NewDescribeVolumesPaginator(a.client, &ec2.DescribeVolumesInput{
+		Filters: filters,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe volumes: %w", err)
+		}
+		for _, volume := range page.Volumes {
+			if volume.VolumeId != nil {
+				volumeIDs = append(volumeIDs, *volume.VolumeId)
+			}
+		}
+	}
+	return volumeIDs, nil
+}
+
+// createCacheVolume creates a cache volume for the pool from its cache
+// snapshot, and waits for it to become available.
+func (a *AwsCli) createCacheVolume(ctx context.Context, spec *spec.RunnerSpec, zone string) (string, error) {
+	tags := append([]types.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String("garm-cache-" + spec.BootstrapParams.PoolID),
+		},
+	}, cacheVolumeTags(spec)...)
+	tags = append(tags, configTags(a.cfg.Tags)...)
+
+	input := &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(zone),
+		SnapshotId:       aws.String(spec.CacheSnapshotID),
+		VolumeType:       types.VolumeTypeGp3,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeVolume,
+				Tags:         tags,
+			},
+		},
+	}
+	if spec.Encrypted {
+		input.Encrypted = aws.Bool(true)
+		if spec.KMSKeyID != "" {
+			input.KmsKeyId = aws.String(spec.KMSKeyID)
+		}
+	}
+	resp, err := a.client.CreateVolume(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache volume: %w", err)
+	}
+	volumeID := aws.ToString(resp.VolumeId)
+
+	waiter := ec2.NewVolumeAvailableWaiter(a.client)
+	err = waiter.Wait(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{volumeID},
+	}, cacheVolumeTimeout)
+	if err != nil {
+		return "", fmt.Errorf("failed waiting for cache volume %s to become available: %w", volumeID, err)
+	}
+	return volumeID, nil
+}
+
+// attachCacheVolume attaches an idle cache volume of the pool to the
+// instance once it is running, or a new one if there is none. The volume is
+// not deleted along with the instance, so it becomes idle again when the
+// instance is terminated, and keeps the blocks it already loaded from the
+// snapshot. Idle volumes beyond the cache pool size are deleted.
+func (a *AwsCli) attachCacheVolume(ctx context.Context, instance types.Instance, spec *spec.RunnerSpec) (string, error) {
+	instanceID := aws.ToString(instance.InstanceId)
+	if err := a.WaitForRunning(ctx, instanceID, instanceRunningTimeout); err != nil {
+		return "", err
+	}
+
+	if instance.Placement == nil || aws.ToString(instance.Placement.AvailabilityZone) == "" {
+		// Fleet responses don't always tell the zone.
+		described, err := a.getInstance(ctx, instanceID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get instance %s: %w", instanceID, err)
+		}
+		instance = described
+	}
+	if instance.Placement == nil || aws.ToString(instance.Placement.AvailabilityZone) == "" {
+		return "", fmt.Errorf("availability zone of instance %s is unknown", instanceID)
+	}
+	zone := aws.ToString(instance.Placement.AvailabilityZone)
+
+	idle, err := a.idleCacheVolumes(ctx, spec, zone)
+	if err != nil {
+		return "", err
+	}
+
+	attach := func(volumeID string) error {
+		_, err := a.client.AttachVolume(ctx, &ec2.AttachVolumeInput{
+			InstanceId: aws.String(instanceID),
+			VolumeId:   aws.String(volumeID),
+			Device:     aws.String(spec.CacheDeviceName),
+		})
+		return err
+	}
+
+	attached := ""
+	for len(idle) > 0 {
+		volumeID := idle[0]
+		idle = idle[1:]
+		err := attach(volumeID)
+		if err == nil {
+			attached = volumeID
+			break
+		}
+		if !util.IsEC2VolumeInUseErr(err) {
+			return "", fmt.Errorf("failed to attach volume %s: %w", volumeID, err)
+		}
+		// Another instance of the pool got it first.
+		slog.DebugContext(ctx, "cache volume is no longer idle", "volume_id", volumeID, "instance_id", instanceID)
+	}
+
+	if attached == "" {
+		volumeID, err := a.createCacheVolume(ctx, spec, zone)
+		if err != nil {
+			return "", err
+		}
+		if err := attach(volumeID); err != nil {
+			return "", fmt.Errorf("failed to attach volume %s: %w", volumeID, err)
+		}
+		attached = volumeID
+	}
+
+	if excess := len(idle) - int(spec.CachePoolSize); excess > 0 {
+		for _, volumeID := range idle[:excess] {
+			_, err := a.client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+				VolumeId: aws.String(volumeID),
+			})
+			if err != nil {
+				slog.WarnContext(ctx, "failed to delete idle cache volume", "volume_id", volumeID, "error", err)
+				continue
+			}
+			slog.InfoContext(ctx, "deleted idle cache volume", "volume_id", volumeID, "pool_id", spec.BootstrapParams.PoolID)
+		}
+	}
+	return attached, nil
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachCacheVolume(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+	inUse := &smithy.GenericAPIError{Code: "VolumeInUse", Message: "vol-1 is already attached to an instance"}
+
+	tests := []struct {
+		name          string
+		zone          string
+		describedZone string
+		poolSize      int32
+		idle          []string
+		attachErrs    map[string]error
+		created       string
+		deleted       []string
+		expected      string
+		errString     string
+	}{
+		{
+			name:     "reuses an idle volume",
+			zone:     "us-west-2a",
+			poolSize: 2,
+			idle:     []string{"vol-1"},
+			expected: "vol-1",
+		},
+		{
+			name:       "skips volumes taken by other instances",
+			zone:       "us-west-2a",
+			poolSize:   2,
+			idle:       []string{"vol-1", "vol-2"},
+			attachErrs: map[string]error{"vol-1": inUse},
+			expected:   "vol-2",
+		},
+		{
+			name:     "creates a volume if none is idle",
+			zone:     "us-west-2a",
+			poolSize: 2,
+			created:  "vol-3",
+			expected: "vol-3",
+		},
+		{
+			name:       "creates a volume if all idle ones are taken",
+			zone:       "us-west-2a",
+			poolSize:   2,
+			idle:       []string{"vol-1"},
+			attachErrs: map[string]error{"vol-1": inUse},
+			created:    "vol-3",
+			expected:   "vol-3",
+		},
+		{
+			name:     "deletes idle volumes beyond the pool size",
+			zone:     "us-west-2a",
+			poolSize: 1,
+			idle:     []string{"vol-1", "vol-2", "vol-3"},
+			deleted:  []string{"vol-2"},
+			expected: "vol-1",
+		},
+		{
+			name:       "attach fails",
+			zone:       "us-west-2a",
+			poolSize:   2,
+			idle:       []string{"vol-1"},
+			attachErrs: map[string]error{"vol-1": &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "invalid device name"}},
+			errString:  "failed to attach volume vol-1: api error InvalidParameterValue: invalid device name",
+		},
+		{
+			name:          "looks up the availability zone",
+			describedZone: "us-west-2b",
+			poolSize:      2,
+			idle:          []string{"vol-1"},
+			expected:      "vol-1",
+		},
+		{
+			name:      "unknown availability zone",
+			poolSize:  2,
+			errString: "availability zone of instance i-1234567890abcdef0 is unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+			runnerSpec := &spec.RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					PoolID: "poolID",
+				},
+				ControllerID:    "controllerID",
+				CacheSnapshotID: "snap-0a0a0a0a0a0a0a0a0",
+				CacheDeviceName: spec.DefaultCacheDeviceName,
+				CachePoolSize:   tt.poolSize,
+			}
+			instance := types.Instance{InstanceId: aws.String(instanceID)}
+			zone := tt.zone
+			if zone != "" {
+				instance.Placement = &types.Placement{AvailabilityZone: aws.String(zone)}
+			}
+			described := types.Instance{
+				InstanceId: aws.String(instanceID),
+				State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			}
+			if tt.describedZone != "" {
+				zone = tt.describedZone
+				described.Placement = &types.Placement{AvailabilityZone: aws.String(zone)}
+			}
+
+			// The waiters call Describe* with their own context.
+			mockClient.On("DescribeInstances", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{
+					{
+						Instances: []types.Instance{described},
+					},
+				},
+			}, nil)
+			var idle []types.Volume
+			for _, volumeID := range tt.idle {
+				idle = append(idle, types.Volume{VolumeId: aws.String(volumeID)})
+			}
+			mockClient.On("DescribeVolumes", mock.Anything, &ec2.DescribeVolumesInput{
+				Filters: []types.Filter{
+					{Name: aws.String("availability-zone"), Values: []string{zone}},
+					{Name: aws.String("status"), Values: []string{"available"}},
+					{Name: aws.String("tag:GARM_CONTROLLER_ID"), Values: []string{"controllerID"}},
+					{Name: aws.String("tag:GARM_POOL_ID"), Values: []string{"poolID"}},
+					{Name: aws.String("tag:garm:cache-snapshot"), Values: []string{"snap-0a0a0a0a0a0a0a0a0"}},
+				},
+			}, mock.Anything).Return(&ec2.DescribeVolumesOutput{Volumes: idle}, nil)
+			mockClient.On("DescribeVolumes", mock.Anything, &ec2.DescribeVolumesInput{
+				VolumeIds: []string{tt.created},
+			}, mock.Anything).Return(&ec2.DescribeVolumesOutput{
+				Volumes: []types.Volume{
+					{VolumeId: aws.String(tt.created), State: types.VolumeStateAvailable},
+				},
+			}, nil)
+			mockClient.On("CreateVolume", ctx, mock.Anything, mock.Anything).Return(&ec2.CreateVolumeOutput{
+				VolumeId: aws.String(tt.created),
+			}, nil)
+			for _, volumeID := range append(tt.idle, tt.created) {
+				mockClient.On("AttachVolume", ctx, &ec2.AttachVolumeInput{
+					InstanceId: aws.String(instanceID),
+					VolumeId:   aws.String(volumeID),
+					Device:     aws.String(spec.DefaultCacheDeviceName),
+				}, mock.Anything).Return(&ec2.AttachVolumeOutput{}, tt.attachErrs[volumeID])
+			}
+			mockClient.On("DeleteVolume", ctx, mock.Anything, mock.Anything).Return(&ec2.DeleteVolumeOutput{}, nil)
+
+			volumeID, err := awsCli.attachCacheVolume(ctx, instance, runnerSpec)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, volumeID)
+
+			if tt.created != "" {
+				mockClient.AssertCalled(t, "CreateVolume", ctx, &ec2.CreateVolumeInput{
+					AvailabilityZone: aws.String(zone),
+					SnapshotId:       aws.String("snap-0a0a0a0a0a0a0a0a0"),
+					VolumeType:       types.VolumeTypeGp3,
+					TagSpecifications: []types.TagSpecification{
+						{
+							ResourceType: types.ResourceTypeVolume,
+							Tags: []types.Tag{
+								{Key: aws.String("Name"), Value: aws.String("garm-cache-poolID")},
+								{Key: aws.String("GARM_CONTROLLER_ID"), Value: aws.String("controllerID")},
+								{Key: aws.String("GARM_POOL_ID"), Value: aws.String("poolID")},
+								{Key: aws.String("garm:cache-snapshot"), Value: aws.String("snap-0a0a0a0a0a0a0a0a0")},
+							},
+						},
+					},
+				}, mock.Anything)
+			} else {
+				mockClient.AssertNotCalled(t, "CreateVolume", mock.Anything, mock.Anything, mock.Anything)
+			}
+			for _, volumeID := range tt.deleted {
+				mockClient.AssertCalled(t, "DeleteVolume", ctx, &ec2.DeleteVolumeInput{
+					VolumeId: aws.String(volumeID),
+				}, mock.Anything)
+			}
+			if len(tt.deleted) == 0 {
+				mockClient.AssertNotCalled(t, "DeleteVolume", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
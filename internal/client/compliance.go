@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Names of the checks in a compliance report.
+const (
+	CheckEncryptedVolumes = "encrypted_volumes"
+	CheckIMDSv2Required   = "imdsv2_required"
+	CheckRequiredTags     = "required_tags"
+	CheckApprovedImage    = "approved_image_owner"
+)
+
+// ComplianceReport is the result of checking every instance of a controller
+// against the compliance policy in the config.
+type ComplianceReport struct {
+	GeneratedAt  time.Time            `json:"generated_at"`
+	ControllerID string               `json:"controller_id"`
+	Region       string               `json:"region"`
+	Compliant    bool                 `json:"compliant"`
+	Total        int                  `json:"total_instances"`
+	NonCompliant int                  `json:"non_compliant_instances"`
+	Instances    []InstanceCompliance `json:"instances"`
+}
+
+type InstanceCompliance struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name,omitempty"`
+	PoolID     string `json:"pool_id,omitempty"`
+	ImageID    string `json:"image_id,omitempty"`
+	State      string `json:"state,omitempty"`
+	Compliant  bool   `json:"compliant"`
+	// Checks maps the name of every check that was run to its outcome.
+	Checks     map[string]bool `json:"checks"`
+	Violations []string        `json:"violations,omitempty"`
+}
+
+func (i *InstanceCompliance) record(check string, violations ...string) {
+	i.Checks[check] = len(violations) == 0
+	i.Violations = append(i.Violations, violations...)
+}
+
+// ListControllerInstances returns all instances, in any state but terminated,
+// that were created for the given controller.
+func (a *AwsCli) ListControllerInstances(ctx context.Context, controllerID string) ([]types.Instance, error) {
+	var instances []types.Instance
+	paginator := ec2.NewDescribeInstancesPaginator(a.client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:GARM_CONTROLLER_ID"),
+				Values: []string{controllerID},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping", "stopped"},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+		for _, reserv := range page.Reservations {
+			instances = append(instances, reserv.Instances...)
+		}
+	}
+	return instances, nil
+}
+
+func (a *AwsCli) describeVolumes(ctx context.Context, volumeIDs []string) (map[string]types.Volume, error) {
+	volumes := map[string]types.Volume{}
+	if len(volumeIDs) == 0 {
+		return volumes, nil
+	}
+
+	paginator := ec2.NewDescribeVolumesPaginator(a.client, &ec2.DescribeVolumesInput{
+		VolumeIds: volumeIDs,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe volumes: %w", err)
+		}
+		for _, volume := range page.Volumes {
+			if volume.VolumeId != nil {
+				volumes[*volume.VolumeId] = volume
+			}
+		}
+	}
+	return volumes, nil
+}
+
+func (a *AwsCli) describeImages(ctx context.Context, imageIDs []string) (map[string]types.Image, error) {
+	images := map[string]types.Image{}
+	if len(imageIDs) == 0 {
+		return images, nil
+	}
+
+	resp, err := a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: imageIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe images: %w", err)
+	}
+	for _, image := range resp.Images {
+		if image.ImageId != nil {
+			images[*image.ImageId] = image
+		}
+	}
+	return images, nil
+}
+
+// CheckCompliance checks every instance of the controller for encrypted
+// volumes, required IMDSv2, the required tags and, if configured, the owner
+// of the image it was launched from.
+func (a *AwsCli) CheckCompliance(ctx context.Context, controllerID string) (ComplianceReport, error) {
+	report := ComplianceReport{
+		GeneratedAt:  time.Now().UTC(),
+		ControllerID: controllerID,
+		Region:       a.cfg.Region,
+		Compliant:    true,
+		Instances:    []InstanceCompliance{},
+	}
+
+	instances, err := a.ListControllerInstances(ctx, controllerID)
+	if err != nil {
+		return ComplianceReport{}, err
+	}
+
+	var volumeIDs, imageIDs []string
+	for _, instance := range instances {
+		for _, mapping := range instance.BlockDeviceMappings {
+			if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+				volumeIDs = append(volumeIDs, *mapping.Ebs.VolumeId)
+			}
+		}
+		if instance.ImageId != nil && !slices.Contains(imageIDs, *instance.ImageId) {
+			imageIDs = append(imageIDs, *instance.ImageId)
+		}
+	}
+
+	volumes, err := a.describeVolumes(ctx, volumeIDs)
+	if err != nil {
+		return ComplianceReport{}, err
+	}
+
+	policy := a.cfg.Compliance
+	images := map[string]types.Image{}
+	if len(policy.ApprovedImageOwners) > 0 {
+		images, err = a.describeImages(ctx, imageIDs)
+		if err != nil {
+			return ComplianceReport{}, err
+		}
+	}
+
+	for _, instance := range instances {
+		result := InstanceCompliance{
+			InstanceID: aws.ToString(instance.InstanceId),
+			ImageID:    aws.ToString(instance.ImageId),
+			Checks:     map[string]bool{},
+		}
+		if instance.State != nil {
+			result.State = string(instance.State.Name)
+		}
+
+		tags := map[string]string{}
+		for _, tag := range instance.Tags {
+			if tag.Key != nil {
+				tags[*tag.Key] = aws.ToString(tag.Value)
+			}
+		}
+		result.Name = tags["Name"]
+		result.PoolID = tags["GARM_POOL_ID"]
+
+		var unencrypted []string
+		for _, mapping := range instance.BlockDeviceMappings {
+			if mapping.Ebs == nil || mapping.Ebs.VolumeId == nil {
+				continue
+			}
+			volume, ok := volumes[*mapping.Ebs.VolumeId]
+			if !ok || !aws.ToBool(volume.Encrypted) {
+				unencrypted = append(unencrypted, fmt.Sprintf("volume %s (%s) is not encrypted", *mapping.Ebs.VolumeId, aws.ToString(mapping.DeviceName)))
+			}
+		}
+		result.record(CheckEncryptedVolumes, unencrypted...)
+
+		if instance.MetadataOptions == nil || instance.MetadataOptions.HttpTokens != types.HttpTokensStateRequired {
+			result.record(CheckIMDSv2Required, "IMDSv2 is not required")
+		} else {
+			result.record(CheckIMDSv2Required)
+		}
+
+		var missingTags []string
+		for _, key := range policy.GetRequiredTags() {
+			if _, ok := tags[key]; !ok {
+				missingTags = append(missingTags, fmt.Sprintf("missing tag %s", key))
+			}
+		}
+		result.record(CheckRequiredTags, missingTags...)
+
+		if len(policy.ApprovedImageOwners) > 0 {
+			image, ok := images[result.ImageID]
+			switch {
+			case !ok:
+				// Deregistered images can't be traced back to an owner.
+				result.record(CheckApprovedImage, fmt.Sprintf("image %s not found", result.ImageID))
+			case !slices.Contains(policy.ApprovedImageOwners, aws.ToString(image.OwnerId)) &&
+				!slices.Contains(policy.ApprovedImageOwners, aws.ToString(image.ImageOwnerAlias)):
+				result.record(CheckApprovedImage, fmt.Sprintf("image %s is owned by unapproved owner %s", result.ImageID, aws.ToString(image.OwnerId)))
+			default:
+				result.record(CheckApprovedImage)
+			}
+		}
+
+		result.Compliant = len(result.Violations) == 0
+		if !result.Compliant {
+			report.Compliant = false
+			report.NonCompliant++
+		}
+		report.Instances = append(report.Instances, result)
+	}
+	report.Total = len(report.Instances)
+
+	return report, nil
+}
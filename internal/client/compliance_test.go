@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func complianceTestInstance(id, volumeID, imageID string, tokens types.HttpTokensState, tags ...string) types.Instance {
+	instance := types.Instance{
+		InstanceId: aws.String(id),
+		ImageId:    aws.String(imageID),
+		State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+		BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs:        &types.EbsInstanceBlockDevice{VolumeId: aws.String(volumeID)},
+			},
+		},
+		MetadataOptions: &types.InstanceMetadataOptionsResponse{HttpTokens: tokens},
+	}
+	for _, key := range tags {
+		instance.Tags = append(instance.Tags, types.Tag{Key: aws.String(key), Value: aws.String(key + "-value")})
+	}
+	return instance
+}
+
+func TestCheckCompliance(t *testing.T) {
+	ctx := context.Background()
+	controllerID := "controller-id"
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+			Compliance: config.Compliance{
+				RequiredTags:        []string{"Name", "GARM_POOL_ID"},
+				ApprovedImageOwners: []string{"123456789012"},
+			},
+		},
+		client: mockClient,
+	}
+
+	compliant := complianceTestInstance("i-compliant", "vol-encrypted", "ami-approved", types.HttpTokensStateRequired, "Name", "GARM_POOL_ID")
+	offending := complianceTestInstance("i-offending", "vol-plain", "ami-foreign", types.HttpTokensStateOptional, "Name")
+
+	mockClient.On("DescribeInstances", ctx, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return aws.ToString(input.Filters[0].Name) == "tag:GARM_CONTROLLER_ID" && input.Filters[0].Values[0] == controllerID
+	}), mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{Instances: []types.Instance{compliant, offending}},
+		},
+	}, nil)
+	mockClient.On("DescribeVolumes", ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{"vol-encrypted", "vol-plain"},
+	}, mock.Anything).Return(&ec2.DescribeVolumesOutput{
+		Volumes: []types.Volume{
+			{VolumeId: aws.String("vol-encrypted"), Encrypted: aws.Bool(true)},
+			{VolumeId: aws.String("vol-plain"), Encrypted: aws.Bool(false)},
+		},
+	}, nil)
+	mockClient.On("DescribeImages", ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{"ami-approved", "ami-foreign"},
+	}, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{ImageId: aws.String("ami-approved"), OwnerId: aws.String("123456789012")},
+			{ImageId: aws.String("ami-foreign"), OwnerId: aws.String("210987654321")},
+		},
+	}, nil)
+
+	report, err := awsCli.CheckCompliance(ctx, controllerID)
+	require.NoError(t, err)
+	require.False(t, report.Compliant)
+	require.Equal(t, 2, report.Total)
+	require.Equal(t, 1, report.NonCompliant)
+	require.Equal(t, controllerID, report.ControllerID)
+
+	require.True(t, report.Instances[0].Compliant)
+	require.Empty(t, report.Instances[0].Violations)
+	require.Equal(t, map[string]bool{
+		CheckEncryptedVolumes: true,
+		CheckIMDSv2Required:   true,
+		CheckRequiredTags:     true,
+		CheckApprovedImage:    true,
+	}, report.Instances[0].Checks)
+
+	require.False(t, report.Instances[1].Compliant)
+	require.Equal(t, "Name-value", report.Instances[1].Name)
+	require.Equal(t, []string{
+		"volume vol-plain (/dev/xvda) is not encrypted",
+		"IMDSv2 is not required",
+		"missing tag GARM_POOL_ID",
+		"image ami-foreign is owned by unapproved owner 210987654321",
+	}, report.Instances[1].Violations)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCheckComplianceWithoutImagePolicy(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+
+	instance := complianceTestInstance("i-compliant", "vol-encrypted", "ami-any", types.HttpTokensStateRequired, config.DefaultRequiredTags...)
+	mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{Instances: []types.Instance{instance}},
+		},
+	}, nil)
+	mockClient.On("DescribeVolumes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeVolumesOutput{
+		Volumes: []types.Volume{
+			{VolumeId: aws.String("vol-encrypted"), Encrypted: aws.Bool(true)},
+		},
+	}, nil)
+
+	report, err := awsCli.CheckCompliance(ctx, "controller-id")
+	require.NoError(t, err)
+	require.True(t, report.Compliant)
+	require.NotContains(t, report.Instances[0].Checks, CheckApprovedImage)
+	mockClient.AssertNotCalled(t, "DescribeImages", mock.Anything, mock.Anything, mock.Anything)
+}
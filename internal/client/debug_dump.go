@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// DebugDirEnvVar names the environment variable that, if set, holds the
+// directory the launch parameters of every instance are written to before
+// it is launched. The files hold the registration token of the runner.
+const DebugDirEnvVar = "GARM_PROVIDER_AWS_DEBUG_DIR"
+
+// decodeUserData returns the user data as the instance sees it, decompressed
+// if it was compressed to fit the EC2 limit.
+func decodeUserData(encoded string) ([]byte, error) {
+	udata, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user data: %w", err)
+	}
+	if !bytes.HasPrefix(udata, []byte{0x1f, 0x8b}) {
+		return udata, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(udata))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress user data: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// dumpLaunch writes the RunInstances input, and the decoded user data, of
+// the instance to the debug directory, if one is set. Failing to write them
+// never fails the launch.
+func dumpLaunch(ctx context.Context, name string, input *ec2.RunInstancesInput) {
+	dir := os.Getenv(DebugDirEnvVar)
+	if dir == "" {
+		return
+	}
+	if err := writeLaunchDump(dir, name, time.Now(), input); err != nil {
+		slog.WarnContext(ctx, "failed to write launch debug dump", "name", name, "dir", dir, "error", err)
+	}
+}
+
+func writeLaunchDump(dir, name string, now time.Time, input *ec2.RunInstancesInput) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("%s-%s", name, now.UTC().Format("20060102T150405.000Z")))
+
+	data, err := json.MarshalIndent(input, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode input: %w", err)
+	}
+	if err := os.WriteFile(prefix+"-run-instances.json", data, 0o600); err != nil {
+		return err
+	}
+
+	if input.UserData == nil {
+		return nil
+	}
+	udata, err := decodeUserData(aws.ToString(input.UserData))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(prefix+"-user-data", udata, 0o600)
+}
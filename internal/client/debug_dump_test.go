@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpLaunch(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte("#!/bin/bash\necho compressed\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	tests := []struct {
+		name     string
+		userData *string
+		expected string
+	}{
+		{
+			name:     "plain user data",
+			userData: aws.String(base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\necho plain\n"))),
+			expected: "#!/bin/bash\necho plain\n",
+		},
+		{
+			name:     "compressed user data",
+			userData: aws.String(base64.StdEncoding.EncodeToString(compressed.Bytes())),
+			expected: "#!/bin/bash\necho compressed\n",
+		},
+		{
+			name: "without user data",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "debug")
+			t.Setenv(DebugDirEnvVar, dir)
+
+			dumpLaunch(context.Background(), "garm-runner", &ec2.RunInstancesInput{
+				ImageId:  aws.String("ami-12345678"),
+				SubnetId: aws.String("subnet-1234567890abcdef0"),
+				UserData: tt.userData,
+			})
+
+			inputs, err := filepath.Glob(filepath.Join(dir, "garm-runner-*-run-instances.json"))
+			require.NoError(t, err)
+			require.Len(t, inputs, 1)
+			data, err := os.ReadFile(inputs[0])
+			require.NoError(t, err)
+			var input ec2.RunInstancesInput
+			require.NoError(t, json.Unmarshal(data, &input))
+			require.Equal(t, "ami-12345678", aws.ToString(input.ImageId))
+
+			userData, err := filepath.Glob(filepath.Join(dir, "garm-runner-*-user-data"))
+			require.NoError(t, err)
+			if tt.userData == nil {
+				require.Empty(t, userData)
+				return
+			}
+			require.Len(t, userData, 1)
+			info, err := os.Stat(userData[0])
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+			data, err = os.ReadFile(userData[0])
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(data))
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ErrDuplicateInstances is returned when an instance is looked up by name,
+// and more than one instance of the controller has that name.
+var ErrDuplicateInstances = errors.New("duplicate instance name")
+
+// FindDuplicateInstances returns every instance of the controller with the
+// given name that isn't shutting down or terminated.
+func (a *AwsCli) FindDuplicateInstances(ctx context.Context, controllerID, instanceName string) ([]types.Instance, error) {
+	return a.findInstancesByName(ctx, controllerID, instanceName)
+}
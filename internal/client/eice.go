@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+)
+
+// eiceCreateTimeout is how long to wait for a new EC2 Instance Connect
+// Endpoint to become available. Creating one usually takes a few minutes.
+const eiceCreateTimeout = 10 * time.Minute
+
+// eicePollInterval is how often a new endpoint is checked while it is being
+// created.
+var eicePollInterval = 10 * time.Second
+
+// FindInstanceConnectEndpoint returns an available EC2 Instance Connect
+// Endpoint in the VPC. An endpoint can reach instances in any subnet of its
+// VPC, as long as their security groups allow it.
+func (a *AwsCli) FindInstanceConnectEndpoint(ctx context.Context, vpcID string) (types.Ec2InstanceConnectEndpoint, error) {
+	resp, err := a.client.DescribeInstanceConnectEndpoints(ctx, &ec2.DescribeInstanceConnectEndpointsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []string{vpcID},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []string{string(types.Ec2InstanceConnectEndpointStateCreateComplete)},
+			},
+		},
+	})
+	if err != nil {
+		return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("failed to describe instance connect endpoints: %w", err)
+	}
+	if len(resp.InstanceConnectEndpoints) == 0 {
+		return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("no instance connect endpoint in vpc %s: %w", vpcID, garmErrors.ErrNotFound)
+	}
+	return resp.InstanceConnectEndpoints[0], nil
+}
+
+func (a *AwsCli) describeInstanceConnectEndpoint(ctx context.Context, endpointID string) (types.Ec2InstanceConnectEndpoint, error) {
+	resp, err := a.client.DescribeInstanceConnectEndpoints(ctx, &ec2.DescribeInstanceConnectEndpointsInput{
+		InstanceConnectEndpointIds: []string{endpointID},
+	})
+	if err != nil {
+		return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("failed to describe instance connect endpoint %s: %w", endpointID, err)
+	}
+	if len(resp.InstanceConnectEndpoints) == 0 {
+		return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("no such instance connect endpoint %s: %w", endpointID, garmErrors.ErrNotFound)
+	}
+	return resp.InstanceConnectEndpoints[0], nil
+}
+
+// CreateInstanceConnectEndpoint creates an EC2 Instance Connect Endpoint in
+// the subnet and waits for it to become available. Without security groups,
+// the default security group of the VPC is used.
+func (a *AwsCli) CreateInstanceConnectEndpoint(ctx context.Context, subnetID string, securityGroupIDs []string) (types.Ec2InstanceConnectEndpoint, error) {
+	resp, err := a.client.CreateInstanceConnectEndpoint(ctx, &ec2.CreateInstanceConnectEndpointInput{
+		SubnetId:         aws.String(subnetID),
+		SecurityGroupIds: securityGroupIDs,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeInstanceConnectEndpoint,
+				Tags: []types.Tag{
+					{Key: aws.String("Name"), Value: aws.String("garm-runners")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("failed to create instance connect endpoint: %w", err)
+	}
+	if resp.InstanceConnectEndpoint == nil || resp.InstanceConnectEndpoint.InstanceConnectEndpointId == nil {
+		return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("failed to create instance connect endpoint: no endpoint was returned")
+	}
+	endpointID := *resp.InstanceConnectEndpoint.InstanceConnectEndpointId
+	slog.InfoContext(ctx, "created instance connect endpoint, waiting for it to become available", "endpoint_id", endpointID, "subnet_id", subnetID)
+
+	ctx, cancel := context.WithTimeout(ctx, eiceCreateTimeout)
+	defer cancel()
+
+	endpoint := *resp.InstanceConnectEndpoint
+	for {
+		switch endpoint.State {
+		case types.Ec2InstanceConnectEndpointStateCreateComplete:
+			return endpoint, nil
+		case types.Ec2InstanceConnectEndpointStateCreateFailed:
+			return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("failed to create instance connect endpoint %s: %s", endpointID, aws.ToString(endpoint.StateMessage))
+		}
+
+		select {
+		case <-ctx.Done():
+			return types.Ec2InstanceConnectEndpoint{}, fmt.Errorf("instance connect endpoint %s did not become available: %w", endpointID, ctx.Err())
+		case <-time.After(eicePollInterval):
+		}
+
+		endpoint, err = a.describeInstanceConnectEndpoint(ctx, endpointID)
+		if err != nil {
+			return types.Ec2InstanceConnectEndpoint{}, err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindInstanceConnectEndpoint(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+
+	inVPC := func(vpcID string) interface{} {
+		return mock.MatchedBy(func(input *ec2.DescribeInstanceConnectEndpointsInput) bool {
+			return input.Filters[0].Values[0] == vpcID && input.Filters[1].Values[0] == "create-complete"
+		})
+	}
+	mockClient.On("DescribeInstanceConnectEndpoints", ctx, inVPC("vpc-0a0a0a0a0a0a0a0a0"), mock.Anything).Return(&ec2.DescribeInstanceConnectEndpointsOutput{
+		InstanceConnectEndpoints: []types.Ec2InstanceConnectEndpoint{
+			{InstanceConnectEndpointId: aws.String("eice-0a0a0a0a0a0a0a0a0")},
+		},
+	}, nil)
+	mockClient.On("DescribeInstanceConnectEndpoints", ctx, inVPC("vpc-0b0b0b0b0b0b0b0b0"), mock.Anything).Return(&ec2.DescribeInstanceConnectEndpointsOutput{}, nil)
+
+	endpoint, err := awsCli.FindInstanceConnectEndpoint(ctx, "vpc-0a0a0a0a0a0a0a0a0")
+	require.NoError(t, err)
+	require.Equal(t, "eice-0a0a0a0a0a0a0a0a0", aws.ToString(endpoint.InstanceConnectEndpointId))
+
+	_, err = awsCli.FindInstanceConnectEndpoint(ctx, "vpc-0b0b0b0b0b0b0b0b0")
+	require.ErrorIs(t, err, garmErrors.ErrNotFound)
+}
+
+func TestCreateInstanceConnectEndpoint(t *testing.T) {
+	defer func(interval time.Duration) { eicePollInterval = interval }(eicePollInterval)
+	eicePollInterval = time.Millisecond
+
+	tests := []struct {
+		name      string
+		states    []types.Ec2InstanceConnectEndpointState
+		errString string
+	}{
+		{
+			name: "becomes available",
+			states: []types.Ec2InstanceConnectEndpointState{
+				types.Ec2InstanceConnectEndpointStateCreateInProgress,
+				types.Ec2InstanceConnectEndpointStateCreateComplete,
+			},
+		},
+		{
+			name: "creation fails",
+			states: []types.Ec2InstanceConnectEndpointState{
+				types.Ec2InstanceConnectEndpointStateCreateFailed,
+			},
+			errString: "failed to create instance connect endpoint eice-0a0a0a0a0a0a0a0a0: quota exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+
+			mockClient.On("CreateInstanceConnectEndpoint", ctx, mock.MatchedBy(func(input *ec2.CreateInstanceConnectEndpointInput) bool {
+				return aws.ToString(input.SubnetId) == "subnet-0a0a0a0a0a0a0a0a0"
+			}), mock.Anything).Return(&ec2.CreateInstanceConnectEndpointOutput{
+				InstanceConnectEndpoint: &types.Ec2InstanceConnectEndpoint{
+					InstanceConnectEndpointId: aws.String("eice-0a0a0a0a0a0a0a0a0"),
+					State:                     types.Ec2InstanceConnectEndpointStateCreateInProgress,
+				},
+			}, nil)
+			for _, state := range tt.states {
+				mockClient.On("DescribeInstanceConnectEndpoints", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceConnectEndpointsOutput{
+					InstanceConnectEndpoints: []types.Ec2InstanceConnectEndpoint{
+						{
+							InstanceConnectEndpointId: aws.String("eice-0a0a0a0a0a0a0a0a0"),
+							State:                     state,
+							StateMessage:              aws.String("quota exceeded"),
+						},
+					},
+				}, nil).Once()
+			}
+
+			endpoint, err := awsCli.CreateInstanceConnectEndpoint(ctx, "subnet-0a0a0a0a0a0a0a0a0", nil)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, types.Ec2InstanceConnectEndpointStateCreateComplete, endpoint.State)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,288 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+)
+
+// launchesFleet returns true if instances of the spec are launched through
+// an EC2 Fleet.
+func launchesFleet(runnerSpec *spec.RunnerSpec) bool {
+	return runnerSpec.LaunchMode == spec.LaunchModeFleet
+}
+
+// fleetError is an error that CreateFleet reported for one of the subnets it
+// tried.
+type fleetError struct {
+	Code             string
+	Message          string
+	SubnetID         string
+	AvailabilityZone string
+	InstanceType     string
+}
+
+func (e fleetError) String() string {
+	return fmt.Sprintf("%s in subnet %s (%s): %s", e.Code, e.SubnetID, e.AvailabilityZone, e.Message)
+}
+
+// fleetErrors converts the errors of a CreateFleet response.
+func fleetErrors(errs []types.CreateFleetError) []fleetError {
+	ret := make([]fleetError, 0, len(errs))
+	for _, err := range errs {
+		fe := fleetError{
+			Code:    aws.ToString(err.ErrorCode),
+			Message: aws.ToString(err.ErrorMessage),
+		}
+		if err.LaunchTemplateAndOverrides != nil && err.LaunchTemplateAndOverrides.Overrides != nil {
+			overrides := err.LaunchTemplateAndOverrides.Overrides
+			fe.SubnetID = aws.ToString(overrides.SubnetId)
+			fe.AvailabilityZone = aws.ToString(overrides.AvailabilityZone)
+			fe.InstanceType = string(overrides.InstanceType)
+		}
+		ret = append(ret, fe)
+	}
+	return ret
+}
+
+// launchTemplateData converts the parameters of a RunInstances request to
+// the data of a launch template. Host placement and IPv6 addresses are not
+// converted, as the spec does not allow them along with fleet launches.
+// Network interfaces are rejected, as the subnets of the fleet overrides
+// don't apply to them.
+func launchTemplateData(input *ec2.RunInstancesInput) (*types.RequestLaunchTemplateData, error) {
+	if len(input.NetworkInterfaces) > 0 {
+		return nil, fmt.Errorf("network interfaces can not be launched through a fleet")
+	}
+
+	data := &types.RequestLaunchTemplateData{
+		ImageId:          input.ImageId,
+		InstanceType:     input.InstanceType,
+		KeyName:          input.KeyName,
+		SecurityGroupIds: input.SecurityGroupIds,
+		UserData:         input.UserData,
+		// Both requests use the same type.
+		CreditSpecification: input.CreditSpecification,
+	}
+	if input.IamInstanceProfile != nil {
+		data.IamInstanceProfile = &types.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Arn:  input.IamInstanceProfile.Arn,
+			Name: input.IamInstanceProfile.Name,
+		}
+	}
+	if input.Placement != nil && input.Placement.Tenancy != "" {
+		data.Placement = &types.LaunchTemplatePlacementRequest{
+			Tenancy: input.Placement.Tenancy,
+		}
+	}
+	if opts := input.CpuOptions; opts != nil {
+		data.CpuOptions = &types.LaunchTemplateCpuOptionsRequest{
+			AmdSevSnp:      opts.AmdSevSnp,
+			CoreCount:      opts.CoreCount,
+			ThreadsPerCore: opts.ThreadsPerCore,
+		}
+	}
+	if reservation := input.CapacityReservationSpecification; reservation != nil {
+		data.CapacityReservationSpecification = &types.LaunchTemplateCapacityReservationSpecificationRequest{
+			CapacityReservationPreference: reservation.CapacityReservationPreference,
+			CapacityReservationTarget:     reservation.CapacityReservationTarget,
+		}
+	}
+	for _, mapping := range input.BlockDeviceMappings {
+		request := types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName:  mapping.DeviceName,
+			NoDevice:    mapping.NoDevice,
+			VirtualName: mapping.VirtualName,
+		}
+		if mapping.Ebs != nil {
+			request.Ebs = &types.LaunchTemplateEbsBlockDeviceRequest{
+				DeleteOnTermination: mapping.Ebs.DeleteOnTermination,
+				Encrypted:           mapping.Ebs.Encrypted,
+				Iops:                mapping.Ebs.Iops,
+				KmsKeyId:            mapping.Ebs.KmsKeyId,
+				SnapshotId:          mapping.Ebs.SnapshotId,
+				Throughput:          mapping.Ebs.Throughput,
+				VolumeSize:          mapping.Ebs.VolumeSize,
+				VolumeType:          mapping.Ebs.VolumeType,
+			}
+		}
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, request)
+	}
+	for _, license := range input.LicenseSpecifications {
+		data.LicenseSpecifications = append(data.LicenseSpecifications, types.LaunchTemplateLicenseConfigurationRequest{
+			LicenseConfigurationArn: license.LicenseConfigurationArn,
+		})
+	}
+	if input.HibernationOptions != nil {
+		data.HibernationOptions = &types.LaunchTemplateHibernationOptionsRequest{
+			Configured: input.HibernationOptions.Configured,
+		}
+	}
+	if opts := input.MetadataOptions; opts != nil {
+		data.MetadataOptions = &types.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint:            types.LaunchTemplateInstanceMetadataEndpointState(opts.HttpEndpoint),
+			HttpProtocolIpv6:        types.LaunchTemplateInstanceMetadataProtocolIpv6(opts.HttpProtocolIpv6),
+			HttpPutResponseHopLimit: opts.HttpPutResponseHopLimit,
+			HttpTokens:              types.LaunchTemplateHttpTokensState(opts.HttpTokens),
+			InstanceMetadataTags:    types.LaunchTemplateInstanceMetadataTagsState(opts.InstanceMetadataTags),
+		}
+	}
+	for _, tagSpec := range input.TagSpecifications {
+		data.TagSpecifications = append(data.TagSpecifications, types.LaunchTemplateTagSpecificationRequest{
+			ResourceType: tagSpec.ResourceType,
+			Tags:         tagSpec.Tags,
+		})
+	}
+	return data, nil
+}
+
+// launchFleet launches the instance through an EC2 Fleet in instant mode,
+// which tries the subnets in order within a single request. The launch
+// template the fleet needs is deleted again once the request returns. Errors
+// reported for subnets that could not host the instance are logged, and only
+// fail the launch if no instance was launched. Instances beyond the first are
+// terminated. The returned instance is only populated with what the fleet
+// response tells about it.
+func (a *AwsCli) launchFleet(ctx context.Context, name string, input *ec2.RunInstancesInput, subnets []string) (types.Instance, error) {
+	data, err := launchTemplateData(input)
+	if err != nil {
+		return types.Instance{}, err
+	}
+
+	templateName := fmt.Sprintf("%s-%d", name, time.Now().UnixNano())
+	template, err := a.client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(templateName),
+		LaunchTemplateData: data,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeLaunchTemplate,
+				Tags:         append([]types.Tag{{Key: aws.String("Name"), Value: aws.String(name)}}, configTags(a.cfg.Tags)...),
+			},
+		},
+	})
+	if err != nil {
+		return types.Instance{}, fmt.Errorf("failed to create launch template: %w", err)
+	}
+	templateID := aws.ToString(template.LaunchTemplate.LaunchTemplateId)
+	defer func() {
+		// The template is not needed once the fleet request returned, even
+		// if ctx was cancelled meanwhile.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lastKnownInstanceTimeout)
+		defer cancel()
+		_, err := a.client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: aws.String(templateID),
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "failed to delete launch template", "launch_template_id", templateID, "error", err)
+		}
+	}()
+
+	overrides := make([]types.FleetLaunchTemplateOverridesRequest, 0, len(subnets))
+	for idx, subnet := range subnets {
+		overrides = append(overrides, types.FleetLaunchTemplateOverridesRequest{
+			SubnetId: aws.String(subnet),
+			Priority: aws.Float64(float64(idx)),
+		})
+	}
+	resp, err := a.client.CreateFleet(ctx, &ec2.CreateFleetInput{
+		Type: types.FleetTypeInstant,
+		LaunchTemplateConfigs: []types.FleetLaunchTemplateConfigRequest{
+			{
+				LaunchTemplateSpecification: &types.FleetLaunchTemplateSpecificationRequest{
+					LaunchTemplateId: aws.String(templateID),
+					Version:          aws.String("$Latest"),
+				},
+				Overrides: overrides,
+			},
+		},
+		TargetCapacitySpecification: &types.TargetCapacitySpecificationRequest{
+			TotalTargetCapacity:       aws.Int32(1),
+			DefaultTargetCapacityType: types.DefaultTargetCapacityTypeOnDemand,
+		},
+		OnDemandOptions: &types.OnDemandOptionsRequest{
+			AllocationStrategy: types.FleetOnDemandAllocationStrategyPrioritized,
+		},
+	})
+	if err != nil {
+		return types.Instance{}, fmt.Errorf("failed to create fleet: %w", err)
+	}
+
+	errs := fleetErrors(resp.Errors)
+	for _, fe := range errs {
+		slog.WarnContext(ctx, "fleet could not launch the instance in subnet",
+			"fleet_id", aws.ToString(resp.FleetId),
+			"subnet_id", fe.SubnetID,
+			"availability_zone", fe.AvailabilityZone,
+			"instance_type", fe.InstanceType,
+			"error_code", fe.Code,
+			"error", fe.Message)
+	}
+
+	var instance types.Instance
+	var excess []string
+	for _, launched := range resp.Instances {
+		for _, instanceID := range launched.InstanceIds {
+			if instance.InstanceId != nil {
+				excess = append(excess, instanceID)
+				continue
+			}
+			instance = types.Instance{
+				InstanceId:   aws.String(instanceID),
+				InstanceType: launched.InstanceType,
+			}
+			if launched.LaunchTemplateAndOverrides != nil && launched.LaunchTemplateAndOverrides.Overrides != nil {
+				overrides := launched.LaunchTemplateAndOverrides.Overrides
+				instance.SubnetId = overrides.SubnetId
+				if overrides.AvailabilityZone != nil {
+					instance.Placement = &types.Placement{AvailabilityZone: overrides.AvailabilityZone}
+				}
+			}
+			for _, tagSpec := range input.TagSpecifications {
+				if tagSpec.ResourceType == types.ResourceTypeInstance {
+					instance.Tags = append(instance.Tags, tagSpec.Tags...)
+				}
+			}
+		}
+	}
+
+	if len(excess) > 0 {
+		slog.WarnContext(ctx, "fleet launched more instances than requested, terminating the excess", "fleet_id", aws.ToString(resp.FleetId), "instance_ids", excess)
+		if _, err := a.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: excess}); err != nil {
+			slog.WarnContext(ctx, "failed to terminate excess fleet instances", "instance_ids", excess, "error", err)
+		}
+	}
+
+	if instance.InstanceId == nil {
+		if len(errs) == 0 {
+			return types.Instance{}, fmt.Errorf("fleet %s launched no instance", aws.ToString(resp.FleetId))
+		}
+		reasons := make([]string, 0, len(errs))
+		for _, fe := range errs {
+			reasons = append(reasons, fe.String())
+		}
+		return types.Instance{}, fmt.Errorf("fleet %s launched no instance: %s", aws.ToString(resp.FleetId), strings.Join(reasons, "; "))
+	}
+	return instance, nil
+}
@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaunchTemplateData(t *testing.T) {
+	input := &ec2.RunInstancesInput{
+		ImageId:          aws.String("ami-12345678"),
+		InstanceType:     types.InstanceTypeT2Micro,
+		KeyName:          aws.String("key"),
+		SecurityGroupIds: []string{"sg-1"},
+		UserData:         aws.String("dXNlcmRhdGE="),
+		IamInstanceProfile: &types.IamInstanceProfileSpecification{
+			Name: aws.String("profile"),
+		},
+		Placement: &types.Placement{Tenancy: types.TenancyDedicated},
+		CpuOptions: &types.CpuOptionsRequest{
+			CoreCount:      aws.Int32(2),
+			ThreadsPerCore: aws.Int32(1),
+		},
+		CreditSpecification: &types.CreditSpecificationRequest{CpuCredits: aws.String("unlimited")},
+		CapacityReservationSpecification: &types.CapacityReservationSpecification{
+			CapacityReservationTarget: &types.CapacityReservationTarget{
+				CapacityReservationId: aws.String("cr-0123456789abcdef0"),
+			},
+		},
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/sda1"),
+				Ebs: &types.EbsBlockDevice{
+					Encrypted:  aws.Bool(true),
+					VolumeSize: aws.Int32(50),
+					VolumeType: types.VolumeTypeGp3,
+				},
+			},
+			{
+				DeviceName: aws.String("/dev/sdb"),
+				NoDevice:   aws.String(""),
+			},
+		},
+		MetadataOptions: &types.InstanceMetadataOptionsRequest{
+			HttpTokens:              types.HttpTokensStateRequired,
+			HttpPutResponseHopLimit: aws.Int32(2),
+		},
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         []types.Tag{{Key: aws.String("Name"), Value: aws.String("garm-instance")}},
+			},
+		},
+	}
+
+	expected := &types.RequestLaunchTemplateData{
+		ImageId:          aws.String("ami-12345678"),
+		InstanceType:     types.InstanceTypeT2Micro,
+		KeyName:          aws.String("key"),
+		SecurityGroupIds: []string{"sg-1"},
+		UserData:         aws.String("dXNlcmRhdGE="),
+		IamInstanceProfile: &types.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Name: aws.String("profile"),
+		},
+		Placement: &types.LaunchTemplatePlacementRequest{Tenancy: types.TenancyDedicated},
+		CpuOptions: &types.LaunchTemplateCpuOptionsRequest{
+			CoreCount:      aws.Int32(2),
+			ThreadsPerCore: aws.Int32(1),
+		},
+		CreditSpecification: &types.CreditSpecificationRequest{CpuCredits: aws.String("unlimited")},
+		CapacityReservationSpecification: &types.LaunchTemplateCapacityReservationSpecificationRequest{
+			CapacityReservationTarget: &types.CapacityReservationTarget{
+				CapacityReservationId: aws.String("cr-0123456789abcdef0"),
+			},
+		},
+		BlockDeviceMappings: []types.LaunchTemplateBlockDeviceMappingRequest{
+			{
+				DeviceName: aws.String("/dev/sda1"),
+				Ebs: &types.LaunchTemplateEbsBlockDeviceRequest{
+					Encrypted:  aws.Bool(true),
+					VolumeSize: aws.Int32(50),
+					VolumeType: types.VolumeTypeGp3,
+				},
+			},
+			{
+				DeviceName: aws.String("/dev/sdb"),
+				NoDevice:   aws.String(""),
+			},
+		},
+		MetadataOptions: &types.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpTokens:              types.LaunchTemplateHttpTokensStateRequired,
+			HttpPutResponseHopLimit: aws.Int32(2),
+		},
+		TagSpecifications: []types.LaunchTemplateTagSpecificationRequest{
+			{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         []types.Tag{{Key: aws.String("Name"), Value: aws.String("garm-instance")}},
+			},
+		},
+	}
+	data, err := launchTemplateData(input)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+
+	input.NetworkInterfaces = []types.InstanceNetworkInterfaceSpecification{{DeviceIndex: aws.Int32(0)}}
+	_, err = launchTemplateData(input)
+	require.EqualError(t, err, "network interfaces can not be launched through a fleet")
+}
+
+func TestLaunchFleet(t *testing.T) {
+	ctx := context.Background()
+	subnets := []string{"subnet-a", "subnet-b"}
+	capacityErr := types.CreateFleetError{
+		ErrorCode:    aws.String("InsufficientInstanceCapacity"),
+		ErrorMessage: aws.String("We currently do not have sufficient t2.micro capacity in the Availability Zone you requested (us-west-2a)."),
+		LaunchTemplateAndOverrides: &types.LaunchTemplateAndOverridesResponse{
+			Overrides: &types.FleetLaunchTemplateOverrides{
+				SubnetId:         aws.String("subnet-a"),
+				AvailabilityZone: aws.String("us-west-2a"),
+				InstanceType:     types.InstanceTypeT2Micro,
+			},
+		},
+	}
+	launchedIn := func(subnet, zone string, instanceIDs ...string) types.CreateFleetInstance {
+		return types.CreateFleetInstance{
+			InstanceIds:  instanceIDs,
+			InstanceType: types.InstanceTypeT2Micro,
+			LaunchTemplateAndOverrides: &types.LaunchTemplateAndOverridesResponse{
+				Overrides: &types.FleetLaunchTemplateOverrides{
+					SubnetId:         aws.String(subnet),
+					AvailabilityZone: aws.String(zone),
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		fleetErr   error
+		errors     []types.CreateFleetError
+		instances  []types.CreateFleetInstance
+		expected   types.Instance
+		terminated []string
+		errString  string
+	}{
+		{
+			name:      "launches in the first subnet",
+			instances: []types.CreateFleetInstance{launchedIn("subnet-a", "us-west-2a", "i-1")},
+			expected: types.Instance{
+				InstanceId:   aws.String("i-1"),
+				InstanceType: types.InstanceTypeT2Micro,
+				SubnetId:     aws.String("subnet-a"),
+				Placement:    &types.Placement{AvailabilityZone: aws.String("us-west-2a")},
+			},
+		},
+		{
+			name:      "partial failure",
+			errors:    []types.CreateFleetError{capacityErr},
+			instances: []types.CreateFleetInstance{launchedIn("subnet-b", "us-west-2b", "i-2")},
+			expected: types.Instance{
+				InstanceId:   aws.String("i-2"),
+				InstanceType: types.InstanceTypeT2Micro,
+				SubnetId:     aws.String("subnet-b"),
+				Placement:    &types.Placement{AvailabilityZone: aws.String("us-west-2b")},
+			},
+		},
+		{
+			name:       "terminates excess instances",
+			instances:  []types.CreateFleetInstance{launchedIn("subnet-a", "us-west-2a", "i-1", "i-2")},
+			terminated: []string{"i-2"},
+			expected: types.Instance{
+				InstanceId:   aws.String("i-1"),
+				InstanceType: types.InstanceTypeT2Micro,
+				SubnetId:     aws.String("subnet-a"),
+				Placement:    &types.Placement{AvailabilityZone: aws.String("us-west-2a")},
+			},
+		},
+		{
+			name:      "only errors",
+			errors:    []types.CreateFleetError{capacityErr},
+			errString: "fleet fleet-1 launched no instance: InsufficientInstanceCapacity in subnet subnet-a (us-west-2a): We currently do not have sufficient t2.micro capacity in the Availability Zone you requested (us-west-2a).",
+		},
+		{
+			name:      "no instances and no errors",
+			errString: "fleet fleet-1 launched no instance",
+		},
+		{
+			name:      "fleet request fails",
+			fleetErr:  &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "not authorized"},
+			errString: "failed to create fleet: api error UnauthorizedOperation: not authorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+
+			mockClient.On("CreateLaunchTemplate", ctx, mock.Anything, mock.Anything).Return(&ec2.CreateLaunchTemplateOutput{
+				LaunchTemplate: &types.LaunchTemplate{LaunchTemplateId: aws.String("lt-1")},
+			}, nil)
+			// The template is deleted with a context that outlives ctx.
+			mockClient.On("DeleteLaunchTemplate", mock.Anything, &ec2.DeleteLaunchTemplateInput{
+				LaunchTemplateId: aws.String("lt-1"),
+			}, mock.Anything).Return(&ec2.DeleteLaunchTemplateOutput{}, nil)
+			mockClient.On("CreateFleet", ctx, mock.Anything, mock.Anything).Return(&ec2.CreateFleetOutput{
+				FleetId:   aws.String("fleet-1"),
+				Errors:    tt.errors,
+				Instances: tt.instances,
+			}, tt.fleetErr)
+			mockClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+			instance, err := awsCli.launchFleet(ctx, "garm-instance", &ec2.RunInstancesInput{
+				ImageId:      aws.String("ami-12345678"),
+				InstanceType: types.InstanceTypeT2Micro,
+			}, subnets)
+
+			mockClient.AssertCalled(t, "CreateFleet", ctx, &ec2.CreateFleetInput{
+				Type: types.FleetTypeInstant,
+				LaunchTemplateConfigs: []types.FleetLaunchTemplateConfigRequest{
+					{
+						LaunchTemplateSpecification: &types.FleetLaunchTemplateSpecificationRequest{
+							LaunchTemplateId: aws.String("lt-1"),
+							Version:          aws.String("$Latest"),
+						},
+						Overrides: []types.FleetLaunchTemplateOverridesRequest{
+							{SubnetId: aws.String("subnet-a"), Priority: aws.Float64(0)},
+							{SubnetId: aws.String("subnet-b"), Priority: aws.Float64(1)},
+						},
+					},
+				},
+				TargetCapacitySpecification: &types.TargetCapacitySpecificationRequest{
+					TotalTargetCapacity:       aws.Int32(1),
+					DefaultTargetCapacityType: types.DefaultTargetCapacityTypeOnDemand,
+				},
+				OnDemandOptions: &types.OnDemandOptionsRequest{
+					AllocationStrategy: types.FleetOnDemandAllocationStrategyPrioritized,
+				},
+			}, mock.Anything)
+			mockClient.AssertCalled(t, "DeleteLaunchTemplate", mock.Anything, mock.Anything, mock.Anything)
+			if len(tt.terminated) > 0 {
+				mockClient.AssertCalled(t, "TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+					InstanceIds: tt.terminated,
+				}, mock.Anything)
+			} else {
+				mockClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+			}
+
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, instance)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// GCOptions tells which instances of a controller are orphaned.
+type GCOptions struct {
+	// Pools are the IDs of the pools the controller still has. Instances
+	// of other pools are orphaned. Pools aren't checked if empty.
+	Pools []string
+	// MaxAge is how long an instance may exist. Older instances are
+	// orphaned. Ages aren't checked if zero.
+	MaxAge time.Duration
+	// Expired makes the instances whose keep_on_failure TTL or deletion
+	// grace period ran out, or that exceeded the max_runtime of their pool,
+	// orphaned as well.
+	Expired bool
+	// FailedGracePeriod makes the instances that failed to bootstrap longer
+	// ago than this orphaned as well. Instances of pools with
+	// keep_on_failure are kept for at least their TTL. Failed instances
+	// aren't checked if zero.
+	FailedGracePeriod time.Duration
+	// DryRun only reports orphaned instances, without terminating them.
+	DryRun bool
+}
+
+// Orphan is an instance found by CollectGarbage.
+type Orphan struct {
+	InstanceID string     `json:"instance_id"`
+	Name       string     `json:"name"`
+	PoolID     string     `json:"pool_id"`
+	LaunchTime *time.Time `json:"launch_time,omitempty"`
+	Reason     string     `json:"reason"`
+	Terminated bool       `json:"terminated"`
+}
+
+// failedLongAgo returns true if the instance failed to bootstrap longer ago
+// than grace, or than the keep_on_failure TTL of its pool if that is longer.
+// Instances kept after GARM deleted them expire on their own.
+func failedLongAgo(instance types.Instance, grace time.Duration, now time.Time) bool {
+	if !util.IsBootstrapFailed(instance) {
+		return false
+	}
+	if _, ok := util.GCAfter(instance); ok {
+		return false
+	}
+	if ttl, ok := keepOnFailureTTL(instance); ok {
+		grace = max(grace, ttl)
+	}
+	return now.Sub(util.BootstrapFailedAt(instance)) > grace
+}
+
+// CollectGarbage terminates the instances of the controller that belong to
+// pools that are gone, that are older than the maximum age, that expired or
+// that failed to bootstrap, and returns them.
+func (a *AwsCli) CollectGarbage(ctx context.Context, controllerID string, opts GCOptions) ([]Orphan, error) {
+	if len(opts.Pools) == 0 && opts.MaxAge <= 0 && !opts.Expired && opts.FailedGracePeriod <= 0 {
+		return nil, fmt.Errorf("either pools, a maximum age, expired or failed instances are needed to tell orphaned instances")
+	}
+
+	instances, err := a.ListControllerInstances(ctx, controllerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var orphans []Orphan
+	var orphaned []types.Instance
+	for _, instance := range instances {
+		if instance.InstanceId == nil {
+			continue
+		}
+		orphan := Orphan{
+			InstanceID: *instance.InstanceId,
+			LaunchTime: instance.LaunchTime,
+		}
+		for _, tag := range instance.Tags {
+			switch aws.ToString(tag.Key) {
+			case "Name":
+				orphan.Name = aws.ToString(tag.Value)
+			case "GARM_POOL_ID":
+				orphan.PoolID = aws.ToString(tag.Value)
+			}
+		}
+
+		expired := ""
+		if opts.Expired {
+			expired = expiryReason(instance, now)
+		}
+		switch {
+		case expired != "":
+			orphan.Reason = expired
+		case opts.FailedGracePeriod > 0 && failedLongAgo(instance, opts.FailedGracePeriod, now):
+			orphan.Reason = fmt.Sprintf("failed to bootstrap more than %s ago", opts.FailedGracePeriod)
+		case len(opts.Pools) > 0 && !slices.Contains(opts.Pools, orphan.PoolID):
+			orphan.Reason = "pool no longer exists"
+		case opts.MaxAge > 0 && instance.LaunchTime != nil && now.Sub(*instance.LaunchTime) > opts.MaxAge:
+			orphan.Reason = fmt.Sprintf("older than %s", opts.MaxAge)
+		default:
+			continue
+		}
+		orphans = append(orphans, orphan)
+		orphaned = append(orphaned, instance)
+	}
+
+	if opts.DryRun || len(orphaned) == 0 {
+		return orphans, nil
+	}
+
+	terminated, err := a.TerminateInstances(ctx, orphaned, "garbage collected")
+	for _, instance := range terminated {
+		a.DeleteEphemeralKeyPair(ctx, instance)
+		a.DeleteUserDataObject(ctx, instance)
+		for i := range orphans {
+			if orphans[i].InstanceID == aws.ToString(instance.InstanceId) {
+				orphans[i].Terminated = true
+			}
+		}
+	}
+	return orphans, err
+}
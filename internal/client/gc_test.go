@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	instance := func(instanceID, poolID string, launched time.Time) types.Instance {
+		return types.Instance{
+			InstanceId: aws.String(instanceID),
+			LaunchTime: aws.Time(launched),
+			Tags: []types.Tag{
+				{Key: aws.String("Name"), Value: aws.String("garm-" + instanceID)},
+				{Key: aws.String("GARM_POOL_ID"), Value: aws.String(poolID)},
+			},
+		}
+	}
+	instances := []types.Instance{
+		instance("i-00000000000000001", "pool-a", now),
+		instance("i-00000000000000002", "pool-gone", now),
+		instance("i-00000000000000003", "pool-a", now.Add(-48*time.Hour)),
+		instance("i-00000000000000004", "pool-a", now),
+		instance("i-00000000000000005", "pool-a", now.Add(-2*time.Hour)),
+		instance("i-00000000000000006", "pool-a", now.Add(-2*time.Hour)),
+	}
+	instances[3].Tags = append(instances[3].Tags, types.Tag{
+		Key:   aws.String(util.DeleteAfterTag),
+		Value: aws.String(now.Add(-time.Minute).Format(time.RFC3339)),
+	})
+	failed := func(instance *types.Instance, ago time.Duration) {
+		instance.Tags = append(instance.Tags,
+			types.Tag{Key: aws.String(util.BootstrapStatusTag), Value: aws.String(util.BootstrapStatusFailed)},
+			types.Tag{Key: aws.String(util.BootstrapFailedAtTag), Value: aws.String(now.Add(-ago).Format(time.RFC3339))},
+		)
+	}
+	failed(&instances[4], 90*time.Minute)
+	failed(&instances[5], 10*time.Minute)
+
+	tests := []struct {
+		name       string
+		opts       GCOptions
+		orphans    []string
+		terminated bool
+		errString  string
+	}{
+		{
+			name:      "no criteria",
+			errString: "either pools, a maximum age, expired or failed instances are needed to tell orphaned instances",
+		},
+		{
+			name:       "gone pools",
+			opts:       GCOptions{Pools: []string{"pool-a"}},
+			orphans:    []string{"i-00000000000000002"},
+			terminated: true,
+		},
+		{
+			name:       "max age",
+			opts:       GCOptions{MaxAge: 24 * time.Hour},
+			orphans:    []string{"i-00000000000000003"},
+			terminated: true,
+		},
+		{
+			name:       "expired",
+			opts:       GCOptions{Expired: true},
+			orphans:    []string{"i-00000000000000004"},
+			terminated: true,
+		},
+		{
+			name:       "failed",
+			opts:       GCOptions{FailedGracePeriod: time.Hour},
+			orphans:    []string{"i-00000000000000005"},
+			terminated: true,
+		},
+		{
+			name:    "dry run",
+			opts:    GCOptions{Pools: []string{"pool-a"}, MaxAge: 24 * time.Hour, DryRun: true},
+			orphans: []string{"i-00000000000000002", "i-00000000000000003"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-east-1"},
+				client: mockClient,
+			}
+			mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{{Instances: instances}},
+			}, nil)
+			mockClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+			orphans, err := awsCli.CollectGarbage(ctx, "controller_id", tt.opts)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+
+			var orphanIDs []string
+			for _, orphan := range orphans {
+				orphanIDs = append(orphanIDs, orphan.InstanceID)
+				require.Equal(t, "garm-"+orphan.InstanceID, orphan.Name)
+				require.NotEmpty(t, orphan.Reason)
+				require.Equal(t, tt.terminated, orphan.Terminated)
+			}
+			require.Equal(t, tt.orphans, orphanIDs)
+			if tt.terminated {
+				mockClient.AssertCalled(t, "TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+					InstanceIds: tt.orphans,
+				}, mock.Anything)
+			} else {
+				mockClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
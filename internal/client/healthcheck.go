@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+)
+
+// HealthCheckOptions configures a health check.
+type HealthCheckOptions struct {
+	// Image, if set, is used to check that instances can be launched, with
+	// a dry run launch of Flavor in the configured subnet.
+	Image  string
+	Flavor string
+}
+
+// HealthReport is the result of checking that the provider can reach AWS
+// with the configured credentials, and is allowed to do its job.
+type HealthReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Region      string        `json:"region"`
+	Healthy     bool          `json:"healthy"`
+	Checks      []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (r *HealthReport) record(name string, healthy bool, detail string) {
+	r.Checks = append(r.Checks, HealthCheck{Name: name, Healthy: healthy, Detail: detail})
+	r.Healthy = r.Healthy && healthy
+}
+
+func (r *HealthReport) recordErr(name string, err error) {
+	r.record(name, false, err.Error())
+}
+
+// recordDryRun records the outcome of a call made with DryRun set, which
+// fails with DryRunOperation if it would have succeeded.
+func (r *HealthReport) recordDryRun(name string, err error) {
+	switch {
+	case err == nil, errorCode(err) == "DryRunOperation":
+		r.record(name, true, "allowed")
+	case errorCode(err) == "UnauthorizedOperation":
+		r.record(name, false, "not allowed by the IAM policy")
+	default:
+		r.recordErr(name, err)
+	}
+}
+
+// HealthCheck checks that the credentials work, that EC2 is reachable in the
+// configured region and that the calls the provider makes are allowed. EC2
+// calls are made with DryRun set, so nothing is changed.
+func (a *AwsCli) HealthCheck(ctx context.Context, opts HealthCheckOptions) HealthReport {
+	report := HealthReport{
+		GeneratedAt: time.Now().UTC(),
+		Region:      a.cfg.Region,
+		Healthy:     true,
+	}
+
+	if arn, err := a.callerIdentity(ctx); err != nil {
+		report.recordErr("credentials", err)
+	} else {
+		report.record("credentials", true, arn)
+	}
+
+	_, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		DryRun: aws.Bool(true),
+	})
+	report.recordDryRun("ec2:DescribeInstances", err)
+
+	_, err = a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		DryRun: aws.Bool(true),
+	})
+	report.recordDryRun("ec2:DescribeImages", err)
+
+	target := &spec.RunnerSpec{
+		SubnetID:         a.cfg.SubnetID,
+		SecurityGroupIDs: a.cfg.SecurityGroupIDs,
+	}
+	target.BootstrapParams.Image = opts.Image
+	if err := a.resolveSSMReferences(ctx, target); err != nil {
+		report.recordErr("references", err)
+		return report
+	}
+
+	if target.SubnetID != "" {
+		_, err = a.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+			SubnetIds: []string{target.SubnetID},
+			DryRun:    aws.Bool(true),
+		})
+		report.recordDryRun("ec2:DescribeSubnets", err)
+	}
+
+	if opts.Image != "" {
+		_, err = a.client.RunInstances(ctx, &ec2.RunInstancesInput{
+			ImageId:          aws.String(target.BootstrapParams.Image),
+			InstanceType:     types.InstanceType(opts.Flavor),
+			MinCount:         aws.Int32(1),
+			MaxCount:         aws.Int32(1),
+			SubnetId:         aws.String(target.SubnetID),
+			SecurityGroupIds: target.SecurityGroupIDs,
+			DryRun:           aws.Bool(true),
+		})
+		report.recordDryRun("ec2:RunInstances", err)
+	}
+
+	return report
+}
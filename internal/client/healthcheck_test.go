@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheck(t *testing.T) {
+	dryRun := &smithy.GenericAPIError{Code: "DryRunOperation"}
+	unauthorized := &smithy.GenericAPIError{Code: "UnauthorizedOperation"}
+
+	tests := []struct {
+		name     string
+		stsErr   error
+		runErr   error
+		image    string
+		healthy  bool
+		expected []HealthCheck
+	}{
+		{
+			name:    "healthy",
+			image:   "ami-12345678",
+			runErr:  dryRun,
+			healthy: true,
+			expected: []HealthCheck{
+				{Name: "credentials", Healthy: true, Detail: "arn:aws:iam::123456789012:user/garm"},
+				{Name: "ec2:DescribeInstances", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:DescribeImages", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:DescribeSubnets", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:RunInstances", Healthy: true, Detail: "allowed"},
+			},
+		},
+		{
+			name:    "launch not allowed",
+			image:   "ami-12345678",
+			runErr:  unauthorized,
+			healthy: false,
+			expected: []HealthCheck{
+				{Name: "credentials", Healthy: true, Detail: "arn:aws:iam::123456789012:user/garm"},
+				{Name: "ec2:DescribeInstances", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:DescribeImages", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:DescribeSubnets", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:RunInstances", Healthy: false, Detail: "not allowed by the IAM policy"},
+			},
+		},
+		{
+			name:    "invalid credentials without image",
+			stsErr:  fmt.Errorf("InvalidClientTokenId"),
+			healthy: false,
+			expected: []HealthCheck{
+				{Name: "credentials", Healthy: false, Detail: "failed to get caller identity: InvalidClientTokenId"},
+				{Name: "ec2:DescribeInstances", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:DescribeImages", Healthy: true, Detail: "allowed"},
+				{Name: "ec2:DescribeSubnets", Healthy: true, Detail: "allowed"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			mockSTS := new(MockSTSClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:   "us-west-2",
+					SubnetID: "subnet-1234567890abcdef0",
+				},
+				client: mockClient,
+				sts:    mockSTS,
+			}
+
+			if tt.stsErr != nil {
+				mockSTS.On("GetCallerIdentity", ctx, mock.Anything, mock.Anything).Return((*sts.GetCallerIdentityOutput)(nil), tt.stsErr)
+			} else {
+				mockCallerAccount(mockSTS, "123456789012")
+			}
+			mockClient.On("DescribeInstances", ctx, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+				return aws.ToBool(input.DryRun)
+			}), mock.Anything).Return((*ec2.DescribeInstancesOutput)(nil), dryRun)
+			mockClient.On("DescribeImages", ctx, mock.MatchedBy(func(input *ec2.DescribeImagesInput) bool {
+				return aws.ToBool(input.DryRun)
+			}), mock.Anything).Return((*ec2.DescribeImagesOutput)(nil), dryRun)
+			mockClient.On("DescribeSubnets", ctx, mock.MatchedBy(func(input *ec2.DescribeSubnetsInput) bool {
+				return aws.ToBool(input.DryRun) && input.SubnetIds[0] == "subnet-1234567890abcdef0"
+			}), mock.Anything).Return((*ec2.DescribeSubnetsOutput)(nil), dryRun)
+			mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+				return aws.ToBool(input.DryRun) && aws.ToString(input.ImageId) == tt.image
+			}), mock.Anything).Return((*ec2.RunInstancesOutput)(nil), tt.runErr)
+
+			report := awsCli.HealthCheck(ctx, HealthCheckOptions{Image: tt.image, Flavor: "t3.nano"})
+			require.Equal(t, "us-west-2", report.Region)
+			require.Equal(t, tt.healthy, report.Healthy)
+			require.Equal(t, tt.expected, report.Checks)
+			if tt.image == "" {
+				mockClient.AssertNotCalled(t, "RunInstances", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
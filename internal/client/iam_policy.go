@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+)
+
+// PolicyOptions lists the pool features an IAM policy must allow. Pools are
+// configured in GARM, so these can't be determined from the provider config.
+type PolicyOptions struct {
+	// ControllerID restricts starting, stopping and terminating instances
+	// to those tagged with the ID of the controller.
+	ControllerID string
+	// SSMParameters is set if pool images, subnets or security groups are
+	// SSM references.
+	SSMParameters bool
+	// SSMDocuments is set if pools use the ssm_documents extra spec.
+	SSMDocuments bool
+	// SecurityGroupLookup is set if pools use the security_group_names or
+	// security_group_tags extra specs.
+	SecurityGroupLookup bool
+	// SharedVolumes is set if pools use the shared_volume extra spec.
+	SharedVolumes bool
+	// CacheVolumes is set if pools use the cache_pool_size extra spec.
+	CacheVolumes bool
+	// Fleet is set if pools set the launch_mode extra spec to fleet.
+	Fleet bool
+	// EphemeralSSHKeys is set if pools use the ephemeral_ssh_key extra
+	// spec.
+	EphemeralSSHKeys bool
+	// SerialConsole is set if pools use the serial_console extra spec.
+	SerialConsole bool
+	// KMSKeyARNs are the customer managed keys pools encrypt volumes with.
+	KMSKeyARNs []string
+}
+
+// PolicyDocument is an IAM policy document.
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+type PolicyStatement struct {
+	Sid       string                       `json:"Sid"`
+	Effect    string                       `json:"Effect"`
+	Action    []string                     `json:"Action"`
+	Resource  []string                     `json:"Resource"`
+	Condition map[string]map[string]string `json:"Condition,omitempty"`
+}
+
+func allow(sid string, resources []string, actions ...string) PolicyStatement {
+	slices.Sort(actions)
+	return PolicyStatement{
+		Sid:      sid,
+		Effect:   "Allow",
+		Action:   slices.Compact(actions),
+		Resource: resources,
+	}
+}
+
+// usesSSMReferences reports whether any of the networking defaults, image
+// aliases or image substitutions of the region in the config is an SSM
+// reference.
+func usesSSMReferences(cfg *config.Config) bool {
+	values := append([]string{cfg.SubnetID}, cfg.FallbackSubnetIDs...)
+	values = append(values, cfg.SecurityGroupIDs...)
+	for _, regions := range cfg.ImageAliases {
+		values = append(values, regions[cfg.Region])
+	}
+	for _, rule := range cfg.Substitutions[config.PartitionID(cfg.Region)].Images {
+		values = append(values, rule.Replacement)
+	}
+	return slices.ContainsFunc(values, isSSMReference)
+}
+
+// IAMPolicy returns the least privilege policy the provider needs to create
+// and manage runners with the given config. Permissions needed by the
+// status, compliance and benchmark commands are not included.
+func IAMPolicy(cfg *config.Config, opts PolicyOptions) PolicyDocument {
+	all := []string{"*"}
+
+	ec2Actions := []string{
+		"ec2:CreateTags",
+		"ec2:DescribeImages",
+		"ec2:DescribeInstanceTypeOfferings",
+		"ec2:DescribeInstanceTypes",
+		"ec2:DescribeInstances",
+		// Used to validate the flavor of pools against the zones of
+		// their subnets.
+		"ec2:DescribeSubnets",
+		"ec2:DescribeVolumeStatus",
+		"ec2:RunInstances",
+	}
+	if cfg.PrivateOnly {
+		ec2Actions = append(ec2Actions, "ec2:DescribeSubnets", "ec2:DescribeVpcEndpoints")
+	}
+	if cfg.CheckCallbackReachability {
+		ec2Actions = append(ec2Actions, "ec2:DescribeSubnets", "ec2:DescribeRouteTables")
+	}
+	if opts.SecurityGroupLookup {
+		ec2Actions = append(ec2Actions, "ec2:DescribeSubnets", "ec2:DescribeSecurityGroups")
+	}
+	if opts.SharedVolumes {
+		ec2Actions = append(ec2Actions, "ec2:AttachVolume", "ec2:DescribeSubnets", "ec2:DescribeVolumes")
+	}
+	if opts.CacheVolumes {
+		ec2Actions = append(ec2Actions, "ec2:AttachVolume", "ec2:CreateVolume", "ec2:DeleteVolume", "ec2:DescribeVolumes")
+	}
+	if opts.Fleet {
+		ec2Actions = append(ec2Actions, "ec2:CreateFleet", "ec2:CreateLaunchTemplate", "ec2:DeleteLaunchTemplate")
+	}
+	if opts.EphemeralSSHKeys {
+		ec2Actions = append(ec2Actions, "ec2:DeleteKeyPair", "ec2:ImportKeyPair")
+	}
+	if opts.SerialConsole {
+		ec2Actions = append(ec2Actions, "ec2:GetSerialConsoleAccessStatus")
+		if cfg.EnableSerialConsoleAccess {
+			ec2Actions = append(ec2Actions, "ec2:EnableSerialConsoleAccess")
+		}
+	}
+
+	lifecycleActions := []string{
+		"ec2:StartInstances",
+		"ec2:StopInstances",
+		"ec2:TerminateInstances",
+	}
+	if cfg.DisableTerminationProtection {
+		lifecycleActions = append(lifecycleActions, "ec2:ModifyInstanceAttribute")
+	}
+	lifecycle := allow("GarmManageInstances", all, lifecycleActions...)
+	if opts.ControllerID != "" {
+		lifecycle.Condition = map[string]map[string]string{
+			"StringEquals": {
+				"aws:ResourceTag/GARM_CONTROLLER_ID": opts.ControllerID,
+			},
+		}
+	}
+
+	statements := []PolicyStatement{
+		allow("GarmCreateInstances", all, ec2Actions...),
+		lifecycle,
+	}
+
+	var ssmActions []string
+	if opts.SSMParameters || usesSSMReferences(cfg) {
+		ssmActions = append(ssmActions, "ssm:GetParameter")
+	}
+	if opts.SSMDocuments {
+		ssmActions = append(ssmActions, "ssm:SendCommand", "ssm:GetCommandInvocation")
+	}
+	if len(ssmActions) > 0 {
+		statements = append(statements, allow("GarmSSM", all, ssmActions...))
+	}
+
+	if offload := cfg.UserDataOffload; offload.Enabled() {
+		// Offloaded user data is uploaded when instances are created, and
+		// deleted along with them.
+		objects := fmt.Sprintf("arn:%s:s3:::%s/%s*", config.PartitionID(cfg.Region), offload.Bucket, offload.Prefix)
+		statements = append(statements, allow("GarmUserDataOffload", []string{objects}, "s3:DeleteObject", "s3:PutObject"))
+		passRole := allow("GarmPassInstanceProfile", all, "iam:PassRole")
+		passRole.Condition = map[string]map[string]string{
+			"StringEquals": {
+				"iam:PassedToService": "ec2.amazonaws.com",
+			},
+		}
+		statements = append(statements, passRole)
+	}
+
+	if cfg.EstimateCost {
+		statements = append(statements, allow("GarmPricing", all, "pricing:GetProducts"))
+	}
+
+	// Every identity may call GetCallerIdentity unless it is explicitly
+	// denied, but list it to document the dependency.
+	if cfg.AuditLogFile != "" || cfg.PrivateOnly || cfg.CheckCallbackReachability {
+		statements = append(statements, allow("GarmCallerIdentity", all, "sts:GetCallerIdentity"))
+	}
+
+	if len(opts.KMSKeyARNs) > 0 {
+		statements = append(statements, allow("GarmEncryptVolumes", opts.KMSKeyARNs,
+			"kms:CreateGrant",
+			"kms:GenerateDataKeyWithoutPlaintext",
+			"kms:ReEncrypt*",
+		))
+	}
+
+	return PolicyDocument{
+		Version:   "2012-10-17",
+		Statement: statements,
+	}
+}
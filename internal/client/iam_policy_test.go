@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/require"
+)
+
+// policyActions maps the Sid of every statement of the policy to its actions.
+func policyActions(policy PolicyDocument) map[string][]string {
+	actions := map[string][]string{}
+	for _, statement := range policy.Statement {
+		actions[statement.Sid] = statement.Action
+	}
+	return actions
+}
+
+func TestIAMPolicy(t *testing.T) {
+	baseActions := []string{
+		"ec2:CreateTags",
+		"ec2:DescribeImages",
+		"ec2:DescribeInstanceTypeOfferings",
+		"ec2:DescribeInstanceTypes",
+		"ec2:DescribeInstances",
+		"ec2:DescribeSubnets",
+		"ec2:DescribeVolumeStatus",
+		"ec2:RunInstances",
+	}
+	lifecycleActions := []string{
+		"ec2:StartInstances",
+		"ec2:StopInstances",
+		"ec2:TerminateInstances",
+	}
+
+	tests := []struct {
+		name     string
+		cfg      *config.Config
+		opts     PolicyOptions
+		expected map[string][]string
+	}{
+		{
+			name: "minimal config",
+			cfg:  &config.Config{SubnetID: "subnet-1234567890abcdef0"},
+			expected: map[string][]string{
+				"GarmCreateInstances": baseActions,
+				"GarmManageInstances": lifecycleActions,
+			},
+		},
+		{
+			name: "network checks",
+			cfg: &config.Config{
+				SubnetID:                  "subnet-1234567890abcdef0",
+				PrivateOnly:               true,
+				CheckCallbackReachability: true,
+			},
+			opts: PolicyOptions{SecurityGroupLookup: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": {
+					"ec2:CreateTags",
+					"ec2:DescribeImages",
+					"ec2:DescribeInstanceTypeOfferings",
+					"ec2:DescribeInstanceTypes",
+					"ec2:DescribeInstances",
+					"ec2:DescribeRouteTables",
+					"ec2:DescribeSecurityGroups",
+					"ec2:DescribeSubnets",
+					"ec2:DescribeVolumeStatus",
+					"ec2:DescribeVpcEndpoints",
+					"ec2:RunInstances",
+				},
+				"GarmManageInstances": lifecycleActions,
+				"GarmCallerIdentity":  {"sts:GetCallerIdentity"},
+			},
+		},
+		{
+			name: "ssm references in config",
+			cfg: &config.Config{
+				SubnetID:     "ssm:/network/runners/subnet",
+				EstimateCost: true,
+				AuditLogFile: "/var/log/garm/aws-audit.log",
+			},
+			opts: PolicyOptions{SSMDocuments: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": baseActions,
+				"GarmManageInstances": lifecycleActions,
+				"GarmSSM":             {"ssm:GetCommandInvocation", "ssm:GetParameter", "ssm:SendCommand"},
+				"GarmPricing":         {"pricing:GetProducts"},
+				"GarmCallerIdentity":  {"sts:GetCallerIdentity"},
+			},
+		},
+		{
+			name: "shared volumes",
+			cfg:  &config.Config{SubnetID: "subnet-1234567890abcdef0"},
+			opts: PolicyOptions{SharedVolumes: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": {
+					"ec2:AttachVolume",
+					"ec2:CreateTags",
+					"ec2:DescribeImages",
+					"ec2:DescribeInstanceTypeOfferings",
+					"ec2:DescribeInstanceTypes",
+					"ec2:DescribeInstances",
+					"ec2:DescribeSubnets",
+					"ec2:DescribeVolumeStatus",
+					"ec2:DescribeVolumes",
+					"ec2:RunInstances",
+				},
+				"GarmManageInstances": lifecycleActions,
+			},
+		},
+		{
+			name: "cache volumes",
+			cfg:  &config.Config{SubnetID: "subnet-1234567890abcdef0"},
+			opts: PolicyOptions{CacheVolumes: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": {
+					"ec2:AttachVolume",
+					"ec2:CreateTags",
+					"ec2:CreateVolume",
+					"ec2:DeleteVolume",
+					"ec2:DescribeImages",
+					"ec2:DescribeInstanceTypeOfferings",
+					"ec2:DescribeInstanceTypes",
+					"ec2:DescribeInstances",
+					"ec2:DescribeSubnets",
+					"ec2:DescribeVolumeStatus",
+					"ec2:DescribeVolumes",
+					"ec2:RunInstances",
+				},
+				"GarmManageInstances": lifecycleActions,
+			},
+		},
+		{
+			name: "fleet",
+			cfg:  &config.Config{SubnetID: "subnet-1234567890abcdef0"},
+			opts: PolicyOptions{Fleet: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": {
+					"ec2:CreateFleet",
+					"ec2:CreateLaunchTemplate",
+					"ec2:CreateTags",
+					"ec2:DeleteLaunchTemplate",
+					"ec2:DescribeImages",
+					"ec2:DescribeInstanceTypeOfferings",
+					"ec2:DescribeInstanceTypes",
+					"ec2:DescribeInstances",
+					"ec2:DescribeSubnets",
+					"ec2:DescribeVolumeStatus",
+					"ec2:RunInstances",
+				},
+				"GarmManageInstances": lifecycleActions,
+			},
+		},
+		{
+			name: "ephemeral ssh keys",
+			cfg:  &config.Config{SubnetID: "subnet-1234567890abcdef0"},
+			opts: PolicyOptions{EphemeralSSHKeys: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": {
+					"ec2:CreateTags",
+					"ec2:DeleteKeyPair",
+					"ec2:DescribeImages",
+					"ec2:DescribeInstanceTypeOfferings",
+					"ec2:DescribeInstanceTypes",
+					"ec2:DescribeInstances",
+					"ec2:DescribeSubnets",
+					"ec2:DescribeVolumeStatus",
+					"ec2:ImportKeyPair",
+					"ec2:RunInstances",
+				},
+				"GarmManageInstances": lifecycleActions,
+			},
+		},
+		{
+			name: "serial console",
+			cfg: &config.Config{
+				SubnetID:                  "subnet-1234567890abcdef0",
+				EnableSerialConsoleAccess: true,
+			},
+			opts: PolicyOptions{SerialConsole: true},
+			expected: map[string][]string{
+				"GarmCreateInstances": {
+					"ec2:CreateTags",
+					"ec2:DescribeImages",
+					"ec2:DescribeInstanceTypeOfferings",
+					"ec2:DescribeInstanceTypes",
+					"ec2:DescribeInstances",
+					"ec2:DescribeSubnets",
+					"ec2:DescribeVolumeStatus",
+					"ec2:EnableSerialConsoleAccess",
+					"ec2:GetSerialConsoleAccessStatus",
+					"ec2:RunInstances",
+				},
+				"GarmManageInstances": lifecycleActions,
+			},
+		},
+		{
+			name: "termination protection",
+			cfg: &config.Config{
+				SubnetID:                     "subnet-1234567890abcdef0",
+				DisableTerminationProtection: true,
+			},
+			expected: map[string][]string{
+				"GarmCreateInstances": baseActions,
+				"GarmManageInstances": {
+					"ec2:ModifyInstanceAttribute",
+					"ec2:StartInstances",
+					"ec2:StopInstances",
+					"ec2:TerminateInstances",
+				},
+			},
+		},
+		{
+			name: "user data offload",
+			cfg: &config.Config{
+				SubnetID: "subnet-1234567890abcdef0",
+				UserDataOffload: config.UserDataOffload{
+					Bucket:          "garm-user-data",
+					InstanceProfile: "garm-runner",
+				},
+			},
+			expected: map[string][]string{
+				"GarmCreateInstances":     baseActions,
+				"GarmManageInstances":     lifecycleActions,
+				"GarmUserDataOffload":     {"s3:DeleteObject", "s3:PutObject"},
+				"GarmPassInstanceProfile": {"iam:PassRole"},
+			},
+		},
+		{
+			name: "customer managed keys",
+			cfg:  &config.Config{SubnetID: "subnet-1234567890abcdef0"},
+			opts: PolicyOptions{
+				SSMParameters: true,
+				KMSKeyARNs:    []string{"arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+			},
+			expected: map[string][]string{
+				"GarmCreateInstances": baseActions,
+				"GarmManageInstances": lifecycleActions,
+				"GarmSSM":             {"ssm:GetParameter"},
+				"GarmEncryptVolumes": {
+					"kms:CreateGrant",
+					"kms:GenerateDataKeyWithoutPlaintext",
+					"kms:ReEncrypt*",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := IAMPolicy(tt.cfg, tt.opts)
+			require.Equal(t, "2012-10-17", policy.Version)
+			require.Equal(t, tt.expected, policyActions(policy))
+		})
+	}
+}
+
+func TestIAMPolicyScopes(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	policy := IAMPolicy(&config.Config{
+		SubnetID: "subnet-1234567890abcdef0",
+		Region:   "cn-north-1",
+		UserDataOffload: config.UserDataOffload{
+			Bucket:          "garm-user-data",
+			Prefix:          "runners/",
+			InstanceProfile: "garm-runner",
+		},
+	}, PolicyOptions{
+		ControllerID: "controllerID",
+		KMSKeyARNs:   []string{keyARN},
+	})
+
+	for _, statement := range policy.Statement {
+		switch statement.Sid {
+		case "GarmManageInstances":
+			require.Equal(t, map[string]map[string]string{
+				"StringEquals": {"aws:ResourceTag/GARM_CONTROLLER_ID": "controllerID"},
+			}, statement.Condition)
+		case "GarmEncryptVolumes":
+			require.Equal(t, []string{keyARN}, statement.Resource)
+		case "GarmUserDataOffload":
+			require.Equal(t, []string{"arn:aws-cn:s3:::garm-user-data/runners/*"}, statement.Resource)
+		case "GarmPassInstanceProfile":
+			require.Equal(t, map[string]map[string]string{
+				"StringEquals": {"iam:PassedToService": "ec2.amazonaws.com"},
+			}, statement.Condition)
+		default:
+			require.Equal(t, []string{"*"}, statement.Resource)
+			require.Nil(t, statement.Condition)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+var (
+	// ErrImageNotFound is returned when an image does not exist, or is not
+	// visible to the account, in the region of the provider.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrImageNotAvailable is returned when an image exists, but is not in a
+	// state instances can be launched from.
+	ErrImageNotAvailable = errors.New("image not available")
+)
+
+// isImageNotFoundErr reports whether EC2 rejected an image ID because no
+// such image exists, which is also what happens when the ID belongs to an
+// image in another region.
+func isImageNotFoundErr(err error) bool {
+	switch errorCode(err) {
+	case "InvalidAMIID.NotFound", "InvalidAMIID.Unavailable", "InvalidAMIID.Malformed":
+		return true
+	}
+	return false
+}
+
+// GetImage returns the details of the given AMI. If the image doesn't exist,
+// the error wraps ErrImageNotFound.
+func (a *AwsCli) GetImage(ctx context.Context, imageID string) (types.Image, error) {
+	resp, err := a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	})
+	if err != nil && !isImageNotFoundErr(err) {
+		return types.Image{}, fmt.Errorf("failed to describe image %s: %w", imageID, err)
+	}
+	if err != nil || len(resp.Images) == 0 {
+		// AMI IDs are specific to a region, so an image copied to another
+		// region has a different ID there.
+		return types.Image{}, fmt.Errorf("image %s does not exist in region %s, or is not shared with this account (images of other regions need to be copied with their own ID): %w", imageID, a.cfg.Region, ErrImageNotFound)
+	}
+	return resp.Images[0], nil
+}
+
+// checkImageState makes sure instances can be launched from the image.
+func checkImageState(image types.Image) error {
+	imageID := aws.ToString(image.ImageId)
+	switch image.State {
+	case types.ImageStateAvailable:
+		return nil
+	case types.ImageStatePending:
+		return fmt.Errorf("image %s is still being created: %w", imageID, ErrImageNotAvailable)
+	}
+	if image.StateReason != nil && image.StateReason.Message != nil {
+		return fmt.Errorf("image %s is %s (%s): %w", imageID, image.State, *image.StateReason.Message, ErrImageNotAvailable)
+	}
+	return fmt.Errorf("image %s is %s: %w", imageID, image.State, ErrImageNotAvailable)
+}
+
+// GetInstanceType returns the details of the given instance type.
+func (a *AwsCli) GetInstanceType(ctx context.Context, instanceType string) (types.InstanceTypeInfo, error) {
+	resp, err := a.client.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return types.InstanceTypeInfo{}, fmt.Errorf("failed to describe instance type %s: %w", instanceType, err)
+	}
+	if len(resp.InstanceTypes) == 0 {
+		return types.InstanceTypeInfo{}, fmt.Errorf("instance type %s not found", instanceType)
+	}
+	return resp.InstanceTypes[0], nil
+}
+
+// checkENASupport makes sure the AMI and the instance type agree on Elastic
+// Network Adapter support. Launching an ENA enabled AMI on an instance type
+// without ENA, or an AMI without ENA on a type that requires it, either fails
+// or produces an instance that never becomes reachable.
+func checkENASupport(image types.Image, instanceType types.InstanceTypeInfo) error {
+	if instanceType.NetworkInfo == nil {
+		return nil
+	}
+
+	imageENA := aws.ToBool(image.EnaSupport)
+	switch instanceType.NetworkInfo.EnaSupport {
+	case types.EnaSupportRequired:
+		if !imageENA {
+			return fmt.Errorf("instance type %s requires ENA, but image %s does not support it", instanceType.InstanceType, aws.ToString(image.ImageId))
+		}
+	case types.EnaSupportUnsupported:
+		if imageENA {
+			return fmt.Errorf("image %s requires ENA, which instance type %s does not support", aws.ToString(image.ImageId), instanceType.InstanceType)
+		}
+	}
+	return nil
+}
+
+// checkHibernationSupport makes sure instances of the given type can be
+// launched with hibernation enabled. RunInstances rejects them otherwise.
+func checkHibernationSupport(instanceType types.InstanceTypeInfo) error {
+	if !aws.ToBool(instanceType.HibernationSupported) {
+		return fmt.Errorf("instance type %s does not support hibernation", instanceType.InstanceType)
+	}
+	return nil
+}
+
+// checkImagePlatform makes sure the AMI is built for the OS type and
+// architecture of the pool. Otherwise the instance boots, but the runner
+// user data never runs.
+func checkImagePlatform(image types.Image, osType params.OSType, osArch params.OSArch) error {
+	imageID := aws.ToString(image.ImageId)
+	if arch := util.EC2OSArch(image.Architecture); arch != "" && osArch != "" && arch != osArch {
+		return fmt.Errorf("image %s is built for %s, but the pool architecture is %s", imageID, image.Architecture, osArch)
+	}
+
+	// The API reports "windows", while the SDK enum is "Windows". Platform
+	// is not set for Linux images.
+	isWindows := strings.EqualFold(string(image.Platform), string(types.PlatformValuesWindows))
+	switch {
+	case osType == params.Windows && !isWindows:
+		return fmt.Errorf("image %s is not a Windows image, but the pool OS type is %s", imageID, osType)
+	case osType == params.Linux && isWindows:
+		return fmt.Errorf("image %s is a Windows image, but the pool OS type is %s", imageID, osType)
+	}
+	return nil
+}
+
+// checkImageCompatibility verifies that the image exists and is available,
+// matches the OS type and architecture of the pool, and can be launched on
+// the given instance type. Other lookup failures are logged and ignored, so
+// missing describe permissions never block instance creation.
+func (a *AwsCli) checkImageCompatibility(ctx context.Context, imageID, instanceType string, osType params.OSType, osArch params.OSArch) error {
+	image, err := a.GetImage(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, ErrImageNotFound) {
+			return err
+		}
+		slog.WarnContext(ctx, "skipping image compatibility checks", "error", err)
+		return nil
+	}
+
+	if err := checkImageState(image); err != nil {
+		return err
+	}
+
+	if err := checkImagePlatform(image, osType, osArch); err != nil {
+		return err
+	}
+
+	typeInfo, err := a.GetInstanceType(ctx, instanceType)
+	if err != nil {
+		slog.WarnContext(ctx, "skipping image compatibility checks", "error", err)
+		return nil
+	}
+
+	return checkENASupport(image, typeInfo)
+}
+
+// ValidatePoolImage makes sure instances can be launched from the image of a
+// pool, on its flavor, if given. Like the image of a pool, the image may be
+// an alias or an SSM parameter reference.
+func (a *AwsCli) ValidatePoolImage(ctx context.Context, image, flavor string) error {
+	imageID, err := a.cfg.ResolveImageAlias(image)
+	if err != nil {
+		return err
+	}
+	imageID, err = a.ResolveSSMParameter(ctx, a.cfg.SubstituteImage(imageID))
+	if err != nil {
+		return fmt.Errorf("failed to resolve image: %w", err)
+	}
+	if flavor != "" {
+		return a.checkImageCompatibility(ctx, imageID, flavor, "", "")
+	}
+
+	resolved, err := a.GetImage(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, ErrImageNotFound) {
+			return err
+		}
+		slog.WarnContext(ctx, "skipping image checks", "error", err)
+		return nil
+	}
+	return checkImageState(resolved)
+}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// imageCacheEntry is the image a pool's image reference resolved to.
+type imageCacheEntry struct {
+	Reference  string    `json:"reference"`
+	ImageID    string    `json:"image_id"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+func loadImageCache(path string) (map[string]imageCacheEntry, error) {
+	cache := map[string]imageCacheEntry{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("failed to read image cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to decode image cache: %w", err)
+	}
+	return cache, nil
+}
+
+func saveImageCache(path string, cache map[string]imageCacheEntry) error {
+	data, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode image cache: %w", err)
+	}
+
+	// Several provider processes may update the cache at the same time.
+	// Renaming a complete file into place makes sure none of them ever
+	// reads a partially written one.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create image cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write image cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write image cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace image cache: %w", err)
+	}
+	return nil
+}
+
+// resolveImage returns the image ID the image reference of the pool
+// resolves to. Images that are not SSM references are returned unchanged.
+// If an image cache is configured, resolved images are reused until the
+// cache TTL expires or the reference of the pool changes. A notice is logged
+// whenever a pool rolls to a different image.
+func (a *AwsCli) resolveImage(ctx context.Context, poolID, reference string) (string, error) {
+	if !isSSMReference(reference) || a.cfg.ImageCacheFile == "" {
+		return a.ResolveSSMParameter(ctx, reference)
+	}
+
+	// The cache is an optimization. If it can't be used, resolve the image
+	// every time.
+	cache, err := loadImageCache(a.cfg.ImageCacheFile)
+	if err != nil {
+		slog.WarnContext(ctx, "ignoring image cache", "error", err)
+		cache = map[string]imageCacheEntry{}
+	}
+
+	entry, ok := cache[poolID]
+	if ok && entry.Reference == reference && time.Since(entry.ResolvedAt) < a.cfg.GetImageCacheTTL() {
+		return entry.ImageID, nil
+	}
+
+	imageID, err := a.ResolveSSMParameter(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case !ok:
+	case entry.Reference != reference:
+		slog.InfoContext(ctx, "image of pool changed", "pool_id", poolID, "old_reference", entry.Reference, "old_image_id", entry.ImageID, "reference", reference, "image_id", imageID)
+	case entry.ImageID != imageID:
+		slog.InfoContext(ctx, "image of pool changed", "pool_id", poolID, "reference", reference, "old_image_id", entry.ImageID, "image_id", imageID)
+	}
+
+	cache[poolID] = imageCacheEntry{
+		Reference:  reference,
+		ImageID:    imageID,
+		ResolvedAt: time.Now().UTC(),
+	}
+	if err := saveImageCache(a.cfg.ImageCacheFile, cache); err != nil {
+		slog.WarnContext(ctx, "failed to update image cache", "error", err)
+	}
+	return imageID, nil
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		cached    map[string]imageCacheEntry
+		resolved  string
+		expected  string
+		lookups   int
+		notice    string
+		reference string
+	}{
+		{
+			name:      "not cached",
+			reference: "ssm:/images/runner",
+			resolved:  "ami-new",
+			expected:  "ami-new",
+			lookups:   1,
+		},
+		{
+			name: "cached",
+			cached: map[string]imageCacheEntry{
+				"pool-id": {Reference: "ssm:/images/runner", ImageID: "ami-cached", ResolvedAt: time.Now()},
+			},
+			reference: "ssm:/images/runner",
+			expected:  "ami-cached",
+		},
+		{
+			name: "expired with new image",
+			cached: map[string]imageCacheEntry{
+				"pool-id": {Reference: "ssm:/images/runner", ImageID: "ami-old", ResolvedAt: time.Now().Add(-2 * time.Hour)},
+			},
+			reference: "ssm:/images/runner",
+			resolved:  "ami-new",
+			expected:  "ami-new",
+			lookups:   1,
+			notice:    `msg="image of pool changed" pool_id=pool-id reference=ssm:/images/runner old_image_id=ami-old image_id=ami-new`,
+		},
+		{
+			name: "reference changed",
+			cached: map[string]imageCacheEntry{
+				"pool-id": {Reference: "ssm:/images/old", ImageID: "ami-old", ResolvedAt: time.Now()},
+			},
+			reference: "ssm:/images/runner",
+			resolved:  "ami-new",
+			expected:  "ami-new",
+			lookups:   1,
+			notice:    `msg="image of pool changed" pool_id=pool-id old_reference=ssm:/images/old old_image_id=ami-old reference=ssm:/images/runner image_id=ami-new`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cacheFile := filepath.Join(t.TempDir(), "images.json")
+			if tt.cached != nil {
+				require.NoError(t, saveImageCache(cacheFile, tt.cached))
+			}
+
+			var logs bytes.Buffer
+			defaultLogger := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+			mockSSM := new(MockSSMClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{ImageCacheFile: cacheFile},
+				ssm: mockSSM,
+			}
+			if tt.resolved != "" {
+				mockSSMParameter(mockSSM, ctx, "/images/runner", tt.resolved)
+			}
+
+			imageID, err := awsCli.resolveImage(ctx, "pool-id", tt.reference)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, imageID)
+			mockSSM.AssertNumberOfCalls(t, "GetParameter", tt.lookups)
+			if tt.notice == "" {
+				require.NotContains(t, logs.String(), "changed")
+			} else {
+				require.Contains(t, logs.String(), tt.notice)
+			}
+
+			cache, err := loadImageCache(cacheFile)
+			require.NoError(t, err)
+			require.Equal(t, tt.reference, cache["pool-id"].Reference)
+			require.Equal(t, tt.expected, cache["pool-id"].ImageID)
+		})
+	}
+}
+
+func TestResolveImageWithoutCache(t *testing.T) {
+	ctx := context.Background()
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{},
+		ssm: mockSSM,
+	}
+
+	imageID, err := awsCli.resolveImage(ctx, "pool-id", "ami-12345678")
+	require.NoError(t, err)
+	require.Equal(t, "ami-12345678", imageID)
+	mockSSM.AssertNotCalled(t, "GetParameter", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckENASupport(t *testing.T) {
+	tests := []struct {
+		name          string
+		imageENA      *bool
+		typeENA       types.EnaSupport
+		errString     string
+		noNetworkInfo bool
+	}{
+		{
+			name:     "ena image on ena type",
+			imageENA: aws.Bool(true),
+			typeENA:  types.EnaSupportRequired,
+		},
+		{
+			name:     "ena image on type supporting ena",
+			imageENA: aws.Bool(true),
+			typeENA:  types.EnaSupportSupported,
+		},
+		{
+			name:     "image without ena on type supporting ena",
+			imageENA: aws.Bool(false),
+			typeENA:  types.EnaSupportSupported,
+		},
+		{
+			name:      "image without ena on type requiring ena",
+			imageENA:  nil,
+			typeENA:   types.EnaSupportRequired,
+			errString: "instance type m5.large requires ENA, but image ami-12345678 does not support it",
+		},
+		{
+			name:      "ena image on type without ena",
+			imageENA:  aws.Bool(true),
+			typeENA:   types.EnaSupportUnsupported,
+			errString: "image ami-12345678 requires ENA, which instance type m5.large does not support",
+		},
+		{
+			name:          "missing network info",
+			imageENA:      aws.Bool(true),
+			noNetworkInfo: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := types.Image{
+				ImageId:    aws.String("ami-12345678"),
+				EnaSupport: tt.imageENA,
+			}
+			typeInfo := types.InstanceTypeInfo{
+				InstanceType: types.InstanceTypeM5Large,
+			}
+			if !tt.noNetworkInfo {
+				typeInfo.NetworkInfo = &types.NetworkInfo{
+					EnaSupport: tt.typeENA,
+				}
+			}
+
+			err := checkENASupport(image, typeInfo)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestCheckImagePlatform(t *testing.T) {
+	tests := []struct {
+		name      string
+		arch      types.ArchitectureValues
+		platform  types.PlatformValues
+		osType    params.OSType
+		osArch    params.OSArch
+		errString string
+	}{
+		{
+			name:   "linux amd64",
+			arch:   types.ArchitectureValuesX8664,
+			osType: params.Linux,
+			osArch: params.Amd64,
+		},
+		{
+			name:     "windows amd64",
+			arch:     types.ArchitectureValuesX8664,
+			platform: "windows",
+			osType:   params.Windows,
+			osArch:   params.Amd64,
+		},
+		{
+			name:      "arm64 image on amd64 pool",
+			arch:      types.ArchitectureValuesArm64,
+			osType:    params.Linux,
+			osArch:    params.Amd64,
+			errString: "image ami-12345678 is built for arm64, but the pool architecture is amd64",
+		},
+		{
+			name:      "linux image on windows pool",
+			arch:      types.ArchitectureValuesX8664,
+			osType:    params.Windows,
+			osArch:    params.Amd64,
+			errString: "image ami-12345678 is not a Windows image, but the pool OS type is windows",
+		},
+		{
+			name:      "windows image on linux pool",
+			arch:      types.ArchitectureValuesX8664,
+			platform:  types.PlatformValuesWindows,
+			osType:    params.Linux,
+			osArch:    params.Amd64,
+			errString: "image ami-12345678 is a Windows image, but the pool OS type is linux",
+		},
+		{
+			name:   "architecture not reported",
+			osType: params.Linux,
+			osArch: params.Arm64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := types.Image{
+				ImageId:      aws.String("ami-12345678"),
+				Architecture: tt.arch,
+				Platform:     tt.platform,
+			}
+
+			err := checkImagePlatform(image, tt.osType, tt.osArch)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestCheckImageCompatibilityPlatformMismatch(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		client: mockClient,
+	}
+	mockClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:      aws.String("ami-12345678"),
+				Architecture: types.ArchitectureValuesArm64,
+				State:        types.ImageStateAvailable,
+			},
+		},
+	}, nil)
+
+	err := awsCli.checkImageCompatibility(ctx, "ami-12345678", "t2.micro", params.Linux, params.Amd64)
+	require.EqualError(t, err, "image ami-12345678 is built for arm64, but the pool architecture is amd64")
+	mockClient.AssertNotCalled(t, "DescribeInstanceTypes", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCheckImageCompatibilityLookupFailure(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		client: mockClient,
+	}
+	mockClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{}, fmt.Errorf("access denied"))
+
+	err := awsCli.checkImageCompatibility(ctx, "ami-12345678", "t2.micro", params.Linux, params.Amd64)
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "DescribeInstanceTypes", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCheckImageCompatibilityUnavailableImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		images    []types.Image
+		err       error
+		errString string
+		target    error
+	}{
+		{
+			name:      "not found",
+			err:       &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound", Message: "The image id '[ami-12345678]' does not exist"},
+			errString: "image ami-12345678 does not exist in region us-west-2",
+			target:    ErrImageNotFound,
+		},
+		{
+			name:      "not returned",
+			errString: "image ami-12345678 does not exist in region us-west-2",
+			target:    ErrImageNotFound,
+		},
+		{
+			name:      "pending",
+			images:    []types.Image{{ImageId: aws.String("ami-12345678"), State: types.ImageStatePending}},
+			errString: "image ami-12345678 is still being created",
+			target:    ErrImageNotAvailable,
+		},
+		{
+			name: "failed",
+			images: []types.Image{{
+				ImageId:     aws.String("ami-12345678"),
+				State:       types.ImageStateFailed,
+				StateReason: &types.StateReason{Message: aws.String("snapshot copy failed")},
+			}},
+			errString: "image ami-12345678 is failed (snapshot copy failed)",
+			target:    ErrImageNotAvailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region: "us-west-2",
+				},
+				client: mockClient,
+			}
+			mockClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{Images: tt.images}, tt.err)
+
+			err := awsCli.checkImageCompatibility(ctx, "ami-12345678", "t2.micro", params.Linux, params.Amd64)
+			require.ErrorContains(t, err, tt.errString)
+			require.ErrorIs(t, err, tt.target)
+			mockClient.AssertNotCalled(t, "DescribeInstanceTypes", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
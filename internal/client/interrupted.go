@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// lastKnownInstanceTimeout bounds the lookup of an instance after waiting on
+// it was interrupted.
+const lastKnownInstanceTimeout = 10 * time.Second
+
+// ErrWaitInterrupted is returned by the waiters if their context is cancelled,
+// for example because the provider received SIGTERM, before the instance
+// reached the state waited for.
+var ErrWaitInterrupted = errors.New("wait interrupted")
+
+// waitError wraps the error of a waiter. If the context of the waiter was
+// cancelled, the error is ErrWaitInterrupted instead, as the instance may
+// still reach the state waited for.
+func waitError(ctx context.Context, err error, instanceID, state string) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("stopped waiting for instance %s to %s: %w", instanceID, state, ErrWaitInterrupted)
+	}
+	return fmt.Errorf("failed waiting for instance %s to %s: %w", instanceID, state, err)
+}
+
+// LastKnownInstance looks up the instance once more after ctx was cancelled,
+// with a deadline of its own, so that its state can still be reported.
+func (a *AwsCli) LastKnownInstance(ctx context.Context, instanceID string) (types.Instance, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lastKnownInstanceTimeout)
+	defer cancel()
+	return a.getInstance(ctx, instanceID)
+}
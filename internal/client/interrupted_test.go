@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForRunningInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	instanceID := "i-1234567890abcdef0"
+
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-east-1"},
+		client: mockClient,
+	}
+	// The waiter calls DescribeInstances with its own context.
+	mockClient.On("DescribeInstances", mock.Anything, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+						State:      &types.InstanceState{Name: types.InstanceStateNamePending},
+					},
+				},
+			},
+		},
+	}, nil).Run(func(mock.Arguments) {
+		// SIGTERM arrives while the instance is still pending.
+		cancel()
+	})
+
+	err := awsCli.WaitForRunning(ctx, instanceID, instanceRunningTimeout)
+	require.ErrorIs(t, err, ErrWaitInterrupted)
+	require.EqualError(t, err, "stopped waiting for instance i-1234567890abcdef0 to run: wait interrupted")
+
+	instance, err := awsCli.LastKnownInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, types.InstanceStateNamePending, instance.State.Name)
+}
@@ -19,6 +19,10 @@ import (
 	"context"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -50,3 +54,169 @@ func (m *MockComputeClient) RunInstances(ctx context.Context, params *ec2.RunIns
 	args := m.Called(ctx, params, optFns)
 	return args.Get(0).(*ec2.RunInstancesOutput), args.Error(1)
 }
+
+func (m *MockComputeClient) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeSubnetsOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeInstanceTypeOfferings(ctx context.Context, params *ec2.DescribeInstanceTypeOfferingsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeInstanceTypeOfferingsOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeSecurityGroupsOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeImagesOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeInstanceTypesOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.CreateTagsOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeVpcEndpoints(ctx context.Context, params *ec2.DescribeVpcEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcEndpointsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeVpcEndpointsOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeRouteTables(ctx context.Context, params *ec2.DescribeRouteTablesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeRouteTablesOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeVolumesOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeVolumeStatus(ctx context.Context, params *ec2.DescribeVolumeStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumeStatusOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeVolumeStatusOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) AttachVolume(ctx context.Context, params *ec2.AttachVolumeInput, optFns ...func(*ec2.Options)) (*ec2.AttachVolumeOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.AttachVolumeOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) CreateVolume(ctx context.Context, params *ec2.CreateVolumeInput, optFns ...func(*ec2.Options)) (*ec2.CreateVolumeOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.CreateVolumeOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DeleteVolume(ctx context.Context, params *ec2.DeleteVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DeleteVolumeOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) CreateFleet(ctx context.Context, params *ec2.CreateFleetInput, optFns ...func(*ec2.Options)) (*ec2.CreateFleetOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.CreateFleetOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) CreateLaunchTemplate(ctx context.Context, params *ec2.CreateLaunchTemplateInput, optFns ...func(*ec2.Options)) (*ec2.CreateLaunchTemplateOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.CreateLaunchTemplateOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DeleteLaunchTemplate(ctx context.Context, params *ec2.DeleteLaunchTemplateInput, optFns ...func(*ec2.Options)) (*ec2.DeleteLaunchTemplateOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DeleteLaunchTemplateOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.ModifyInstanceAttributeOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) ImportKeyPair(ctx context.Context, params *ec2.ImportKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.ImportKeyPairOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.ImportKeyPairOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DeleteKeyPair(ctx context.Context, params *ec2.DeleteKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.DeleteKeyPairOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DeleteKeyPairOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) DescribeInstanceConnectEndpoints(ctx context.Context, params *ec2.DescribeInstanceConnectEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceConnectEndpointsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.DescribeInstanceConnectEndpointsOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) CreateInstanceConnectEndpoint(ctx context.Context, params *ec2.CreateInstanceConnectEndpointInput, optFns ...func(*ec2.Options)) (*ec2.CreateInstanceConnectEndpointOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.CreateInstanceConnectEndpointOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) GetSerialConsoleAccessStatus(ctx context.Context, params *ec2.GetSerialConsoleAccessStatusInput, optFns ...func(*ec2.Options)) (*ec2.GetSerialConsoleAccessStatusOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.GetSerialConsoleAccessStatusOutput), args.Error(1)
+}
+
+func (m *MockComputeClient) EnableSerialConsoleAccess(ctx context.Context, params *ec2.EnableSerialConsoleAccessInput, optFns ...func(*ec2.Options)) (*ec2.EnableSerialConsoleAccessOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ec2.EnableSerialConsoleAccessOutput), args.Error(1)
+}
+
+type MockPricingClient struct {
+	mock.Mock
+}
+
+func (m *MockPricingClient) GetProducts(ctx context.Context, params *pricing.GetProductsInput, optFns ...func(*pricing.Options)) (*pricing.GetProductsOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*pricing.GetProductsOutput), args.Error(1)
+}
+
+type MockSSMClient struct {
+	mock.Mock
+}
+
+func (m *MockSSMClient) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ssm.GetParameterOutput), args.Error(1)
+}
+
+func (m *MockSSMClient) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ssm.SendCommandOutput), args.Error(1)
+}
+
+func (m *MockSSMClient) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*ssm.GetCommandInvocationOutput), args.Error(1)
+}
+
+type MockS3Client struct {
+	mock.Mock
+}
+
+func (m *MockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.PutObjectOutput), args.Error(1)
+}
+
+func (m *MockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+}
+
+type MockSTSClient struct {
+	mock.Mock
+}
+
+func (m *MockSTSClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*sts.GetCallerIdentityOutput), args.Error(1)
+}
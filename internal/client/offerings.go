@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// The instance types offered in each availability zone are cached in a
+// hidden file in the state directory, as they rarely change and listing
+// them takes several calls per zone. Zones are keyed by ID, which, unlike
+// zone names, means the same zone in every account.
+const (
+	offeringsCacheFile = ".instance-type-offerings"
+	offeringsCacheTTL  = 6 * time.Hour
+)
+
+// offeringsCacheEntry holds the instance types offered in a zone.
+type offeringsCacheEntry struct {
+	InstanceTypes []string  `json:"instance_types"`
+	FetchedAt     time.Time `json:"fetched_at"`
+}
+
+func loadOfferingsCache(path string) (map[string]offeringsCacheEntry, error) {
+	cache := map[string]offeringsCacheEntry{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("failed to read offerings cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to decode offerings cache: %w", err)
+	}
+	return cache, nil
+}
+
+func saveOfferingsCache(path string, cache map[string]offeringsCacheEntry) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("failed to encode offerings cache: %w", err)
+	}
+
+	// Renaming a complete file into place makes sure concurrent provider
+	// processes never read a partially written one.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create offerings cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write offerings cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write offerings cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace offerings cache: %w", err)
+	}
+	return nil
+}
+
+// describeOfferings returns the instance types offered in each of the given
+// zones, by zone ID.
+func (a *AwsCli) describeOfferings(ctx context.Context, zoneIDs []string) (map[string][]string, error) {
+	offerings := map[string][]string{}
+	input := &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: types.LocationTypeAvailabilityZoneId,
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("location"),
+				Values: zoneIDs,
+			},
+		},
+		MaxResults: aws.Int32(1000),
+	}
+	for {
+		resp, err := a.client.DescribeInstanceTypeOfferings(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe instance type offerings: %w", err)
+		}
+		for _, offering := range resp.InstanceTypeOfferings {
+			zoneID := aws.ToString(offering.Location)
+			offerings[zoneID] = append(offerings[zoneID], string(offering.InstanceType))
+		}
+		if aws.ToString(resp.NextToken) == "" {
+			return offerings, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// zoneOfferings returns the instance types offered in each of the given
+// zones, by zone ID. If a state directory is configured, offerings are
+// reused until offeringsCacheTTL expires.
+func (a *AwsCli) zoneOfferings(ctx context.Context, zoneIDs []string) (map[string][]string, error) {
+	if a.cfg.StateDir == "" {
+		return a.describeOfferings(ctx, zoneIDs)
+	}
+
+	// The cache is an optimization. If it can't be used, look the offerings
+	// up every time.
+	path := filepath.Join(a.cfg.StateDir, offeringsCacheFile)
+	cache, err := loadOfferingsCache(path)
+	if err != nil {
+		slog.WarnContext(ctx, "ignoring offerings cache", "error", err)
+		cache = map[string]offeringsCacheEntry{}
+	}
+
+	offerings := map[string][]string{}
+	var stale []string
+	for _, zoneID := range zoneIDs {
+		entry, ok := cache[zoneID]
+		if ok && time.Since(entry.FetchedAt) < offeringsCacheTTL {
+			offerings[zoneID] = entry.InstanceTypes
+			continue
+		}
+		stale = append(stale, zoneID)
+	}
+	if len(stale) == 0 {
+		return offerings, nil
+	}
+
+	fetched, err := a.describeOfferings(ctx, stale)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, zoneID := range stale {
+		offerings[zoneID] = fetched[zoneID]
+		cache[zoneID] = offeringsCacheEntry{
+			InstanceTypes: fetched[zoneID],
+			FetchedAt:     now,
+		}
+	}
+	if err := saveOfferingsCache(path, cache); err != nil {
+		slog.WarnContext(ctx, "failed to update offerings cache", "error", err)
+	}
+	return offerings, nil
+}
+
+// ValidatePoolFlavor checks that the flavor of a pool is offered in the
+// availability zones of its subnets, in the order creates try them. A
+// warning is logged for every subnet whose zone can't host the flavor, as
+// creates then always fall back past it, and the validation fails if none
+// can. Lookups that fail skip the check, as they don't mean the pool is
+// invalid.
+func (a *AwsCli) ValidatePoolFlavor(ctx context.Context, flavor string, subnets []string) error {
+	var subnetIDs []string
+	for _, subnet := range subnets {
+		subnetID, err := a.ResolveSSMParameter(ctx, subnet)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping instance type offering checks", "error", err)
+			return nil
+		}
+		subnetIDs = append(subnetIDs, subnetID)
+	}
+	if len(subnetIDs) == 0 {
+		return nil
+	}
+
+	resp, err := a.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: subnetIDs,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "skipping instance type offering checks", "error", fmt.Errorf("failed to describe subnets: %w", err))
+		return nil
+	}
+	zones := map[string]types.Subnet{}
+	var zoneIDs []string
+	for _, subnet := range resp.Subnets {
+		zones[aws.ToString(subnet.SubnetId)] = subnet
+		zoneIDs = append(zoneIDs, aws.ToString(subnet.AvailabilityZoneId))
+	}
+	slices.Sort(zoneIDs)
+
+	offerings, err := a.zoneOfferings(ctx, slices.Compact(zoneIDs))
+	if err != nil {
+		slog.WarnContext(ctx, "skipping instance type offering checks", "error", err)
+		return nil
+	}
+
+	var unavailable []string
+	for _, subnetID := range subnetIDs {
+		subnet := zones[subnetID]
+		zoneID := aws.ToString(subnet.AvailabilityZoneId)
+		if slices.Contains(offerings[zoneID], flavor) {
+			continue
+		}
+		zone := aws.ToString(subnet.AvailabilityZone)
+		unavailable = append(unavailable, zone)
+		slog.WarnContext(ctx, "instance type is not offered in the availability zone of subnet, creates will skip it", "flavor", flavor, "subnet_id", subnetID, "availability_zone", zone, "availability_zone_id", zoneID)
+	}
+	if len(unavailable) == len(subnetIDs) {
+		return fmt.Errorf("instance type %s is not offered in the availability zones of the subnets of the pool (%s)", flavor, strings.Join(unavailable, ", "))
+	}
+	return nil
+}
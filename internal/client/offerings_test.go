@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func offeringsSubnets() *ec2.DescribeSubnetsOutput {
+	return &ec2.DescribeSubnetsOutput{
+		Subnets: []types.Subnet{
+			{SubnetId: aws.String("subnet-0a0a0a0a0a0a0a0a0"), AvailabilityZone: aws.String("us-east-1a"), AvailabilityZoneId: aws.String("use1-az1")},
+			{SubnetId: aws.String("subnet-0b0b0b0b0b0b0b0b0"), AvailabilityZone: aws.String("us-east-1e"), AvailabilityZoneId: aws.String("use1-az3")},
+		},
+	}
+}
+
+func offeringsOutput(zoneID string, instanceTypes ...types.InstanceType) *ec2.DescribeInstanceTypeOfferingsOutput {
+	out := &ec2.DescribeInstanceTypeOfferingsOutput{}
+	for _, instanceType := range instanceTypes {
+		out.InstanceTypeOfferings = append(out.InstanceTypeOfferings, types.InstanceTypeOffering{
+			InstanceType: instanceType,
+			Location:     aws.String(zoneID),
+			LocationType: types.LocationTypeAvailabilityZoneId,
+		})
+	}
+	return out
+}
+
+func TestValidatePoolFlavor(t *testing.T) {
+	ctx := context.Background()
+	subnets := []string{"subnet-0a0a0a0a0a0a0a0a0", "subnet-0b0b0b0b0b0b0b0b0"}
+
+	tests := []struct {
+		name         string
+		flavor       string
+		offeringsErr error
+		warning      string
+		errString    string
+	}{
+		{
+			name:   "offered everywhere",
+			flavor: "t3.micro",
+		},
+		{
+			name:    "offered in some zones",
+			flavor:  "p5.48xlarge",
+			warning: "subnet_id=subnet-0b0b0b0b0b0b0b0b0 availability_zone=us-east-1e",
+		},
+		{
+			name:      "offered nowhere",
+			flavor:    "u-24tb1.metal",
+			warning:   "subnet_id=subnet-0a0a0a0a0a0a0a0a0",
+			errString: "instance type u-24tb1.metal is not offered in the availability zones of the subnets of the pool (us-east-1a, us-east-1e)",
+		},
+		{
+			name:         "lookup fails",
+			flavor:       "t3.micro",
+			offeringsErr: errors.New("access denied"),
+			warning:      "skipping instance type offering checks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defaultLogger := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-east-1"},
+				client: mockClient,
+			}
+			mockClient.On("DescribeSubnets", ctx, &ec2.DescribeSubnetsInput{
+				SubnetIds: subnets,
+			}, mock.Anything).Return(offeringsSubnets(), nil)
+			offerings := offeringsOutput("use1-az1", "t3.micro", "p5.48xlarge")
+			offerings.InstanceTypeOfferings = append(offerings.InstanceTypeOfferings, offeringsOutput("use1-az3", "t3.micro").InstanceTypeOfferings...)
+			mockClient.On("DescribeInstanceTypeOfferings", ctx, mock.MatchedBy(func(input *ec2.DescribeInstanceTypeOfferingsInput) bool {
+				return input.LocationType == types.LocationTypeAvailabilityZoneId &&
+					len(input.Filters) == 1 && len(input.Filters[0].Values) == 2
+			}), mock.Anything).Return(offerings, tt.offeringsErr)
+
+			err := awsCli.ValidatePoolFlavor(ctx, tt.flavor, subnets)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+			} else {
+				require.NoError(t, err)
+			}
+			if tt.warning != "" {
+				require.Contains(t, logs.String(), tt.warning)
+			} else {
+				require.NotContains(t, logs.String(), "level=WARN")
+			}
+		})
+	}
+}
+
+func TestZoneOfferingsCache(t *testing.T) {
+	ctx := context.Background()
+	stateDir := t.TempDir()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-east-1", StateDir: stateDir},
+		client: mockClient,
+	}
+
+	// A stale entry is looked up again, a fresh one is not.
+	require.NoError(t, saveOfferingsCache(filepath.Join(stateDir, offeringsCacheFile), map[string]offeringsCacheEntry{
+		"use1-az1": {InstanceTypes: []string{"t3.micro"}, FetchedAt: time.Now().UTC()},
+		"use1-az3": {InstanceTypes: []string{"t2.micro"}, FetchedAt: time.Now().UTC().Add(-2 * offeringsCacheTTL)},
+	}))
+	mockClient.On("DescribeInstanceTypeOfferings", ctx, mock.MatchedBy(func(input *ec2.DescribeInstanceTypeOfferingsInput) bool {
+		return len(input.Filters) == 1 && len(input.Filters[0].Values) == 1 && input.Filters[0].Values[0] == "use1-az3"
+	}), mock.Anything).Return(offeringsOutput("use1-az3", "t3.micro"), nil).Once()
+
+	offerings, err := awsCli.zoneOfferings(ctx, []string{"use1-az1", "use1-az3"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"use1-az1": {"t3.micro"}, "use1-az3": {"t3.micro"}}, offerings)
+
+	// Both are fresh now.
+	offerings, err = awsCli.zoneOfferings(ctx, []string{"use1-az1", "use1-az3"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"use1-az1": {"t3.micro"}, "use1-az3": {"t3.micro"}}, offerings)
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// ErrLaunchNotAuthorized is returned when the IAM policy of the provider does
+// not allow launching an instance with the given parameters.
+var ErrLaunchNotAuthorized = errors.New("launch not authorized")
+
+// preflightLaunch makes a dry run of the launch, which makes EC2 check
+// permissions and parameters without creating anything. Authorization
+// failures wrap ErrLaunchNotAuthorized.
+func (a *AwsCli) preflightLaunch(ctx context.Context, input *ec2.RunInstancesInput) error {
+	dryRun := *input
+	dryRun.DryRun = aws.Bool(true)
+	_, err := a.client.RunInstances(ctx, &dryRun)
+	switch {
+	case err == nil, errorCode(err) == "DryRunOperation":
+		return nil
+	case errorCode(err) == "UnauthorizedOperation":
+		return fmt.Errorf("%w: %w", ErrLaunchNotAuthorized, err)
+	default:
+		return fmt.Errorf("dry run launch failed: %w", err)
+	}
+}
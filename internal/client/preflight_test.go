@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflightLaunch(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		unauthorized bool
+		errString    string
+	}{
+		{
+			name: "allowed",
+			err:  &smithy.GenericAPIError{Code: "DryRunOperation", Message: "Request would have succeeded, but DryRun flag is set."},
+		},
+		{
+			name:         "not authorized",
+			err:          &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation."},
+			unauthorized: true,
+			errString:    "launch not authorized: api error UnauthorizedOperation: You are not authorized to perform this operation.",
+		},
+		{
+			name:      "invalid parameter",
+			err:       &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "Invalid instance type"},
+			errString: "dry run launch failed: api error InvalidParameterValue: Invalid instance type",
+		},
+		{
+			name:      "unreachable",
+			err:       fmt.Errorf("connection refused"),
+			errString: "dry run launch failed: connection refused",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+			input := &ec2.RunInstancesInput{
+				ImageId:  aws.String("ami-12345678"),
+				SubnetId: aws.String("subnet-1234567890abcdef0"),
+			}
+
+			mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+				return aws.ToBool(input.DryRun) && aws.ToString(input.ImageId) == "ami-12345678"
+			}), mock.Anything).Return((*ec2.RunInstancesOutput)(nil), tt.err)
+
+			err := awsCli.preflightLaunch(ctx, input)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.unauthorized, errors.Is(err, ErrLaunchNotAuthorized))
+			// The launch itself must not be made a dry run.
+			require.Nil(t, input.DryRun)
+		})
+	}
+}
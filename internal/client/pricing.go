@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	pricingTypes "github.com/aws/aws-sdk-go-v2/service/pricing/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// pricingEndpoint is where the prices of the regions of a partition are
+// served from, and the currency they are in.
+type pricingEndpoint struct {
+	region   string
+	currency string
+}
+
+// pricingEndpoints maps partition IDs to the region of their Pricing API
+// endpoint. The API is only served from a handful of regions, and not at all
+// in the other partitions.
+var pricingEndpoints = map[string]pricingEndpoint{
+	"aws":    {region: "us-east-1", currency: "USD"},
+	"aws-cn": {region: "cn-northwest-1", currency: "CNY"},
+}
+
+// pricingEndpointFor returns the Pricing API endpoint that serves the prices
+// of region. It returns false if its partition has none.
+func pricingEndpointFor(region string) (pricingEndpoint, bool) {
+	endpoint, ok := pricingEndpoints[config.PartitionID(region)]
+	return endpoint, ok
+}
+
+type PricingClientInterface interface {
+	GetProducts(ctx context.Context, params *pricing.GetProductsInput, optFns ...func(*pricing.Options)) (*pricing.GetProductsOutput, error)
+}
+
+// priceListItem holds the subset of a price list document we care about.
+type priceListItem struct {
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+func pricingOperatingSystem(osType params.OSType) (string, error) {
+	switch osType {
+	case params.Linux:
+		return "Linux", nil
+	case params.Windows:
+		return "Windows", nil
+	}
+	return "", fmt.Errorf("unsupported OS type for pricing: %s", osType)
+}
+
+func pricingTenancy(tenancy types.Tenancy) (string, error) {
+	switch tenancy {
+	case "", types.TenancyDefault:
+		return "Shared", nil
+	case types.TenancyDedicated:
+		return "Dedicated", nil
+	}
+	// Dedicated hosts are billed per host, not per instance.
+	return "", fmt.Errorf("unsupported tenancy for pricing: %s", tenancy)
+}
+
+func termMatch(field, value string) pricingTypes.Filter {
+	return pricingTypes.Filter{
+		Field: aws.String(field),
+		Type:  pricingTypes.FilterTypeTermMatch,
+		Value: aws.String(value),
+	}
+}
+
+// GetHourlyPrice returns the on-demand hourly price of the given instance
+// type in the configured region, and the code of the currency it is in,
+// which depends on the partition: USD, or CNY in the China regions.
+func (a *AwsCli) GetHourlyPrice(ctx context.Context, instanceType string, osType params.OSType, tenancy types.Tenancy) (float64, string, error) {
+	if a.pricing == nil {
+		return 0, "", fmt.Errorf("pricing client is not initialized")
+	}
+	endpoint, ok := pricingEndpointFor(a.cfg.Region)
+	if !ok {
+		return 0, "", fmt.Errorf("the pricing API is not available in the partition of %s", a.cfg.Region)
+	}
+
+	operatingSystem, err := pricingOperatingSystem(osType)
+	if err != nil {
+		return 0, "", err
+	}
+
+	pricedTenancy, err := pricingTenancy(tenancy)
+	if err != nil {
+		return 0, "", err
+	}
+
+	resp, err := a.pricing.GetProducts(ctx, &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []pricingTypes.Filter{
+			termMatch("instanceType", instanceType),
+			termMatch("regionCode", a.cfg.Region),
+			termMatch("operatingSystem", operatingSystem),
+			termMatch("tenancy", pricedTenancy),
+			termMatch("preInstalledSw", "NA"),
+			termMatch("capacitystatus", "Used"),
+			termMatch("licenseModel", "No License required"),
+		},
+		MaxResults: aws.Int32(10),
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get products: %w", err)
+	}
+
+	for _, item := range resp.PriceList {
+		price, err := parseHourlyPrice(item, endpoint.currency)
+		if err != nil {
+			return 0, "", err
+		}
+		if price > 0 {
+			return price, endpoint.currency, nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("no on-demand price found for %s in %s", instanceType, a.cfg.Region)
+}
+
+func parseHourlyPrice(priceList, currency string) (float64, error) {
+	var item priceListItem
+	if err := json.Unmarshal([]byte(priceList), &item); err != nil {
+		return 0, fmt.Errorf("failed to decode price list: %w", err)
+	}
+
+	for _, term := range item.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			if dimension.Unit != "Hrs" {
+				continue
+			}
+			amount, ok := dimension.PricePerUnit[currency]
+			if !ok {
+				continue
+			}
+			price, err := strconv.ParseFloat(amount, 64)
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse price %q: %w", amount, err)
+			}
+			if price > 0 {
+				return price, nil
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const t2MicroPriceList = `{
+	"product": {"attributes": {"instanceType": "t2.micro"}},
+	"terms": {
+		"OnDemand": {
+			"ABCDEFGH.JRTCKXETXF": {
+				"priceDimensions": {
+					"ABCDEFGH.JRTCKXETXF.6YS6EN2CT7": {
+						"unit": "Hrs",
+						"pricePerUnit": {"USD": "0.0116000000"}
+					}
+				}
+			}
+		}
+	}
+}`
+
+func TestParseHourlyPrice(t *testing.T) {
+	tests := []struct {
+		name      string
+		priceList string
+		expected  float64
+		errString string
+	}{
+		{
+			name:      "valid price list",
+			priceList: t2MicroPriceList,
+			expected:  0.0116,
+		},
+		{
+			name:      "no hourly dimension",
+			priceList: `{"terms": {"OnDemand": {"a": {"priceDimensions": {"b": {"unit": "Quantity", "pricePerUnit": {"USD": "1"}}}}}}}`,
+			expected:  0,
+		},
+		{
+			name:      "invalid price",
+			priceList: `{"terms": {"OnDemand": {"a": {"priceDimensions": {"b": {"unit": "Hrs", "pricePerUnit": {"USD": "bogus"}}}}}}}`,
+			errString: "failed to parse price \"bogus\"",
+		},
+		{
+			name:      "invalid json",
+			priceList: `bogus`,
+			errString: "failed to decode price list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, err := parseHourlyPrice(tt.priceList, "USD")
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, price)
+		})
+	}
+}
+
+func TestPricingEndpointFor(t *testing.T) {
+	tests := []struct {
+		region   string
+		expected pricingEndpoint
+		ok       bool
+	}{
+		{region: "eu-central-1", expected: pricingEndpoint{region: "us-east-1", currency: "USD"}, ok: true},
+		{region: "cn-north-1", expected: pricingEndpoint{region: "cn-northwest-1", currency: "CNY"}, ok: true},
+		{region: "us-gov-west-1"},
+		{region: "us-iso-east-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			endpoint, ok := pricingEndpointFor(tt.region)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.expected, endpoint)
+		})
+	}
+}
+
+func TestGetHourlyPrice(t *testing.T) {
+	ctx := context.Background()
+	mockPricing := new(MockPricingClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		pricing: mockPricing,
+	}
+	mockPricing.On("GetProducts", ctx, mock.MatchedBy(func(input *pricing.GetProductsInput) bool {
+		return *input.ServiceCode == "AmazonEC2" && len(input.Filters) == 7
+	}), mock.Anything).Return(&pricing.GetProductsOutput{
+		PriceList: []string{t2MicroPriceList},
+	}, nil)
+
+	price, currency, err := awsCli.GetHourlyPrice(ctx, "t2.micro", params.Linux, types.TenancyDefault)
+	require.NoError(t, err)
+	require.Equal(t, 0.0116, price)
+	require.Equal(t, "USD", currency)
+
+	mockPricing.AssertExpectations(t)
+}
+
+func TestGetHourlyPriceChina(t *testing.T) {
+	ctx := context.Background()
+	mockPricing := new(MockPricingClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "cn-north-1",
+		},
+		pricing: mockPricing,
+	}
+	mockPricing.On("GetProducts", ctx, mock.Anything, mock.Anything).Return(&pricing.GetProductsOutput{
+		PriceList: []string{`{"terms": {"OnDemand": {"a": {"priceDimensions": {"b": {"unit": "Hrs", "pricePerUnit": {"CNY": "0.1030000000"}}}}}}}`},
+	}, nil)
+
+	price, currency, err := awsCli.GetHourlyPrice(ctx, "t2.micro", params.Linux, types.TenancyDefault)
+	require.NoError(t, err)
+	require.Equal(t, 0.103, price)
+	require.Equal(t, "CNY", currency)
+}
+
+func TestGetHourlyPriceNoProducts(t *testing.T) {
+	ctx := context.Background()
+	mockPricing := new(MockPricingClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		pricing: mockPricing,
+	}
+	mockPricing.On("GetProducts", ctx, mock.Anything, mock.Anything).Return(&pricing.GetProductsOutput{}, nil)
+
+	_, _, err := awsCli.GetHourlyPrice(ctx, "t2.micro", params.Windows, types.TenancyDefault)
+	require.EqualError(t, err, "no on-demand price found for t2.micro in us-west-2")
+}
+
+func TestGetHourlyPriceError(t *testing.T) {
+	ctx := context.Background()
+	mockPricing := new(MockPricingClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		pricing: mockPricing,
+	}
+	mockPricing.On("GetProducts", ctx, mock.Anything, mock.Anything).Return(&pricing.GetProductsOutput{}, fmt.Errorf("access denied"))
+
+	_, _, err := awsCli.GetHourlyPrice(ctx, "t2.micro", params.Linux, types.TenancyDefault)
+	require.EqualError(t, err, "failed to get products: access denied")
+}
+
+func TestGetHourlyPriceTenancy(t *testing.T) {
+	ctx := context.Background()
+	mockPricing := new(MockPricingClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+		},
+		pricing: mockPricing,
+	}
+	mockPricing.On("GetProducts", ctx, mock.MatchedBy(func(input *pricing.GetProductsInput) bool {
+		for _, filter := range input.Filters {
+			if *filter.Field == "tenancy" {
+				return *filter.Value == "Dedicated"
+			}
+		}
+		return false
+	}), mock.Anything).Return(&pricing.GetProductsOutput{
+		PriceList: []string{t2MicroPriceList},
+	}, nil)
+
+	_, _, err := awsCli.GetHourlyPrice(ctx, "t2.micro", params.Linux, types.TenancyDedicated)
+	require.NoError(t, err)
+	mockPricing.AssertExpectations(t)
+
+	_, _, err = awsCli.GetHourlyPrice(ctx, "t2.micro", params.Linux, types.TenancyHost)
+	require.EqualError(t, err, "unsupported tenancy for pricing: host")
+}
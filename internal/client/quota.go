@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// ErrQuotaExceeded is returned when creating an instance would take a GitHub
+// entity over its instance quota.
+var ErrQuotaExceeded = errors.New("instance quota exceeded")
+
+// countEntityInstances returns the number of instances, in any state but
+// terminated, that the controller created for entity.
+func (a *AwsCli) countEntityInstances(ctx context.Context, controllerID, entity string) (int, error) {
+	var count int
+	paginator := ec2.NewDescribeInstancesPaginator(a.client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:GARM_CONTROLLER_ID"),
+				Values: []string{controllerID},
+			},
+			{
+				Name:   aws.String("tag:" + util.EntityTag),
+				Values: []string{entity},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping", "stopped"},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list instances: %w", err)
+		}
+		for _, reserv := range page.Reservations {
+			count += len(reserv.Instances)
+		}
+	}
+	return count, nil
+}
+
+// checkEntityQuota returns ErrQuotaExceeded if the entity the instance is
+// created for already has as many instances as its quota allows. Creates
+// that run at the same time are not serialized, so an entity may briefly
+// go over its quota.
+func (a *AwsCli) checkEntityQuota(ctx context.Context, spec *spec.RunnerSpec) error {
+	entity := util.Entity(spec.BootstrapParams.RepoURL)
+	quota, ok := a.cfg.GetEntityQuota(entity)
+	if !ok {
+		return nil
+	}
+
+	count, err := a.countEntityInstances(ctx, spec.ControllerID, entity)
+	if err != nil {
+		return err
+	}
+	if count >= quota {
+		return fmt.Errorf("%w: %s already has %d of %d instances", ErrQuotaExceeded, entity, count, quota)
+	}
+	return nil
+}
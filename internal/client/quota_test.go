@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEntityQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		repoURL   string
+		instances int
+		errString string
+	}{
+		{
+			name:      "below quota",
+			repoURL:   "https://github.com/Owner/Noisy",
+			instances: 1,
+		},
+		{
+			name:      "quota reached",
+			repoURL:   "https://github.com/owner/noisy",
+			instances: 2,
+			errString: "instance quota exceeded: owner/noisy already has 2 of 2 instances",
+		},
+		{
+			name:    "no quota",
+			repoURL: "https://github.com/other/repo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					EntityQuotas: []config.EntityQuota{{Entity: "owner/*", MaxInstances: 2}},
+				},
+				client: mockClient,
+			}
+			var instances []types.Instance
+			for range tt.instances {
+				instances = append(instances, types.Instance{InstanceId: aws.String("i-1234567890abcdef0")})
+			}
+			mockClient.On("DescribeInstances", ctx, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+				return *input.Filters[1].Name == "tag:garm:entity" && input.Filters[1].Values[0] == "owner/noisy"
+			}), mock.Anything).Return(&ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{{Instances: instances}},
+			}, nil)
+
+			err := awsCli.checkEntityQuota(ctx, &spec.RunnerSpec{
+				ControllerID: "controller_id",
+				BootstrapParams: params.BootstrapInstance{
+					RepoURL: tt.repoURL,
+				},
+			})
+			if tt.errString != "" {
+				require.ErrorIs(t, err, ErrQuotaExceeded)
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
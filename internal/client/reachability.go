@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// lookupHost resolves the addresses of a host. It is a variable so tests can
+// replace it.
+var lookupHost = func(ctx context.Context, host string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+func (a *AwsCli) describeSubnet(ctx context.Context, subnetID string) (types.Subnet, error) {
+	resp, err := a.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: []string{subnetID},
+	})
+	if err != nil {
+		return types.Subnet{}, fmt.Errorf("failed to describe subnet %s: %w", subnetID, err)
+	}
+	if len(resp.Subnets) == 0 {
+		return types.Subnet{}, fmt.Errorf("subnet %s not found", subnetID)
+	}
+	return resp.Subnets[0], nil
+}
+
+// subnetRouteTable returns the route table associated with the subnet, or
+// the main route table of its VPC if it has none.
+func (a *AwsCli) subnetRouteTable(ctx context.Context, subnet types.Subnet) (types.RouteTable, error) {
+	for _, filters := range [][]types.Filter{
+		{
+			{Name: aws.String("association.subnet-id"), Values: []string{aws.ToString(subnet.SubnetId)}},
+		},
+		{
+			{Name: aws.String("vpc-id"), Values: []string{aws.ToString(subnet.VpcId)}},
+			{Name: aws.String("association.main"), Values: []string{"true"}},
+		},
+	} {
+		resp, err := a.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+			Filters: filters,
+		})
+		if err != nil {
+			return types.RouteTable{}, fmt.Errorf("failed to describe route tables: %w", err)
+		}
+		if len(resp.RouteTables) > 0 {
+			return resp.RouteTables[0], nil
+		}
+	}
+	return types.RouteTable{}, fmt.Errorf("no route table found for subnet %s", aws.ToString(subnet.SubnetId))
+}
+
+// longestPrefixRoute returns the most specific route matching addr, the way
+// the VPC router picks it.
+func longestPrefixRoute(routes []types.Route, addr netip.Addr) (types.Route, bool) {
+	var match types.Route
+	bits := -1
+	for _, route := range routes {
+		destination := aws.ToString(route.DestinationCidrBlock)
+		if addr.Is6() && !addr.Is4In6() {
+			destination = aws.ToString(route.DestinationIpv6CidrBlock)
+		}
+		if destination == "" {
+			// Prefix list destinations can't be matched without
+			// looking up the prefix list, so ignore them.
+			continue
+		}
+		prefix, err := netip.ParsePrefix(destination)
+		if err != nil || !prefix.Contains(addr.Unmap()) {
+			continue
+		}
+		if prefix.Bits() > bits {
+			match, bits = route, prefix.Bits()
+		}
+	}
+	return match, bits >= 0
+}
+
+// checkRoute returns why an instance in the subnet can not reach addr
+// through route, or nil if it looks like it can.
+func checkRoute(subnet types.Subnet, route types.Route, addr netip.Addr) error {
+	if route.State == types.RouteStateBlackhole {
+		return fmt.Errorf("the route to %s is a blackhole", addr)
+	}
+	gateway := aws.ToString(route.GatewayId)
+	if strings.HasPrefix(gateway, "igw-") && addr.Unmap().Is4() && !aws.ToBool(subnet.MapPublicIpOnLaunch) {
+		return fmt.Errorf("%s is routed through internet gateway %s, but instances in the subnet get no public IPv4 address", addr, gateway)
+	}
+	return nil
+}
+
+// checkCallbackReachability makes sure the route table of the subnet has a
+// usable route to the hosts of the given URLs. Hosts are resolved from where
+// the provider runs, which may differ from what the runner sees with split
+// horizon DNS. A host passes the check if any of its addresses is routable.
+func (a *AwsCli) checkCallbackReachability(ctx context.Context, subnetID string, urls ...string) error {
+	subnet, err := a.describeSubnet(ctx, subnetID)
+	if err != nil {
+		return err
+	}
+	routeTable, err := a.subnetRouteTable(ctx, subnet)
+	if err != nil {
+		// Depending on how the VPC owner shares it, the route tables of a
+		// shared subnet may not be visible. Don't fail creates over it.
+		owner, ownerErr := a.sharedSubnetOwner(ctx, subnet)
+		if ownerErr == nil && owner != "" {
+			slog.InfoContext(ctx, "not checking callback reachability from shared subnet", "subnet_id", subnetID, "owner", owner, "error", err)
+			return nil
+		}
+		return err
+	}
+
+	checked := map[string]bool{}
+	for _, rawURL := range urls {
+		if rawURL == "" {
+			continue
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", rawURL, err)
+		}
+		host := u.Hostname()
+		if checked[host] {
+			continue
+		}
+		checked[host] = true
+
+		addrs, err := lookupHost(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+
+		var problem error
+		for _, addr := range addrs {
+			route, ok := longestPrefixRoute(routeTable.Routes, addr)
+			if !ok {
+				problem = fmt.Errorf("no route to %s", addr)
+				continue
+			}
+			if problem = checkRoute(subnet, route, addr); problem == nil {
+				break
+			}
+		}
+		if problem != nil {
+			return fmt.Errorf("instances in subnet %s can not reach %s (route table %s): %w", subnetID, host, aws.ToString(routeTable.RouteTableId), problem)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCallbackReachability(t *testing.T) {
+	localRoute := types.Route{
+		DestinationCidrBlock: aws.String("10.0.0.0/16"),
+		GatewayId:            aws.String("local"),
+		State:                types.RouteStateActive,
+	}
+	peeringRoute := types.Route{
+		DestinationCidrBlock:   aws.String("10.1.0.0/16"),
+		VpcPeeringConnectionId: aws.String("pcx-0a0a0a0a0a0a0a0a0"),
+		State:                  types.RouteStateActive,
+	}
+
+	tests := []struct {
+		name           string
+		addrs          []string
+		mapPublicIP    bool
+		routes         []types.Route
+		mainRouteTable bool
+		errString      string
+	}{
+		{
+			name:   "garm in peered vpc",
+			addrs:  []string{"10.1.2.3"},
+			routes: []types.Route{localRoute, peeringRoute},
+		},
+		{
+			name:           "main route table",
+			addrs:          []string{"10.1.2.3"},
+			routes:         []types.Route{localRoute, peeringRoute},
+			mainRouteTable: true,
+		},
+		{
+			name:      "no route",
+			addrs:     []string{"10.2.2.3"},
+			routes:    []types.Route{localRoute, peeringRoute},
+			errString: "instances in subnet subnet-0a0a0a0a0a0a0a0a0 can not reach garm.example.com (route table rtb-0a0a0a0a0a0a0a0a0): no route to 10.2.2.3",
+		},
+		{
+			name:  "blackhole beats default route",
+			addrs: []string{"10.1.2.3"},
+			routes: []types.Route{
+				localRoute,
+				{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-0a0a0a0a0a0a0a0a0"), State: types.RouteStateActive},
+				{DestinationCidrBlock: aws.String("10.1.0.0/16"), TransitGatewayId: aws.String("tgw-0a0a0a0a0a0a0a0a0"), State: types.RouteStateBlackhole},
+			},
+			errString: "the route to 10.1.2.3 is a blackhole",
+		},
+		{
+			name:  "internet gateway without public ip",
+			addrs: []string{"203.0.113.10"},
+			routes: []types.Route{
+				localRoute,
+				{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-0a0a0a0a0a0a0a0a0"), State: types.RouteStateActive},
+			},
+			errString: "203.0.113.10 is routed through internet gateway igw-0a0a0a0a0a0a0a0a0, but instances in the subnet get no public IPv4 address",
+		},
+		{
+			name:        "internet gateway with public ip",
+			addrs:       []string{"203.0.113.10"},
+			mapPublicIP: true,
+			routes: []types.Route{
+				localRoute,
+				{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-0a0a0a0a0a0a0a0a0"), State: types.RouteStateActive},
+			},
+		},
+		{
+			name:   "any routable address",
+			addrs:  []string{"2001:db8::10", "10.1.2.3"},
+			routes: []types.Route{localRoute, peeringRoute},
+		},
+	}
+
+	defaultLookupHost := lookupHost
+	defer func() { lookupHost = defaultLookupHost }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+			lookupHost = func(_ context.Context, host string) ([]netip.Addr, error) {
+				if host != "garm.example.com" {
+					return nil, fmt.Errorf("unexpected host %s", host)
+				}
+				var addrs []netip.Addr
+				for _, addr := range tt.addrs {
+					addrs = append(addrs, netip.MustParseAddr(addr))
+				}
+				return addrs, nil
+			}
+
+			mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+				Subnets: []types.Subnet{
+					{
+						SubnetId:            aws.String("subnet-0a0a0a0a0a0a0a0a0"),
+						VpcId:               aws.String("vpc-0a0a0a0a0a0a0a0a0"),
+						MapPublicIpOnLaunch: aws.Bool(tt.mapPublicIP),
+					},
+				},
+			}, nil)
+			routeTables := &ec2.DescribeRouteTablesOutput{
+				RouteTables: []types.RouteTable{
+					{RouteTableId: aws.String("rtb-0a0a0a0a0a0a0a0a0"), Routes: tt.routes},
+				},
+			}
+			associated, main := routeTables, &ec2.DescribeRouteTablesOutput{}
+			if tt.mainRouteTable {
+				associated, main = main, associated
+			}
+			mockClient.On("DescribeRouteTables", ctx, mock.MatchedBy(func(input *ec2.DescribeRouteTablesInput) bool {
+				return aws.ToString(input.Filters[0].Name) == "association.subnet-id"
+			}), mock.Anything).Return(associated, nil)
+			mockClient.On("DescribeRouteTables", ctx, mock.MatchedBy(func(input *ec2.DescribeRouteTablesInput) bool {
+				return aws.ToString(input.Filters[0].Name) == "vpc-id"
+			}), mock.Anything).Return(main, nil)
+
+			err := awsCli.checkCallbackReachability(ctx, "subnet-0a0a0a0a0a0a0a0a0",
+				"https://garm.example.com/api/v1/callbacks", "https://garm.example.com/api/v1/metadata")
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestCheckCallbackReachabilityHiddenRouteTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		owner     string
+		errString string
+	}{
+		{
+			name:      "subnet owned by caller",
+			owner:     "111111111111",
+			errString: "no route table found for subnet subnet-0a0a0a0a0a0a0a0a0",
+		},
+		{
+			name:  "shared subnet",
+			owner: "222222222222",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			mockSTS := new(MockSTSClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+				sts:    mockSTS,
+			}
+			mockCallerAccount(mockSTS, "111111111111")
+			mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+				Subnets: []types.Subnet{
+					{
+						SubnetId: aws.String("subnet-0a0a0a0a0a0a0a0a0"),
+						VpcId:    aws.String("vpc-0a0a0a0a0a0a0a0a0"),
+						OwnerId:  aws.String(tt.owner),
+					},
+				},
+			}, nil)
+			mockClient.On("DescribeRouteTables", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeRouteTablesOutput{}, nil)
+
+			err := awsCli.checkCallbackReachability(ctx, "subnet-0a0a0a0a0a0a0a0a0", "https://garm.example.com/api/v1/callbacks")
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// keepOnFailureTTL returns how long the instance is kept if it failed to
+// bootstrap. It returns false if the instance was not created by a pool with
+// keep_on_failure, or did not fail.
+func keepOnFailureTTL(instance types.Instance) (time.Duration, bool) {
+	if !util.IsBootstrapFailed(instance) {
+		return 0, false
+	}
+	for _, tag := range instance.Tags {
+		if aws.ToString(tag.Key) != util.KeepOnFailureTag {
+			continue
+		}
+		ttl, err := time.ParseDuration(aws.ToString(tag.Value))
+		if err != nil || ttl <= 0 {
+			return 0, false
+		}
+		return min(ttl, spec.MaxKeepOnFailureTTL), true
+	}
+	return 0, false
+}
+
+// RetainFailedInstance keeps an instance that failed to bootstrap, instead of
+// terminating it, if its pool has keep_on_failure set. The instance is tagged
+// with the time after which it is terminated by ReapRetainedInstances. It
+// returns false if the instance should be terminated as usual.
+func (a *AwsCli) RetainFailedInstance(ctx context.Context, instance types.Instance) (bool, error) {
+	if gcAfter, ok := util.GCAfter(instance); ok {
+		// Already kept. Deleting it again doesn't extend its life.
+		return time.Now().Before(gcAfter), nil
+	}
+	ttl, ok := keepOnFailureTTL(instance)
+	if !ok {
+		return false, nil
+	}
+
+	instanceID := aws.ToString(instance.InstanceId)
+	gcAfter := time.Now().UTC().Add(ttl).Format(time.RFC3339)
+	_, err := a.client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{instanceID},
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(util.GCAfterTag),
+				Value: aws.String(gcAfter),
+			},
+		},
+	})
+	a.audit(ctx, "retain", instanceID, fmt.Sprintf("failed to bootstrap, kept until %s", gcAfter), err)
+	if err != nil {
+		return false, fmt.Errorf("failed to tag instance %s: %w", instanceID, err)
+	}
+	slog.InfoContext(ctx, "keeping failed instance", "instance_id", instanceID, "until", gcAfter)
+	return true, nil
+}
+
+// ReapRetainedInstances terminates the kept instances among the given ones
+// whose time is up, and returns those that aren't kept. Failing to terminate
+// an instance is logged, and retried the next time.
+func (a *AwsCli) ReapRetainedInstances(ctx context.Context, instances []types.Instance) []types.Instance {
+	var active []types.Instance
+	for _, instance := range instances {
+		gcAfter, ok := util.GCAfter(instance)
+		if !ok {
+			active = append(active, instance)
+			continue
+		}
+		if time.Now().Before(gcAfter) {
+			continue
+		}
+		instanceID := aws.ToString(instance.InstanceId)
+		if err := a.TerminateInstance(ctx, instanceID, "keep_on_failure TTL expired"); err != nil {
+			slog.WarnContext(ctx, "failed to terminate expired instance", "instance_id", instanceID, "error", err)
+			continue
+		}
+		a.DeleteEphemeralKeyPair(ctx, instance)
+		a.DeleteUserDataObject(ctx, instance)
+	}
+	return active
+}
+
+// DeferTermination tags an instance GARM deleted with the time after which
+// it is terminated by ReapDeletedInstances, if a deletion grace period is
+// configured. It returns false if the instance should be terminated right
+// away.
+func (a *AwsCli) DeferTermination(ctx context.Context, instance types.Instance) (bool, error) {
+	grace := a.cfg.GetDeletionGracePeriod()
+	if grace <= 0 || instance.InstanceId == nil {
+		return false, nil
+	}
+	if instance.State != nil && (instance.State.Name == types.InstanceStateNameShuttingDown || instance.State.Name == types.InstanceStateNameTerminated) {
+		return false, nil
+	}
+	if _, ok := util.DeleteAfter(instance); ok {
+		// Already deferred. Deleting it again doesn't extend its life.
+		return true, nil
+	}
+
+	instanceID := aws.ToString(instance.InstanceId)
+	deleteAfter := time.Now().UTC().Add(grace).Format(time.RFC3339)
+	_, err := a.client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{instanceID},
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(util.DeleteAfterTag),
+				Value: aws.String(deleteAfter),
+			},
+		},
+	})
+	a.audit(ctx, "defer", instanceID, fmt.Sprintf("deleted by GARM, terminated after %s", deleteAfter), err)
+	if err != nil {
+		return false, fmt.Errorf("failed to tag instance %s: %w", instanceID, err)
+	}
+	slog.InfoContext(ctx, "deferring termination of instance", "instance_id", instanceID, "until", deleteAfter)
+	return true, nil
+}
+
+// ReapDeletedInstances terminates the instances among the given ones whose
+// deletion grace period is over, and returns those whose termination isn't
+// deferred. Failing to terminate an instance is logged, and retried the next
+// time.
+func (a *AwsCli) ReapDeletedInstances(ctx context.Context, instances []types.Instance) []types.Instance {
+	var active []types.Instance
+	for _, instance := range instances {
+		deleteAfter, ok := util.DeleteAfter(instance)
+		if !ok {
+			active = append(active, instance)
+			continue
+		}
+		if time.Now().Before(deleteAfter) {
+			continue
+		}
+		instanceID := aws.ToString(instance.InstanceId)
+		if err := a.TerminateInstance(ctx, instanceID, "deletion grace period expired"); err != nil {
+			slog.WarnContext(ctx, "failed to terminate deleted instance", "instance_id", instanceID, "error", err)
+			continue
+		}
+		a.DeleteEphemeralKeyPair(ctx, instance)
+		a.DeleteUserDataObject(ctx, instance)
+	}
+	return active
+}
+
+// ActiveInstances returns the instances among the given ones that are
+// neither kept by keep_on_failure nor have their termination deferred, as
+// GARM already deleted those. Nothing is terminated.
+func ActiveInstances(instances []types.Instance) []types.Instance {
+	var active []types.Instance
+	for _, instance := range instances {
+		if _, ok := util.GCAfter(instance); ok {
+			continue
+		}
+		if _, ok := util.DeleteAfter(instance); ok {
+			continue
+		}
+		active = append(active, instance)
+	}
+	return active
+}
+
+// expiryReason returns why the instance is due to be terminated: its
+// keep_on_failure TTL or deletion grace period ran out, or it exceeded the
+// max_runtime of its pool. It returns an empty string if it isn't due.
+func expiryReason(instance types.Instance, now time.Time) string {
+	if gcAfter, ok := util.GCAfter(instance); ok && !now.Before(gcAfter) {
+		return "keep_on_failure TTL expired"
+	}
+	if deleteAfter, ok := util.DeleteAfter(instance); ok && !now.Before(deleteAfter) {
+		return "deletion grace period expired"
+	}
+	if maxRuntime, ok := util.MaxRuntime(instance); ok && instance.LaunchTime != nil && now.Sub(*instance.LaunchTime) >= maxRuntime {
+		return fmt.Sprintf("max_runtime of %s exceeded", maxRuntime)
+	}
+	return ""
+}
+
+// EnforceMaxRuntime terminates the instances among the given ones that have
+// been running for longer than the max_runtime of their pool, whether GARM
+// still considers them busy or not. It returns the others. Failing to
+// terminate an instance is logged, and retried the next time.
+func (a *AwsCli) EnforceMaxRuntime(ctx context.Context, instances []types.Instance) []types.Instance {
+	var active []types.Instance
+	for _, instance := range instances {
+		maxRuntime, ok := util.MaxRuntime(instance)
+		if !ok || instance.LaunchTime == nil || time.Since(*instance.LaunchTime) < maxRuntime {
+			active = append(active, instance)
+			continue
+		}
+		instanceID := aws.ToString(instance.InstanceId)
+		reason := fmt.Sprintf("max_runtime of %s exceeded", maxRuntime)
+		slog.InfoContext(ctx, "terminating instance", "instance_id", instanceID, "reason", reason)
+		if err := a.TerminateInstance(ctx, instanceID, reason); err != nil {
+			slog.WarnContext(ctx, "failed to terminate instance", "instance_id", instanceID, "error", err)
+			continue
+		}
+		a.DeleteEphemeralKeyPair(ctx, instance)
+		a.DeleteUserDataObject(ctx, instance)
+	}
+	return active
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func retentionInstance(id string, tags map[string]string) types.Instance {
+	instance := types.Instance{InstanceId: aws.String(id)}
+	for key, value := range tags {
+		instance.Tags = append(instance.Tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return instance
+}
+
+func TestRetainFailedInstance(t *testing.T) {
+	failed := map[string]string{util.BootstrapStatusTag: util.BootstrapStatusFailed}
+
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		retained bool
+		tagged   bool
+	}{
+		{
+			name: "pool without keep_on_failure",
+			tags: failed,
+		},
+		{
+			name: "bootstrap did not fail",
+			tags: map[string]string{util.KeepOnFailureTag: "4h0m0s"},
+		},
+		{
+			name:     "failed instance is kept",
+			tags:     map[string]string{util.BootstrapStatusTag: util.BootstrapStatusFailed, util.KeepOnFailureTag: "4h0m0s"},
+			retained: true,
+			tagged:   true,
+		},
+		{
+			name:     "already kept",
+			tags:     map[string]string{util.GCAfterTag: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+			retained: true,
+		},
+		{
+			name: "kept until the past",
+			tags: map[string]string{util.GCAfterTag: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+			if tt.tagged {
+				mockClient.On("CreateTags", ctx, mock.MatchedBy(func(input *ec2.CreateTagsInput) bool {
+					if len(input.Tags) != 1 || aws.ToString(input.Tags[0].Key) != util.GCAfterTag {
+						return false
+					}
+					gcAfter, err := time.Parse(time.RFC3339, aws.ToString(input.Tags[0].Value))
+					return err == nil && time.Until(gcAfter) > 3*time.Hour && time.Until(gcAfter) <= 4*time.Hour
+				}), mock.Anything).Return(&ec2.CreateTagsOutput{}, nil)
+			}
+
+			retained, err := awsCli.RetainFailedInstance(ctx, retentionInstance("i-1234567890abcdef0", tt.tags))
+			require.NoError(t, err)
+			require.Equal(t, tt.retained, retained)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReapRetainedInstances(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+	instances := []types.Instance{
+		retentionInstance("i-active", nil),
+		retentionInstance("i-kept", map[string]string{util.GCAfterTag: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}),
+		retentionInstance("i-expired", map[string]string{util.GCAfterTag: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}),
+	}
+	mockClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{"i-expired"},
+	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	active := awsCli.ReapRetainedInstances(ctx, instances)
+	require.Equal(t, instances[:1], active)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeferTermination(t *testing.T) {
+	tests := []struct {
+		name     string
+		grace    string
+		instance types.Instance
+		deferred bool
+		tagged   bool
+	}{
+		{
+			name:     "no grace period",
+			instance: retentionInstance("i-1234567890abcdef0", nil),
+		},
+		{
+			name:     "termination is deferred",
+			grace:    "15m",
+			instance: retentionInstance("i-1234567890abcdef0", nil),
+			deferred: true,
+			tagged:   true,
+		},
+		{
+			name:     "already deferred",
+			grace:    "15m",
+			instance: retentionInstance("i-1234567890abcdef0", map[string]string{util.DeleteAfterTag: time.Now().Add(time.Minute).UTC().Format(time.RFC3339)}),
+			deferred: true,
+		},
+		{
+			name:  "already terminating",
+			grace: "15m",
+			instance: types.Instance{
+				InstanceId: aws.String("i-1234567890abcdef0"),
+				State:      &types.InstanceState{Name: types.InstanceStateNameShuttingDown},
+			},
+		},
+		{
+			name:  "unknown instance",
+			grace: "15m",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2", DeletionGracePeriod: tt.grace},
+				client: mockClient,
+			}
+			if tt.tagged {
+				mockClient.On("CreateTags", ctx, mock.MatchedBy(func(input *ec2.CreateTagsInput) bool {
+					if len(input.Tags) != 1 || aws.ToString(input.Tags[0].Key) != util.DeleteAfterTag {
+						return false
+					}
+					deleteAfter, err := time.Parse(time.RFC3339, aws.ToString(input.Tags[0].Value))
+					return err == nil && time.Until(deleteAfter) > 14*time.Minute && time.Until(deleteAfter) <= 15*time.Minute
+				}), mock.Anything).Return(&ec2.CreateTagsOutput{}, nil)
+			}
+
+			deferred, err := awsCli.DeferTermination(ctx, tt.instance)
+			require.NoError(t, err)
+			require.Equal(t, tt.deferred, deferred)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReapDeletedInstances(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+	instances := []types.Instance{
+		retentionInstance("i-active", nil),
+		retentionInstance("i-deferred", map[string]string{util.DeleteAfterTag: time.Now().Add(time.Minute).UTC().Format(time.RFC3339)}),
+		retentionInstance("i-expired", map[string]string{util.DeleteAfterTag: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)}),
+	}
+	mockClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{"i-expired"},
+	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	active := awsCli.ReapDeletedInstances(ctx, instances)
+	require.Equal(t, instances[:1], active)
+	mockClient.AssertExpectations(t)
+}
+
+func TestActiveInstances(t *testing.T) {
+	instances := []types.Instance{
+		retentionInstance("i-active", nil),
+		retentionInstance("i-kept", map[string]string{util.GCAfterTag: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}),
+		retentionInstance("i-deferred", map[string]string{util.DeleteAfterTag: time.Now().Add(time.Minute).UTC().Format(time.RFC3339)}),
+	}
+	require.Equal(t, instances[:1], ActiveInstances(instances))
+}
+
+func TestEnforceMaxRuntime(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+	launched := func(instance types.Instance, ago time.Duration) types.Instance {
+		instance.LaunchTime = aws.Time(time.Now().Add(-ago))
+		return instance
+	}
+	instances := []types.Instance{
+		launched(retentionInstance("i-no-limit", nil), 48*time.Hour),
+		launched(retentionInstance("i-within", map[string]string{util.MaxRuntimeTag: "6h0m0s"}), time.Hour),
+		launched(retentionInstance("i-mangled", map[string]string{util.MaxRuntimeTag: "six hours"}), 48*time.Hour),
+		launched(retentionInstance("i-overdue", map[string]string{util.MaxRuntimeTag: "6h0m0s"}), 7*time.Hour),
+	}
+	mockClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{"i-overdue"},
+	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	active := awsCli.EnforceMaxRuntime(ctx, instances)
+	require.Equal(t, instances[:3], active)
+	mockClient.AssertExpectations(t)
+}
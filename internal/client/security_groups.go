@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+)
+
+// GetSubnetVpcID returns the ID of the VPC the subnet belongs to.
+func (a *AwsCli) GetSubnetVpcID(ctx context.Context, subnetID string) (string, error) {
+	resp, err := a.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: []string{subnetID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe subnet %s: %w", subnetID, err)
+	}
+
+	if len(resp.Subnets) == 0 || resp.Subnets[0].VpcId == nil {
+		return "", fmt.Errorf("failed to determine VPC of subnet %s", subnetID)
+	}
+
+	return *resp.Subnets[0].VpcId, nil
+}
+
+func (a *AwsCli) describeSecurityGroups(ctx context.Context, filters []types.Filter) ([]types.SecurityGroup, error) {
+	var groups []types.SecurityGroup
+	paginator := ec2.NewDescribeSecurityGroupsPaginator(a.client, &ec2.DescribeSecurityGroupsInput{
+		Filters: filters,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe security groups: %w", err)
+		}
+		groups = append(groups, page.SecurityGroups...)
+	}
+	return groups, nil
+}
+
+// resolveSecurityGroups looks up the IDs of the security groups referenced by
+// name or by tags in the runner spec and adds them to its security group IDs.
+// Security groups belong to a VPC, so the lookup is limited to the VPC of the
+// subnet the instance is created in.
+func (a *AwsCli) resolveSecurityGroups(ctx context.Context, spec *spec.RunnerSpec) error {
+	if len(spec.SecurityGroupNames) == 0 && len(spec.SecurityGroupTags) == 0 {
+		return nil
+	}
+
+	vpcID, err := a.GetSubnetVpcID(ctx, spec.SubnetID)
+	if err != nil {
+		return err
+	}
+	vpcFilter := types.Filter{
+		Name:   aws.String("vpc-id"),
+		Values: []string{vpcID},
+	}
+
+	securityGroupIDs := append([]string{}, spec.SecurityGroupIDs...)
+	seen := map[string]bool{}
+	for _, id := range securityGroupIDs {
+		seen[id] = true
+	}
+	add := func(groups []types.SecurityGroup) {
+		for _, group := range groups {
+			if group.GroupId == nil || seen[*group.GroupId] {
+				continue
+			}
+			seen[*group.GroupId] = true
+			securityGroupIDs = append(securityGroupIDs, *group.GroupId)
+		}
+	}
+
+	if len(spec.SecurityGroupNames) > 0 {
+		groups, err := a.describeSecurityGroups(ctx, []types.Filter{
+			vpcFilter,
+			{
+				Name:   aws.String("group-name"),
+				Values: spec.SecurityGroupNames,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		found := map[string]bool{}
+		for _, group := range groups {
+			found[aws.ToString(group.GroupName)] = true
+		}
+		var missing []string
+		for _, name := range spec.SecurityGroupNames {
+			if !found[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("security groups %s not found in %s", strings.Join(missing, ", "), vpcID)
+		}
+		add(groups)
+	}
+
+	if len(spec.SecurityGroupTags) > 0 {
+		// Sort the keys to keep the request deterministic.
+		keys := make([]string, 0, len(spec.SecurityGroupTags))
+		for key := range spec.SecurityGroupTags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		filters := []types.Filter{vpcFilter}
+		for _, key := range keys {
+			filters = append(filters, types.Filter{
+				Name:   aws.String("tag:" + key),
+				Values: []string{spec.SecurityGroupTags[key]},
+			})
+		}
+		groups, err := a.describeSecurityGroups(ctx, filters)
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			return fmt.Errorf("no security groups in %s match the given tags", vpcID)
+		}
+		add(groups)
+	}
+
+	spec.SecurityGroupIDs = securityGroupIDs
+	return nil
+}
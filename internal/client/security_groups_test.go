@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func hasFilter(filters []types.Filter, name, value string) bool {
+	for _, filter := range filters {
+		if aws.ToString(filter.Name) == name && len(filter.Values) > 0 && filter.Values[0] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecurityGroupsTestCli() (*AwsCli, *MockComputeClient) {
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+	}
+	mockClient.On("DescribeSubnets", mock.Anything, mock.MatchedBy(func(input *ec2.DescribeSubnetsInput) bool {
+		return len(input.SubnetIds) == 1 && input.SubnetIds[0] == "subnet-1234567890abcdef0"
+	}), mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []types.Subnet{
+			{
+				SubnetId: aws.String("subnet-1234567890abcdef0"),
+				VpcId:    aws.String("vpc-1234567890abcdef0"),
+			},
+		},
+	}, nil)
+	return awsCli, mockClient
+}
+
+func TestResolveSecurityGroups(t *testing.T) {
+	ctx := context.Background()
+	awsCli, mockClient := newSecurityGroupsTestCli()
+
+	mockClient.On("DescribeSecurityGroups", ctx, mock.MatchedBy(func(input *ec2.DescribeSecurityGroupsInput) bool {
+		return hasFilter(input.Filters, "vpc-id", "vpc-1234567890abcdef0") && hasFilter(input.Filters, "group-name", "runners")
+	}), mock.Anything).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []types.SecurityGroup{
+			{
+				GroupId:   aws.String("sg-0a0a0a0a0a0a0a0a0"),
+				GroupName: aws.String("runners"),
+			},
+		},
+	}, nil)
+	mockClient.On("DescribeSecurityGroups", ctx, mock.MatchedBy(func(input *ec2.DescribeSecurityGroupsInput) bool {
+		return hasFilter(input.Filters, "vpc-id", "vpc-1234567890abcdef0") && hasFilter(input.Filters, "tag:role", "garm-runner")
+	}), mock.Anything).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []types.SecurityGroup{
+			{
+				GroupId:   aws.String("sg-0a0a0a0a0a0a0a0a0"),
+				GroupName: aws.String("runners"),
+			},
+			{
+				GroupId:   aws.String("sg-0b0b0b0b0b0b0b0b0"),
+				GroupName: aws.String("egress"),
+			},
+		},
+	}, nil)
+
+	runnerSpec := &spec.RunnerSpec{
+		SubnetID:           "subnet-1234567890abcdef0",
+		SecurityGroupIDs:   []string{"sg-0c0c0c0c0c0c0c0c0"},
+		SecurityGroupNames: []string{"runners"},
+		SecurityGroupTags:  map[string]string{"role": "garm-runner"},
+	}
+	err := awsCli.resolveSecurityGroups(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sg-0c0c0c0c0c0c0c0c0", "sg-0a0a0a0a0a0a0a0a0", "sg-0b0b0b0b0b0b0b0b0"}, runnerSpec.SecurityGroupIDs)
+	mockClient.AssertExpectations(t)
+}
+
+func TestResolveSecurityGroupsMissingName(t *testing.T) {
+	ctx := context.Background()
+	awsCli, mockClient := newSecurityGroupsTestCli()
+
+	mockClient.On("DescribeSecurityGroups", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []types.SecurityGroup{
+			{
+				GroupId:   aws.String("sg-0a0a0a0a0a0a0a0a0"),
+				GroupName: aws.String("runners"),
+			},
+		},
+	}, nil)
+
+	runnerSpec := &spec.RunnerSpec{
+		SubnetID:           "subnet-1234567890abcdef0",
+		SecurityGroupNames: []string{"runners", "deleted"},
+	}
+	err := awsCli.resolveSecurityGroups(ctx, runnerSpec)
+	require.ErrorContains(t, err, "security groups deleted not found in vpc-1234567890abcdef0")
+}
+
+func TestResolveSecurityGroupsNoTagMatch(t *testing.T) {
+	ctx := context.Background()
+	awsCli, mockClient := newSecurityGroupsTestCli()
+
+	mockClient.On("DescribeSecurityGroups", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSecurityGroupsOutput{}, nil)
+
+	runnerSpec := &spec.RunnerSpec{
+		SubnetID:          "subnet-1234567890abcdef0",
+		SecurityGroupTags: map[string]string{"role": "garm-runner"},
+	}
+	err := awsCli.resolveSecurityGroups(ctx, runnerSpec)
+	require.ErrorContains(t, err, "no security groups in vpc-1234567890abcdef0 match the given tags")
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// SerialConsoleHost returns the host of the EC2 serial console endpoint of
+// the region.
+func SerialConsoleHost(region string) string {
+	return fmt.Sprintf("serial-console.ec2-instance-connect.%s.aws", region)
+}
+
+// SerialConsoleUser returns the user that connects to the given serial port
+// of an instance, on the serial console endpoint.
+func SerialConsoleUser(instanceID string, port int) string {
+	return fmt.Sprintf("%s.port%d", instanceID, port)
+}
+
+// ensureSerialConsole makes sure the serial console of an instance of the
+// given type can be used. Serial console access is turned on for the
+// account if it is off and the config allows it. Problems are only logged,
+// as the runner works without a serial console.
+func (a *AwsCli) ensureSerialConsole(ctx context.Context, flavor string) {
+	typeInfo, err := a.GetInstanceType(ctx, flavor)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to check serial console support", "flavor", flavor, "error", err)
+	} else if typeInfo.Hypervisor != types.InstanceTypeHypervisorNitro && !aws.ToBool(typeInfo.BareMetal) {
+		slog.WarnContext(ctx, "instance type is not Nitro based and has no serial console", "flavor", flavor)
+	}
+
+	status, err := a.client.GetSerialConsoleAccessStatus(ctx, &ec2.GetSerialConsoleAccessStatusInput{})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to get serial console access status", "error", err)
+		return
+	}
+	if aws.ToBool(status.SerialConsoleAccessEnabled) {
+		return
+	}
+	if !a.cfg.EnableSerialConsoleAccess {
+		slog.WarnContext(ctx, "serial console access is disabled for the account", "region", a.cfg.Region)
+		return
+	}
+	if _, err := a.client.EnableSerialConsoleAccess(ctx, &ec2.EnableSerialConsoleAccessInput{}); err != nil {
+		slog.WarnContext(ctx, "failed to enable serial console access", "region", a.cfg.Region, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "enabled serial console access for the account", "region", a.cfg.Region)
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerialConsoleIdentifiers(t *testing.T) {
+	require.Equal(t, "serial-console.ec2-instance-connect.us-west-2.aws", SerialConsoleHost("us-west-2"))
+	require.Equal(t, "i-1234567890abcdef0.port0", SerialConsoleUser("i-1234567890abcdef0", 0))
+}
+
+func TestEnsureSerialConsole(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		allowEnable bool
+		enables     bool
+	}{
+		{
+			name:    "already enabled",
+			enabled: true,
+		},
+		{
+			name:        "enabled by the provider",
+			allowEnable: true,
+			enables:     true,
+		},
+		{
+			name: "not allowed to enable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:                    "us-west-2",
+					EnableSerialConsoleAccess: tt.allowEnable,
+				},
+				client: mockClient,
+			}
+
+			mockClient.On("DescribeInstanceTypes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+				InstanceTypes: []types.InstanceTypeInfo{
+					{
+						InstanceType: types.InstanceTypeT3Small,
+						Hypervisor:   types.InstanceTypeHypervisorNitro,
+					},
+				},
+			}, nil)
+			mockClient.On("GetSerialConsoleAccessStatus", ctx, mock.Anything, mock.Anything).Return(&ec2.GetSerialConsoleAccessStatusOutput{
+				SerialConsoleAccessEnabled: aws.Bool(tt.enabled),
+			}, nil)
+			mockClient.On("EnableSerialConsoleAccess", ctx, mock.Anything, mock.Anything).Return(&ec2.EnableSerialConsoleAccessOutput{
+				SerialConsoleAccessEnabled: aws.Bool(true),
+			}, nil)
+
+			awsCli.ensureSerialConsole(ctx, "t3.small")
+
+			if tt.enables {
+				mockClient.AssertCalled(t, "EnableSerialConsoleAccess", ctx, mock.Anything, mock.Anything)
+			} else {
+				mockClient.AssertNotCalled(t, "EnableSerialConsoleAccess", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// sharedSubnetOwner returns the account owning the subnet if it was shared
+// with the provider's account through AWS RAM, or an empty string if the
+// provider's account owns it.
+func (a *AwsCli) sharedSubnetOwner(ctx context.Context, subnet types.Subnet) (string, error) {
+	owner := aws.ToString(subnet.OwnerId)
+	if owner == "" {
+		return "", nil
+	}
+	account, err := a.callerAccountID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine owner of subnet %s: %w", aws.ToString(subnet.SubnetId), err)
+	}
+	if owner == account {
+		return "", nil
+	}
+	return owner, nil
+}
+
+// explainSharedSubnetErr adds a hint to errors returned by RunInstances when
+// no security groups were set and the subnet is shared. Participants of a
+// shared VPC can't use the default security group of the VPC, which is what
+// EC2 falls back to, so those launches always fail.
+func (a *AwsCli) explainSharedSubnetErr(ctx context.Context, subnetID string, input *ec2.RunInstancesInput, err error) error {
+	var apiErr smithy.APIError
+	if len(input.SecurityGroupIds) > 0 || !errors.As(err, &apiErr) || util.IsEC2CapacityErr(err) {
+		return err
+	}
+	subnet, descErr := a.describeSubnet(ctx, subnetID)
+	if descErr != nil {
+		slog.WarnContext(ctx, "failed to check if subnet is shared", "subnet_id", subnetID, "error", descErr)
+		return err
+	}
+	owner, ownerErr := a.sharedSubnetOwner(ctx, subnet)
+	if ownerErr != nil {
+		slog.WarnContext(ctx, "failed to check if subnet is shared", "subnet_id", subnetID, "error", ownerErr)
+		return err
+	}
+	if owner == "" {
+		return err
+	}
+	return fmt.Errorf("%w (subnet %s is shared by account %s and the default security group of its VPC can't be used; set security groups owned by or shared with this account)", err, subnetID, owner)
+}
@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func mockCallerAccount(m *MockSTSClient, account string) {
+	m.On("GetCallerIdentity", mock.Anything, mock.Anything, mock.Anything).Return(&sts.GetCallerIdentityOutput{
+		Arn:     aws.String("arn:aws:iam::" + account + ":user/garm"),
+		Account: aws.String(account),
+	}, nil)
+}
+
+func sharedSubnetSpec() *spec.RunnerSpec {
+	return &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		ControllerID: "controllerID",
+	}
+}
+
+func sharedSubnet(owner string) *ec2.DescribeSubnetsOutput {
+	return &ec2.DescribeSubnetsOutput{
+		Subnets: []types.Subnet{
+			{
+				SubnetId: aws.String("subnet-1234567890abcdef0"),
+				VpcId:    aws.String("vpc-1234567890abcdef0"),
+				OwnerId:  aws.String(owner),
+			},
+		},
+	}
+}
+
+func TestSharedSubnetOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		owner     *string
+		stsErr    error
+		expected  string
+		errString string
+	}{
+		{
+			name: "owner not reported",
+		},
+		{
+			name:  "owned by caller",
+			owner: aws.String("111111111111"),
+		},
+		{
+			name:     "shared by another account",
+			owner:    aws.String("222222222222"),
+			expected: "222222222222",
+		},
+		{
+			name:      "caller identity error",
+			owner:     aws.String("222222222222"),
+			stsErr:    fmt.Errorf("access denied"),
+			errString: "failed to determine owner of subnet subnet-1234567890abcdef0: failed to get caller identity: access denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockSTS := new(MockSTSClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{Region: "us-west-2"},
+				sts: mockSTS,
+			}
+			if tt.stsErr != nil {
+				mockSTS.On("GetCallerIdentity", ctx, mock.Anything, mock.Anything).Return((*sts.GetCallerIdentityOutput)(nil), tt.stsErr)
+			} else {
+				mockCallerAccount(mockSTS, "111111111111")
+			}
+
+			owner, err := awsCli.sharedSubnetOwner(ctx, types.Subnet{
+				SubnetId: aws.String("subnet-1234567890abcdef0"),
+				OwnerId:  tt.owner,
+			})
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, owner)
+			if tt.owner == nil {
+				mockSTS.AssertNotCalled(t, "GetCallerIdentity", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCreateRunningInstancePrivateOnlySharedSubnet(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	mockSTS := new(MockSTSClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:      "us-west-2",
+			SubnetID:    "subnet-1234567890abcdef0",
+			PrivateOnly: true,
+		},
+		client: mockClient,
+		sts:    mockSTS,
+	}
+	spec := sharedSubnetSpec()
+	spec.SecurityGroupIDs = []string{"sg-1234567890abcdef0"}
+
+	mockCreateLookups(mockClient)
+	mockCallerAccount(mockSTS, "111111111111")
+	mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(sharedSubnet("222222222222"), nil)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String("i-1234567890abcdef0"),
+			},
+		},
+	}, nil)
+
+	instanceID, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, "i-1234567890abcdef0", instanceID)
+	mockClient.AssertNotCalled(t, "DescribeVpcEndpoints", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateRunningInstanceSharedSubnetWithoutSecurityGroups(t *testing.T) {
+	tests := []struct {
+		name      string
+		owner     string
+		errString string
+	}{
+		{
+			name:      "subnet owned by caller",
+			owner:     "111111111111",
+			errString: "failed to create instance: api error UnauthorizedOperation: ",
+		},
+		{
+			name:      "shared subnet",
+			owner:     "222222222222",
+			errString: "failed to create instance: api error UnauthorizedOperation:  (subnet subnet-1234567890abcdef0 is shared by account 222222222222 and the default security group of its VPC can't be used; set security groups owned by or shared with this account)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			mockSTS := new(MockSTSClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:   "us-west-2",
+					SubnetID: "subnet-1234567890abcdef0",
+				},
+				client: mockClient,
+				sts:    mockSTS,
+			}
+
+			mockCreateLookups(mockClient)
+			mockCallerAccount(mockSTS, "111111111111")
+			mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(sharedSubnet(tt.owner), nil)
+			mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return((*ec2.RunInstancesOutput)(nil), &smithy.GenericAPIError{
+				Code: "UnauthorizedOperation",
+			})
+
+			_, err := awsCli.CreateRunningInstance(ctx, sharedSubnetSpec())
+			require.EqualError(t, err, tt.errString)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+)
+
+// checkSharedVolume makes sure the shared volume of the pool can be attached
+// to many instances, and limits the subnets instances are created in to
+// those in the availability zone of the volume. Volumes can only be attached
+// to instances in their own availability zone.
+func (a *AwsCli) checkSharedVolume(ctx context.Context, spec *spec.RunnerSpec) error {
+	volumeID := spec.SharedVolume.VolumeID
+	volumes, err := a.describeVolumes(ctx, []string{volumeID})
+	if err != nil {
+		return err
+	}
+	volume, ok := volumes[volumeID]
+	if !ok {
+		return fmt.Errorf("volume %s not found", volumeID)
+	}
+	if !aws.ToBool(volume.MultiAttachEnabled) {
+		return fmt.Errorf("volume %s does not have multi-attach enabled", volumeID)
+	}
+	zone := aws.ToString(volume.AvailabilityZone)
+
+	subnetIDs := append([]string{spec.SubnetID}, spec.FallbackSubnetIDs...)
+	resp, err := a.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: subnetIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe subnets: %w", err)
+	}
+	zones := map[string]string{}
+	for _, subnet := range resp.Subnets {
+		zones[aws.ToString(subnet.SubnetId)] = aws.ToString(subnet.AvailabilityZone)
+	}
+
+	var usable []string
+	for _, subnetID := range subnetIDs {
+		if zones[subnetID] == zone {
+			usable = append(usable, subnetID)
+			continue
+		}
+		slog.InfoContext(ctx, "not using subnet outside the availability zone of the shared volume", "subnet_id", subnetID, "availability_zone", zone, "volume_id", volumeID)
+	}
+	if len(usable) == 0 {
+		return fmt.Errorf("none of the subnets is in availability zone %s of volume %s", zone, volumeID)
+	}
+	spec.SubnetID = usable[0]
+	spec.FallbackSubnetIDs = usable[1:]
+	return nil
+}
+
+// attachSharedVolume attaches the shared volume to the instance once it is
+// running. The instance mounts it when it appears.
+func (a *AwsCli) attachSharedVolume(ctx context.Context, instanceID string, volume spec.SharedVolume) error {
+	if err := a.WaitForRunning(ctx, instanceID, instanceRunningTimeout); err != nil {
+		return err
+	}
+	_, err := a.client.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		InstanceId: aws.String(instanceID),
+		VolumeId:   aws.String(volume.VolumeID),
+		Device:     aws.String(volume.GetDeviceName()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach volume %s: %w", volume.VolumeID, err)
+	}
+	return nil
+}
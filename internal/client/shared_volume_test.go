@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSharedVolume(t *testing.T) {
+	tests := []struct {
+		name            string
+		multiAttach     bool
+		primary         string
+		fallbackSubnets []string
+		subnet          string
+		fallback        []string
+		errString       string
+	}{
+		{
+			name:        "subnet in the zone of the volume",
+			multiAttach: true,
+			primary:     "subnet-a",
+			subnet:      "subnet-a",
+			fallback:    []string{},
+		},
+		{
+			name:            "subnets in other zones are skipped",
+			multiAttach:     true,
+			primary:         "subnet-b",
+			fallbackSubnets: []string{"subnet-a", "subnet-c", "subnet-a2"},
+			subnet:          "subnet-a",
+			fallback:        []string{"subnet-a2"},
+		},
+		{
+			name:        "multi-attach not enabled",
+			multiAttach: false,
+			primary:     "subnet-a",
+			errString:   "volume vol-0123456789abcdef0 does not have multi-attach enabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+			runnerSpec := &spec.RunnerSpec{
+				SubnetID:          tt.primary,
+				FallbackSubnetIDs: tt.fallbackSubnets,
+				SharedVolume:      &spec.SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"},
+			}
+			mockClient.On("DescribeVolumes", ctx, &ec2.DescribeVolumesInput{
+				VolumeIds: []string{"vol-0123456789abcdef0"},
+			}, mock.Anything).Return(&ec2.DescribeVolumesOutput{
+				Volumes: []types.Volume{
+					{
+						VolumeId:           aws.String("vol-0123456789abcdef0"),
+						AvailabilityZone:   aws.String("us-west-2a"),
+						MultiAttachEnabled: aws.Bool(tt.multiAttach),
+					},
+				},
+			}, nil)
+			mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+				Subnets: []types.Subnet{
+					{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-west-2a")},
+					{SubnetId: aws.String("subnet-a2"), AvailabilityZone: aws.String("us-west-2a")},
+					{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-west-2b")},
+					{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-west-2c")},
+				},
+			}, nil)
+
+			err := awsCli.checkSharedVolume(ctx, runnerSpec)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.subnet, runnerSpec.SubnetID)
+			require.Equal(t, tt.fallback, runnerSpec.FallbackSubnetIDs)
+		})
+	}
+}
+
+func TestCheckSharedVolumeNoSubnetInZone(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2"},
+		client: mockClient,
+	}
+	mockClient.On("DescribeVolumes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeVolumesOutput{
+		Volumes: []types.Volume{
+			{
+				VolumeId:           aws.String("vol-0123456789abcdef0"),
+				AvailabilityZone:   aws.String("us-west-2a"),
+				MultiAttachEnabled: aws.Bool(true),
+			},
+		},
+	}, nil)
+	mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []types.Subnet{
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-west-2b")},
+		},
+	}, nil)
+
+	err := awsCli.checkSharedVolume(ctx, &spec.RunnerSpec{
+		SubnetID:     "subnet-b",
+		SharedVolume: &spec.SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"},
+	})
+	require.EqualError(t, err, "none of the subnets is in availability zone us-west-2a of volume vol-0123456789abcdef0")
+}
+
+func TestCreateRunningInstanceWithSharedVolume(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-a",
+		},
+		client: mockClient,
+	}
+	instanceID := "i-1234567890abcdef0"
+	runnerSpec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-a",
+		SharedVolume: &spec.SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"},
+		ControllerID: "controllerID",
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("DescribeVolumes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeVolumesOutput{
+		Volumes: []types.Volume{
+			{
+				VolumeId:           aws.String("vol-0123456789abcdef0"),
+				AvailabilityZone:   aws.String("us-west-2a"),
+				MultiAttachEnabled: aws.Bool(true),
+			},
+		},
+	}, nil)
+	mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []types.Subnet{
+			{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-west-2a")},
+		},
+	}, nil)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String(instanceID),
+			},
+		},
+	}, nil)
+	// The waiter calls DescribeInstances with its own context.
+	mockClient.On("DescribeInstances", mock.Anything, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == instanceID
+	}), mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+						State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockClient.On("AttachVolume", ctx, &ec2.AttachVolumeInput{
+		InstanceId: aws.String(instanceID),
+		VolumeId:   aws.String("vol-0123456789abcdef0"),
+		Device:     aws.String("/dev/sdg"),
+	}, mock.Anything).Return(&ec2.AttachVolumeOutput{}, nil)
+
+	instance, err := awsCli.CreateRunningInstance(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, instanceID, instance)
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every provider process creates a single instance, so launches are spaced
+// out by having processes reserve time slots. A slot is reserved by creating
+// a file named after its start time in the slot directory, which only one
+// process can do. The files are hidden, so the slot directory can be the
+// state directory.
+const launchSlotPrefix = ".launch-slot-"
+
+// launchProgressInterval is how often a create waiting for its turn logs
+// that it is still waiting.
+const launchProgressInterval = 15 * time.Second
+
+// reserveLaunchSlot reserves the first free slot of the given length that
+// starts within maxDelay, and returns the time it starts as well as how many
+// slots were already taken before it. Slots that have passed are removed.
+func reserveLaunchSlot(dir string, spacing, maxDelay time.Duration, now time.Time) (time.Time, int, error) {
+	current := now.UnixNano() / int64(spacing)
+	removePastLaunchSlots(dir, current*int64(spacing))
+
+	for ahead := 0; time.Duration(ahead)*spacing <= maxDelay; ahead++ {
+		start := (current + int64(ahead)) * int64(spacing)
+		path := filepath.Join(dir, launchSlotPrefix+strconv.FormatInt(start, 10))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			return time.Time{}, 0, fmt.Errorf("failed to reserve launch slot: %w", err)
+		}
+		f.Close()
+		return time.Unix(0, start), ahead, nil
+	}
+	return time.Time{}, 0, fmt.Errorf("no launch slot is free within %s", maxDelay)
+}
+
+func removePastLaunchSlots(dir string, before int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), launchSlotPrefix)
+		if !ok {
+			continue
+		}
+		start, err := strconv.ParseInt(name, 10, 64)
+		if err != nil || start >= before {
+			continue
+		}
+		// Another process may have removed it already.
+		_ = os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// waitForLaunchSlot delays the launch of an instance as configured in
+// create_spreading, so that instances GARM creates in a burst don't all hit
+// the EC2 API, and its capacity, at the same time. If no slot can be
+// reserved, the instance is launched without waiting for one.
+func (a *AwsCli) waitForLaunchSlot(ctx context.Context, name string) error {
+	opts := a.cfg.CreateSpreading
+	now := time.Now()
+	launchAt := now
+
+	if spacing := opts.GetSpacing(); spacing > 0 {
+		start, ahead, err := reserveLaunchSlot(opts.SlotDir, spacing, opts.GetMaxDelay(), now)
+		if err != nil {
+			slog.WarnContext(ctx, "launching without waiting for its turn", "name", name, "error", err)
+		} else {
+			if ahead > 0 {
+				slog.DebugContext(ctx, "waiting for launches ahead", "name", name, "ahead", ahead)
+			}
+			launchAt = start
+		}
+	}
+	if jitter := opts.GetJitter(); jitter > 0 {
+		launchAt = launchAt.Add(rand.N(jitter))
+	}
+
+	delay := launchAt.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	slog.DebugContext(ctx, "delaying launch", "name", name, "delay", delay.Round(time.Millisecond))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	ticker := time.NewTicker(launchProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting to launch %s: %w", name, ctx.Err())
+		case <-ticker.C:
+			slog.DebugContext(ctx, "delaying launch", "name", name, "delay", time.Until(launchAt).Round(time.Second))
+		case <-timer.C:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveLaunchSlot(t *testing.T) {
+	dir := t.TempDir()
+	spacing := 2 * time.Second
+	now := time.Unix(1700000001, 0)
+
+	// A slot that has passed is cleaned up.
+	past := filepath.Join(dir, launchSlotPrefix+strconv.FormatInt(time.Unix(1699999990, 0).UnixNano(), 10))
+	require.NoError(t, os.WriteFile(past, nil, 0o600))
+
+	var starts []time.Time
+	for ahead := range 3 {
+		start, gotAhead, err := reserveLaunchSlot(dir, spacing, 4*time.Second, now)
+		require.NoError(t, err)
+		require.Equal(t, ahead, gotAhead)
+		starts = append(starts, start)
+	}
+	require.Equal(t, []time.Time{
+		time.Unix(1700000000, 0),
+		time.Unix(1700000002, 0),
+		time.Unix(1700000004, 0),
+	}, starts)
+	require.NoFileExists(t, past)
+
+	_, _, err := reserveLaunchSlot(dir, spacing, 4*time.Second, now)
+	require.ErrorContains(t, err, "no launch slot is free within 4s")
+}
+
+func TestWaitForLaunchSlot(t *testing.T) {
+	dir := t.TempDir()
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			CreateSpreading: config.CreateSpreading{
+				Spacing:  "1h",
+				SlotDir:  dir,
+				MaxDelay: "2h",
+			},
+		},
+	}
+
+	// The current slot is free, so the first create launches right away.
+	require.NoError(t, awsCli.waitForLaunchSlot(context.Background(), "first"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := awsCli.waitForLaunchSlot(ctx, "second")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// sshKeyBits is the size of the RSA keys generated for ephemeral key pairs.
+// RSA is used, rather than ED25519, as EC2 can only encrypt the password of
+// Windows instances with RSA keys.
+var sshKeyBits = 3072
+
+// appendSSHString appends data in the SSH wire format (RFC 4251), prefixed
+// with its length.
+func appendSSHString(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// sshMPInt returns n in the mpint format of RFC 4251, without the length.
+func sshMPInt(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// sshPublicKey returns the public key in the OpenSSH authorized_keys format.
+func sshPublicKey(key *rsa.PublicKey) string {
+	var b []byte
+	b = appendSSHString(b, []byte("ssh-rsa"))
+	b = appendSSHString(b, sshMPInt(big.NewInt(int64(key.E))))
+	b = appendSSHString(b, sshMPInt(key.N))
+	return "ssh-rsa " + base64.StdEncoding.EncodeToString(b)
+}
+
+// sshKeyFile returns the path the private key of an ephemeral key pair is
+// written to, or an empty string if private keys are not kept.
+func (a *AwsCli) sshKeyFile(keyName string) (string, error) {
+	if a.cfg.SSHKeyDir == "" {
+		return "", nil
+	}
+	if keyName == "" || keyName != filepath.Base(keyName) || keyName[0] == '.' {
+		return "", fmt.Errorf("invalid key pair name %q", keyName)
+	}
+	return filepath.Join(a.cfg.SSHKeyDir, keyName+".pem"), nil
+}
+
+// importEphemeralKeyPair generates a key pair for a single instance and
+// imports it into EC2 under the name of the instance. The private key is
+// written to the SSH key directory, if one is configured, and discarded
+// otherwise.
+func (a *AwsCli) importEphemeralKeyPair(ctx context.Context, spec *spec.RunnerSpec) (string, error) {
+	keyName := spec.BootstrapParams.Name
+	keyFile, err := a.sshKeyFile(keyName)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, sshKeyBits)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	if keyFile != "" {
+		privateKey := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+		if err := os.WriteFile(keyFile, privateKey, 0o600); err != nil {
+			return "", fmt.Errorf("failed to write private key: %w", err)
+		}
+	}
+
+	_, err = a.client.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(keyName),
+		PublicKeyMaterial: []byte(sshPublicKey(&key.PublicKey)),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeKeyPair,
+				Tags: []types.Tag{
+					{Key: aws.String("Name"), Value: aws.String(keyName)},
+					{Key: aws.String("GARM_POOL_ID"), Value: aws.String(spec.BootstrapParams.PoolID)},
+					{Key: aws.String("GARM_CONTROLLER_ID"), Value: aws.String(spec.ControllerID)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		if keyFile != "" {
+			os.Remove(keyFile)
+		}
+		return "", fmt.Errorf("failed to import key pair %s: %w", keyName, err)
+	}
+	return keyName, nil
+}
+
+// deleteKeyPair deletes the ephemeral key pair, and its private key. Errors
+// are logged, as they should never prevent an instance from being removed.
+func (a *AwsCli) deleteKeyPair(ctx context.Context, keyName string) {
+	if _, err := a.client.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{
+		KeyName: aws.String(keyName),
+	}); err != nil {
+		slog.WarnContext(ctx, "failed to delete key pair", "key_name", keyName, "error", err)
+	}
+
+	keyFile, err := a.sshKeyFile(keyName)
+	if err != nil || keyFile == "" {
+		return
+	}
+	if err := os.Remove(keyFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.WarnContext(ctx, "failed to remove private key", "key_name", keyName, "error", err)
+	}
+}
+
+// DeleteEphemeralKeyPair deletes the key pair that was generated for the
+// instance, if any. Call it once the instance is terminated.
+func (a *AwsCli) DeleteEphemeralKeyPair(ctx context.Context, instance types.Instance) {
+	for _, tag := range instance.Tags {
+		if aws.ToString(tag.Key) == util.EphemeralKeyTag && aws.ToString(tag.Value) != "" {
+			a.deleteKeyPair(ctx, aws.ToString(tag.Value))
+			return
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+// ssmReferencePrefix marks a config or extra specs value that should be read
+// from SSM Parameter Store. For example: ssm:/network/runners/subnet
+const ssmReferencePrefix = "ssm:"
+
+const (
+	// ssmRegistrationTimeout is how long to wait for the SSM agent of a new
+	// instance to register before giving up on running documents on it.
+	ssmRegistrationTimeout = 5 * time.Minute
+	// ssmCommandTimeout is how long to wait for a document to finish
+	// running on an instance.
+	ssmCommandTimeout = 10 * time.Minute
+)
+
+// ssmRetryInterval is the delay between attempts to send a command to an
+// instance that is not registered with SSM yet.
+var ssmRetryInterval = 10 * time.Second
+
+// ssmPollInterval is the delay between checks of a command that is still
+// running.
+var ssmPollInterval = 5 * time.Second
+
+type SSMClientInterface interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error)
+	GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error)
+}
+
+func isSSMReference(value string) bool {
+	return strings.HasPrefix(value, ssmReferencePrefix)
+}
+
+// ResolveSSMParameter returns the value of the SSM parameter referenced by
+// value. Values that are not SSM references are returned unchanged.
+func (a *AwsCli) ResolveSSMParameter(ctx context.Context, value string) (string, error) {
+	if !isSSMReference(value) {
+		return value, nil
+	}
+
+	if a.ssm == nil {
+		return "", fmt.Errorf("ssm client is not initialized")
+	}
+
+	name := strings.TrimPrefix(value, ssmReferencePrefix)
+	if name == "" {
+		return "", fmt.Errorf("invalid ssm reference %q", value)
+	}
+
+	resp, err := a.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get ssm parameter %s: %w", name, err)
+	}
+
+	if resp.Parameter == nil || resp.Parameter.Value == nil || *resp.Parameter.Value == "" {
+		return "", fmt.Errorf("ssm parameter %s is empty", name)
+	}
+
+	return *resp.Parameter.Value, nil
+}
+
+// resolveSSMReferences replaces any SSM references in the subnets, security
+// groups and image of the runner spec with their current values. Image
+// aliases are replaced with the image of the configured region first. Security
+// group parameters may be of type StringList, in which case every element
+// of the list is added to the spec.
+func (a *AwsCli) resolveSSMReferences(ctx context.Context, spec *spec.RunnerSpec) error {
+	subnetID, err := a.ResolveSSMParameter(ctx, spec.SubnetID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve subnet: %w", err)
+	}
+	spec.SubnetID = subnetID
+
+	var fallbackSubnetIDs []string
+	for _, val := range spec.FallbackSubnetIDs {
+		resolved, err := a.ResolveSSMParameter(ctx, val)
+		if err != nil {
+			return fmt.Errorf("failed to resolve fallback subnet: %w", err)
+		}
+		fallbackSubnetIDs = append(fallbackSubnetIDs, resolved)
+	}
+	spec.FallbackSubnetIDs = fallbackSubnetIDs
+
+	var securityGroupIDs []string
+	for _, val := range spec.SecurityGroupIDs {
+		resolved, err := a.ResolveSSMParameter(ctx, val)
+		if err != nil {
+			return fmt.Errorf("failed to resolve security group: %w", err)
+		}
+		for _, id := range strings.Split(resolved, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				securityGroupIDs = append(securityGroupIDs, id)
+			}
+		}
+	}
+	spec.SecurityGroupIDs = securityGroupIDs
+
+	image, err := a.cfg.ResolveImageAlias(spec.BootstrapParams.Image)
+	if err != nil {
+		return err
+	}
+	image = a.cfg.SubstituteImage(image)
+	image, err = a.resolveImage(ctx, spec.BootstrapParams.PoolID, image)
+	if err != nil {
+		return fmt.Errorf("failed to resolve image: %w", err)
+	}
+	spec.BootstrapParams.Image = image
+
+	return nil
+}
+
+// RunSSMDocuments runs the given documents on the instance, in order. Each
+// document is only sent once the previous one succeeded. The instance must
+// be running.
+func (a *AwsCli) RunSSMDocuments(ctx context.Context, instanceID string, documents []spec.SSMDocument) error {
+	if a.ssm == nil {
+		return fmt.Errorf("ssm client is not initialized")
+	}
+
+	for _, document := range documents {
+		commandID, err := a.sendCommand(ctx, instanceID, document)
+		if err != nil {
+			return fmt.Errorf("failed to run ssm document %s: %w", document.Name, err)
+		}
+		if err := a.waitForCommand(ctx, instanceID, commandID); err != nil {
+			return fmt.Errorf("failed to run ssm document %s: %w", document.Name, err)
+		}
+	}
+	return nil
+}
+
+// sendCommand sends the document to the instance, waiting for its SSM agent
+// to register first, and returns the ID of the command.
+func (a *AwsCli) sendCommand(ctx context.Context, instanceID string, document spec.SSMDocument) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ssmRegistrationTimeout)
+	defer cancel()
+
+	input := &ssm.SendCommandInput{
+		DocumentName: aws.String(document.Name),
+		InstanceIds:  []string{instanceID},
+		Parameters:   document.Parameters,
+		Comment:      aws.String("garm post-create"),
+	}
+
+	for {
+		resp, err := a.ssm.SendCommand(ctx, input)
+		if err == nil {
+			if resp.Command == nil || resp.Command.CommandId == nil {
+				return "", fmt.Errorf("ssm returned no command ID")
+			}
+			slog.InfoContext(ctx, "sent ssm document", "document", document.Name, "instance_id", instanceID, "command_id", *resp.Command.CommandId)
+			return *resp.Command.CommandId, nil
+		}
+
+		if !util.IsSSMInvalidInstanceErr(err) {
+			return "", err
+		}
+
+		// The SSM agent registers a little while after the instance is
+		// running.
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("instance did not register with ssm: %w", err)
+		case <-time.After(ssmRetryInterval):
+		}
+	}
+}
+
+// waitForCommand polls the invocation of the command on the instance until
+// it reaches a terminal state, and returns an error unless it succeeded.
+func (a *AwsCli) waitForCommand(ctx context.Context, instanceID, commandID string) error {
+	ctx, cancel := context.WithTimeout(ctx, ssmCommandTimeout)
+	defer cancel()
+
+	input := &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: aws.String(instanceID),
+	}
+	for {
+		resp, err := a.ssm.GetCommandInvocation(ctx, input)
+		if err == nil {
+			switch resp.Status {
+			case ssmTypes.CommandInvocationStatusSuccess:
+				slog.InfoContext(ctx, "ssm document finished", "instance_id", instanceID, "command_id", commandID)
+				return nil
+			case ssmTypes.CommandInvocationStatusCancelled,
+				ssmTypes.CommandInvocationStatusTimedOut,
+				ssmTypes.CommandInvocationStatusFailed:
+
+				return fmt.Errorf("command %s ended with status %s: %s", commandID, resp.Status, aws.ToString(resp.StatusDetails))
+			}
+		} else if !util.IsSSMInvocationDoesNotExistErr(err) {
+			// Invocations show up a little while after the command was
+			// sent.
+			return fmt.Errorf("failed to get invocation of command %s: %w", commandID, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("command %s did not finish: %w", commandID, ctx.Err())
+		case <-time.After(ssmPollInterval):
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func mockSSMParameter(m *MockSSMClient, ctx context.Context, name, value string) {
+	m.On("GetParameter", ctx, mock.MatchedBy(func(input *ssm.GetParameterInput) bool {
+		return *input.Name == name
+	}), mock.Anything).Return(&ssm.GetParameterOutput{
+		Parameter: &ssmTypes.Parameter{
+			Value: aws.String(value),
+		},
+	}, nil)
+}
+
+func TestResolveSSMParameter(t *testing.T) {
+	ctx := context.Background()
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{},
+		ssm: mockSSM,
+	}
+	mockSSMParameter(mockSSM, ctx, "/network/subnet", "subnet-0a0a0a0a0a0a0a0a0")
+	mockSSM.On("GetParameter", ctx, mock.MatchedBy(func(input *ssm.GetParameterInput) bool {
+		return *input.Name == "/missing"
+	}), mock.Anything).Return(&ssm.GetParameterOutput{}, fmt.Errorf("ParameterNotFound"))
+
+	tests := []struct {
+		name      string
+		value     string
+		expected  string
+		errString string
+	}{
+		{
+			name:     "plain value",
+			value:    "subnet-0b0b0b0b0b0b0b0b0",
+			expected: "subnet-0b0b0b0b0b0b0b0b0",
+		},
+		{
+			name:     "ssm reference",
+			value:    "ssm:/network/subnet",
+			expected: "subnet-0a0a0a0a0a0a0a0a0",
+		},
+		{
+			name:      "empty ssm reference",
+			value:     "ssm:",
+			errString: "invalid ssm reference \"ssm:\"",
+		},
+		{
+			name:      "missing parameter",
+			value:     "ssm:/missing",
+			errString: "failed to get ssm parameter /missing: ParameterNotFound",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := awsCli.ResolveSSMParameter(ctx, tt.value)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestResolveSSMReferences(t *testing.T) {
+	ctx := context.Background()
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{},
+		ssm: mockSSM,
+	}
+	mockSSMParameter(mockSSM, ctx, "/network/subnet", "subnet-0a0a0a0a0a0a0a0a0")
+	mockSSMParameter(mockSSM, ctx, "/network/sgs", "sg-0a0a0a0a0a0a0a0a0, sg-0b0b0b0b0b0b0b0b0")
+	mockSSMParameter(mockSSM, ctx, "/images/ubuntu", "ami-12345678")
+
+	mockSSMParameter(mockSSM, ctx, "/network/fallback", "subnet-0b0b0b0b0b0b0b0b0")
+
+	runnerSpec := &spec.RunnerSpec{
+		SubnetID:          "ssm:/network/subnet",
+		FallbackSubnetIDs: []string{"ssm:/network/fallback"},
+		SecurityGroupIDs:  []string{"sg-0c0c0c0c0c0c0c0c0", "ssm:/network/sgs"},
+		BootstrapParams: params.BootstrapInstance{
+			Image: "ssm:/images/ubuntu",
+		},
+	}
+
+	err := awsCli.resolveSSMReferences(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, "subnet-0a0a0a0a0a0a0a0a0", runnerSpec.SubnetID)
+	require.Equal(t, []string{"subnet-0b0b0b0b0b0b0b0b0"}, runnerSpec.FallbackSubnetIDs)
+	require.Equal(t, []string{"sg-0c0c0c0c0c0c0c0c0", "sg-0a0a0a0a0a0a0a0a0", "sg-0b0b0b0b0b0b0b0b0"}, runnerSpec.SecurityGroupIDs)
+	require.Equal(t, "ami-12345678", runnerSpec.BootstrapParams.Image)
+
+	mockSSM.AssertExpectations(t)
+}
+
+func TestResolveSSMReferencesImageAlias(t *testing.T) {
+	ctx := context.Background()
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+			ImageAliases: map[string]map[string]string{
+				"ubuntu-22.04": {"us-west-2": "ssm:/images/ubuntu"},
+				"ubuntu-24.04": {"us-east-1": "ami-12345678"},
+			},
+		},
+		ssm: mockSSM,
+	}
+	mockSSMParameter(mockSSM, ctx, "/images/ubuntu", "ami-87654321")
+
+	runnerSpec := &spec.RunnerSpec{
+		SubnetID: "subnet-0a0a0a0a0a0a0a0a0",
+		BootstrapParams: params.BootstrapInstance{
+			Image: "ubuntu-22.04",
+		},
+	}
+	err := awsCli.resolveSSMReferences(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, "ami-87654321", runnerSpec.BootstrapParams.Image)
+
+	runnerSpec.BootstrapParams.Image = "ubuntu-24.04"
+	err = awsCli.resolveSSMReferences(ctx, runnerSpec)
+	require.EqualError(t, err, "image alias ubuntu-24.04 has no image for region us-west-2")
+}
+
+func TestResolveSSMReferencesImageSubstitution(t *testing.T) {
+	ctx := context.Background()
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "cn-northwest-1",
+			ImageAliases: map[string]map[string]string{
+				"ubuntu-22.04": {"cn-northwest-1": "ssm:/aws/service/canonical/ubuntu"},
+			},
+			Substitutions: map[string]config.Substitutions{
+				"aws-cn": {
+					Images: []config.Substitution{{Prefix: "ssm:/aws/service/canonical/", Replacement: "ssm:/mirror/canonical/"}},
+				},
+			},
+		},
+		ssm: mockSSM,
+	}
+	mockSSMParameter(mockSSM, ctx, "/mirror/canonical/ubuntu", "ami-87654321")
+
+	runnerSpec := &spec.RunnerSpec{
+		SubnetID: "subnet-0a0a0a0a0a0a0a0a0",
+		BootstrapParams: params.BootstrapInstance{
+			Image: "ubuntu-22.04",
+		},
+	}
+	err := awsCli.resolveSSMReferences(ctx, runnerSpec)
+	require.NoError(t, err)
+	require.Equal(t, "ami-87654321", runnerSpec.BootstrapParams.Image)
+	mockSSM.AssertExpectations(t)
+}
+
+func TestRunSSMDocumentsWaitsForRegistration(t *testing.T) {
+	ctx := context.Background()
+	defer func(interval time.Duration) { ssmRetryInterval = interval }(ssmRetryInterval)
+	ssmRetryInterval = time.Millisecond
+	defer func(interval time.Duration) { ssmPollInterval = interval }(ssmPollInterval)
+	ssmPollInterval = time.Millisecond
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{},
+		ssm: mockSSM,
+	}
+	documents := []spec.SSMDocument{
+		{
+			Name:       "CIS-Hardening",
+			Parameters: map[string][]string{"level": {"1"}},
+		},
+	}
+	mockSSM.On("SendCommand", mock.Anything, mock.Anything, mock.Anything).Return(&ssm.SendCommandOutput{}, &smithy.GenericAPIError{
+		Code: "InvalidInstanceId",
+	}).Twice()
+	mockSSM.On("SendCommand", mock.Anything, mock.MatchedBy(func(input *ssm.SendCommandInput) bool {
+		return *input.DocumentName == "CIS-Hardening" && input.InstanceIds[0] == "i-1234567890abcdef0" && input.Parameters["level"][0] == "1"
+	}), mock.Anything).Return(&ssm.SendCommandOutput{
+		Command: &ssmTypes.Command{
+			CommandId: aws.String("command-id"),
+		},
+	}, nil).Once()
+	mockSSM.On("GetCommandInvocation", mock.Anything, mock.MatchedBy(func(input *ssm.GetCommandInvocationInput) bool {
+		return *input.CommandId == "command-id" && *input.InstanceId == "i-1234567890abcdef0"
+	}), mock.Anything).Return(&ssm.GetCommandInvocationOutput{
+		Status: ssmTypes.CommandInvocationStatusSuccess,
+	}, nil)
+
+	err := awsCli.RunSSMDocuments(ctx, "i-1234567890abcdef0", documents)
+	require.NoError(t, err)
+	mockSSM.AssertNumberOfCalls(t, "SendCommand", 3)
+}
+
+func TestRunSSMDocumentsWaitsForCommands(t *testing.T) {
+	defer func(interval time.Duration) { ssmPollInterval = interval }(ssmPollInterval)
+	ssmPollInterval = time.Millisecond
+
+	tests := []struct {
+		name      string
+		statuses  []ssmTypes.CommandInvocationStatus
+		sent      []string
+		errString string
+	}{
+		{
+			name:     "documents run in order",
+			statuses: []ssmTypes.CommandInvocationStatus{ssmTypes.CommandInvocationStatusInProgress, ssmTypes.CommandInvocationStatusSuccess},
+			sent:     []string{"CIS-Hardening", "Install-Agent"},
+		},
+		{
+			name:      "failed document stops the rest",
+			statuses:  []ssmTypes.CommandInvocationStatus{ssmTypes.CommandInvocationStatusFailed},
+			sent:      []string{"CIS-Hardening"},
+			errString: "failed to run ssm document CIS-Hardening: command CIS-Hardening-command ended with status Failed: script exited with 1",
+		},
+		{
+			name:      "timed out document",
+			statuses:  []ssmTypes.CommandInvocationStatus{ssmTypes.CommandInvocationStatusTimedOut},
+			sent:      []string{"CIS-Hardening"},
+			errString: "failed to run ssm document CIS-Hardening: command CIS-Hardening-command ended with status TimedOut: script exited with 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockSSM := new(MockSSMClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{},
+				ssm: mockSSM,
+			}
+			var sent []string
+			for _, name := range []string{"CIS-Hardening", "Install-Agent"} {
+				mockSSM.On("SendCommand", mock.Anything, mock.MatchedBy(func(input *ssm.SendCommandInput) bool {
+					return *input.DocumentName == name
+				}), mock.Anything).Run(func(mock.Arguments) {
+					sent = append(sent, name)
+				}).Return(&ssm.SendCommandOutput{
+					Command: &ssmTypes.Command{
+						CommandId: aws.String(name + "-command"),
+					},
+				}, nil)
+			}
+			// The invocation isn't known right after the command is sent.
+			mockSSM.On("GetCommandInvocation", mock.Anything, mock.Anything, mock.Anything).Return(&ssm.GetCommandInvocationOutput{}, &smithy.GenericAPIError{
+				Code: "InvocationDoesNotExist",
+			}).Once()
+			for _, status := range tt.statuses {
+				mockSSM.On("GetCommandInvocation", mock.Anything, mock.Anything, mock.Anything).Return(&ssm.GetCommandInvocationOutput{
+					Status:        status,
+					StatusDetails: aws.String("script exited with 1"),
+				}, nil).Once()
+			}
+			// Commands sent after the first one succeed right away.
+			mockSSM.On("GetCommandInvocation", mock.Anything, mock.Anything, mock.Anything).Return(&ssm.GetCommandInvocationOutput{
+				Status: ssmTypes.CommandInvocationStatusSuccess,
+			}, nil)
+
+			err := awsCli.RunSSMDocuments(ctx, "i-1234567890abcdef0", []spec.SSMDocument{{Name: "CIS-Hardening"}, {Name: "Install-Agent"}})
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.sent, sent)
+		})
+	}
+}
+
+func TestRunSSMDocumentsError(t *testing.T) {
+	ctx := context.Background()
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{},
+		ssm: mockSSM,
+	}
+	mockSSM.On("SendCommand", mock.Anything, mock.Anything, mock.Anything).Return(&ssm.SendCommandOutput{}, &smithy.GenericAPIError{
+		Code: "InvalidDocument",
+	})
+
+	err := awsCli.RunSSMDocuments(ctx, "i-1234567890abcdef0", []spec.SSMDocument{{Name: "missing"}})
+	require.ErrorContains(t, err, "failed to run ssm document missing")
+	mockSSM.AssertNumberOfCalls(t, "SendCommand", 1)
+}
+
+func TestCreateRunningInstanceMarksFailedSSMDocuments(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	mockSSM := new(MockSSMClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:   "us-west-2",
+			SubnetID: "subnet-1234567890abcdef0",
+		},
+		client: mockClient,
+		ssm:    mockSSM,
+	}
+	runnerSpec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		SSMDocuments: []spec.SSMDocument{{Name: "CIS-Hardening"}},
+	}
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String("i-1234567890abcdef0"),
+			},
+		},
+	}, nil)
+	// The waiter calls DescribeInstances with its own context.
+	mockClient.On("DescribeInstances", mock.Anything, mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == "i-1234567890abcdef0"
+	}), mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String("i-1234567890abcdef0"),
+						State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockSSM.On("SendCommand", mock.Anything, mock.Anything, mock.Anything).Return(&ssm.SendCommandOutput{}, &smithy.GenericAPIError{
+		Code: "InvalidDocument",
+	})
+	// The instance is tagged with a context of its own.
+	mockClient.On("CreateTags", mock.Anything, mock.MatchedBy(func(input *ec2.CreateTagsInput) bool {
+		return input.Resources[0] == "i-1234567890abcdef0" &&
+			*input.Tags[0].Key == "garm:bootstrap" && *input.Tags[0].Value == "failed"
+	}), mock.Anything).Return(&ec2.CreateTagsOutput{}, nil)
+
+	instanceID, err := awsCli.CreateRunningInstance(ctx, runnerSpec)
+	require.ErrorContains(t, err, "failed to run ssm documents on i-1234567890abcdef0")
+	require.Equal(t, "i-1234567890abcdef0", instanceID)
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,268 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// The state directory holds one file per instance, named after the instance
+// and holding its ID. Keeping instances in separate files means concurrent
+// provider processes never have to coordinate writes.
+
+func (a *AwsCli) stateFile(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid instance name %q", name)
+	}
+	return filepath.Join(a.cfg.StateDir, name), nil
+}
+
+// rememberInstance records the ID of a newly created instance.
+func (a *AwsCli) rememberInstance(name, instanceID string) error {
+	path, err := a.stateFile(name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(a.cfg.StateDir, ".instance-*")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(instanceID); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// recalledInstance returns the ID recorded for the instance, if any.
+func (a *AwsCli) recalledInstance(name string) (string, bool, error) {
+	path, err := a.stateFile(name)
+	if err != nil {
+		return "", false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// forgetInstance removes the record of the instance with the given ID.
+func (a *AwsCli) forgetInstance(instanceID string) error {
+	entries, err := os.ReadDir(a.cfg.StateDir)
+	if err != nil {
+		return fmt.Errorf("failed to read state dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(a.cfg.StateDir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil || strings.TrimSpace(string(data)) != instanceID {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove state file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Failed creates are recorded in hidden files in the state directory, named
+// after the time of the failure, so that they can be reported by the status
+// command. Only the most recent maxCreateFailures are kept.
+const (
+	createFailurePrefix = ".create-failure-"
+	maxCreateFailures   = 50
+)
+
+// CreateFailure is the record of a failed create.
+type CreateFailure struct {
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	PoolID string    `json:"pool_id,omitempty"`
+	Flavor string    `json:"flavor,omitempty"`
+	Error  string    `json:"error"`
+}
+
+// RecordCreateFailure records the reason the instance could not be created
+// in the state directory, if one is configured. Failing to record it is only
+// logged.
+func (a *AwsCli) RecordCreateFailure(bootstrapParams params.BootstrapInstance, createErr error) {
+	if a.cfg.StateDir == "" || createErr == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	data, err := json.Marshal(CreateFailure{
+		Time:   now,
+		Name:   bootstrapParams.Name,
+		PoolID: bootstrapParams.PoolID,
+		Flavor: bootstrapParams.Flavor,
+		Error:  createErr.Error(),
+	})
+	if err != nil {
+		slog.Warn("failed to record create failure", "error", err)
+		return
+	}
+	f, err := os.CreateTemp(a.cfg.StateDir, createFailurePrefix+strconv.FormatInt(now.UnixNano(), 10)+"-*")
+	if err != nil {
+		slog.Warn("failed to record create failure", "error", err)
+		return
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		slog.Warn("failed to record create failure", "error", err)
+		os.Remove(f.Name())
+		return
+	}
+	a.pruneCreateFailures()
+}
+
+// createFailureFiles returns the paths of the create failure records, most
+// recent first.
+func (a *AwsCli) createFailureFiles() ([]string, error) {
+	entries, err := os.ReadDir(a.cfg.StateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state dir: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), createFailurePrefix) {
+			paths = append(paths, filepath.Join(a.cfg.StateDir, entry.Name()))
+		}
+	}
+	// Names start with the time in nanoseconds, which has the same number
+	// of digits for the foreseeable future.
+	slices.Sort(paths)
+	slices.Reverse(paths)
+	return paths, nil
+}
+
+// pruneCreateFailures removes all but the most recent create failure
+// records.
+func (a *AwsCli) pruneCreateFailures() {
+	paths, err := a.createFailureFiles()
+	if err != nil || len(paths) <= maxCreateFailures {
+		return
+	}
+	for _, path := range paths[maxCreateFailures:] {
+		// Another process may have removed it already.
+		os.Remove(path)
+	}
+}
+
+// CreateFailures returns the recorded create failures, most recent first.
+// It returns nothing if no state directory is configured.
+func (a *AwsCli) CreateFailures() ([]CreateFailure, error) {
+	if a.cfg.StateDir == "" {
+		return nil, nil
+	}
+
+	paths, err := a.createFailureFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []CreateFailure
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read create failure: %w", err)
+		}
+		var failure CreateFailure
+		if err := json.Unmarshal(data, &failure); err != nil {
+			// Still being written.
+			continue
+		}
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+// usesStateDir returns true if instances created by the provider need to be
+// recorded in the state directory.
+func (a *AwsCli) usesStateDir() bool {
+	resolution := a.cfg.GetNameResolution()
+	return resolution == config.NameResolutionStateFile || resolution == config.NameResolutionStrict
+}
+
+// findInstancesByName returns the instances the name resolves to, using the
+// configured name resolution strategy.
+func (a *AwsCli) findInstancesByName(ctx context.Context, controllerID, instanceName string) ([]types.Instance, error) {
+	resolution := a.cfg.GetNameResolution()
+	switch resolution {
+	case config.NameResolutionStateFile, config.NameResolutionStrict:
+		instanceID, ok, err := a.recalledInstance(instanceName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			instance, err := a.GetInstance(ctx, instanceID)
+			if err != nil {
+				if errors.Is(err, garmErrors.ErrNotFound) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return []types.Instance{instance}, nil
+		}
+		if resolution == config.NameResolutionStrict {
+			return nil, nil
+		}
+	case config.NameResolutionTags:
+		controllerID = ""
+	}
+	return a.FindInstances(ctx, controllerID, instanceName)
+}
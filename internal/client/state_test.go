@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateDir(t *testing.T) {
+	awsCli := &AwsCli{
+		cfg: &config.Config{StateDir: t.TempDir()},
+	}
+
+	require.NoError(t, awsCli.rememberInstance("garm-runner-1", "i-0a0a0a0a0a0a0a0a0"))
+	require.NoError(t, awsCli.rememberInstance("garm-runner-2", "i-0b0b0b0b0b0b0b0b0"))
+
+	instanceID, ok, err := awsCli.recalledInstance("garm-runner-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "i-0a0a0a0a0a0a0a0a0", instanceID)
+
+	require.NoError(t, awsCli.forgetInstance("i-0a0a0a0a0a0a0a0a0"))
+	_, ok, err = awsCli.recalledInstance("garm-runner-1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = awsCli.recalledInstance("garm-runner-2")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, _, err = awsCli.recalledInstance("../garm-runner-2")
+	require.EqualError(t, err, "invalid instance name \"../garm-runner-2\"")
+}
+
+func TestCreateFailures(t *testing.T) {
+	awsCli := &AwsCli{
+		cfg: &config.Config{StateDir: t.TempDir()},
+	}
+
+	for i := range maxCreateFailures + 2 {
+		awsCli.RecordCreateFailure(params.BootstrapInstance{
+			Name:   fmt.Sprintf("garm-runner-%d", i),
+			PoolID: "pool-id",
+			Flavor: "t3.micro",
+		}, fmt.Errorf("failed to create instance: InsufficientInstanceCapacity"))
+	}
+	// Instance IDs recorded in the same directory are left alone.
+	require.NoError(t, awsCli.rememberInstance("garm-runner-0", "i-0a0a0a0a0a0a0a0a0"))
+
+	failures, err := awsCli.CreateFailures()
+	require.NoError(t, err)
+	require.Len(t, failures, maxCreateFailures)
+	require.Equal(t, fmt.Sprintf("garm-runner-%d", maxCreateFailures+1), failures[0].Name)
+	require.Equal(t, "pool-id", failures[0].PoolID)
+	require.Equal(t, "t3.micro", failures[0].Flavor)
+	require.Equal(t, "failed to create instance: InsufficientInstanceCapacity", failures[0].Error)
+	require.Equal(t, "garm-runner-2", failures[len(failures)-1].Name)
+
+	_, ok, err := awsCli.recalledInstance("garm-runner-0")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	awsCli.cfg.StateDir = ""
+	awsCli.RecordCreateFailure(params.BootstrapInstance{Name: "garm-runner-0"}, fmt.Errorf("failed"))
+	failures, err = awsCli.CreateFailures()
+	require.NoError(t, err)
+	require.Empty(t, failures)
+}
+
+func nameLookupOutput(instanceID string) *ec2.DescribeInstancesOutput {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{InstanceId: aws.String(instanceID)},
+				},
+			},
+		},
+	}
+}
+
+func byNameTag(controllerScoped bool) interface{} {
+	return mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		if len(input.InstanceIds) > 0 {
+			return false
+		}
+		hasController := false
+		for _, filter := range input.Filters {
+			if aws.ToString(filter.Name) == "tag:GARM_CONTROLLER_ID" {
+				hasController = true
+			}
+		}
+		return hasController == controllerScoped
+	})
+}
+
+func byID(instanceID string) interface{} {
+	return mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) == 1 && input.InstanceIds[0] == instanceID
+	})
+}
+
+func TestFindOneInstanceNameResolution(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution config.NameResolution
+		recorded   bool
+		expected   string
+		notFound   bool
+	}{
+		{
+			name:     "controller scoped tags by default",
+			expected: "i-0c0c0c0c0c0c0c0c0",
+		},
+		{
+			name:       "tags only",
+			resolution: config.NameResolutionTags,
+			expected:   "i-0d0d0d0d0d0d0d0d0",
+		},
+		{
+			name:       "state file first",
+			resolution: config.NameResolutionStateFile,
+			recorded:   true,
+			expected:   "i-0a0a0a0a0a0a0a0a0",
+		},
+		{
+			name:       "state file falls back to tags",
+			resolution: config.NameResolutionStateFile,
+			expected:   "i-0c0c0c0c0c0c0c0c0",
+		},
+		{
+			name:       "strict",
+			resolution: config.NameResolutionStrict,
+			recorded:   true,
+			expected:   "i-0a0a0a0a0a0a0a0a0",
+		},
+		{
+			name:       "strict never uses tags",
+			resolution: config.NameResolutionStrict,
+			notFound:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					NameResolution: tt.resolution,
+					StateDir:       t.TempDir(),
+				},
+				client: mockClient,
+			}
+			if tt.recorded {
+				require.NoError(t, awsCli.rememberInstance("garm-runner", "i-0a0a0a0a0a0a0a0a0"))
+			}
+
+			mockClient.On("DescribeInstances", ctx, byID("i-0a0a0a0a0a0a0a0a0"), mock.Anything).Return(nameLookupOutput("i-0a0a0a0a0a0a0a0a0"), nil)
+			mockClient.On("DescribeInstances", ctx, byNameTag(true), mock.Anything).Return(nameLookupOutput("i-0c0c0c0c0c0c0c0c0"), nil)
+			mockClient.On("DescribeInstances", ctx, byNameTag(false), mock.Anything).Return(nameLookupOutput("i-0d0d0d0d0d0d0d0d0"), nil)
+
+			instance, err := awsCli.FindOneInstance(ctx, "controller-id", "garm-runner")
+			if tt.notFound {
+				require.ErrorIs(t, err, garmErrors.ErrNotFound)
+				mockClient.AssertNotCalled(t, "DescribeInstances", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, aws.ToString(instance.InstanceId))
+		})
+	}
+}
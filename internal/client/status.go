@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// FleetStatus summarizes the instances of a controller, as the provider
+// sees them.
+type FleetStatus struct {
+	GeneratedAt  time.Time    `json:"generated_at"`
+	ControllerID string       `json:"controller_id"`
+	Region       string       `json:"region"`
+	Total        int          `json:"total_instances"`
+	Pools        []PoolStatus `json:"pools"`
+	// RecentCreateFailures are the most recent creates that failed, most
+	// recent first. They are only recorded if a state directory is
+	// configured.
+	RecentCreateFailures []CreateFailure `json:"recent_create_failures,omitempty"`
+}
+
+type PoolStatus struct {
+	PoolID string `json:"pool_id"`
+	Total  int    `json:"total_instances"`
+	// States maps instance states to the number of instances in them.
+	States   map[string]int `json:"states"`
+	Spot     int            `json:"spot"`
+	OnDemand int            `json:"on_demand"`
+	// AvailabilityZones maps availability zones to the number of instances
+	// in them.
+	AvailabilityZones map[string]int `json:"availability_zones"`
+	OldestLaunchTime  *time.Time     `json:"oldest_launch_time,omitempty"`
+	OldestAge         string         `json:"oldest_age,omitempty"`
+}
+
+// GetFleetStatus summarizes the instances of the controller per pool, along
+// with up to maxFailures of the most recent create failures.
+func (a *AwsCli) GetFleetStatus(ctx context.Context, controllerID string, maxFailures int) (*FleetStatus, error) {
+	instances, err := a.ListControllerInstances(ctx, controllerID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &FleetStatus{
+		GeneratedAt:  time.Now().UTC(),
+		ControllerID: controllerID,
+		Region:       a.cfg.Region,
+		Total:        len(instances),
+	}
+
+	pools := map[string]*PoolStatus{}
+	for _, instance := range instances {
+		var poolID string
+		for _, tag := range instance.Tags {
+			if aws.ToString(tag.Key) == "GARM_POOL_ID" {
+				poolID = aws.ToString(tag.Value)
+			}
+		}
+		pool, ok := pools[poolID]
+		if !ok {
+			pool = &PoolStatus{
+				PoolID:            poolID,
+				States:            map[string]int{},
+				AvailabilityZones: map[string]int{},
+			}
+			pools[poolID] = pool
+		}
+
+		pool.Total++
+		if instance.State != nil {
+			pool.States[string(instance.State.Name)]++
+		}
+		if instance.InstanceLifecycle == types.InstanceLifecycleTypeSpot {
+			pool.Spot++
+		} else {
+			pool.OnDemand++
+		}
+		if instance.Placement != nil && instance.Placement.AvailabilityZone != nil {
+			pool.AvailabilityZones[*instance.Placement.AvailabilityZone]++
+		}
+		if launched := instance.LaunchTime; launched != nil && (pool.OldestLaunchTime == nil || launched.Before(*pool.OldestLaunchTime)) {
+			pool.OldestLaunchTime = aws.Time(launched.UTC())
+		}
+	}
+
+	for _, pool := range pools {
+		if pool.OldestLaunchTime != nil {
+			pool.OldestAge = status.GeneratedAt.Sub(*pool.OldestLaunchTime).Round(time.Second).String()
+		}
+		status.Pools = append(status.Pools, *pool)
+	}
+	slices.SortFunc(status.Pools, func(a, b PoolStatus) int {
+		return strings.Compare(a.PoolID, b.PoolID)
+	})
+
+	failures, err := a.CreateFailures()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read create failures: %w", err)
+	}
+	status.RecentCreateFailures = failures[:min(len(failures), maxFailures)]
+
+	return status, nil
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFleetStatus(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-west-2", StateDir: t.TempDir()},
+		client: mockClient,
+	}
+	awsCli.RecordCreateFailure(params.BootstrapInstance{Name: "garm-runner-1"}, fmt.Errorf("first"))
+	awsCli.RecordCreateFailure(params.BootstrapInstance{Name: "garm-runner-2"}, fmt.Errorf("second"))
+
+	oldest := time.Now().Add(-3 * time.Hour).UTC().Truncate(time.Second)
+	instance := func(poolID string, state types.InstanceStateName, lifecycle types.InstanceLifecycleType, zone string, launched time.Time) types.Instance {
+		return types.Instance{
+			InstanceId:        aws.String("i-1234567890abcdef0"),
+			State:             &types.InstanceState{Name: state},
+			InstanceLifecycle: lifecycle,
+			Placement:         &types.Placement{AvailabilityZone: aws.String(zone)},
+			LaunchTime:        aws.Time(launched),
+			Tags:              []types.Tag{{Key: aws.String("GARM_POOL_ID"), Value: aws.String(poolID)}},
+		}
+	}
+	mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					instance("pool-b", types.InstanceStateNameRunning, "", "us-west-2a", time.Now()),
+					instance("pool-a", types.InstanceStateNameRunning, types.InstanceLifecycleTypeSpot, "us-west-2a", time.Now().Add(-time.Hour)),
+					instance("pool-a", types.InstanceStateNameStopped, "", "us-west-2b", oldest),
+				},
+			},
+		},
+	}, nil)
+
+	status, err := awsCli.GetFleetStatus(ctx, "controller_id", 1)
+	require.NoError(t, err)
+	require.Equal(t, "controller_id", status.ControllerID)
+	require.Equal(t, 3, status.Total)
+	require.Len(t, status.Pools, 2)
+
+	poolA := status.Pools[0]
+	require.Equal(t, "pool-a", poolA.PoolID)
+	require.Equal(t, 2, poolA.Total)
+	require.Equal(t, map[string]int{"running": 1, "stopped": 1}, poolA.States)
+	require.Equal(t, 1, poolA.Spot)
+	require.Equal(t, 1, poolA.OnDemand)
+	require.Equal(t, map[string]int{"us-west-2a": 1, "us-west-2b": 1}, poolA.AvailabilityZones)
+	require.Equal(t, oldest, *poolA.OldestLaunchTime)
+	require.Equal(t, "pool-b", status.Pools[1].PoolID)
+
+	require.Len(t, status.RecentCreateFailures, 1)
+	require.Equal(t, "second", status.RecentCreateFailures[0].Error)
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// launchTaggedResources are the resources RunInstances creates for a runner.
+// Tagging all of them in the launch request, instead of calling CreateTags
+// afterwards, lets accounts enforce tag based IAM conditions, like
+// aws:RequestTag, on RunInstances.
+var launchTaggedResources = []types.ResourceType{
+	types.ResourceTypeInstance,
+	types.ResourceTypeVolume,
+	types.ResourceTypeNetworkInterface,
+}
+
+// launchTagSpecifications applies the tags to every resource created by
+// RunInstances.
+func launchTagSpecifications(tags []types.Tag) []types.TagSpecification {
+	specs := make([]types.TagSpecification, 0, len(launchTaggedResources))
+	for _, resourceType := range launchTaggedResources {
+		specs = append(specs, types.TagSpecification{
+			ResourceType: resourceType,
+			Tags:         tags,
+		})
+	}
+	return specs
+}
+
+// configTags returns the tags of the config, in the order of their keys.
+func configTags(tags map[string]string) []types.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	result := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(tags[key]),
+		})
+	}
+	return result
+}
+
+// checkRequiredTags makes sure all required tag keys are set, so a launch
+// that IAM would reject fails with a clear error instead of an
+// UnauthorizedOperation.
+func checkRequiredTags(tags []types.Tag, required []string) error {
+	var missing []string
+	for _, key := range required {
+		if !slices.ContainsFunc(tags, func(tag types.Tag) bool {
+			return aws.ToString(tag.Key) == key
+		}) {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required tags: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
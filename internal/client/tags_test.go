@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRequiredTags(t *testing.T) {
+	tags := []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("instance-name")},
+		{Key: aws.String("GARM_POOL_ID"), Value: aws.String("poolID")},
+	}
+
+	tests := []struct {
+		name      string
+		required  []string
+		errString string
+	}{
+		{
+			name: "nothing required",
+		},
+		{
+			name:     "all present",
+			required: []string{"Name", "GARM_POOL_ID"},
+		},
+		{
+			name:      "missing tags",
+			required:  []string{"Name", "CostCenter", "Team"},
+			errString: "missing required tags: CostCenter, Team",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRequiredTags(tags, tt.required)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func tagsRunnerSpec() *spec.RunnerSpec {
+	return &spec.RunnerSpec{
+		Region: "us-west-2",
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+			PoolID: "poolID",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		ControllerID: "controllerID",
+	}
+}
+
+func TestCreateRunningInstanceTagsAllResources(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:              "us-west-2",
+			SubnetID:            "subnet-1234567890abcdef0",
+			RequiredRequestTags: []string{"GARM_CONTROLLER_ID"},
+		},
+		client: mockClient,
+	}
+
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		var resourceTypes []types.ResourceType
+		for _, tagSpec := range input.TagSpecifications {
+			if len(tagSpec.Tags) != len(input.TagSpecifications[0].Tags) {
+				return false
+			}
+			resourceTypes = append(resourceTypes, tagSpec.ResourceType)
+		}
+		return assert.ObjectsAreEqual([]types.ResourceType{
+			types.ResourceTypeInstance,
+			types.ResourceTypeVolume,
+			types.ResourceTypeNetworkInterface,
+		}, resourceTypes)
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{InstanceId: aws.String("i-1234567890abcdef0")},
+		},
+	}, nil)
+
+	instanceID, err := awsCli.CreateRunningInstance(ctx, tagsRunnerSpec())
+	require.NoError(t, err)
+	require.Equal(t, "i-1234567890abcdef0", instanceID)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateRunningInstanceMissingRequiredTags(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:              "us-west-2",
+			SubnetID:            "subnet-1234567890abcdef0",
+			RequiredRequestTags: []string{"CostCenter"},
+		},
+		client: mockClient,
+	}
+
+	mockCreateLookups(mockClient)
+
+	_, err := awsCli.CreateRunningInstance(ctx, tagsRunnerSpec())
+	require.EqualError(t, err, "missing required tags: CostCenter")
+	mockClient.AssertNotCalled(t, "RunInstances", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateRunningInstanceConfigTags(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:              "us-west-2",
+			SubnetID:            "subnet-1234567890abcdef0",
+			RequiredRequestTags: []string{"CostCenter"},
+			Tags: map[string]string{
+				"Team":       "ci",
+				"CostCenter": "1234",
+			},
+		},
+		client: mockClient,
+	}
+
+	mockCreateLookups(mockClient)
+	mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		for _, tagSpec := range input.TagSpecifications {
+			tags := map[string]string{}
+			for _, tag := range tagSpec.Tags {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			if tags["CostCenter"] != "1234" || tags["Team"] != "ci" || tags["GARM_POOL_ID"] != "poolID" {
+				return false
+			}
+		}
+		return true
+	}), mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{InstanceId: aws.String("i-1234567890abcdef0")},
+		},
+	}, nil)
+
+	instanceID, err := awsCli.CreateRunningInstance(ctx, tagsRunnerSpec())
+	require.NoError(t, err)
+	require.Equal(t, "i-1234567890abcdef0", instanceID)
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	// terminateBatchSize is the number of instances terminated by a single
+	// TerminateInstances call. EC2 accepts up to 1000, but recommends
+	// smaller batches.
+	terminateBatchSize = 50
+	// terminateConcurrency is the number of TerminateInstances calls in
+	// flight at once.
+	terminateConcurrency = 4
+)
+
+type terminateResult struct {
+	batch []types.Instance
+	resp  *ec2.TerminateInstancesOutput
+	err   error
+}
+
+// TerminateInstances terminates the instances in batches, a few batches at a
+// time, and returns the instances that were terminated. EC2 refuses a batch
+// as a whole if any of its instances can't be terminated, for example because
+// it is gone or protected, so the instances of refused batches are terminated
+// one at a time.
+//
+// The reason is only used for the audit log.
+func (a *AwsCli) TerminateInstances(ctx context.Context, instances []types.Instance, reason string) ([]types.Instance, error) {
+	var batches [][]types.Instance
+	for start := 0; start < len(instances); start += terminateBatchSize {
+		batches = append(batches, instances[start:min(start+terminateBatchSize, len(instances))])
+	}
+
+	jobs := make(chan []types.Instance)
+	results := make(chan terminateResult)
+	var wg sync.WaitGroup
+	for range min(terminateConcurrency, len(batches)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				ids := make([]string, 0, len(batch))
+				for _, instance := range batch {
+					ids = append(ids, aws.ToString(instance.InstanceId))
+				}
+				resp, err := a.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+					InstanceIds: ids,
+				})
+				results <- terminateResult{batch: batch, resp: resp, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, batch := range batches {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- batch:
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// The audit log, webhook and state dir are only ever updated from
+	// here, one instance at a time.
+	var terminated []types.Instance
+	var errs []error
+	for result := range results {
+		if result.err != nil {
+			for _, instance := range result.batch {
+				instanceID := aws.ToString(instance.InstanceId)
+				if err := a.TerminateInstance(ctx, instanceID, reason); err != nil {
+					errs = append(errs, fmt.Errorf("failed to remove instance %s: %w", instanceID, err))
+					continue
+				}
+				terminated = append(terminated, instance)
+			}
+			continue
+		}
+
+		for _, instance := range result.batch {
+			instanceID := aws.ToString(instance.InstanceId)
+			a.audit(ctx, "terminate", instanceID, reason, nil)
+			a.instanceTerminated(ctx, instance, wasTerminating(result.resp, instanceID))
+			terminated = append(terminated, instance)
+		}
+	}
+
+	if len(terminated)+len(errs) < len(instances) && ctx.Err() != nil {
+		errs = append(errs, fmt.Errorf("failed to terminate all instances: %w", ctx.Err()))
+	}
+	return terminated, errors.Join(errs...)
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminateInstancesInBatches(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-east-1"},
+		client: mockClient,
+	}
+
+	var instances []types.Instance
+	for i := range 120 {
+		instances = append(instances, types.Instance{InstanceId: aws.String(fmt.Sprintf("i-%017d", i))})
+	}
+	mockClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	terminated, err := awsCli.TerminateInstances(ctx, instances, "test reason")
+	require.NoError(t, err)
+	require.ElementsMatch(t, instances, terminated)
+
+	var sizes []int
+	for _, call := range mockClient.Calls {
+		sizes = append(sizes, len(call.Arguments.Get(1).(*ec2.TerminateInstancesInput).InstanceIds))
+	}
+	require.ElementsMatch(t, []int{50, 50, 20}, sizes)
+}
+
+func TestTerminateInstancesRefusedBatch(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg:    &config.Config{Region: "us-east-1"},
+		client: mockClient,
+	}
+
+	instances := []types.Instance{
+		{InstanceId: aws.String("i-00000000000000001")},
+		{InstanceId: aws.String("i-00000000000000002")},
+		{InstanceId: aws.String("i-00000000000000003")},
+	}
+	single := func(instanceID string) *ec2.TerminateInstancesInput {
+		return &ec2.TerminateInstancesInput{InstanceIds: []string{instanceID}}
+	}
+	protectedErr := &smithy.GenericAPIError{Code: "OperationNotPermitted"}
+	mockClient.On("TerminateInstances", ctx, mock.MatchedBy(func(input *ec2.TerminateInstancesInput) bool {
+		return len(input.InstanceIds) == 3
+	}), mock.Anything).Return((*ec2.TerminateInstancesOutput)(nil), protectedErr)
+	mockClient.On("TerminateInstances", ctx, single("i-00000000000000001"), mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+	mockClient.On("TerminateInstances", ctx, single("i-00000000000000002"), mock.Anything).Return((*ec2.TerminateInstancesOutput)(nil), &smithy.GenericAPIError{
+		Code: "InvalidInstanceID.NotFound",
+	})
+	mockClient.On("TerminateInstances", ctx, single("i-00000000000000003"), mock.Anything).Return((*ec2.TerminateInstancesOutput)(nil), protectedErr)
+
+	terminated, err := awsCli.TerminateInstances(ctx, instances, "test reason")
+	require.ErrorContains(t, err, "failed to remove instance i-00000000000000003")
+	require.Equal(t, instances[:2], terminated)
+}
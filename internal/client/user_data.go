@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+)
+
+type S3ClientInterface interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// userDataObjectURL returns the S3 URL of an object in the user data bucket.
+func (a *AwsCli) userDataObjectURL(key string) string {
+	return fmt.Sprintf("s3://%s/%s", a.cfg.UserDataOffload.Bucket, key)
+}
+
+// composeUserData returns the user data to launch the instance with. If the
+// user data is too large for EC2 and user data offloading is configured,
+// it is uploaded to S3 and replaced by a script that downloads it. The S3
+// URL of the uploaded object is returned along with it.
+func (a *AwsCli) composeUserData(ctx context.Context, runnerSpec *spec.RunnerSpec) (string, string, error) {
+	udata, err := runnerSpec.ComposeUserData()
+	if err == nil || !errors.Is(err, spec.ErrUserDataTooLarge) || !a.cfg.UserDataOffload.Enabled() {
+		return udata, "", err
+	}
+
+	payload, err := runnerSpec.UserDataPayload()
+	if err != nil {
+		return "", "", err
+	}
+
+	// Instance names are unique within a controller.
+	key := fmt.Sprintf("%s%s/%s", a.cfg.UserDataOffload.Prefix, runnerSpec.ControllerID, runnerSpec.BootstrapParams.Name)
+	udata, err = runnerSpec.ComposeOffloadedUserData(a.cfg.Region, a.cfg.UserDataOffload.Bucket, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	object := a.userDataObjectURL(key)
+	// The user data holds the credentials the runner registers with, so
+	// it is always encrypted at rest.
+	if _, err := a.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(a.cfg.UserDataOffload.Bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(payload),
+		ContentLength:        aws.Int64(int64(len(payload))),
+		ServerSideEncryption: s3Types.ServerSideEncryptionAes256,
+	}); err != nil {
+		return "", "", fmt.Errorf("failed to upload user data to %s: %w", object, err)
+	}
+	slog.InfoContext(ctx, "offloaded user data", "name", runnerSpec.BootstrapParams.Name, "bytes", len(payload), "object", object)
+	return udata, object, nil
+}
+
+// deleteUserDataObject deletes the offloaded user data at the given S3 URL.
+// Errors are logged, as they should never prevent an instance from being
+// removed.
+func (a *AwsCli) deleteUserDataObject(ctx context.Context, object string) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(object, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		slog.WarnContext(ctx, "invalid user data object", "object", object)
+		return
+	}
+	if _, err := a.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		slog.WarnContext(ctx, "failed to delete user data", "object", object, "error", err)
+	}
+}
+
+// DeleteUserDataObject deletes the user data that was offloaded to S3 for
+// the instance, if any. Call it once the instance is terminated.
+func (a *AwsCli) DeleteUserDataObject(ctx context.Context, instance types.Instance) {
+	for _, tag := range instance.Tags {
+		if aws.ToString(tag.Key) == util.UserDataObjectTag && aws.ToString(tag.Value) != "" {
+			a.deleteUserDataObject(ctx, aws.ToString(tag.Value))
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRunningInstanceUserDataOffload(t *testing.T) {
+	random := make([]byte, spec.MaxUserDataSize)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	tooLarge := json.RawMessage(fmt.Sprintf(`{"pre_install_scripts": {"10-random": %q}}`, base64.StdEncoding.EncodeToString(random)))
+
+	tests := []struct {
+		name       string
+		offload    config.UserDataOffload
+		extraSpecs json.RawMessage
+		launchErr  error
+		offloaded  bool
+		errString  string
+	}{
+		{
+			name: "user data that fits is not offloaded",
+			offload: config.UserDataOffload{
+				Bucket:          "garm-user-data",
+				InstanceProfile: "garm-runner",
+			},
+			extraSpecs: json.RawMessage(`{}`),
+		},
+		{
+			name:       "too large without offloading",
+			extraSpecs: tooLarge,
+			errString:  "over the 16384 byte limit of EC2",
+		},
+		{
+			name: "too large user data is offloaded",
+			offload: config.UserDataOffload{
+				Bucket:          "garm-user-data",
+				Prefix:          "runners/",
+				InstanceProfile: "arn:aws:iam::123456789012:instance-profile/garm-runner",
+			},
+			extraSpecs: tooLarge,
+			offloaded:  true,
+		},
+		{
+			name: "offloaded user data is deleted if the launch fails",
+			offload: config.UserDataOffload{
+				Bucket:          "garm-user-data",
+				Prefix:          "runners/",
+				InstanceProfile: "arn:aws:iam::123456789012:instance-profile/garm-runner",
+			},
+			extraSpecs: tooLarge,
+			launchErr:  fmt.Errorf("UnauthorizedOperation"),
+			offloaded:  true,
+			errString:  "UnauthorizedOperation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			mockS3 := new(MockS3Client)
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region:          "us-west-2",
+					SubnetID:        "subnet-1234567890abcdef0",
+					UserDataOffload: tt.offload,
+				},
+				client: mockClient,
+				s3:     mockS3,
+			}
+			runnerSpec := tagsRunnerSpec()
+			runnerSpec.BootstrapParams.ExtraSpecs = tt.extraSpecs
+
+			object := "s3://garm-user-data/runners/controllerID/instance-name"
+			mockCreateLookups(mockClient)
+			mockS3.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+				body, err := io.ReadAll(input.Body)
+				return err == nil && aws.ToString(input.Bucket) == "garm-user-data" &&
+					aws.ToString(input.Key) == "runners/controllerID/instance-name" &&
+					strings.HasPrefix(string(body), "#cloud-config") && len(body) > spec.MaxUserDataSize
+			}), mock.Anything).Return(&s3.PutObjectOutput{}, nil)
+			mockS3.On("DeleteObject", ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String("garm-user-data"),
+				Key:    aws.String("runners/controllerID/instance-name"),
+			}, mock.Anything).Return(&s3.DeleteObjectOutput{}, nil)
+			mockClient.On("RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+				udata, err := base64.StdEncoding.DecodeString(aws.ToString(input.UserData))
+				if err != nil {
+					return false
+				}
+				hasTag := false
+				for _, tag := range input.TagSpecifications[0].Tags {
+					if aws.ToString(tag.Key) == util.UserDataObjectTag && aws.ToString(tag.Value) == object {
+						hasTag = true
+					}
+				}
+				if !tt.offloaded {
+					return !hasTag && input.IamInstanceProfile == nil && strings.HasPrefix(string(udata), "#cloud-config")
+				}
+				return hasTag && strings.HasPrefix(string(udata), "#cloud-boothook") &&
+					aws.ToString(input.IamInstanceProfile.Arn) == tt.offload.InstanceProfile
+			}), mock.Anything).Return(&ec2.RunInstancesOutput{
+				Instances: []types.Instance{
+					{InstanceId: aws.String("i-1234567890abcdef0")},
+				},
+			}, tt.launchErr)
+
+			_, err := awsCli.CreateRunningInstance(ctx, runnerSpec)
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+			} else {
+				require.NoError(t, err)
+			}
+			if !tt.offloaded {
+				mockS3.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			mockS3.AssertCalled(t, "PutObject", ctx, mock.Anything, mock.Anything)
+			if tt.launchErr != nil {
+				mockS3.AssertCalled(t, "DeleteObject", ctx, mock.Anything, mock.Anything)
+				return
+			}
+			mockS3.AssertNotCalled(t, "DeleteObject", mock.Anything, mock.Anything, mock.Anything)
+
+			awsCli.DeleteUserDataObject(ctx, types.Instance{
+				Tags: []types.Tag{{Key: aws.String(util.UserDataObjectTag), Value: aws.String(object)}},
+			})
+			mockS3.AssertCalled(t, "DeleteObject", ctx, mock.Anything, mock.Anything)
+		})
+	}
+}
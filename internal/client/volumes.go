@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// rootVolumeID returns the ID of the EBS volume the instance boots from.
+func rootVolumeID(instance types.Instance) (string, bool) {
+	rootDevice := aws.ToString(instance.RootDeviceName)
+	for _, mapping := range instance.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == rootDevice && mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+			return *mapping.Ebs.VolumeId, true
+		}
+	}
+	return "", false
+}
+
+// RootVolumeFault returns a description of the problem if the root volume of
+// the instance is impaired. IO on an impaired volume may block, which hangs
+// jobs without the runner ever going offline. An empty string is returned if
+// the volume is fine, or its status is not known yet.
+func (a *AwsCli) RootVolumeFault(ctx context.Context, instance types.Instance) (string, error) {
+	volumeID, ok := rootVolumeID(instance)
+	if !ok {
+		return "", nil
+	}
+
+	resp, err := a.client.DescribeVolumeStatus(ctx, &ec2.DescribeVolumeStatusInput{
+		VolumeIds: []string{volumeID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe status of volume %s: %w", volumeID, err)
+	}
+
+	for _, item := range resp.VolumeStatuses {
+		if item.VolumeStatus == nil || item.VolumeStatus.Status != types.VolumeStatusInfoStatusImpaired {
+			continue
+		}
+		var failed []string
+		for _, detail := range item.VolumeStatus.Details {
+			status := aws.ToString(detail.Status)
+			if status != "passed" && status != "normal" {
+				failed = append(failed, fmt.Sprintf("%s: %s", detail.Name, status))
+			}
+		}
+		fault := fmt.Sprintf("root volume %s is impaired", volumeID)
+		if len(failed) > 0 {
+			fault = fmt.Sprintf("%s (%s)", fault, strings.Join(failed, ", "))
+		}
+		return fault, nil
+	}
+	return "", nil
+}
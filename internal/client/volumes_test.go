@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootVolumeFault(t *testing.T) {
+	instance := types.Instance{
+		InstanceId:     aws.String("i-1234567890abcdef0"),
+		RootDeviceName: aws.String("/dev/sda1"),
+		BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/sdb"),
+				Ebs:        &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data")},
+			},
+			{
+				DeviceName: aws.String("/dev/sda1"),
+				Ebs:        &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		status    *types.VolumeStatusInfo
+		err       error
+		fault     string
+		errString string
+	}{
+		{
+			name: "ok",
+			status: &types.VolumeStatusInfo{
+				Status: types.VolumeStatusInfoStatusOk,
+			},
+		},
+		{
+			name: "insufficient data",
+			status: &types.VolumeStatusInfo{
+				Status: types.VolumeStatusInfoStatusInsufficientData,
+			},
+		},
+		{
+			name: "impaired",
+			status: &types.VolumeStatusInfo{
+				Status: types.VolumeStatusInfoStatusImpaired,
+				Details: []types.VolumeStatusDetails{
+					{Name: types.VolumeStatusNameIoEnabled, Status: aws.String("failed")},
+					{Name: types.VolumeStatusNameIoPerformance, Status: aws.String("normal")},
+				},
+			},
+			fault: "root volume vol-root is impaired (io-enabled: failed)",
+		},
+		{
+			name:      "lookup error",
+			err:       fmt.Errorf("UnauthorizedOperation"),
+			errString: "failed to describe status of volume vol-root",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+
+			output := &ec2.DescribeVolumeStatusOutput{}
+			if tt.status != nil {
+				output.VolumeStatuses = []types.VolumeStatusItem{
+					{VolumeId: aws.String("vol-root"), VolumeStatus: tt.status},
+				}
+			}
+			mockClient.On("DescribeVolumeStatus", ctx, &ec2.DescribeVolumeStatusInput{
+				VolumeIds: []string{"vol-root"},
+			}, mock.Anything).Return(output, tt.err)
+
+			fault, err := awsCli.RootVolumeFault(ctx, instance)
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.fault, fault)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRootVolumeFaultInstanceStoreRoot(t *testing.T) {
+	awsCli := &AwsCli{client: new(MockComputeClient)}
+
+	fault, err := awsCli.RootVolumeFault(context.Background(), types.Instance{
+		InstanceId:     aws.String("i-1234567890abcdef0"),
+		RootDeviceName: aws.String("/dev/sda1"),
+	})
+	require.NoError(t, err)
+	require.Empty(t, fault)
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// privateOnlyServices are the AWS services runners in a VPC without internet
+// access need to reach through VPC endpoints.
+var privateOnlyServices = []string{"ec2", "s3", "ssm"}
+
+// checkVPCEndpoints makes sure the VPC has an available endpoint for every
+// service in privateOnlyServices.
+func (a *AwsCli) checkVPCEndpoints(ctx context.Context, vpcID string) error {
+	found := map[string]bool{}
+	paginator := ec2.NewDescribeVpcEndpointsPaginator(a.client, &ec2.DescribeVpcEndpointsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []string{vpcID},
+			},
+			{
+				Name:   aws.String("vpc-endpoint-state"),
+				Values: []string{"available"},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to describe vpc endpoints: %w", err)
+		}
+		for _, endpoint := range page.VpcEndpoints {
+			if endpoint.ServiceName != nil {
+				found[*endpoint.ServiceName] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, service := range privateOnlyServices {
+		name := fmt.Sprintf("com.amazonaws.%s.%s", a.cfg.Region, service)
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("private_only is set, but VPC %s has no available endpoint for: %s", vpcID, strings.Join(missing, ", "))
+	}
+	return nil
+}
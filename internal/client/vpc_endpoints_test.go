@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func vpcEndpoints(services ...string) *ec2.DescribeVpcEndpointsOutput {
+	out := &ec2.DescribeVpcEndpointsOutput{}
+	for _, service := range services {
+		out.VpcEndpoints = append(out.VpcEndpoints, types.VpcEndpoint{
+			ServiceName: aws.String("com.amazonaws.us-west-2." + service),
+		})
+	}
+	return out
+}
+
+func TestCheckVPCEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints *ec2.DescribeVpcEndpointsOutput
+		errString string
+	}{
+		{
+			name:      "all endpoints present",
+			endpoints: vpcEndpoints("ec2", "s3", "ssm", "logs"),
+		},
+		{
+			name:      "missing endpoints",
+			endpoints: vpcEndpoints("ec2"),
+			errString: "private_only is set, but VPC vpc-1234567890abcdef0 has no available endpoint for: com.amazonaws.us-west-2.s3, com.amazonaws.us-west-2.ssm",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockComputeClient)
+			awsCli := &AwsCli{
+				cfg:    &config.Config{Region: "us-west-2"},
+				client: mockClient,
+			}
+			mockClient.On("DescribeVpcEndpoints", ctx, mock.MatchedBy(func(input *ec2.DescribeVpcEndpointsInput) bool {
+				return len(input.Filters) == 2 && input.Filters[0].Values[0] == "vpc-1234567890abcdef0"
+			}), mock.Anything).Return(tt.endpoints, nil)
+
+			err := awsCli.checkVPCEndpoints(ctx, "vpc-1234567890abcdef0")
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestCreateRunningInstancePrivateOnlyMissingEndpoints(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:      "us-west-2",
+			SubnetID:    "subnet-1234567890abcdef0",
+			PrivateOnly: true,
+		},
+		client: mockClient,
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+		},
+		SubnetID:     "subnet-1234567890abcdef0",
+		PrivateOnly:  true,
+		ControllerID: "controllerID",
+	}
+
+	mockCreateLookups(mockClient)
+	mockClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []types.Subnet{
+			{
+				SubnetId: aws.String("subnet-1234567890abcdef0"),
+				VpcId:    aws.String("vpc-1234567890abcdef0"),
+			},
+		},
+	}, nil)
+	mockClient.On("DescribeVpcEndpoints", ctx, mock.Anything, mock.Anything).Return(vpcEndpoints("ec2", "ssm"), nil)
+
+	_, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.ErrorContains(t, err, "has no available endpoint for: com.amazonaws.us-west-2.s3")
+	mockClient.AssertNotCalled(t, "RunInstances", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateRunningInstancePrivateOnlySkipsFallbackSubnets(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region:      "us-west-2",
+			SubnetID:    "subnet-1234567890abcdef0",
+			PrivateOnly: true,
+		},
+		client: mockClient,
+	}
+	spec := &spec.RunnerSpec{
+		Region: "us-west-2",
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "instance-name",
+			OSType: "linux",
+			Image:  "ami-12345678",
+			Flavor: "t2.micro",
+		},
+		SubnetID:          "subnet-1234567890abcdef0",
+		FallbackSubnetIDs: []string{"subnet-0fedcba9876543210"},
+		PrivateOnly:       true,
+		ControllerID:      "controllerID",
+	}
+
+	mockCreateLookups(mockClient)
+	for subnetID, vpcID := range map[string]string{
+		"subnet-1234567890abcdef0": "vpc-1234567890abcdef0",
+		"subnet-0fedcba9876543210": "vpc-0fedcba9876543210",
+	} {
+		mockClient.On("DescribeSubnets", ctx, &ec2.DescribeSubnetsInput{
+			SubnetIds: []string{subnetID},
+		}, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+			Subnets: []types.Subnet{
+				{
+					SubnetId: aws.String(subnetID),
+					VpcId:    aws.String(vpcID),
+				},
+			},
+		}, nil)
+	}
+	inVPC := func(vpcID string) interface{} {
+		return mock.MatchedBy(func(input *ec2.DescribeVpcEndpointsInput) bool {
+			return input.Filters[0].Values[0] == vpcID
+		})
+	}
+	mockClient.On("DescribeVpcEndpoints", ctx, inVPC("vpc-1234567890abcdef0"), mock.Anything).Return(vpcEndpoints("ec2"), nil)
+	mockClient.On("DescribeVpcEndpoints", ctx, inVPC("vpc-0fedcba9876543210"), mock.Anything).Return(vpcEndpoints("ec2", "s3", "ssm"), nil)
+	mockClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
+		Instances: []types.Instance{
+			{
+				InstanceId: aws.String("i-1234567890abcdef0"),
+			},
+		},
+	}, nil)
+
+	instanceID, err := awsCli.CreateRunningInstance(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, "i-1234567890abcdef0", instanceID)
+	mockClient.AssertCalled(t, "RunInstances", ctx, mock.MatchedBy(func(input *ec2.RunInstancesInput) bool {
+		return aws.ToString(input.SubnetId) == "subnet-0fedcba9876543210"
+	}), mock.Anything)
+	mockClient.AssertNumberOfCalls(t, "RunInstances", 1)
+}
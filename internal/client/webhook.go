@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	// webhookSignatureHeader holds the hex encoded HMAC-SHA256 of the
+	// request body, keyed with the configured secret.
+	webhookSignatureHeader = "X-Garm-Signature-256"
+	webhookTimeout         = 10 * time.Second
+
+	lifecycleEventCreate = "create"
+	lifecycleEventDelete = "delete"
+)
+
+var (
+	// webhookRetryInterval is the base delay between attempts to deliver a
+	// lifecycle event. It grows linearly with every attempt.
+	webhookRetryInterval = 2 * time.Second
+	// webhookDeadline bounds the time spent delivering a lifecycle event,
+	// retries included, as the create or delete waits for it.
+	webhookDeadline = 30 * time.Second
+)
+
+type lifecycleEvent struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Event        string    `json:"event"`
+	InstanceID   string    `json:"instance_id"`
+	Name         string    `json:"name,omitempty"`
+	PoolID       string    `json:"pool_id,omitempty"`
+	ControllerID string    `json:"controller_id,omitempty"`
+	Region       string    `json:"region"`
+	PrivateIPs   []string  `json:"private_ips,omitempty"`
+	PublicIPs    []string  `json:"public_ips,omitempty"`
+}
+
+func newLifecycleEvent(event, region string, instance types.Instance) lifecycleEvent {
+	ev := lifecycleEvent{
+		Timestamp: time.Now().UTC(),
+		Event:     event,
+		Region:    region,
+	}
+	if instance.InstanceId != nil {
+		ev.InstanceID = *instance.InstanceId
+	}
+
+	for _, tag := range instance.Tags {
+		if tag.Key == nil || tag.Value == nil {
+			continue
+		}
+		switch *tag.Key {
+		case "Name":
+			ev.Name = *tag.Value
+		case "GARM_POOL_ID":
+			ev.PoolID = *tag.Value
+		case "GARM_CONTROLLER_ID":
+			ev.ControllerID = *tag.Value
+		}
+	}
+
+	if instance.PrivateIpAddress != nil {
+		ev.PrivateIPs = append(ev.PrivateIPs, *instance.PrivateIpAddress)
+	}
+	if instance.PublicIpAddress != nil {
+		ev.PublicIPs = append(ev.PublicIPs, *instance.PublicIpAddress)
+	}
+	// IPv6 addresses are globally routable, so they are reported as public.
+	for _, iface := range instance.NetworkInterfaces {
+		for _, addr := range iface.Ipv6Addresses {
+			if addr.Ipv6Address != nil {
+				ev.PublicIPs = append(ev.PublicIPs, *addr.Ipv6Address)
+			}
+		}
+	}
+	return ev
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyLifecycle sends a lifecycle event for the instance to the configured
+// webhook. Delivery is retried until webhookDeadline, but failures are only
+// logged, as they should not prevent runners from being created or removed.
+func (a *AwsCli) notifyLifecycle(ctx context.Context, event string, instance types.Instance) {
+	webhook := a.cfg.LifecycleWebhook
+	if webhook.URL == "" {
+		return
+	}
+
+	payload, err := json.Marshal(newLifecycleEvent(event, a.cfg.Region, instance))
+	if err != nil {
+		slog.WarnContext(ctx, "failed to encode lifecycle event", "error", err)
+		return
+	}
+	client, err := a.cfg.HTTPClient()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to create lifecycle webhook client", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookDeadline)
+	defer cancel()
+
+	maxRetries := webhook.GetMaxRetries()
+	for attempt := 0; ; attempt++ {
+		err = sendWebhook(ctx, client, webhook.URL, webhook.Secret, payload)
+		if err == nil {
+			return
+		}
+		if attempt >= maxRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.WarnContext(ctx, "failed to send lifecycle event", "event", event, "instance_id", aws.ToString(instance.InstanceId), "error", ctx.Err())
+			return
+		case <-time.After(time.Duration(attempt+1) * webhookRetryInterval):
+		}
+	}
+	slog.WarnContext(ctx, "failed to send lifecycle event", "event", event, "instance_id", aws.ToString(instance.InstanceId), "attempts", maxRetries+1, "error", err)
+}
+
+func sendWebhook(ctx context.Context, client aws.HTTPClient, url, secret string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, signWebhookPayload(secret, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
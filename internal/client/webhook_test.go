@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookRecorder struct {
+	mux      sync.Mutex
+	events   []lifecycleEvent
+	failures int
+}
+
+func (w *webhookRecorder) handler(t *testing.T, secret string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, signWebhookPayload(secret, body), r.Header.Get(webhookSignatureHeader))
+
+		w.mux.Lock()
+		defer w.mux.Unlock()
+		if w.failures > 0 {
+			w.failures--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var event lifecycleEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		w.events = append(w.events, event)
+	}
+}
+
+func TestNotifyLifecycle(t *testing.T) {
+	webhookRetryInterval = time.Millisecond
+	defer func() { webhookRetryInterval = 2 * time.Second }()
+
+	secret := "s3cr3t"
+	noRetries := 0
+	instance := types.Instance{
+		InstanceId:       aws.String("i-1234567890abcdef0"),
+		PrivateIpAddress: aws.String("10.0.0.10"),
+		PublicIpAddress:  aws.String("203.0.113.10"),
+		NetworkInterfaces: []types.InstanceNetworkInterface{
+			{
+				Ipv6Addresses: []types.InstanceIpv6Address{
+					{Ipv6Address: aws.String("2001:db8::10")},
+				},
+			},
+		},
+		Tags: []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("garm-runner")},
+			{Key: aws.String("GARM_POOL_ID"), Value: aws.String("pool-id")},
+			{Key: aws.String("GARM_CONTROLLER_ID"), Value: aws.String("controller-id")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		failures   int
+		maxRetries *int
+		delivered  bool
+	}{
+		{
+			name:      "delivered on first attempt",
+			delivered: true,
+		},
+		{
+			name:      "delivered after retries",
+			failures:  2,
+			delivered: true,
+		},
+		{
+			name:       "not retried",
+			failures:   1,
+			maxRetries: &noRetries,
+			delivered:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &webhookRecorder{failures: tt.failures}
+			server := httptest.NewServer(recorder.handler(t, secret))
+			defer server.Close()
+
+			awsCli := &AwsCli{
+				cfg: &config.Config{
+					Region: "us-west-2",
+					LifecycleWebhook: config.LifecycleWebhook{
+						URL:        server.URL,
+						Secret:     secret,
+						MaxRetries: tt.maxRetries,
+					},
+				},
+			}
+
+			awsCli.notifyLifecycle(context.Background(), lifecycleEventCreate, instance)
+
+			if !tt.delivered {
+				require.Empty(t, recorder.events)
+				return
+			}
+			require.Len(t, recorder.events, 1)
+			event := recorder.events[0]
+			require.Equal(t, lifecycleEventCreate, event.Event)
+			require.Equal(t, "i-1234567890abcdef0", event.InstanceID)
+			require.Equal(t, "garm-runner", event.Name)
+			require.Equal(t, "pool-id", event.PoolID)
+			require.Equal(t, "controller-id", event.ControllerID)
+			require.Equal(t, "us-west-2", event.Region)
+			require.Equal(t, []string{"10.0.0.10"}, event.PrivateIPs)
+			require.Equal(t, []string{"203.0.113.10", "2001:db8::10"}, event.PublicIPs)
+		})
+	}
+}
+
+func TestNotifyLifecycleDeadline(t *testing.T) {
+	webhookDeadline = 50 * time.Millisecond
+	defer func() { webhookDeadline = 30 * time.Second }()
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hangs until the test is over.
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+			LifecycleWebhook: config.LifecycleWebhook{
+				URL:    server.URL,
+				Secret: "s3cr3t",
+			},
+		},
+	}
+
+	start := time.Now()
+	awsCli.notifyLifecycle(context.Background(), lifecycleEventCreate, types.Instance{InstanceId: aws.String("i-1234567890abcdef0")})
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestTerminateInstanceNotifiesWebhook(t *testing.T) {
+	ctx := context.Background()
+	secret := "s3cr3t"
+	instanceID := "i-1234567890abcdef0"
+
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder.handler(t, secret))
+	defer server.Close()
+
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+			LifecycleWebhook: config.LifecycleWebhook{
+				URL:    server.URL,
+				Secret: secret,
+			},
+		},
+		client: mockClient,
+	}
+
+	mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId:       aws.String(instanceID),
+						PrivateIpAddress: aws.String("10.0.0.10"),
+						Tags: []types.Tag{
+							{Key: aws.String("GARM_POOL_ID"), Value: aws.String("pool-id")},
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	require.NoError(t, awsCli.TerminateInstance(ctx, instanceID, "test reason"))
+
+	require.Len(t, recorder.events, 1)
+	require.Equal(t, lifecycleEventDelete, recorder.events[0].Event)
+	require.Equal(t, instanceID, recorder.events[0].InstanceID)
+	require.Equal(t, "pool-id", recorder.events[0].PoolID)
+	require.Equal(t, []string{"10.0.0.10"}, recorder.events[0].PrivateIPs)
+	mockClient.AssertExpectations(t)
+}
+
+func TestTerminateInstanceSkipsWebhookIfAlreadyTerminating(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder.handler(t, "s3cr3t"))
+	defer server.Close()
+
+	mockClient := new(MockComputeClient)
+	awsCli := &AwsCli{
+		cfg: &config.Config{
+			Region: "us-west-2",
+			LifecycleWebhook: config.LifecycleWebhook{
+				URL:    server.URL,
+				Secret: "s3cr3t",
+			},
+		},
+		client: mockClient,
+	}
+
+	mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+	mockClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{
+		TerminatingInstances: []types.InstanceStateChange{
+			{
+				InstanceId:    aws.String(instanceID),
+				PreviousState: &types.InstanceState{Name: types.InstanceStateNameShuttingDown},
+				CurrentState:  &types.InstanceState{Name: types.InstanceStateNameShuttingDown},
+			},
+		},
+	}, nil)
+
+	require.NoError(t, awsCli.TerminateInstance(ctx, instanceID, "test reason"))
+	require.Empty(t, recorder.events)
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CallStats sums up the calls made to one AWS API.
+type CallStats struct {
+	Count     int     `json:"count"`
+	Failures  int     `json:"failures,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Diagnostics sums up how long an operation took, and the AWS calls it
+// made, by service and API, like "EC2.DescribeInstances".
+type Diagnostics struct {
+	LatencyMS float64              `json:"latency_ms"`
+	AWSCalls  int                  `json:"aws_calls"`
+	Calls     map[string]CallStats `json:"calls,omitempty"`
+}
+
+var (
+	callsMu sync.Mutex
+	calls   = map[string]CallStats{}
+
+	diagnosticsEnabled atomic.Bool
+)
+
+// RecordCall adds a call to AWS to the diagnostics of the operation. Calls
+// are always recorded, whether diagnostics are enabled or not.
+func RecordCall(service, api string, latency time.Duration, failed bool) {
+	callsMu.Lock()
+	defer callsMu.Unlock()
+	key := service + "." + api
+	stats := calls[key]
+	stats.Count++
+	if failed {
+		stats.Failures++
+	}
+	stats.LatencyMS += milliseconds(latency)
+	calls[key] = stats
+}
+
+// SetDiagnostics enables logging the diagnostics of operations.
+func SetDiagnostics(enabled bool) {
+	diagnosticsEnabled.Store(enabled)
+}
+
+// DiagnosticsEnabled returns true if the diagnostics of operations are
+// logged.
+func DiagnosticsEnabled() bool {
+	return diagnosticsEnabled.Load()
+}
+
+// Diagnose returns the diagnostics of an operation that took latency, with
+// the calls recorded so far.
+func Diagnose(latency time.Duration) Diagnostics {
+	callsMu.Lock()
+	defer callsMu.Unlock()
+	d := Diagnostics{
+		LatencyMS: milliseconds(latency),
+	}
+	if len(calls) > 0 {
+		d.Calls = make(map[string]CallStats, len(calls))
+	}
+	for key, stats := range calls {
+		d.AWSCalls += stats.Count
+		d.Calls[key] = stats
+	}
+	return d
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	callsMu.Lock()
+	calls = map[string]CallStats{}
+	callsMu.Unlock()
+
+	RecordCall("EC2", "DescribeInstances", 20*time.Millisecond, false)
+	RecordCall("EC2", "DescribeInstances", 30*time.Millisecond, true)
+	RecordCall("EC2", "RunInstances", 150*time.Millisecond, false)
+
+	d := Diagnose(time.Second)
+	require.Equal(t, Diagnostics{
+		LatencyMS: 1000,
+		AWSCalls:  3,
+		Calls: map[string]CallStats{
+			"EC2.DescribeInstances": {Count: 2, Failures: 1, LatencyMS: 50},
+			"EC2.RunInstances":      {Count: 1, LatencyMS: 150},
+		},
+	}, d)
+}
@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package metrics emits the operational metrics of the provider, to statsd
+// or as CloudWatch embedded metric format (EMF) documents. Every metric is
+// emitted right away, as the provider only runs for a single operation.
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures where a Recorder emits metrics.
+type Options struct {
+	// Namespace prefixes the names of statsd metrics, and is the namespace
+	// of EMF metrics.
+	Namespace string
+	// StatsdAddress is the host:port of a statsd server, to which metrics
+	// are sent over UDP with DogStatsD tags.
+	StatsdAddress string
+	// EMFFile is the file EMF documents are appended to, one per line, for
+	// the CloudWatch agent to pick up.
+	EMFFile string
+}
+
+// Recorder emits metrics. The zero value discards them.
+type Recorder struct {
+	namespace string
+	statsd    net.Conn
+	emfFile   string
+
+	// mu serializes writes to the EMF file.
+	mu sync.Mutex
+}
+
+// New returns a Recorder emitting metrics as configured in opts.
+func New(opts Options) (*Recorder, error) {
+	r := &Recorder{
+		namespace: opts.Namespace,
+		emfFile:   opts.EMFFile,
+	}
+	if opts.StatsdAddress != "" {
+		conn, err := net.Dial("udp", opts.StatsdAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to statsd: %w", err)
+		}
+		r.statsd = conn
+	}
+	return r, nil
+}
+
+// Count emits a counter.
+func (r *Recorder) Count(name string, value int, tags map[string]string) {
+	r.emit(name, float64(value), "c", "Count", tags)
+}
+
+// Timing emits a duration, in milliseconds.
+func (r *Recorder) Timing(name string, d time.Duration, tags map[string]string) {
+	r.emit(name, float64(d)/float64(time.Millisecond), "ms", "Milliseconds", tags)
+}
+
+func (r *Recorder) emit(name string, value float64, statsdType, unit string, tags map[string]string) {
+	if r == nil {
+		return
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Metrics are best effort, and never fail an operation.
+	if r.statsd != nil {
+		if _, err := r.statsd.Write(statsdLine(r.namespace, name, value, statsdType, keys, tags)); err != nil {
+			slog.Debug("failed to send metric to statsd", "metric", name, "error", err)
+		}
+	}
+	if r.emfFile != "" {
+		if err := r.appendEMF(name, value, unit, keys, tags); err != nil {
+			slog.Debug("failed to write emf metric", "metric", name, "error", err)
+		}
+	}
+}
+
+func statsdLine(namespace, name string, value float64, statsdType string, keys []string, tags map[string]string) []byte {
+	var line strings.Builder
+	if namespace != "" {
+		line.WriteString(namespace + ".")
+	}
+	line.WriteString(name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + statsdType)
+	for idx, key := range keys {
+		if idx == 0 {
+			line.WriteString("|#")
+		} else {
+			line.WriteString(",")
+		}
+		line.WriteString(key + ":" + tags[key])
+	}
+	return []byte(line.String())
+}
+
+func (r *Recorder) appendEMF(name string, value float64, unit string, keys []string, tags map[string]string) error {
+	doc := map[string]any{
+		"_aws": map[string]any{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]any{
+				{
+					"Namespace":  r.namespace,
+					"Dimensions": [][]string{keys},
+					"Metrics":    []map[string]string{{"Name": name, "Unit": unit}},
+				},
+			},
+		},
+		name: value,
+	}
+	for key, val := range tags {
+		doc[key] = val
+	}
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Many provider processes may append at once. Writes of a single line
+	// to a file opened for appending don't interleave.
+	f, err := os.OpenFile(r.emfFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close releases the connection to statsd.
+func (r *Recorder) Close() error {
+	if r == nil || r.statsd == nil {
+		return nil
+	}
+	return r.statsd.Close()
+}
+
+var defaultRecorder atomic.Pointer[Recorder]
+
+// SetDefault makes r the recorder used by the package level functions.
+func SetDefault(r *Recorder) {
+	defaultRecorder.Store(r)
+}
+
+// Default returns the default recorder, which discards metrics unless one
+// was set.
+func Default() *Recorder {
+	return defaultRecorder.Load()
+}
+
+// Count emits a counter with the default recorder.
+func Count(name string, value int, tags map[string]string) {
+	Default().Count(name, value, tags)
+}
+
+// Timing emits a duration with the default recorder.
+func Timing(name string, d time.Duration, tags map[string]string) {
+	Default().Timing(name, d, tags)
+}
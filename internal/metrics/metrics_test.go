@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package metrics
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	recorder, err := New(Options{Namespace: "garm", StatsdAddress: conn.LocalAddr().String()})
+	require.NoError(t, err)
+	defer recorder.Close()
+
+	recorder.Count("operations", 1, map[string]string{"outcome": "failure", "operation": "CreateInstance"})
+	recorder.Timing("operation_latency", 1500*time.Microsecond, nil)
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "garm.operations:1|c|#operation:CreateInstance,outcome:failure", string(buf[:n]))
+	n, _, err = conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "garm.operation_latency:1.5|ms", string(buf[:n]))
+}
+
+func TestEMF(t *testing.T) {
+	emfFile := filepath.Join(t.TempDir(), "metrics.json")
+	recorder, err := New(Options{Namespace: "garm", EMFFile: emfFile})
+	require.NoError(t, err)
+
+	recorder.Count("aws_calls", 1, map[string]string{"service": "EC2", "api": "RunInstances"})
+	recorder.Timing("aws_call_latency", 20*time.Millisecond, map[string]string{"service": "EC2", "api": "RunInstances"})
+
+	data, err := os.ReadFile(emfFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &doc))
+	require.Equal(t, float64(1), doc["aws_calls"])
+	require.Equal(t, "EC2", doc["service"])
+	require.Equal(t, "RunInstances", doc["api"])
+	definition := doc["_aws"].(map[string]any)["CloudWatchMetrics"].([]any)[0].(map[string]any)
+	require.Equal(t, "garm", definition["Namespace"])
+	require.Equal(t, []any{[]any{"api", "service"}}, definition["Dimensions"])
+	require.Equal(t, []any{map[string]any{"Name": "aws_calls", "Unit": "Count"}}, definition["Metrics"])
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &doc))
+	require.Equal(t, float64(20), doc["aws_call_latency"])
+}
+
+func TestDiscard(t *testing.T) {
+	// Without a default recorder, metrics are discarded.
+	Count("operations", 1, nil)
+	Timing("operation_latency", time.Second, nil)
+	require.NoError(t, Default().Close())
+}
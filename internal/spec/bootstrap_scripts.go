@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+	"gopkg.in/yaml.v3"
+)
+
+// preBootstrapScriptName is the name of the pre-install script the pre
+// bootstrap script of the provider config is added as. It runs after the
+// instance store is mounted, and before the scripts set in the extra specs.
+const preBootstrapScriptName = "00-garm-pre-bootstrap"
+
+// postBootstrapScriptPath is where the post bootstrap script of the provider
+// config is written to on Linux.
+const postBootstrapScriptPath = "/garm-post-bootstrap.sh"
+
+// bootstrapScripts returns the bootstrap scripts of the provider config for
+// the OS type.
+func bootstrapScripts(cfg *config.Config, osType params.OSType) config.OSBootstrapScripts {
+	switch osType {
+	case params.Linux:
+		return cfg.BootstrapScripts.Linux
+	case params.Windows:
+		return cfg.BootstrapScripts.Windows
+	}
+	return config.OSBootstrapScripts{}
+}
+
+// withPreBootstrapScript returns the bootstrap params with the pre bootstrap
+// script added to the pre_install_scripts extra spec.
+func (r *RunnerSpec) withPreBootstrapScript(bootstrapParams params.BootstrapInstance) (params.BootstrapInstance, error) {
+	// On Windows, the script is added to the install script instead.
+	if r.PreBootstrapScript == "" || bootstrapParams.OSType != params.Linux {
+		return bootstrapParams, nil
+	}
+	return withPreInstallScript(bootstrapParams, preBootstrapScriptName, []byte(r.PreBootstrapScript))
+}
+
+// withPostBootstrapScript returns the cloud-init config with the post
+// bootstrap script written to disk and run after the runner is installed.
+// Pre-install scripts and the runner install script are run commands, so
+// the script is added as the last one.
+func (r *RunnerSpec) withPostBootstrapScript(cloudConfig string) (string, error) {
+	if r.PostBootstrapScript == "" {
+		return cloudConfig, nil
+	}
+
+	return editCloudConfig(cloudConfig, func(root *yaml.Node) error {
+		writeFile := &yaml.Node{}
+		if err := writeFile.Encode(map[string]string{
+			"encoding":    "b64",
+			"content":     base64.StdEncoding.EncodeToString([]byte(r.PostBootstrapScript)),
+			"owner":       "root:root",
+			"path":        postBootstrapScriptPath,
+			"permissions": "0755",
+		}); err != nil {
+			return fmt.Errorf("failed to encode post bootstrap script: %w", err)
+		}
+		appendToSequence(root, "write_files", writeFile)
+		appendToSequence(root, "runcmd",
+			&yaml.Node{Kind: yaml.ScalarNode, Value: postBootstrapScriptPath},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "rm -f " + postBootstrapScriptPath},
+		)
+		return nil
+	})
+}
+
+// editCloudConfig decodes the cloud-init config, lets edit change its root
+// mapping, and encodes it again.
+func editCloudConfig(cloudConfig string, edit func(root *yaml.Node) error) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(cloudConfig), &doc); err != nil {
+		return "", fmt.Errorf("failed to decode cloud config: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return "", fmt.Errorf("cloud config is not a mapping")
+	}
+	if err := edit(doc.Content[0]); err != nil {
+		return "", err
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cloud config: %w", err)
+	}
+	// Cloud-init only reads user data that starts with the header.
+	if !strings.HasPrefix(string(out), "#cloud-config") {
+		out = append([]byte("#cloud-config\n"), out...)
+	}
+	return string(out), nil
+}
+
+// mappingValue returns the value under key in the mapping, or nil if there
+// is none.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return mapping.Content[idx+1]
+		}
+	}
+	return nil
+}
+
+// appendToSequence appends nodes to the sequence under key in the mapping,
+// adding the sequence if the mapping has none.
+func appendToSequence(mapping *yaml.Node, key string, nodes ...*yaml.Node) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key && mapping.Content[idx+1].Kind == yaml.SequenceNode {
+			mapping.Content[idx+1].Content = append(mapping.Content[idx+1].Content, nodes...)
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.SequenceNode, Content: nodes},
+	)
+}
+
+// withWindowsBootstrapScripts returns the install script with the bootstrap
+// scripts run before and after it, along with the script that links a
+// pre-installed runner. The install script starts with a Param block, which
+// has to come first, so it is run as a script block.
+func (r *RunnerSpec) withWindowsBootstrapScripts(installScript string) string {
+	var pre []string
+	for _, script := range []string{r.PreBootstrapScript, r.windowsPreinstalledRunnerScript()} {
+		if script != "" {
+			pre = append(pre, script)
+		}
+	}
+	if len(pre) == 0 && r.PostBootstrapScript == "" {
+		return installScript
+	}
+	return fmt.Sprintf("%s\r\n& {\r\n%s\r\n}\r\n%s\r\n", strings.Join(pre, "\r\n"), installScript, r.PostBootstrapScript)
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestComposeUserDataBootstrapScriptsLinux(t *testing.T) {
+	spec := &RunnerSpec{
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("https://example.com/runner.tar.gz"),
+			Filename:     aws.String("runner.tar.gz"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:       "mock-name",
+			OSType:     params.Linux,
+			ExtraSpecs: json.RawMessage(`{"pre_install_scripts": {"10-pool": "IyEvYmluL3NoCmVjaG8gcG9vbAo="}}`),
+		},
+		PreBootstrapScript:  "#!/bin/sh\necho pre\n",
+		PostBootstrapScript: "#!/bin/sh\necho post\n",
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(udata)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(decoded), "#cloud-config\n"))
+
+	var cloudConfig struct {
+		WriteFiles []struct {
+			Path     string `yaml:"path"`
+			Content  string `yaml:"content"`
+			Encoding string `yaml:"encoding"`
+		} `yaml:"write_files"`
+		RunCmd []string `yaml:"runcmd"`
+	}
+	require.NoError(t, yaml.Unmarshal(decoded, &cloudConfig))
+
+	files := map[string]string{}
+	for _, file := range cloudConfig.WriteFiles {
+		require.Equal(t, "b64", file.Encoding)
+		content, err := base64.StdEncoding.DecodeString(file.Content)
+		require.NoError(t, err)
+		files[file.Path] = string(content)
+	}
+	require.Equal(t, "#!/bin/sh\necho pre\n", files["/garm-pre-install/00-garm-pre-bootstrap"])
+	require.Equal(t, "#!/bin/sh\necho post\n", files["/garm-post-bootstrap.sh"])
+
+	// The pre bootstrap script runs before the scripts of the pool, and
+	// the post bootstrap script after the runner is installed.
+	runCmd := strings.Join(cloudConfig.RunCmd, "\n")
+	require.Less(t, strings.Index(runCmd, "00-garm-pre-bootstrap"), strings.Index(runCmd, "10-pool"))
+	require.Less(t, strings.Index(runCmd, "/install_runner.sh"), strings.Index(runCmd, "/garm-post-bootstrap.sh"))
+	require.Equal(t, []string{"/garm-post-bootstrap.sh", "rm -f /garm-post-bootstrap.sh"}, cloudConfig.RunCmd[len(cloudConfig.RunCmd)-2:])
+}
+
+func TestComposeUserDataBootstrapScriptsWindows(t *testing.T) {
+	spec := &RunnerSpec{
+		BootstrapParams: params.BootstrapInstance{
+			Name:       "mock-name",
+			OSType:     params.Windows,
+			ExtraSpecs: json.RawMessage(fmt.Sprintf(`{"runner_install_template": %q}`, base64.StdEncoding.EncodeToString([]byte("Param()\r\nWrite-Host install")))),
+		},
+		RunnerInstallTemplateFormat: TemplateFormatRaw,
+		PreBootstrapScript:          "Write-Host pre",
+		PostBootstrapScript:         "Write-Host post",
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(udata)
+	require.NoError(t, err)
+	require.Equal(t, "<powershell>Write-Host pre\r\n& {\r\nParam()\r\nWrite-Host install\r\n}\r\nWrite-Host post\r\n</powershell>", string(decoded))
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// exclusiveExtraSpecs are groups of extra specs that set the same thing in
+// different ways, and can't be used together. A pool that sets any key of a
+// group replaces the defaults of the whole group.
+var exclusiveExtraSpecs = [][]string{
+	{"ssh_key_name", "ephemeral_ssh_key"},
+	{"host_id", "host_resource_group_arn"},
+}
+
+// ValidateDefaultExtraSpecs checks the default_extra_specs of the provider
+// config against the extra specs schema, so that mistakes are reported when
+// the config is loaded rather than when an instance is created.
+func ValidateDefaultExtraSpecs(cfg *config.Config) error {
+	if len(cfg.DefaultExtraSpecs) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(cfg.DefaultExtraSpecs)
+	if err != nil {
+		return fmt.Errorf("invalid default_extra_specs: %w", err)
+	}
+	if err := jsonSchemaValidation(data); err != nil {
+		return fmt.Errorf("invalid default_extra_specs: %w", err)
+	}
+	return nil
+}
+
+// WithDefaultExtraSpecs returns the bootstrap params with the
+// default_extra_specs of the provider config added to the extra specs of the
+// pool. Keys the pool sets are left alone, so objects like metadata_options
+// are replaced as a whole rather than merged, and so are the other keys of
+// their group in exclusiveExtraSpecs.
+func WithDefaultExtraSpecs(cfg *config.Config, data params.BootstrapInstance) (params.BootstrapInstance, error) {
+	if len(cfg.DefaultExtraSpecs) == 0 {
+		return data, nil
+	}
+
+	merged := map[string]any{}
+	if len(data.ExtraSpecs) > 0 {
+		if err := json.Unmarshal(data.ExtraSpecs, &merged); err != nil {
+			return params.BootstrapInstance{}, fmt.Errorf("failed to unmarshal extra specs: %w", err)
+		}
+		// The extra specs may be a JSON null.
+		if merged == nil {
+			merged = map[string]any{}
+		}
+	}
+	overridden := map[string]bool{}
+	for key, value := range merged {
+		overridden[key] = true
+		// Turning off ephemeral_ssh_key, for example, doesn't replace the
+		// default ssh_key_name.
+		if value == nil || value == false {
+			continue
+		}
+		for _, group := range exclusiveExtraSpecs {
+			if slices.Contains(group, key) {
+				for _, other := range group {
+					overridden[other] = true
+				}
+			}
+		}
+	}
+	for key, value := range cfg.DefaultExtraSpecs {
+		if !overridden[key] {
+			merged[key] = value
+		}
+	}
+
+	extraSpecs, err := json.Marshal(merged)
+	if err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to marshal extra specs: %w", err)
+	}
+	data.ExtraSpecs = extraSpecs
+	return data, nil
+}
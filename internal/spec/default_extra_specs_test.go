@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDefaultExtraSpecs(t *testing.T) {
+	defaults := map[string]any{
+		"disable_updates": true,
+		"ssh_key_name":    "default-key",
+		"metadata_options": map[string]any{
+			"http_tokens": "required",
+		},
+	}
+	tests := []struct {
+		name       string
+		defaults   map[string]any
+		extraSpecs json.RawMessage
+		expected   string
+		errString  string
+	}{
+		{
+			name:       "no defaults",
+			extraSpecs: json.RawMessage(`{"ssh_key_name": "pool-key"}`),
+			expected:   `{"ssh_key_name": "pool-key"}`,
+		},
+		{
+			name:     "no extra specs",
+			defaults: defaults,
+			expected: `{"disable_updates": true, "ssh_key_name": "default-key", "metadata_options": {"http_tokens": "required"}}`,
+		},
+		{
+			name:       "null extra specs",
+			defaults:   defaults,
+			extraSpecs: json.RawMessage(`null`),
+			expected:   `{"disable_updates": true, "ssh_key_name": "default-key", "metadata_options": {"http_tokens": "required"}}`,
+		},
+		{
+			name:       "pool takes precedence",
+			defaults:   defaults,
+			extraSpecs: json.RawMessage(`{"ssh_key_name": "pool-key", "metadata_options": {"http_put_response_hop_limit": 2}, "disable_updates": false}`),
+			expected:   `{"disable_updates": false, "ssh_key_name": "pool-key", "metadata_options": {"http_put_response_hop_limit": 2}}`,
+		},
+		{
+			name:       "pool sets another key of an exclusive group",
+			defaults:   defaults,
+			extraSpecs: json.RawMessage(`{"ephemeral_ssh_key": true}`),
+			expected:   `{"disable_updates": true, "ephemeral_ssh_key": true, "metadata_options": {"http_tokens": "required"}}`,
+		},
+		{
+			name:       "pool turns off another key of an exclusive group",
+			defaults:   defaults,
+			extraSpecs: json.RawMessage(`{"ephemeral_ssh_key": false}`),
+			expected:   `{"disable_updates": true, "ephemeral_ssh_key": false, "ssh_key_name": "default-key", "metadata_options": {"http_tokens": "required"}}`,
+		},
+		{
+			name: "defaults of an exclusive group are replaced together",
+			defaults: map[string]any{
+				"tenancy": "host",
+				"host_id": "h-0123456789abcdef0",
+			},
+			extraSpecs: json.RawMessage(`{"host_resource_group_arn": "arn:aws:resource-groups:us-east-1:123456789012:group/hosts"}`),
+			expected:   `{"tenancy": "host", "host_resource_group_arn": "arn:aws:resource-groups:us-east-1:123456789012:group/hosts"}`,
+		},
+		{
+			name:       "invalid extra specs",
+			defaults:   defaults,
+			extraSpecs: json.RawMessage(`[]`),
+			errString:  "failed to unmarshal extra specs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{DefaultExtraSpecs: tt.defaults}
+			data, err := WithDefaultExtraSpecs(cfg, params.BootstrapInstance{Name: "mock-name", ExtraSpecs: tt.extraSpecs})
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "mock-name", data.Name)
+			require.JSONEq(t, tt.expected, string(data.ExtraSpecs))
+		})
+	}
+}
+
+func TestValidateDefaultExtraSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		errString string
+	}{
+		{
+			name: "no defaults",
+		},
+		{
+			name: "valid defaults",
+			config: `
+[default_extra_specs]
+disable_updates = true
+security_group_ids = ["sg-0123456789abcdef0"]
+  [default_extra_specs.metadata_options]
+  http_tokens = "required"
+  http_put_response_hop_limit = 2
+`,
+		},
+		{
+			name: "unknown key",
+			config: `
+[default_extra_specs]
+volume_typ = "gp3"
+`,
+			errString: "invalid default_extra_specs",
+		},
+		{
+			name: "invalid value",
+			config: `
+[default_extra_specs]
+tenancy = "shared"
+`,
+			errString: "invalid default_extra_specs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.Config
+			_, err := toml.Decode(tt.config, &cfg)
+			require.NoError(t, err)
+			err = ValidateDefaultExtraSpecs(&cfg)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestGetRunnerSpecFromBootstrapParamsWithDefaults(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("x64"),
+			DownloadURL:  aws.String("https://github.com/actions/runner/releases/download/v2.317.0/actions-runner-linux-x64-2.317.0.tar.gz"),
+			Filename:     aws.String("actions-runner-linux-x64-2.317.0.tar.gz"),
+		}, nil
+	}
+	cfg := &config.Config{
+		SubnetID: "subnet_id",
+		Region:   "us-east-1",
+		DefaultExtraSpecs: map[string]any{
+			"ssh_key_name":    "default-key",
+			"disable_updates": true,
+		},
+	}
+
+	runnerSpec, err := GetRunnerSpecFromBootstrapParams(cfg, params.BootstrapInstance{Name: "mock-name", ExtraSpecs: json.RawMessage(`{"ssh_key_name": "pool-key"}`)}, "controller_id")
+	require.NoError(t, err)
+	require.Equal(t, "pool-key", *runnerSpec.SSHKeyName)
+	require.True(t, runnerSpec.DisableUpdates)
+	require.JSONEq(t, `{"ssh_key_name": "pool-key", "disable_updates": true}`, string(runnerSpec.BootstrapParams.ExtraSpecs))
+
+	// The default ssh_key_name would fail validation together with the
+	// ephemeral_ssh_key of the pool.
+	runnerSpec, err = GetRunnerSpecFromBootstrapParams(cfg, params.BootstrapInstance{Name: "mock-name", ExtraSpecs: json.RawMessage(`{"ephemeral_ssh_key": true}`)}, "controller_id")
+	require.NoError(t, err)
+	require.Nil(t, runnerSpec.SSHKeyName)
+	require.True(t, runnerSpec.EphemeralSSHKey)
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// instanceStoreScriptName is the name of the pre-install script that mounts
+// instance store volumes. Pre-install scripts run in lexical order, so this
+// one runs before any script set in the extra specs that may use the mounts.
+const instanceStoreScriptName = "00-garm-instance-store"
+
+// InstanceStoreVolume maps an instance store volume of the instance type to
+// a device.
+type InstanceStoreVolume struct {
+	VirtualName string `json:"virtual_name" jsonschema:"required,pattern=^ephemeral[0-9]+$,description=The virtual name of the volume. The first instance store volume is ephemeral0."`
+	DeviceName  string `json:"device_name" jsonschema:"required,pattern=^/dev/[a-z0-9]+$,description=The device name under which the volume is attached (for example /dev/sdb)."`
+	MountPoint  string `json:"mount_point,omitempty" jsonschema:"pattern=^/[A-Za-z0-9_./-]+$,description=If set\\, the volume is formatted and mounted here on boot. Only supported on Linux."`
+	Filesystem  string `json:"filesystem,omitempty" jsonschema:"enum=ext4,enum=xfs,description=The filesystem the volume is formatted with. Defaults to ext4."`
+}
+
+// index returns the number in the virtual name of the volume.
+func (v InstanceStoreVolume) index() string {
+	return strings.TrimPrefix(v.VirtualName, "ephemeral")
+}
+
+const instanceStoreScriptHeader = `#!/bin/bash
+set -e
+
+# Nitro instances expose all instance store volumes as NVMe devices, no matter
+# how they are mapped. Xen instances use the mapped device names.
+mapfile -t NVME_DISKS < <(lsblk -dpno NAME,MODEL | awk '/Amazon EC2 NVMe Instance Storage/ {print $1}' | sort -V)
+
+mount_instance_store() {
+	local index=$1 device=$2 mount_point=$3 fs=$4
+	if [ ${#NVME_DISKS[@]} -gt 0 ]; then
+		device=${NVME_DISKS[$index]}
+	elif [ ! -b "$device" ]; then
+		device=/dev/xvd${device#/dev/sd}
+	fi
+	if [ -z "$device" ] || [ ! -b "$device" ]; then
+		echo "instance store volume ephemeral$index not found" >&2
+		return 1
+	fi
+
+	case "$fs" in
+		xfs) mkfs.xfs -f "$device" ;;
+		*) mkfs.ext4 -F "$device" ;;
+	esac
+	mkdir -p "$mount_point"
+	mount -t "$fs" "$device" "$mount_point"
+	echo "$device $mount_point $fs defaults,nofail 0 2" >> /etc/fstab
+}
+
+`
+
+// instanceStoreScript returns the script that formats and mounts the
+// instance store volumes that have a mount point, or nil if there are none.
+func (r *RunnerSpec) instanceStoreScript() []byte {
+	var script strings.Builder
+	for _, volume := range r.InstanceStoreVolumes {
+		if volume.MountPoint == "" {
+			continue
+		}
+		filesystem := volume.Filesystem
+		if filesystem == "" {
+			filesystem = "ext4"
+		}
+		// All values are restricted by the schema to characters that
+		// need no quoting.
+		fmt.Fprintf(&script, "mount_instance_store %s %s %s %s\n", volume.index(), volume.DeviceName, volume.MountPoint, filesystem)
+	}
+	if script.Len() == 0 {
+		return nil
+	}
+	return []byte(instanceStoreScriptHeader + script.String())
+}
+
+// withInstanceStoreScript returns the bootstrap params with the instance
+// store script added to the pre_install_scripts extra spec, which
+// garm-provider-common turns into cloud-init run commands.
+func (r *RunnerSpec) withInstanceStoreScript(bootstrapParams params.BootstrapInstance) (params.BootstrapInstance, error) {
+	return withPreInstallScript(bootstrapParams, instanceStoreScriptName, r.instanceStoreScript())
+}
+
+// withMountScripts returns the bootstrap params with the scripts that mount
+// the instance store and shared volumes added.
+func (r *RunnerSpec) withMountScripts(bootstrapParams params.BootstrapInstance) (params.BootstrapInstance, error) {
+	bootstrapParams, err := r.withInstanceStoreScript(bootstrapParams)
+	if err != nil {
+		return params.BootstrapInstance{}, err
+	}
+	return withPreInstallScript(bootstrapParams, sharedVolumeScriptName, r.sharedVolumeScript())
+}
+
+// withPreInstallScript returns the bootstrap params with the script added to
+// the pre_install_scripts extra spec under the given name. Nil scripts are
+// not added.
+func withPreInstallScript(bootstrapParams params.BootstrapInstance, name string, script []byte) (params.BootstrapInstance, error) {
+	if script == nil {
+		return bootstrapParams, nil
+	}
+
+	specs := map[string]json.RawMessage{}
+	if len(bootstrapParams.ExtraSpecs) > 0 {
+		if err := json.Unmarshal(bootstrapParams.ExtraSpecs, &specs); err != nil {
+			return params.BootstrapInstance{}, fmt.Errorf("failed to decode extra specs: %w", err)
+		}
+	}
+
+	scripts := map[string][]byte{}
+	if raw, ok := specs["pre_install_scripts"]; ok {
+		if err := json.Unmarshal(raw, &scripts); err != nil {
+			return params.BootstrapInstance{}, fmt.Errorf("failed to decode pre_install_scripts: %w", err)
+		}
+	}
+	scripts[name] = script
+
+	raw, err := json.Marshal(scripts)
+	if err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to encode pre_install_scripts: %w", err)
+	}
+	specs["pre_install_scripts"] = raw
+
+	extraSpecs, err := json.Marshal(specs)
+	if err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to encode extra specs: %w", err)
+	}
+	bootstrapParams.ExtraSpecs = extraSpecs
+	return bootstrapParams, nil
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceStoreScript(t *testing.T) {
+	spec := &RunnerSpec{
+		InstanceStoreVolumes: []InstanceStoreVolume{
+			{VirtualName: "ephemeral0", DeviceName: "/dev/sdb", MountPoint: "/mnt/scratch"},
+			{VirtualName: "ephemeral1", DeviceName: "/dev/sdc"},
+			{VirtualName: "ephemeral2", DeviceName: "/dev/sdd", MountPoint: "/var/lib/docker", Filesystem: "xfs"},
+		},
+	}
+
+	script := string(spec.instanceStoreScript())
+	require.Contains(t, script, "mount_instance_store 0 /dev/sdb /mnt/scratch ext4\n")
+	require.Contains(t, script, "mount_instance_store 2 /dev/sdd /var/lib/docker xfs\n")
+	require.NotContains(t, script, "/dev/sdc")
+
+	spec.InstanceStoreVolumes = spec.InstanceStoreVolumes[1:2]
+	require.Nil(t, spec.instanceStoreScript())
+}
+
+func TestWithInstanceStoreScript(t *testing.T) {
+	spec := &RunnerSpec{
+		InstanceStoreVolumes: []InstanceStoreVolume{
+			{VirtualName: "ephemeral0", DeviceName: "/dev/sdb", MountPoint: "/mnt/scratch"},
+		},
+	}
+	userScript := base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\necho hello"))
+	bootstrapParams := params.BootstrapInstance{
+		Name:       "mock-name",
+		OSType:     params.Linux,
+		ExtraSpecs: json.RawMessage(`{"extra_context": {"key": "value"}, "pre_install_scripts": {"10-hello": "` + userScript + `"}}`),
+	}
+
+	withScript, err := spec.withInstanceStoreScript(bootstrapParams)
+	require.NoError(t, err)
+
+	specs, err := cloudconfig.GetSpecs(withScript)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key": "value"}, specs.ExtraContext)
+	require.Equal(t, "#!/bin/bash\necho hello", string(specs.PreInstallScripts["10-hello"]))
+	require.Equal(t, spec.instanceStoreScript(), specs.PreInstallScripts[instanceStoreScriptName])
+}
+
+func TestComposeUserDataWithInstanceStore(t *testing.T) {
+	spec := &RunnerSpec{
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("https://example.com/runner.tar.gz"),
+			Filename:     aws.String("runner.tar.gz"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "mock-name",
+			OSType: params.Linux,
+		},
+		InstanceStoreVolumes: []InstanceStoreVolume{
+			{VirtualName: "ephemeral0", DeviceName: "/dev/sdb", MountPoint: "/mnt/scratch"},
+		},
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(udata)
+	require.NoError(t, err)
+	require.Contains(t, string(decoded), "/garm-pre-install/"+instanceStoreScriptName)
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cloudbase/garm-provider-common/defaults"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// preinstalledRunnerScriptName is the name of the pre-install script that
+// puts the pre-installed runner in place. It runs after the scripts that
+// mount volumes, so the runner may be on one of them.
+const preinstalledRunnerScriptName = "02-garm-preinstalled-runner"
+
+const (
+	// DefaultLinuxRunnerPreinstalledPath is where the runner is looked
+	// for on Linux when runner_preinstalled_path is not set.
+	DefaultLinuxRunnerPreinstalledPath = "/opt/cache/actions-runner/latest"
+	// DefaultWindowsRunnerPreinstalledPath is where the runner is looked
+	// for on Windows when runner_preinstalled_path is not set. It is where
+	// the install script looks for a runner to use.
+	DefaultWindowsRunnerPreinstalledPath = `C:\actions-runner`
+)
+
+var (
+	linuxRunnerPathRe   = regexp.MustCompile(`^/[A-Za-z0-9_./-]+$`)
+	windowsRunnerPathRe = regexp.MustCompile(`^[A-Za-z]:\\[A-Za-z0-9_.\\ -]*$`)
+)
+
+// GetRunnerPreinstalledPath returns where the runner is installed in the
+// image, or the default for the OS type.
+func (r *RunnerSpec) GetRunnerPreinstalledPath() string {
+	if r.RunnerPreinstalledPath != "" {
+		return r.RunnerPreinstalledPath
+	}
+	if r.BootstrapParams.OSType == params.Windows {
+		return DefaultWindowsRunnerPreinstalledPath
+	}
+	return DefaultLinuxRunnerPreinstalledPath
+}
+
+// validateRunnerPreinstalledPath checks that the path is absolute, and only
+// holds characters that need no quoting in the scripts it is used in.
+func (r *RunnerSpec) validateRunnerPreinstalledPath() error {
+	path := r.GetRunnerPreinstalledPath()
+	pathRe := linuxRunnerPathRe
+	if r.BootstrapParams.OSType == params.Windows {
+		pathRe = windowsRunnerPathRe
+	}
+	if !pathRe.MatchString(path) {
+		return fmt.Errorf("invalid runner_preinstalled_path %q", path)
+	}
+	return nil
+}
+
+// The install script of garm-provider-common skips downloading the runner if
+// there is one in the home of the runner user. The runner is copied rather
+// than linked, as configuring it writes to its directory, which may be on a
+// read-only volume. The script is POSIX, so it also runs on images without
+// bash.
+const preinstalledRunnerScriptTemplate = `#!/bin/sh
+set -e
+
+RUNNER_PATH=%[1]s
+RUN_HOME=/home/%[2]s/actions-runner
+
+if [ ! -x "$RUNNER_PATH/config.sh" ]; then
+	echo "no runner found in $RUNNER_PATH, it will be downloaded" >&2
+	exit 1
+fi
+if [ "$RUNNER_PATH" != "$RUN_HOME" ] && [ ! -e "$RUN_HOME" ]; then
+	cp -a "$RUNNER_PATH" "$RUN_HOME"
+	chown -R %[2]s:%[2]s "$RUN_HOME"
+fi
+`
+
+// preinstalledRunnerScript returns the script that puts the pre-installed
+// runner where the install script looks for it, or nil if the runner is not
+// pre-installed.
+func (r *RunnerSpec) preinstalledRunnerScript() []byte {
+	if !r.RunnerPreinstalled {
+		return nil
+	}
+	return []byte(fmt.Sprintf(preinstalledRunnerScriptTemplate, r.GetRunnerPreinstalledPath(), defaults.DefaultUser))
+}
+
+// withPreinstalledRunnerScript returns the bootstrap params with the script
+// that puts the pre-installed runner in place added to the
+// pre_install_scripts extra spec.
+func (r *RunnerSpec) withPreinstalledRunnerScript(bootstrapParams params.BootstrapInstance) (params.BootstrapInstance, error) {
+	// On Windows, the script is added to the install script instead.
+	if bootstrapParams.OSType != params.Linux {
+		return bootstrapParams, nil
+	}
+	return withPreInstallScript(bootstrapParams, preinstalledRunnerScriptName, r.preinstalledRunnerScript())
+}
+
+// windowsPreinstalledRunnerScript returns the PowerShell that links the
+// pre-installed runner to where the install script looks for it, or an
+// empty string if there is nothing to link.
+func (r *RunnerSpec) windowsPreinstalledRunnerScript() string {
+	path := r.GetRunnerPreinstalledPath()
+	if !r.RunnerPreinstalled || path == DefaultWindowsRunnerPreinstalledPath {
+		return ""
+	}
+	return fmt.Sprintf("if ((Test-Path '%[1]s') -and -not (Test-Path '%[2]s')) { New-Item -ItemType Junction -Path '%[2]s' -Target '%[1]s' | Out-Null }", path, DefaultWindowsRunnerPreinstalledPath)
+}
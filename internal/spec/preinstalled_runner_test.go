@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreinstalledRunnerScript(t *testing.T) {
+	spec := &RunnerSpec{}
+	require.Nil(t, spec.preinstalledRunnerScript())
+
+	spec.RunnerPreinstalled = true
+	script := string(spec.preinstalledRunnerScript())
+	require.Contains(t, script, "RUNNER_PATH=/opt/cache/actions-runner/latest\n")
+	require.Contains(t, script, "RUN_HOME=/home/runner/actions-runner\n")
+
+	spec.RunnerPreinstalledPath = "/mnt/mirror/actions-runner"
+	script = string(spec.preinstalledRunnerScript())
+	require.Contains(t, script, "RUNNER_PATH=/mnt/mirror/actions-runner\n")
+}
+
+func TestWithPreinstalledRunnerScript(t *testing.T) {
+	spec := &RunnerSpec{
+		RunnerPreinstalled: true,
+	}
+
+	withScript, err := spec.withPreInstallScripts(params.BootstrapInstance{
+		Name:   "mock-name",
+		OSType: params.Linux,
+	})
+	require.NoError(t, err)
+
+	specs, err := cloudconfig.GetSpecs(withScript)
+	require.NoError(t, err)
+	require.Equal(t, spec.preinstalledRunnerScript(), specs.PreInstallScripts[preinstalledRunnerScriptName])
+}
+
+func TestComposeUserDataPreinstalledRunnerWindows(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "default path",
+			expected: "Param()\r\nWrite-Host install",
+		},
+		{
+			name:     "custom path",
+			path:     `D:\runner`,
+			expected: "if ((Test-Path 'D:\\runner') -and -not (Test-Path 'C:\\actions-runner')) { New-Item -ItemType Junction -Path 'C:\\actions-runner' -Target 'D:\\runner' | Out-Null }\r\n& {\r\nParam()\r\nWrite-Host install\r\n}\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "mock-name",
+					OSType:     params.Windows,
+					ExtraSpecs: []byte(fmt.Sprintf(`{"runner_install_template": %q}`, base64.StdEncoding.EncodeToString([]byte("Param()\r\nWrite-Host install")))),
+				},
+				RunnerInstallTemplateFormat: TemplateFormatRaw,
+				RunnerPreinstalled:          true,
+				RunnerPreinstalledPath:      tt.path,
+			}
+
+			udata, err := spec.ComposeUserData()
+			require.NoError(t, err)
+			decoded, err := base64.StdEncoding.DecodeString(udata)
+			require.NoError(t, err)
+			require.Equal(t, "<powershell>"+tt.expected+"</powershell>", string(decoded))
+		})
+	}
+}
+
+func TestValidateRunnerPreinstalledPath(t *testing.T) {
+	tests := []struct {
+		osType    params.OSType
+		path      string
+		errString string
+	}{
+		{osType: params.Linux, path: ""},
+		{osType: params.Linux, path: "/opt/runner"},
+		{osType: params.Linux, path: "opt/runner", errString: `invalid runner_preinstalled_path "opt/runner"`},
+		{osType: params.Linux, path: "/opt/runner; reboot", errString: `invalid runner_preinstalled_path "/opt/runner; reboot"`},
+		{osType: params.Windows, path: ""},
+		{osType: params.Windows, path: `D:\Program Files\runner`},
+		{osType: params.Windows, path: "/opt/runner", errString: `invalid runner_preinstalled_path "/opt/runner"`},
+		{osType: params.Windows, path: `D:\runner'`, errString: `invalid runner_preinstalled_path "D:\\runner'"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join([]string{string(tt.osType), tt.path}, " "), func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams:        params.BootstrapInstance{OSType: tt.osType},
+				RunnerPreinstalledPath: tt.path,
+			}
+			err := spec.validateRunnerPreinstalledPath()
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+)
+
+// sharedVolumeScriptName is the name of the pre-install script that mounts
+// the shared volume. It runs after the instance store script, and before
+// any script set in the extra specs that may use the mount.
+const sharedVolumeScriptName = "01-garm-shared-volume"
+
+// DefaultSharedVolumeDeviceName is the device name under which the shared
+// volume is attached when device_name is not set.
+const DefaultSharedVolumeDeviceName = "/dev/sdg"
+
+// SharedVolume is an existing EBS volume with multi-attach enabled that is
+// attached to every instance of a pool, and mounted read-only.
+type SharedVolume struct {
+	VolumeID   string `json:"volume_id" jsonschema:"required,pattern=^vol-[0-9a-f]+$,description=The ID of an io1 or io2 volume with multi-attach enabled. Instances are only created in subnets in the availability zone of the volume."`
+	DeviceName string `json:"device_name,omitempty" jsonschema:"pattern=^/dev/[a-z0-9]+$,description=The device name under which the volume is attached. Defaults to /dev/sdg."`
+	MountPoint string `json:"mount_point" jsonschema:"required,pattern=^/[A-Za-z0-9_./-]+$,description=Where the volume is mounted read-only on boot."`
+	Filesystem string `json:"filesystem,omitempty" jsonschema:"enum=ext4,enum=xfs,description=The filesystem of the volume. Defaults to ext4."`
+}
+
+// GetDeviceName returns the device name of the volume, or the default.
+func (v SharedVolume) GetDeviceName() string {
+	if v.DeviceName == "" {
+		return DefaultSharedVolumeDeviceName
+	}
+	return v.DeviceName
+}
+
+const sharedVolumeScriptTemplate = `#!/bin/bash
+set -e
+
+# The shared volume is attached once the instance is running, so it may not be
+# there yet. Nitro instances expose EBS volumes as NVMe devices, with the
+# volume ID as serial number. Xen instances use the mapped device names.
+wait_for_shared_volume() {
+	local volume_id=$1 device=$2
+	local nvme=/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_${volume_id/-/}
+	for _ in $(seq 300); do
+		for candidate in "$nvme" "$device" "/dev/xvd${device#/dev/sd}"; do
+			if [ -b "$candidate" ]; then
+				echo "$candidate"
+				return 0
+			fi
+		done
+		sleep 1
+	done
+	echo "shared volume $volume_id was not attached" >&2
+	return 1
+}
+
+device=$(wait_for_shared_volume %[1]s %[2]s)
+mkdir -p %[3]s
+# Other instances have the volume attached too, so it must never be written
+# to, not even to replay the journal.
+mount -t %[4]s -o %[5]s "$device" %[3]s
+echo "$device %[3]s %[4]s %[5]s,nofail 0 0" >> /etc/fstab
+`
+
+// sharedVolumeScript returns the script that mounts the shared volume, or
+// nil if there is none.
+func (r *RunnerSpec) sharedVolumeScript() []byte {
+	volume := r.SharedVolume
+	if volume == nil {
+		return nil
+	}
+
+	filesystem := volume.Filesystem
+	options := "ro,noload"
+	if filesystem == "xfs" {
+		options = "ro,norecovery"
+	} else {
+		filesystem = "ext4"
+	}
+	// All values are restricted by the schema to characters that need no
+	// quoting.
+	return []byte(fmt.Sprintf(sharedVolumeScriptTemplate, volume.VolumeID, volume.GetDeviceName(), volume.MountPoint, filesystem, options))
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedVolumeScript(t *testing.T) {
+	spec := &RunnerSpec{}
+	require.Nil(t, spec.sharedVolumeScript())
+
+	spec.SharedVolume = &SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"}
+	script := string(spec.sharedVolumeScript())
+	require.Contains(t, script, "device=$(wait_for_shared_volume vol-0123456789abcdef0 /dev/sdg)\n")
+	require.Contains(t, script, "mount -t ext4 -o ro,noload \"$device\" /mnt/mirror\n")
+
+	spec.SharedVolume = &SharedVolume{VolumeID: "vol-0123456789abcdef0", DeviceName: "/dev/sdh", MountPoint: "/mnt/mirror", Filesystem: "xfs"}
+	script = string(spec.sharedVolumeScript())
+	require.Contains(t, script, "device=$(wait_for_shared_volume vol-0123456789abcdef0 /dev/sdh)\n")
+	require.Contains(t, script, "mount -t xfs -o ro,norecovery \"$device\" /mnt/mirror\n")
+}
+
+func TestWithMountScripts(t *testing.T) {
+	spec := &RunnerSpec{
+		InstanceStoreVolumes: []InstanceStoreVolume{
+			{VirtualName: "ephemeral0", DeviceName: "/dev/sdb", MountPoint: "/mnt/scratch"},
+		},
+		SharedVolume: &SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"},
+	}
+
+	withScripts, err := spec.withMountScripts(params.BootstrapInstance{
+		Name:   "mock-name",
+		OSType: params.Linux,
+	})
+	require.NoError(t, err)
+
+	specs, err := cloudconfig.GetSpecs(withScripts)
+	require.NoError(t, err)
+	require.Equal(t, spec.instanceStoreScript(), specs.PreInstallScripts[instanceStoreScriptName])
+	require.Equal(t, spec.sharedVolumeScript(), specs.PreInstallScripts[sharedVolumeScriptName])
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Shells the Linux install script can be run with.
+const (
+	// BootstrapShellBash is the shell garm-provider-common writes the
+	// install script for. This is the default.
+	BootstrapShellBash = "bash"
+	// BootstrapShellSh is the POSIX shell of the image.
+	BootstrapShellSh = "sh"
+	// BootstrapShellAsh is the shell of busybox, used by Alpine.
+	BootstrapShellAsh = "ash"
+)
+
+// bootstrapShellPath returns the path of the bootstrap shell, or an empty
+// string if the install script is left to bash.
+func (r *RunnerSpec) bootstrapShellPath() string {
+	if r.BootstrapShell == "" || r.BootstrapShell == BootstrapShellBash {
+		return ""
+	}
+	return "/bin/" + r.BootstrapShell
+}
+
+// withBootstrapShell returns the install script with its shebang replaced
+// by the bootstrap shell.
+func (r *RunnerSpec) withBootstrapShell(installScript []byte) []byte {
+	shell := r.bootstrapShellPath()
+	if shell == "" {
+		return installScript
+	}
+	if bytes.HasPrefix(installScript, []byte("#!")) {
+		_, rest, _ := bytes.Cut(installScript, []byte("\n"))
+		installScript = rest
+	}
+	return append([]byte("#!"+shell+"\n"), installScript...)
+}
+
+// withBootstrapShellUser returns the cloud-init config with the login shell
+// of the runner user set to the bootstrap shell. The install script is run
+// with su -l, which needs the login shell to exist.
+func (r *RunnerSpec) withBootstrapShellUser(cloudConfig string) (string, error) {
+	shell := r.bootstrapShellPath()
+	if shell == "" {
+		return cloudConfig, nil
+	}
+
+	return editCloudConfig(cloudConfig, func(root *yaml.Node) error {
+		systemInfo := mappingValue(root, "system_info")
+		if systemInfo == nil || systemInfo.Kind != yaml.MappingNode {
+			return fmt.Errorf("cloud config has no system_info")
+		}
+		defaultUser := mappingValue(systemInfo, "default_user")
+		if defaultUser == nil || defaultUser.Kind != yaml.MappingNode {
+			return fmt.Errorf("cloud config has no default_user")
+		}
+		if value := mappingValue(defaultUser, "shell"); value != nil {
+			value.Value = shell
+			return nil
+		}
+		defaultUser.Content = append(defaultUser.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "shell"},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: shell},
+		)
+		return nil
+	})
+}
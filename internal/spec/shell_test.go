@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWithBootstrapShell(t *testing.T) {
+	tests := []struct {
+		shell    string
+		script   string
+		expected string
+	}{
+		{shell: "", script: "#!/bin/bash\necho hi\n", expected: "#!/bin/bash\necho hi\n"},
+		{shell: BootstrapShellBash, script: "#!/bin/bash\necho hi\n", expected: "#!/bin/bash\necho hi\n"},
+		{shell: BootstrapShellSh, script: "#!/bin/bash\necho hi\n", expected: "#!/bin/sh\necho hi\n"},
+		{shell: BootstrapShellAsh, script: "echo hi\n", expected: "#!/bin/ash\necho hi\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			spec := &RunnerSpec{BootstrapShell: tt.shell}
+			require.Equal(t, tt.expected, string(spec.withBootstrapShell([]byte(tt.script))))
+		})
+	}
+}
+
+func TestComposeUserDataBootstrapShell(t *testing.T) {
+	spec := &RunnerSpec{
+		BootstrapParams: params.BootstrapInstance{
+			Name:       "mock-name",
+			OSType:     params.Linux,
+			ExtraSpecs: []byte(fmt.Sprintf(`{"runner_install_template": %q}`, base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\necho install\n")))),
+		},
+		RunnerInstallTemplateFormat: TemplateFormatRaw,
+		BootstrapShell:              BootstrapShellAsh,
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(udata)
+	require.NoError(t, err)
+
+	var cloudConfig struct {
+		SystemInfo struct {
+			DefaultUser struct {
+				Shell string `yaml:"shell"`
+			} `yaml:"default_user"`
+		} `yaml:"system_info"`
+		WriteFiles []struct {
+			Path    string `yaml:"path"`
+			Content string `yaml:"content"`
+		} `yaml:"write_files"`
+	}
+	require.NoError(t, yaml.Unmarshal(decoded, &cloudConfig))
+	require.Equal(t, "/bin/ash", cloudConfig.SystemInfo.DefaultUser.Shell)
+
+	var installScript string
+	for _, file := range cloudConfig.WriteFiles {
+		if file.Path == "/install_runner.sh" {
+			content, err := base64.StdEncoding.DecodeString(file.Content)
+			require.NoError(t, err)
+			installScript = string(content)
+		}
+	}
+	require.Equal(t, "#!/bin/ash\necho install\n", installScript)
+}
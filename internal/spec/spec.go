@@ -19,6 +19,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/cloudbase/garm-provider-aws/config"
 	"github.com/cloudbase/garm-provider-common/cloudconfig"
@@ -28,6 +32,28 @@ import (
 	"github.com/xeipuuv/gojsonschema"
 )
 
+// DefaultCacheDeviceName is the device name under which the cache volume is
+// attached when cache_device_name is not set.
+const DefaultCacheDeviceName = "/dev/sdf"
+
+const (
+	// DefaultKeepOnFailureTTL is how long failed instances are kept when
+	// keep_on_failure_ttl is not set.
+	DefaultKeepOnFailureTTL = 24 * time.Hour
+	// MaxKeepOnFailureTTL is the longest failed instances can be kept.
+	MaxKeepOnFailureTTL = 7 * 24 * time.Hour
+)
+
+// How instances are launched.
+const (
+	// LaunchModeRunInstances launches instances with RunInstances, trying
+	// the subnets one after another. This is the default.
+	LaunchModeRunInstances = "run_instances"
+	// LaunchModeFleet launches instances with an EC2 Fleet in instant
+	// mode, which tries all subnets in a single request.
+	LaunchModeFleet = "fleet"
+)
+
 type ToolFetchFunc func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error)
 
 var DefaultToolFetch ToolFetchFunc = util.GetTools
@@ -42,6 +68,15 @@ func generateJSONSchema() *jsonschema.Schema {
 	return schema
 }
 
+// ExtraSpecsJSONSchema returns the JSON schema of the extra specs of pools.
+func ExtraSpecsJSONSchema() (string, error) {
+	schema, err := json.MarshalIndent(generateJSONSchema(), "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode extra specs schema: %w", err)
+	}
+	return string(schema), nil
+}
+
 func jsonSchemaValidation(schema json.RawMessage) error {
 	jsonSchema := generateJSONSchema()
 	schemaLoader := gojsonschema.NewGoLoader(jsonSchema)
@@ -72,55 +107,294 @@ func newExtraSpecsFromBootstrapData(data params.BootstrapInstance) (*extraSpecs,
 	return spec, nil
 }
 
+// GetEnvironment returns the name of the environment of the provider config
+// the extra specs select, or an empty string if they don't select one.
+func GetEnvironment(cfg *config.Config, data params.BootstrapInstance) (string, error) {
+	data, err := WithDefaultExtraSpecs(cfg, data)
+	if err != nil {
+		return "", fmt.Errorf("error loading extra specs: %w", err)
+	}
+	extraSpecs, err := newExtraSpecsFromBootstrapData(data)
+	if err != nil {
+		return "", fmt.Errorf("error loading extra specs: %w", err)
+	}
+	if extraSpecs.Environment == nil {
+		return "", nil
+	}
+	return *extraSpecs.Environment, nil
+}
+
+// SSMDocument is an SSM document that is run on new instances once they are
+// running.
+type SSMDocument struct {
+	Name       string              `json:"name" jsonschema:"required,description=The name or ARN of the SSM document."`
+	Parameters map[string][]string `json:"parameters,omitempty" jsonschema:"description=The parameters passed to the document."`
+}
+
+// BlockDeviceMapping describes an additional EBS volume attached to new
+// instances.
+type BlockDeviceMapping struct {
+	DeviceName          string  `json:"device_name" jsonschema:"required,description=The device name under which the volume is attached (for example /dev/sdg)."`
+	VolumeSize          *int32  `json:"volume_size,omitempty" jsonschema:"minimum=1,description=The size of the volume in GiB."`
+	VolumeType          *string `json:"volume_type,omitempty" jsonschema:"enum=gp2,enum=gp3,enum=io1,enum=io2,enum=st1,enum=sc1,enum=standard,description=The EBS volume type. Defaults to gp3."`
+	Iops                *int32  `json:"iops,omitempty" jsonschema:"minimum=0,description=The provisioned IOPS. Only valid for gp3\\, io1 and io2 volumes."`
+	Throughput          *int32  `json:"throughput,omitempty" jsonschema:"minimum=0,description=The throughput in MiB/s. Only valid for gp3 volumes."`
+	Encrypted           *bool   `json:"encrypted,omitempty" jsonschema:"description=Whether the volume is encrypted."`
+	DeleteOnTermination *bool   `json:"delete_on_termination,omitempty" jsonschema:"description=Whether the volume is deleted when the instance is terminated. Defaults to true."`
+}
+
+// MetadataOptions configures the instance metadata service of new instances.
+type MetadataOptions struct {
+	HttpTokens              *string `json:"http_tokens,omitempty" jsonschema:"enum=required,enum=optional,description=Set to required to only allow IMDSv2 requests\\, which need a session token."`
+	HttpPutResponseHopLimit *int32  `json:"http_put_response_hop_limit,omitempty" jsonschema:"minimum=1,maximum=64,description=The number of network hops a metadata session token can travel. Containers that use the metadata service through a bridge network (for example in docker builds) need at least 2."`
+	HttpEndpoint            *string `json:"http_endpoint,omitempty" jsonschema:"enum=enabled,enum=disabled,description=Whether the metadata service is enabled. It is needed to read the user data\\, so it can't be disabled."`
+}
+
 type extraSpecs struct {
-	SubnetID        *string  `json:"subnet_id,omitempty" jsonschema:"pattern=^subnet-[0-9a-fA-F]{17}$"`
-	SSHKeyName      *string  `json:"ssh_key_name,omitempty" jsonschema:"description=The name of the Key Pair to use for the instance."`
-	DisableUpdates  *bool    `json:"disable_updates,omitempty" jsonschema:"description=Disable automatic updates on the VM."`
-	EnableBootDebug *bool    `json:"enable_boot_debug,omitempty" jsonschema:"description=Enable boot debug on the VM"`
-	ExtraPackages   []string `json:"extra_packages,omitempty" jsonschema:"description=Extra packages to install on the VM"`
+	SubnetID                    *string               `json:"subnet_id,omitempty"`
+	FallbackSubnetIDs           []string              `json:"fallback_subnet_ids,omitempty" jsonschema:"description=Subnets to try in order when EC2 reports insufficient capacity in the primary subnet\\, or that it does not offer the flavor in its availability zone. Entries prefixed with ssm: are read from SSM Parameter Store."`
+	SecurityGroupIDs            []string              `json:"security_group_ids,omitempty" jsonschema:"description=The security group IDs to attach to the instance. Entries prefixed with ssm: are read from SSM Parameter Store."`
+	SecurityGroupNames          []string              `json:"security_group_names,omitempty" jsonschema:"description=Names of security groups to attach to the instance. The names are resolved to IDs in the VPC of the subnet when the instance is created."`
+	SecurityGroupTags           map[string]string     `json:"security_group_tags,omitempty" jsonschema:"description=Tags used to select security groups to attach to the instance. All security groups in the VPC of the subnet that have all of these tags are attached."`
+	SSHKeyName                  *string               `json:"ssh_key_name,omitempty" jsonschema:"description=The name of the Key Pair to use for the instance."`
+	EphemeralSSHKey             *bool                 `json:"ephemeral_ssh_key,omitempty" jsonschema:"description=Generate a key pair for every instance and delete it along with the instance\\, instead of using a shared key pair. Can't be used with ssh_key_name."`
+	SerialConsole               *bool                 `json:"serial_console,omitempty" jsonschema:"description=Make sure the EC2 serial console can be used to reach the instance\\, even without network access. Needs a Nitro based instance type."`
+	DisableUpdates              *bool                 `json:"disable_updates,omitempty" jsonschema:"description=Disable automatic updates on the VM."`
+	EnableBootDebug             *bool                 `json:"enable_boot_debug,omitempty" jsonschema:"description=Enable boot debug on the VM"`
+	ExtraPackages               []string              `json:"extra_packages,omitempty" jsonschema:"description=Extra packages to install on the VM"`
+	Tenancy                     *string               `json:"tenancy,omitempty" jsonschema:"enum=default,enum=dedicated,enum=host,description=The tenancy of the instance. Use dedicated to run on single-tenant hardware\\, or host to run on a Dedicated Host."`
+	HostID                      *string               `json:"host_id,omitempty" jsonschema:"pattern=^h-[0-9a-fA-F]+$,description=The ID of the Dedicated Host on which to launch the instance. Implies host tenancy."`
+	HostResourceGroupARN        *string               `json:"host_resource_group_arn,omitempty" jsonschema:"pattern=^arn:aws[a-z-]*:resource-groups:.+$,description=The ARN of the host resource group in which to launch the instance. Implies host tenancy."`
+	SSMDocuments                []SSMDocument         `json:"ssm_documents,omitempty" jsonschema:"description=SSM documents to run on the instance through SendCommand once it is running. Requires the SSM agent in the image and an instance profile that allows the instance to register with SSM."`
+	Encrypted                   *bool                 `json:"encrypted,omitempty" jsonschema:"description=Encrypt the root volume of the instance. Also applies to additional volumes that don't set encrypted themselves."`
+	KMSKeyID                    *string               `json:"kms_key_id,omitempty" jsonschema:"description=The ID\\, ARN or alias of the KMS key used to encrypt the volumes. Implies encrypted. Defaults to the AWS managed key for EBS."`
+	BlockDeviceMappings         []BlockDeviceMapping  `json:"block_device_mappings,omitempty" jsonschema:"description=Additional EBS volumes to attach to the instance\\, for example as scratch space or for docker."`
+	InstanceStoreVolumes        []InstanceStoreVolume `json:"instance_store_volumes,omitempty" jsonschema:"description=Instance store volumes of the instance type to attach\\, for example as fast local scratch space on d\\, i3 or i4i instances."`
+	SnapshotID                  *string               `json:"snapshot_id,omitempty" jsonschema:"pattern=^snap-[0-9a-fA-F]+$,description=The ID of a snapshot from which the root volume is created instead of the root snapshot of the image. The snapshot must be bootable with the image."`
+	CacheSnapshotID             *string               `json:"cache_snapshot_id,omitempty" jsonschema:"pattern=^snap-[0-9a-fA-F]+$,description=The ID of a snapshot (for example one holding a pre-populated docker layer cache) from which a secondary volume is created and attached to every instance."`
+	CacheDeviceName             *string               `json:"cache_device_name,omitempty" jsonschema:"description=The device name under which the cache volume is attached. Defaults to /dev/sdf."`
+	CachePoolSize               *int32                `json:"cache_pool_size,omitempty" jsonschema:"minimum=1,maximum=100,description=Reuse the cache volumes of terminated instances for new instances instead of deleting them\\, keeping up to this many idle volumes per availability zone. Requires cache_snapshot_id."`
+	Ipv6AddressCount            *int32                `json:"ipv6_address_count,omitempty" jsonschema:"minimum=0,description=The number of IPv6 addresses to assign to the primary network interface. The subnet must have an IPv6 CIDR block. Setting this also enables the IPv6 endpoint of the instance metadata service."`
+	RunnerInstallTemplateFormat *string               `json:"runner_install_template_format,omitempty" jsonschema:"enum=go,enum=jinja,enum=raw,description=The format of the runner_install_template. go (the default) renders it as a Go template. jinja expands jinja variable expressions. raw uses the template as is."`
+	MetadataOptions             *MetadataOptions      `json:"metadata_options,omitempty" jsonschema:"description=Options for the instance metadata service\\, for example to require IMDSv2."`
+	KeepOnFailure               *bool                 `json:"keep_on_failure,omitempty" jsonschema:"description=Keep instances that failed to bootstrap running when GARM deletes them\\, so they can be debugged. They are terminated once keep_on_failure_ttl has passed."`
+	KeepOnFailureTTL            *string               `json:"keep_on_failure_ttl,omitempty" jsonschema:"description=How long failed instances are kept\\, as a Go duration (for example 4h). Defaults to 24h and can't be more than 168h."`
+	MaxRuntime                  *string               `json:"max_runtime,omitempty" jsonschema:"description=Terminate instances that have been running for longer than this\\, as a Go duration (for example 6h)\\, whatever their state in GARM. A backstop for jobs that never release their runner."`
+	EnableHibernation           *bool                 `json:"enable_hibernation,omitempty" jsonschema:"description=Launch instances with hibernation enabled\\, so that they are hibernated instead of stopped when hibernate_on_stop is set in the provider config. Requires encrypted volumes and an instance type that supports hibernation."`
+	SharedVolume                *SharedVolume         `json:"shared_volume,omitempty" jsonschema:"description=An existing multi-attach volume attached to every instance and mounted read-only\\, for example to share a warm mirror of a repository. Only supported on Linux."`
+	SuppressDevices             []string              `json:"suppress_devices,omitempty" jsonschema:"description=Device names of block device mappings defined by the image that are not attached to the instance\\, for example unwanted secondary volumes of vendor AMIs. The root device can't be suppressed."`
+	LicenseSpecifications       []string              `json:"license_specifications,omitempty" jsonschema:"description=ARNs of License Manager license configurations the instance is launched with\\, for example to track BYOL Windows or SQL Server licenses of the image."`
+	UserDataParts               []UserDataPart        `json:"user_data_parts,omitempty" jsonschema:"description=Parts added to the user data of Linux instances\\, like shell scripts\\, boothooks or additional cloud-init configs. The user data is then sent as a multi-part MIME message. Only supported on Linux."`
+	WindowsUserDataWrapper      *string               `json:"windows_user_data_wrapper,omitempty" jsonschema:"enum=powershell,enum=script,description=The tag the user data of Windows instances is wrapped in. powershell (the default) runs the install script with PowerShell. script runs it with cmd.exe\\, so the runner_install_template must be a batch script."`
+	WindowsUserDataPersist      *bool                 `json:"windows_user_data_persist,omitempty" jsonschema:"description=Add <persist>true</persist> to the user data of Windows instances\\, so that EC2Launch runs it on every boot instead of only on the first."`
+	DisableUserData             *bool                 `json:"disable_userdata,omitempty" jsonschema:"description=Don't generate user data\\, for images that bootstrap the runner on their own. Instances are launched with custom_userdata\\, or without user data."`
+	CustomUserData              []byte                `json:"custom_userdata,omitempty" jsonschema:"description=User data sent as is when disable_userdata is set\\, base64 encoded."`
+	BootstrapShell              *string               `json:"bootstrap_shell,omitempty" jsonschema:"enum=bash,enum=sh,enum=ash,description=The shell the install script of Linux runners is run with. Defaults to bash. Other shells need a runner_install_template written for them\\, for example for Alpine images without bash."`
+	RunnerPreinstalled          *bool                 `json:"runner_preinstalled,omitempty" jsonschema:"description=Use the runner installed in the image at runner_preinstalled_path instead of downloading it. The runner is still downloaded if it is missing."`
+	RunnerPreinstalledPath      *string               `json:"runner_preinstalled_path,omitempty" jsonschema:"description=Where the runner is installed in the image. Defaults to /opt/cache/actions-runner/latest on Linux and C:\\actions-runner on Windows."`
+	Environment                 *string               `json:"environment,omitempty" jsonschema:"pattern=^[A-Za-z0-9_-]+$,description=The name of the environment of the provider config in which instances are created. Defaults to the region\\, subnets and credentials of the provider config."`
+	LaunchMode                  *string               `json:"launch_mode,omitempty" jsonschema:"enum=run_instances,enum=fleet,description=How instances are launched. run_instances (the default) tries the subnets one after another. fleet launches them with an EC2 Fleet in instant mode\\, which picks one of the subnets in a single request."`
 	// The Cloudconfig struct from common package
 	cloudconfig.CloudConfigSpec
 }
 
+// JSONSchemaExtend sets the pattern of the subnet settings from the one the
+// provider config is validated with, so that both accept the same IDs.
+func (extraSpecs) JSONSchemaExtend(schema *jsonschema.Schema) {
+	if subnet, ok := schema.Properties.Get("subnet_id"); ok {
+		subnet.Pattern = config.SubnetRefPattern
+	}
+	if fallback, ok := schema.Properties.Get("fallback_subnet_ids"); ok && fallback.Items != nil {
+		fallback.Items.Pattern = config.SubnetRefPattern
+	}
+}
+
 func GetRunnerSpecFromBootstrapParams(cfg *config.Config, data params.BootstrapInstance, controllerID string) (*RunnerSpec, error) {
 	tools, err := DefaultToolFetch(data.OSType, data.OSArch, data.Tools)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tools: %s", err)
 	}
+	if downloadURL := tools.GetDownloadURL(); downloadURL != "" {
+		downloadURL = cfg.SubstituteToolURL(downloadURL)
+		tools.DownloadURL = &downloadURL
+	}
+
+	spec, err := newRunnerSpec(cfg, data, controllerID)
+	if err != nil {
+		return nil, err
+	}
+	spec.Tools = tools
+
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("error validating spec: %w", err)
+	}
+
+	slog.Debug("runner spec",
+		"name", data.Name,
+		"pool_id", data.PoolID,
+		"region", spec.Region,
+		"image", spec.BootstrapParams.Image,
+		"flavor", spec.BootstrapParams.Flavor,
+		"subnet_id", spec.SubnetID,
+		"security_group_ids", spec.SecurityGroupIDs)
+
+	return spec, nil
+}
+
+// ValidatePoolExtraSpecs makes sure instances can be created with the extra
+// specs of a pool, merged with the default extra specs of the config.
+func ValidatePoolExtraSpecs(cfg *config.Config, extraSpecs json.RawMessage) error {
+	spec, err := newRunnerSpec(cfg, params.BootstrapInstance{
+		// Validate needs a name, which pools don't have.
+		Name:       "pool-validation",
+		ExtraSpecs: extraSpecs,
+	}, "")
+	if err != nil {
+		return err
+	}
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("error validating spec: %w", err)
+	}
+	return nil
+}
+
+// PoolSubnets returns the subnets instances of a pool with the given extra
+// specs are created in, in the order they are tried. SSM references are not
+// resolved.
+func PoolSubnets(cfg *config.Config, extraSpecs json.RawMessage) ([]string, error) {
+	spec, err := newRunnerSpec(cfg, params.BootstrapInstance{ExtraSpecs: extraSpecs}, "")
+	if err != nil {
+		return nil, err
+	}
+	var subnets []string
+	for _, subnet := range append([]string{spec.SubnetID}, spec.FallbackSubnetIDs...) {
+		if subnet != "" {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets, nil
+}
 
+// newRunnerSpec returns the spec of the instance, without its tools.
+func newRunnerSpec(cfg *config.Config, data params.BootstrapInstance, controllerID string) (*RunnerSpec, error) {
+	data, err := WithDefaultExtraSpecs(cfg, data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading extra specs: %w", err)
+	}
 	extraSpecs, err := newExtraSpecsFromBootstrapData(data)
 	if err != nil {
 		return nil, fmt.Errorf("error loading extra specs: %w", err)
 	}
 
 	spec := &RunnerSpec{
-		Region:          cfg.Region,
-		ExtraPackages:   extraSpecs.ExtraPackages,
-		Tools:           tools,
-		BootstrapParams: data,
-		SubnetID:        cfg.SubnetID,
-		ControllerID:    controllerID,
+		Region:            cfg.Region,
+		ExtraPackages:     extraSpecs.ExtraPackages,
+		BootstrapParams:   data,
+		SubnetID:          cfg.SubnetID,
+		FallbackSubnetIDs: cfg.FallbackSubnetIDs,
+		SecurityGroupIDs:  cfg.SecurityGroupIDs,
+		CacheDeviceName:   DefaultCacheDeviceName,
+		ControllerID:      controllerID,
 	}
 
+	scripts := bootstrapScripts(cfg, data.OSType)
+	spec.PreBootstrapScript = scripts.Pre
+	spec.PostBootstrapScript = scripts.Post
+
 	spec.MergeExtraSpecs(extraSpecs)
 
-	if err := spec.Validate(); err != nil {
-		return nil, fmt.Errorf("error validating spec: %w", err)
+	if cfg.PrivateOnly {
+		// Updating packages on boot needs the public package mirrors.
+		spec.PrivateOnly = true
+		spec.DisableUpdates = true
 	}
 
 	return spec, nil
 }
 
 type RunnerSpec struct {
-	Region          string
-	DisableUpdates  bool
-	ExtraPackages   []string
-	EnableBootDebug bool
-	Tools           params.RunnerApplicationDownload
-	BootstrapParams params.BootstrapInstance
-	SubnetID        string
-	SSHKeyName      *string
-	ControllerID    string
+	Region            string
+	DisableUpdates    bool
+	ExtraPackages     []string
+	EnableBootDebug   bool
+	Tools             params.RunnerApplicationDownload
+	BootstrapParams   params.BootstrapInstance
+	SubnetID          string
+	FallbackSubnetIDs []string
+	SecurityGroupIDs  []string
+	// SecurityGroupNames and SecurityGroupTags are resolved to IDs and
+	// added to SecurityGroupIDs when the instance is created.
+	SecurityGroupNames []string
+	SecurityGroupTags  map[string]string
+	SSHKeyName         *string
+	// EphemeralSSHKey generates a key pair for every instance.
+	EphemeralSSHKey      bool
+	SerialConsole        bool
+	Ipv6AddressCount     int32
+	Tenancy              string
+	HostID               string
+	HostResourceGroupARN string
+	SSMDocuments         []SSMDocument
+	Encrypted            bool
+	KMSKeyID             string
+	BlockDeviceMappings  []BlockDeviceMapping
+	InstanceStoreVolumes []InstanceStoreVolume
+	// SnapshotID replaces the root snapshot of the image.
+	SnapshotID      string
+	CacheSnapshotID string
+	CacheDeviceName string
+	// CachePoolSize is the number of idle cache volumes kept per
+	// availability zone. If set, cache volumes are attached after launch
+	// and reused instead of being created with every instance.
+	CachePoolSize int32
+	// RunnerInstallTemplateFormat is one of the TemplateFormat constants.
+	RunnerInstallTemplateFormat string
+	MetadataOptions             *MetadataOptions
+	// KeepOnFailure keeps instances that failed to bootstrap for
+	// KeepOnFailureTTL after GARM deletes them.
+	KeepOnFailure    bool
+	KeepOnFailureTTL string
+	// MaxRuntime is how long instances may run before they are terminated,
+	// as a Go duration.
+	MaxRuntime string
+	// EnableHibernation launches instances that can be hibernated.
+	EnableHibernation bool
+	// SharedVolume is attached to every instance after launch.
+	SharedVolume *SharedVolume
+	// SuppressDevices are mappings of the image that are left out.
+	SuppressDevices []string
+	// LicenseSpecifications are the ARNs of the license configurations
+	// instances are launched with.
+	LicenseSpecifications []string
+	// UserDataParts are added to the user data next to the cloud-init
+	// config.
+	UserDataParts []UserDataPart
+	// PreBootstrapScript and PostBootstrapScript are the bootstrap
+	// scripts of the provider config for the OS type of the instance.
+	PreBootstrapScript  string
+	PostBootstrapScript string
+	// WindowsUserDataWrapper is one of the WindowsUserDataWrapper
+	// constants.
+	WindowsUserDataWrapper string
+	// WindowsUserDataPersist makes EC2Launch run the user data on every
+	// boot.
+	WindowsUserDataPersist bool
+	// DisableUserData launches instances with CustomUserData instead of
+	// the generated user data.
+	DisableUserData bool
+	CustomUserData  []byte
+	// BootstrapShell is one of the BootstrapShell constants.
+	BootstrapShell string
+	// RunnerPreinstalled uses the runner installed in the image at
+	// RunnerPreinstalledPath instead of downloading it.
+	RunnerPreinstalled     bool
+	RunnerPreinstalledPath string
+	// LaunchMode is one of the LaunchMode constants.
+	LaunchMode string
+	// PrivateOnly is set when instances are created in a VPC without
+	// internet access.
+	PrivateOnly  bool
+	ControllerID string
 }
 
 func (r *RunnerSpec) Validate() error {
@@ -130,18 +404,375 @@ func (r *RunnerSpec) Validate() error {
 	if r.BootstrapParams.Name == "" {
 		return fmt.Errorf("missing bootstrap params")
 	}
+	if r.PrivateOnly && len(r.ExtraPackages) > 0 {
+		return fmt.Errorf("extra_packages can not be installed when private_only is set")
+	}
+	if r.KMSKeyID != "" && !r.Encrypted {
+		return fmt.Errorf("kms_key_id can not be used with unencrypted volumes")
+	}
+	if r.EnableHibernation && !r.Encrypted {
+		// The memory contents are written to the root volume.
+		return fmt.Errorf("enable_hibernation requires encrypted volumes, set encrypted or kms_key_id")
+	}
+	if r.CachePoolSize > 0 && r.CacheSnapshotID == "" {
+		return fmt.Errorf("cache_pool_size requires cache_snapshot_id")
+	}
+	devices := map[string]bool{}
+	if r.CacheSnapshotID != "" {
+		devices[r.CacheDeviceName] = true
+	}
+	for _, mapping := range r.BlockDeviceMappings {
+		if devices[mapping.DeviceName] {
+			return fmt.Errorf("device %s is used by more than one volume", mapping.DeviceName)
+		}
+		devices[mapping.DeviceName] = true
+
+		volumeType := "gp3"
+		if mapping.VolumeType != nil {
+			volumeType = *mapping.VolumeType
+		}
+		if mapping.Iops != nil && volumeType != "gp3" && volumeType != "io1" && volumeType != "io2" {
+			return fmt.Errorf("iops can not be set on %s volume %s", volumeType, mapping.DeviceName)
+		}
+		if mapping.Throughput != nil && volumeType != "gp3" {
+			return fmt.Errorf("throughput can not be set on %s volume %s", volumeType, mapping.DeviceName)
+		}
+	}
+	virtualNames := map[string]bool{}
+	for _, volume := range r.InstanceStoreVolumes {
+		if devices[volume.DeviceName] {
+			return fmt.Errorf("device %s is used by more than one volume", volume.DeviceName)
+		}
+		devices[volume.DeviceName] = true
+		if virtualNames[volume.VirtualName] {
+			return fmt.Errorf("instance store volume %s is mapped more than once", volume.VirtualName)
+		}
+		virtualNames[volume.VirtualName] = true
+		if volume.MountPoint != "" && r.BootstrapParams.OSType != params.Linux {
+			return fmt.Errorf("instance store volumes can only be mounted on Linux")
+		}
+	}
+	for _, device := range r.SuppressDevices {
+		if devices[device] {
+			return fmt.Errorf("device %s can not be suppressed and used at the same time", device)
+		}
+	}
+	if r.EphemeralSSHKey && r.SSHKeyName != nil {
+		return fmt.Errorf("ssh_key_name and ephemeral_ssh_key can not be used together")
+	}
+	for _, arn := range r.LicenseSpecifications {
+		if !strings.HasPrefix(arn, "arn:") || !strings.Contains(arn, ":license-configuration:") {
+			return fmt.Errorf("invalid license configuration ARN %q", arn)
+		}
+	}
+	if r.SharedVolume != nil {
+		if r.BootstrapParams.OSType != params.Linux {
+			return fmt.Errorf("shared_volume is only supported on Linux")
+		}
+		if devices[r.SharedVolume.GetDeviceName()] {
+			return fmt.Errorf("device %s is used by more than one volume", r.SharedVolume.GetDeviceName())
+		}
+	}
+	if len(r.UserDataParts) > 0 && r.BootstrapParams.OSType != params.Linux {
+		return fmt.Errorf("user_data_parts is only supported on Linux")
+	}
+	for idx, part := range r.UserDataParts {
+		if !slices.Contains(userDataPartContentTypes, part.ContentType) {
+			return fmt.Errorf("unsupported content type %q of user data part %s", part.ContentType, part.GetFilename(idx+1))
+		}
+		if len(part.Content) == 0 {
+			return fmt.Errorf("user data part %s is empty", part.GetFilename(idx+1))
+		}
+	}
+	if (r.WindowsUserDataWrapper != "" || r.WindowsUserDataPersist) && r.BootstrapParams.OSType != params.Windows {
+		return fmt.Errorf("windows_user_data_wrapper and windows_user_data_persist are only supported on Windows")
+	}
+	if r.WindowsUserDataWrapper == WindowsUserDataWrapperScript {
+		// The install script garm-provider-common generates is PowerShell.
+		specs, err := cloudconfig.GetSpecs(r.BootstrapParams)
+		if err != nil {
+			return fmt.Errorf("failed to get cloud config specs: %w", err)
+		}
+		if len(specs.RunnerInstallTemplate) == 0 {
+			return fmt.Errorf("windows_user_data_wrapper %s requires a runner_install_template that is a batch script", r.WindowsUserDataWrapper)
+		}
+		// The bootstrap scripts are run from PowerShell.
+		if r.PreBootstrapScript != "" || r.PostBootstrapScript != "" {
+			return fmt.Errorf("windows_user_data_wrapper %s can not be used with the windows bootstrap_scripts of the provider config", r.WindowsUserDataWrapper)
+		}
+		if r.RunnerPreinstalled {
+			return fmt.Errorf("windows_user_data_wrapper %s can not be used with runner_preinstalled", r.WindowsUserDataWrapper)
+		}
+	}
+	if len(r.CustomUserData) > 0 && !r.DisableUserData {
+		return fmt.Errorf("custom_userdata requires disable_userdata")
+	}
+	if len(r.CustomUserData) > MaxUserDataSize {
+		return fmt.Errorf("custom_userdata is %d bytes, over the %d byte limit of EC2", len(r.CustomUserData), MaxUserDataSize)
+	}
+	if r.DisableUserData {
+		// These are all set up by the generated user data.
+		if len(r.UserDataParts) > 0 {
+			return fmt.Errorf("user_data_parts can not be used with disable_userdata")
+		}
+		if r.WindowsUserDataWrapper != "" || r.WindowsUserDataPersist {
+			return fmt.Errorf("windows_user_data_wrapper and windows_user_data_persist can not be used with disable_userdata")
+		}
+		if r.SharedVolume != nil {
+			return fmt.Errorf("shared_volume can not be used with disable_userdata")
+		}
+		for _, volume := range r.InstanceStoreVolumes {
+			if volume.MountPoint != "" {
+				return fmt.Errorf("instance store volume %s can not be mounted with disable_userdata", volume.VirtualName)
+			}
+		}
+		if r.RunnerPreinstalled {
+			return fmt.Errorf("runner_preinstalled can not be used with disable_userdata")
+		}
+	}
+	if r.bootstrapShellPath() != "" {
+		if r.BootstrapParams.OSType != params.Linux {
+			return fmt.Errorf("bootstrap_shell is only supported on Linux")
+		}
+		// The install script garm-provider-common generates needs bash.
+		specs, err := cloudconfig.GetSpecs(r.BootstrapParams)
+		if err != nil {
+			return fmt.Errorf("failed to get cloud config specs: %w", err)
+		}
+		if len(specs.RunnerInstallTemplate) == 0 {
+			return fmt.Errorf("bootstrap_shell %s requires a runner_install_template written for it", r.BootstrapShell)
+		}
+		// So do the scripts that mount volumes.
+		if r.SharedVolume != nil || r.instanceStoreScript() != nil {
+			return fmt.Errorf("bootstrap_shell %s can not be used to mount instance store volumes or shared_volume", r.BootstrapShell)
+		}
+	}
+	if r.RunnerPreinstalledPath != "" && !r.RunnerPreinstalled {
+		return fmt.Errorf("runner_preinstalled_path requires runner_preinstalled")
+	}
+	if r.RunnerPreinstalled {
+		if err := r.validateRunnerPreinstalledPath(); err != nil {
+			return err
+		}
+	}
+	if r.MetadataOptions != nil && r.MetadataOptions.HttpEndpoint != nil && *r.MetadataOptions.HttpEndpoint == "disabled" {
+		return fmt.Errorf("the metadata service can not be disabled, it is needed to read the user data")
+	}
+	if r.KeepOnFailureTTL != "" {
+		if !r.KeepOnFailure {
+			return fmt.Errorf("keep_on_failure_ttl requires keep_on_failure")
+		}
+		ttl, err := time.ParseDuration(r.KeepOnFailureTTL)
+		if err != nil {
+			return fmt.Errorf("invalid keep_on_failure_ttl: %w", err)
+		}
+		if ttl <= 0 || ttl > MaxKeepOnFailureTTL {
+			return fmt.Errorf("keep_on_failure_ttl must be between 0 and %s, got %s", MaxKeepOnFailureTTL, ttl)
+		}
+	}
+	if r.MaxRuntime != "" {
+		maxRuntime, err := time.ParseDuration(r.MaxRuntime)
+		if err != nil {
+			return fmt.Errorf("invalid max_runtime: %w", err)
+		}
+		if maxRuntime <= 0 {
+			return fmt.Errorf("max_runtime must be positive, got %s", maxRuntime)
+		}
+	}
+	if r.HostID != "" && r.HostResourceGroupARN != "" {
+		return fmt.Errorf("host_id and host_resource_group_arn are mutually exclusive")
+	}
+	if (r.HostID != "" || r.HostResourceGroupARN != "") && r.Tenancy != "host" {
+		return fmt.Errorf("host_id and host_resource_group_arn require host tenancy, got %q", r.Tenancy)
+	}
+	if r.LaunchMode == LaunchModeFleet {
+		// EC2 Fleet can't launch onto Dedicated Hosts, and launch
+		// templates with network interfaces can't be spread over subnets.
+		if r.Tenancy == "host" {
+			return fmt.Errorf("launch_mode fleet can not be used with host tenancy")
+		}
+		if r.Ipv6AddressCount > 0 {
+			return fmt.Errorf("launch_mode fleet can not be used with ipv6_address_count")
+		}
+	}
 	return nil
 }
 
+// GetKeepOnFailureTTL returns how long failed instances are kept, or the
+// default.
+func (r *RunnerSpec) GetKeepOnFailureTTL() time.Duration {
+	if r.KeepOnFailureTTL == "" {
+		return DefaultKeepOnFailureTTL
+	}
+	// Validate already rejected invalid durations.
+	ttl, _ := time.ParseDuration(r.KeepOnFailureTTL)
+	return ttl
+}
+
 func (r *RunnerSpec) MergeExtraSpecs(extraSpecs *extraSpecs) {
 	if extraSpecs.SubnetID != nil && *extraSpecs.SubnetID != "" {
 		r.SubnetID = *extraSpecs.SubnetID
 	}
 
+	if len(extraSpecs.FallbackSubnetIDs) > 0 {
+		r.FallbackSubnetIDs = extraSpecs.FallbackSubnetIDs
+	}
+
+	if len(extraSpecs.SecurityGroupIDs) > 0 {
+		r.SecurityGroupIDs = extraSpecs.SecurityGroupIDs
+	}
+
+	if len(extraSpecs.SecurityGroupNames) > 0 {
+		r.SecurityGroupNames = extraSpecs.SecurityGroupNames
+	}
+
+	if len(extraSpecs.SecurityGroupTags) > 0 {
+		r.SecurityGroupTags = extraSpecs.SecurityGroupTags
+	}
+
 	if extraSpecs.SSHKeyName != nil {
 		r.SSHKeyName = extraSpecs.SSHKeyName
 	}
 
+	if extraSpecs.EphemeralSSHKey != nil {
+		r.EphemeralSSHKey = *extraSpecs.EphemeralSSHKey
+	}
+
+	if extraSpecs.SerialConsole != nil {
+		r.SerialConsole = *extraSpecs.SerialConsole
+	}
+
+	if extraSpecs.Tenancy != nil {
+		r.Tenancy = *extraSpecs.Tenancy
+	}
+
+	if extraSpecs.HostID != nil {
+		r.HostID = *extraSpecs.HostID
+	}
+
+	if extraSpecs.HostResourceGroupARN != nil {
+		r.HostResourceGroupARN = *extraSpecs.HostResourceGroupARN
+	}
+
+	// Targeting a host or host resource group only works with host tenancy,
+	// so don't make users spell it out.
+	if (r.HostID != "" || r.HostResourceGroupARN != "") && r.Tenancy == "" {
+		r.Tenancy = "host"
+	}
+
+	if len(extraSpecs.SSMDocuments) > 0 {
+		r.SSMDocuments = extraSpecs.SSMDocuments
+	}
+
+	if extraSpecs.KMSKeyID != nil {
+		r.KMSKeyID = *extraSpecs.KMSKeyID
+		r.Encrypted = true
+	}
+
+	if extraSpecs.Encrypted != nil {
+		r.Encrypted = *extraSpecs.Encrypted
+	}
+
+	if len(extraSpecs.BlockDeviceMappings) > 0 {
+		r.BlockDeviceMappings = extraSpecs.BlockDeviceMappings
+	}
+
+	if len(extraSpecs.InstanceStoreVolumes) > 0 {
+		r.InstanceStoreVolumes = extraSpecs.InstanceStoreVolumes
+	}
+
+	if extraSpecs.SnapshotID != nil {
+		r.SnapshotID = *extraSpecs.SnapshotID
+	}
+
+	if extraSpecs.CacheSnapshotID != nil {
+		r.CacheSnapshotID = *extraSpecs.CacheSnapshotID
+	}
+
+	if extraSpecs.CacheDeviceName != nil && *extraSpecs.CacheDeviceName != "" {
+		r.CacheDeviceName = *extraSpecs.CacheDeviceName
+	}
+
+	if extraSpecs.CachePoolSize != nil {
+		r.CachePoolSize = *extraSpecs.CachePoolSize
+	}
+
+	if extraSpecs.Ipv6AddressCount != nil {
+		r.Ipv6AddressCount = *extraSpecs.Ipv6AddressCount
+	}
+
+	if extraSpecs.RunnerInstallTemplateFormat != nil {
+		r.RunnerInstallTemplateFormat = *extraSpecs.RunnerInstallTemplateFormat
+	}
+
+	if extraSpecs.MetadataOptions != nil {
+		r.MetadataOptions = extraSpecs.MetadataOptions
+	}
+
+	if extraSpecs.KeepOnFailure != nil {
+		r.KeepOnFailure = *extraSpecs.KeepOnFailure
+	}
+
+	if extraSpecs.KeepOnFailureTTL != nil {
+		r.KeepOnFailureTTL = *extraSpecs.KeepOnFailureTTL
+	}
+
+	if extraSpecs.MaxRuntime != nil {
+		r.MaxRuntime = *extraSpecs.MaxRuntime
+	}
+
+	if extraSpecs.EnableHibernation != nil {
+		r.EnableHibernation = *extraSpecs.EnableHibernation
+	}
+
+	if extraSpecs.SharedVolume != nil {
+		r.SharedVolume = extraSpecs.SharedVolume
+	}
+
+	if len(extraSpecs.SuppressDevices) > 0 {
+		r.SuppressDevices = extraSpecs.SuppressDevices
+	}
+
+	if len(extraSpecs.LicenseSpecifications) > 0 {
+		r.LicenseSpecifications = extraSpecs.LicenseSpecifications
+	}
+
+	if len(extraSpecs.UserDataParts) > 0 {
+		r.UserDataParts = extraSpecs.UserDataParts
+	}
+
+	if extraSpecs.WindowsUserDataWrapper != nil {
+		r.WindowsUserDataWrapper = *extraSpecs.WindowsUserDataWrapper
+	}
+
+	if extraSpecs.WindowsUserDataPersist != nil {
+		r.WindowsUserDataPersist = *extraSpecs.WindowsUserDataPersist
+	}
+
+	if extraSpecs.DisableUserData != nil {
+		r.DisableUserData = *extraSpecs.DisableUserData
+	}
+
+	if len(extraSpecs.CustomUserData) > 0 {
+		r.CustomUserData = extraSpecs.CustomUserData
+	}
+
+	if extraSpecs.BootstrapShell != nil {
+		r.BootstrapShell = *extraSpecs.BootstrapShell
+	}
+
+	if extraSpecs.RunnerPreinstalled != nil {
+		r.RunnerPreinstalled = *extraSpecs.RunnerPreinstalled
+	}
+
+	if extraSpecs.RunnerPreinstalledPath != nil {
+		r.RunnerPreinstalledPath = *extraSpecs.RunnerPreinstalledPath
+	}
+
+	if extraSpecs.LaunchMode != nil {
+		r.LaunchMode = *extraSpecs.LaunchMode
+	}
+
 	if extraSpecs.DisableUpdates != nil {
 		r.DisableUpdates = *extraSpecs.DisableUpdates
 	}
@@ -152,26 +783,106 @@ func (r *RunnerSpec) MergeExtraSpecs(extraSpecs *extraSpecs) {
 }
 
 func (r *RunnerSpec) ComposeUserData() (string, error) {
+	if r.DisableUserData {
+		return base64.StdEncoding.EncodeToString(r.CustomUserData), nil
+	}
+	bootstrapParams, udata, err := r.userData()
+	if err != nil {
+		return "", err
+	}
+	if bootstrapParams.OSType == params.Windows {
+		udata = r.wrapWindowsUserData(udata)
+	}
+	fitted, err := r.fitUserData(bootstrapParams, udata)
+	if err != nil {
+		return "", err
+	}
+	asBase64 := base64.StdEncoding.EncodeToString(fitted)
+	return asBase64, nil
+}
+
+// UserDataPayload returns the cloud-init config on Linux and the install
+// script on Windows, as the instance runs them. Unlike ComposeUserData, the
+// payload is neither compressed nor encoded.
+func (r *RunnerSpec) UserDataPayload() ([]byte, error) {
+	if r.DisableUserData {
+		return r.CustomUserData, nil
+	}
+	_, udata, err := r.userData()
+	return udata, err
+}
+
+func (r *RunnerSpec) userData() (params.BootstrapInstance, []byte, error) {
 	bootstrapParams := r.BootstrapParams
 	bootstrapParams.UserDataOptions.DisableUpdatesOnBoot = r.DisableUpdates
 	bootstrapParams.UserDataOptions.ExtraPackages = r.ExtraPackages
 	bootstrapParams.UserDataOptions.EnableBootDebug = r.EnableBootDebug
 	switch bootstrapParams.OSType {
-	case params.Linux:
-		udata, err := cloudconfig.GetCloudConfig(bootstrapParams, r.Tools, bootstrapParams.Name)
+	case params.Linux, params.Windows:
+		udata, err := r.cloudConfig(bootstrapParams)
 		if err != nil {
-			return "", fmt.Errorf("failed to generate userdata: %w", err)
+			return bootstrapParams, nil, fmt.Errorf("failed to generate userdata: %w", err)
 		}
-		asBase64 := base64.StdEncoding.EncodeToString([]byte(udata))
-		return asBase64, nil
-	case params.Windows:
-		udata, err := cloudconfig.GetCloudConfig(bootstrapParams, r.Tools, bootstrapParams.Name)
-		if err != nil {
-			return "", fmt.Errorf("failed to generate userdata: %w", err)
+		if len(r.UserDataParts) > 0 {
+			multipart, err := r.multipartUserData([]byte(udata))
+			return bootstrapParams, multipart, err
 		}
-		wrapped := fmt.Sprintf("<powershell>%s</powershell>", udata)
-		asBase64 := base64.StdEncoding.EncodeToString([]byte(wrapped))
-		return asBase64, nil
+		return bootstrapParams, []byte(udata), nil
+	}
+	return bootstrapParams, nil, fmt.Errorf("unsupported OS type for cloud config: %s", bootstrapParams.OSType)
+}
+
+// cloudConfig returns the cloud-init config on Linux and the install script
+// on Windows.
+func (r *RunnerSpec) cloudConfig(bootstrapParams params.BootstrapInstance) (string, error) {
+	bootstrapParams, err := r.withPreInstallScripts(bootstrapParams)
+	if err != nil {
+		return "", err
+	}
+
+	installScript, err := r.installScript(bootstrapParams)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate install script: %w", err)
+	}
+	if bootstrapParams.OSType == params.Windows {
+		return r.withWindowsBootstrapScripts(string(installScript)), nil
+	}
+
+	udata, err := cloudconfig.GetCloudInitConfig(bootstrapParams, r.withBootstrapShell(installScript))
+	if err != nil {
+		return "", err
+	}
+	udata, err = r.withBootstrapShellUser(udata)
+	if err != nil {
+		return "", err
+	}
+	return r.withPostBootstrapScript(udata)
+}
+
+// installScript returns the runner install script. Go templates are left to
+// garm-provider-common; other template formats are rendered here.
+func (r *RunnerSpec) installScript(bootstrapParams params.BootstrapInstance) ([]byte, error) {
+	if r.RunnerInstallTemplateFormat == "" || r.RunnerInstallTemplateFormat == TemplateFormatGo {
+		return cloudconfig.GetRunnerInstallScript(bootstrapParams, r.Tools, bootstrapParams.Name)
+	}
+	return r.runnerInstallScript(bootstrapParams)
+}
+
+// withPreInstallScripts returns the bootstrap params with the pre-install
+// scripts of the provider added: the pre bootstrap script, the scripts that
+// mount volumes and the script that puts a pre-installed runner in place.
+func (r *RunnerSpec) withPreInstallScripts(bootstrapParams params.BootstrapInstance) (params.BootstrapInstance, error) {
+	bootstrapParams, err := r.withMountScripts(bootstrapParams)
+	if err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to add mount scripts: %w", err)
+	}
+	bootstrapParams, err = r.withPreBootstrapScript(bootstrapParams)
+	if err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to add pre bootstrap script: %w", err)
+	}
+	bootstrapParams, err = r.withPreinstalledRunnerScript(bootstrapParams)
+	if err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to add preinstalled runner script: %w", err)
 	}
-	return "", fmt.Errorf("unsupported OS type for cloud config: %s", bootstrapParams.OSType)
+	return bootstrapParams, nil
 }
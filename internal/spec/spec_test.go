@@ -156,7 +156,335 @@ func TestExtraSpecsFromBootstrapData(t *testing.T) {
 				ExtraSpecs: json.RawMessage(`{"subnet_id": "subnet-1"}`),
 			},
 			expectedOutput: nil,
-			errString:      "subnet_id: Does not match pattern '^subnet-[0-9a-fA-F]{17}$'",
+			errString:      "subnet_id: Does not match pattern '^(subnet-([0-9a-f]{8}|[0-9a-f]{17})|ssm:.+)$'",
+		},
+		{
+			name: "subnet_id from ssm",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"subnet_id": "ssm:/network/runners/subnet"}`),
+			},
+			expectedOutput: &extraSpecs{
+				SubnetID: aws.String("ssm:/network/runners/subnet"),
+			},
+			errString: "",
+		},
+		{
+			name: "specs just with fallback_subnet_ids",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"fallback_subnet_ids": ["subnet-0b0b0b0b0b0b0b0b0"]}`),
+			},
+			expectedOutput: &extraSpecs{
+				FallbackSubnetIDs: []string{"subnet-0b0b0b0b0b0b0b0b0"},
+			},
+			errString: "",
+		},
+		{
+			name: "legacy subnet_id",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"subnet_id": "subnet-0a0a0a0a"}`),
+			},
+			expectedOutput: &extraSpecs{
+				SubnetID: aws.String("subnet-0a0a0a0a"),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid format for fallback_subnet_ids",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"fallback_subnet_ids": ["subnet-0B0B0B0B0B0B0B0B0"]}`),
+			},
+			expectedOutput: nil,
+			errString:      "fallback_subnet_ids.0: Does not match pattern '^(subnet-([0-9a-f]{8}|[0-9a-f]{17})|ssm:.+)$'",
+		},
+		{
+			name: "specs just with security_group_ids",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"security_group_ids": ["sg-0a0a0a0a0a0a0a0a0", "ssm:/network/runners/sg"]}`),
+			},
+			expectedOutput: &extraSpecs{
+				SecurityGroupIDs: []string{"sg-0a0a0a0a0a0a0a0a0", "ssm:/network/runners/sg"},
+			},
+			errString: "",
+		},
+		{
+			name: "specs with security_group_names and security_group_tags",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"security_group_names": ["runners"], "security_group_tags": {"role": "garm-runner"}}`),
+			},
+			expectedOutput: &extraSpecs{
+				SecurityGroupNames: []string{"runners"},
+				SecurityGroupTags:  map[string]string{"role": "garm-runner"},
+			},
+			errString: "",
+		},
+		{
+			name: "invalid type for security_group_tags",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"security_group_tags": {"role": 1}}`),
+			},
+			expectedOutput: nil,
+			errString:      "security_group_tags.role: Invalid type. Expected: string, given: integer",
+		},
+		{
+			name: "invalid type for security_group_ids",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"security_group_ids": "sg-0a0a0a0a0a0a0a0a0"}`),
+			},
+			expectedOutput: nil,
+			errString:      "security_group_ids: Invalid type. Expected: array, given: string",
+		},
+		{
+			name: "specs with disable_userdata and custom_userdata",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"disable_userdata": true, "custom_userdata": "IyEvYmluL3NoCg=="}`),
+			},
+			expectedOutput: &extraSpecs{
+				DisableUserData: aws.Bool(true),
+				CustomUserData:  []byte("#!/bin/sh\n"),
+			},
+			errString: "",
+		},
+		{
+			name: "specs just with tenancy",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"tenancy": "dedicated"}`),
+			},
+			expectedOutput: &extraSpecs{
+				Tenancy: aws.String("dedicated"),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid value for tenancy",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"tenancy": "shared"}`),
+			},
+			expectedOutput: nil,
+			errString:      "tenancy: tenancy must be one of the following",
+		},
+		{
+			name: "specs with host_id",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"host_id": "h-0a0a0a0a0a0a0a0a0"}`),
+			},
+			expectedOutput: &extraSpecs{
+				HostID: aws.String("h-0a0a0a0a0a0a0a0a0"),
+			},
+			errString: "",
+		},
+		{
+			name: "specs with host_resource_group_arn",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"host_resource_group_arn": "arn:aws:resource-groups:us-east-1:123456789012:group/mac-hosts"}`),
+			},
+			expectedOutput: &extraSpecs{
+				HostResourceGroupARN: aws.String("arn:aws:resource-groups:us-east-1:123456789012:group/mac-hosts"),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid format for host_id",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"host_id": "i-0a0a0a0a0a0a0a0a0"}`),
+			},
+			expectedOutput: nil,
+			errString:      "host_id: Does not match pattern '^h-[0-9a-fA-F]+$'",
+		},
+		{
+			name: "specs with ssm_documents",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"ssm_documents": [{"name": "CIS-Hardening", "parameters": {"level": ["1"]}}]}`),
+			},
+			expectedOutput: &extraSpecs{
+				SSMDocuments: []SSMDocument{
+					{
+						Name:       "CIS-Hardening",
+						Parameters: map[string][]string{"level": {"1"}},
+					},
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "ssm_documents without name",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"ssm_documents": [{"parameters": {"level": ["1"]}}]}`),
+			},
+			expectedOutput: nil,
+			errString:      "ssm_documents.0: name is required",
+		},
+		{
+			name: "specs with cache_snapshot_id and cache_device_name",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"cache_snapshot_id": "snap-0a0a0a0a0a0a0a0a0", "cache_device_name": "/dev/sdg"}`),
+			},
+			expectedOutput: &extraSpecs{
+				CacheSnapshotID: aws.String("snap-0a0a0a0a0a0a0a0a0"),
+				CacheDeviceName: aws.String("/dev/sdg"),
+			},
+			errString: "",
+		},
+		{
+			name: "specs with launch_mode",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"launch_mode": "fleet"}`),
+			},
+			expectedOutput: &extraSpecs{
+				LaunchMode: aws.String("fleet"),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid launch_mode",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"launch_mode": "spot_fleet"}`),
+			},
+			expectedOutput: nil,
+			errString:      "launch_mode: launch_mode must be one of the following",
+		},
+		{
+			name: "specs with cache_pool_size",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"cache_snapshot_id": "snap-0a0a0a0a0a0a0a0a0", "cache_pool_size": 4}`),
+			},
+			expectedOutput: &extraSpecs{
+				CacheSnapshotID: aws.String("snap-0a0a0a0a0a0a0a0a0"),
+				CachePoolSize:   aws.Int32(4),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid cache_pool_size",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"cache_snapshot_id": "snap-0a0a0a0a0a0a0a0a0", "cache_pool_size": 0}`),
+			},
+			expectedOutput: nil,
+			errString:      "cache_pool_size: Must be greater than or equal to 1",
+		},
+		{
+			name: "specs just with snapshot_id",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"snapshot_id": "snap-0b0b0b0b0b0b0b0b0"}`),
+			},
+			expectedOutput: &extraSpecs{
+				SnapshotID: aws.String("snap-0b0b0b0b0b0b0b0b0"),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid format for cache_snapshot_id",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"cache_snapshot_id": "vol-0a0a0a0a0a0a0a0a0"}`),
+			},
+			expectedOutput: nil,
+			errString:      "cache_snapshot_id: Does not match pattern '^snap-[0-9a-fA-F]+$'",
+		},
+		{
+			name: "specs just with ipv6_address_count",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"ipv6_address_count": 1}`),
+			},
+			expectedOutput: &extraSpecs{
+				Ipv6AddressCount: aws.Int32(1),
+			},
+			errString: "",
+		},
+		{
+			name: "negative ipv6_address_count",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"ipv6_address_count": -1}`),
+			},
+			expectedOutput: nil,
+			errString:      "ipv6_address_count: Must be greater than or equal to 0",
+		},
+		{
+			name: "specs just with metadata_options",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"metadata_options": {"http_tokens": "required", "http_put_response_hop_limit": 2}}`),
+			},
+			expectedOutput: &extraSpecs{
+				MetadataOptions: &MetadataOptions{
+					HttpTokens:              aws.String("required"),
+					HttpPutResponseHopLimit: aws.Int32(2),
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "metadata_options hop limit out of range",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"metadata_options": {"http_put_response_hop_limit": 65}}`),
+			},
+			expectedOutput: nil,
+			errString:      "http_put_response_hop_limit: Must be less than or equal to 64",
+		},
+		{
+			name: "specs just with runner_install_template_format",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"runner_install_template_format": "jinja"}`),
+			},
+			expectedOutput: &extraSpecs{
+				RunnerInstallTemplateFormat: aws.String("jinja"),
+			},
+			errString: "",
+		},
+		{
+			name: "invalid runner_install_template_format",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"runner_install_template_format": "mustache"}`),
+			},
+			expectedOutput: nil,
+			errString:      "runner_install_template_format: runner_install_template_format must be one of the following",
+		},
+		{
+			name: "specs just with block_device_mappings",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"block_device_mappings": [{"device_name": "/dev/sdg", "volume_size": 100, "volume_type": "gp3", "throughput": 250}]}`),
+			},
+			expectedOutput: &extraSpecs{
+				BlockDeviceMappings: []BlockDeviceMapping{
+					{
+						DeviceName: "/dev/sdg",
+						VolumeSize: aws.Int32(100),
+						VolumeType: aws.String("gp3"),
+						Throughput: aws.Int32(250),
+					},
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "block_device_mappings without device_name",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"block_device_mappings": [{"volume_size": 100}]}`),
+			},
+			expectedOutput: nil,
+			errString:      "device_name is required",
+		},
+		{
+			name: "specs just with instance_store_volumes",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"instance_store_volumes": [{"virtual_name": "ephemeral0", "device_name": "/dev/sdb", "mount_point": "/mnt/scratch", "filesystem": "xfs"}]}`),
+			},
+			expectedOutput: &extraSpecs{
+				InstanceStoreVolumes: []InstanceStoreVolume{
+					{
+						VirtualName: "ephemeral0",
+						DeviceName:  "/dev/sdb",
+						MountPoint:  "/mnt/scratch",
+						Filesystem:  "xfs",
+					},
+				},
+			},
+			errString: "",
+		},
+		{
+			name: "invalid mount_point for instance_store_volumes",
+			input: params.BootstrapInstance{
+				ExtraSpecs: json.RawMessage(`{"instance_store_volumes": [{"virtual_name": "ephemeral0", "device_name": "/dev/sdb", "mount_point": "/mnt/$(reboot)"}]}`),
+			},
+			expectedOutput: nil,
+			errString:      "mount_point: Does not match pattern",
 		},
 		{
 			name: "invalid type for subnet_id",
@@ -292,6 +620,7 @@ func TestGetRunnerSpecFromBootstrapParams(t *testing.T) {
 		ControllerID:    "controller_id",
 		BootstrapParams: data,
 		SSHKeyName:      aws.String("ssh_key_name"),
+		CacheDeviceName: DefaultCacheDeviceName,
 	}
 
 	runnerSpec, err := GetRunnerSpecFromBootstrapParams(config, data, "controller_id")
@@ -299,53 +628,607 @@ func TestGetRunnerSpecFromBootstrapParams(t *testing.T) {
 	require.Equal(t, expectedRunnerSpec, runnerSpec)
 }
 
-func TestRunnerSpecValidate(t *testing.T) {
+func TestGetRunnerSpecFromBootstrapParamsSubstitutesToolURL(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("x64"),
+			DownloadURL:  aws.String("https://github.com/actions/runner/releases/download/v2.317.0/actions-runner-linux-x64-2.317.0.tar.gz"),
+			Filename:     aws.String("actions-runner-linux-x64-2.317.0.tar.gz"),
+		}, nil
+	}
+
+	cfg := &config.Config{
+		SubnetID: "subnet_id",
+		Region:   "cn-north-1",
+		Substitutions: map[string]config.Substitutions{
+			"aws-cn": {
+				ToolURLs: []config.Substitution{{Prefix: "https://github.com/", Replacement: "https://mirror.example.cn/github/"}},
+			},
+		},
+	}
+
+	runnerSpec, err := GetRunnerSpecFromBootstrapParams(cfg, params.BootstrapInstance{Name: "mock-name", ExtraSpecs: json.RawMessage(`{}`)}, "controller_id")
+	require.NoError(t, err)
+	require.Equal(t, "https://mirror.example.cn/github/actions/runner/releases/download/v2.317.0/actions-runner-linux-x64-2.317.0.tar.gz", runnerSpec.Tools.GetDownloadURL())
+}
+
+func TestGetEnvironment(t *testing.T) {
 	tests := []struct {
-		name      string
-		spec      *RunnerSpec
-		errString string
+		name        string
+		extraSpecs  string
+		environment string
+		errString   string
 	}{
 		{
-			name:      "empty runner spec",
-			spec:      &RunnerSpec{},
-			errString: "missing region",
+			name:       "no environment",
+			extraSpecs: `{}`,
 		},
 		{
-			name: "missing bootstrap params",
-			spec: &RunnerSpec{
-				Region:       "region",
-				SubnetID:     "subnet_id",
-				ControllerID: "controller_id",
-			},
-			errString: "missing bootstrap params",
+			name:        "environment",
+			extraSpecs:  `{"environment": "eu-ci"}`,
+			environment: "eu-ci",
 		},
 		{
-			name: "valid runner spec",
-			spec: &RunnerSpec{
-				Region:          "region",
-				DisableUpdates:  true,
-				ExtraPackages:   []string{"package1", "package2"},
-				EnableBootDebug: true,
-				Tools: params.RunnerApplicationDownload{
-					OS:           aws.String("linux"),
-					Architecture: aws.String("amd64"),
-					DownloadURL:  aws.String("MockURL"),
-					Filename:     aws.String("garm-runner"),
-				},
-				SubnetID:     "subnet_id",
-				ControllerID: "controller_id",
-				BootstrapParams: params.BootstrapInstance{
-					Name: "name",
-				},
-			},
+			name:       "invalid environment",
+			extraSpecs: `{"environment": "eu ci"}`,
+			errString:  "error loading extra specs: failed to validate extra specs",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.spec.Validate()
+			environment, err := GetEnvironment(&config.Config{}, params.BootstrapInstance{ExtraSpecs: json.RawMessage(tt.extraSpecs)})
 			if tt.errString == "" {
-				require.Nil(t, err)
+				require.NoError(t, err)
+				require.Equal(t, tt.environment, environment)
+			} else {
+				require.ErrorContains(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestPoolSubnets(t *testing.T) {
+	cfg := &config.Config{
+		SubnetID:          "subnet-0a0a0a0a0a0a0a0a0",
+		FallbackSubnetIDs: []string{"subnet-0b0b0b0b0b0b0b0b0"},
+	}
+	tests := []struct {
+		name       string
+		extraSpecs string
+		subnets    []string
+		errString  string
+	}{
+		{
+			name:       "subnets of the config",
+			extraSpecs: `{}`,
+			subnets:    []string{"subnet-0a0a0a0a0a0a0a0a0", "subnet-0b0b0b0b0b0b0b0b0"},
+		},
+		{
+			name:       "subnets of the pool",
+			extraSpecs: `{"subnet_id": "subnet-0c0c0c0c0c0c0c0c0", "fallback_subnet_ids": ["ssm:/network/runners/subnet"]}`,
+			subnets:    []string{"subnet-0c0c0c0c0c0c0c0c0", "ssm:/network/runners/subnet"},
+		},
+		{
+			name:       "invalid extra specs",
+			extraSpecs: `{"subnet_id": "subnet-1"}`,
+			errString:  "error loading extra specs: failed to validate extra specs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnets, err := PoolSubnets(cfg, json.RawMessage(tt.extraSpecs))
+			if tt.errString == "" {
+				require.NoError(t, err)
+				require.Equal(t, tt.subnets, subnets)
+			} else {
+				require.ErrorContains(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestRunnerSpecValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      *RunnerSpec
+		errString string
+	}{
+		{
+			name:      "empty runner spec",
+			spec:      &RunnerSpec{},
+			errString: "missing region",
+		},
+		{
+			name: "keep_on_failure_ttl without keep_on_failure",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				KeepOnFailureTTL: "4h",
+			},
+			errString: "keep_on_failure_ttl requires keep_on_failure",
+		},
+		{
+			name: "keep_on_failure_ttl too long",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				KeepOnFailure:    true,
+				KeepOnFailureTTL: "200h",
+			},
+			errString: "keep_on_failure_ttl must be between 0 and 168h0m0s, got 200h0m0s",
+		},
+		{
+			name: "invalid max_runtime",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				MaxRuntime: "6 hours",
+			},
+			errString: "invalid max_runtime",
+		},
+		{
+			name: "negative max_runtime",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				MaxRuntime: "-6h",
+			},
+			errString: "max_runtime must be positive, got -6h0m0s",
+		},
+		{
+			name: "metadata service disabled",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				MetadataOptions: &MetadataOptions{
+					HttpEndpoint: aws.String("disabled"),
+				},
+			},
+			errString: "the metadata service can not be disabled",
+		},
+		{
+			name: "missing bootstrap params",
+			spec: &RunnerSpec{
+				Region:       "region",
+				SubnetID:     "subnet_id",
+				ControllerID: "controller_id",
+			},
+			errString: "missing bootstrap params",
+		},
+		{
+			name: "extra_packages with private_only",
+			spec: &RunnerSpec{
+				Region:        "region",
+				PrivateOnly:   true,
+				ExtraPackages: []string{"package1"},
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "extra_packages can not be installed when private_only is set",
+		},
+		{
+			name: "cache_pool_size without cache_snapshot_id",
+			spec: &RunnerSpec{
+				Region:        "region",
+				CachePoolSize: 4,
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "cache_pool_size requires cache_snapshot_id",
+		},
+		{
+			name: "block device mapping on the cache device",
+			spec: &RunnerSpec{
+				Region:          "region",
+				CacheSnapshotID: "snap-0a0a0a0a0a0a0a0a0",
+				CacheDeviceName: DefaultCacheDeviceName,
+				BlockDeviceMappings: []BlockDeviceMapping{
+					{DeviceName: DefaultCacheDeviceName},
+				},
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "device /dev/sdf is used by more than one volume",
+		},
+		{
+			name: "iops on st1 volume",
+			spec: &RunnerSpec{
+				Region: "region",
+				BlockDeviceMappings: []BlockDeviceMapping{
+					{DeviceName: "/dev/sdg", VolumeType: aws.String("st1"), Iops: aws.Int32(3000)},
+				},
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "iops can not be set on st1 volume /dev/sdg",
+		},
+		{
+			name: "instance store volume mapped twice",
+			spec: &RunnerSpec{
+				Region: "region",
+				InstanceStoreVolumes: []InstanceStoreVolume{
+					{VirtualName: "ephemeral0", DeviceName: "/dev/sdb"},
+					{VirtualName: "ephemeral0", DeviceName: "/dev/sdc"},
+				},
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "instance store volume ephemeral0 is mapped more than once",
+		},
+		{
+			name: "instance store volume mounted on windows",
+			spec: &RunnerSpec{
+				Region: "region",
+				InstanceStoreVolumes: []InstanceStoreVolume{
+					{VirtualName: "ephemeral0", DeviceName: "/dev/sdb", MountPoint: "/scratch"},
+				},
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Windows,
+				},
+			},
+			errString: "instance store volumes can only be mounted on Linux",
+		},
+		{
+			name: "kms_key_id without encryption",
+			spec: &RunnerSpec{
+				Region:   "region",
+				KMSKeyID: "alias/runners",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "kms_key_id can not be used with unencrypted volumes",
+		},
+		{
+			name: "hibernation without encryption",
+			spec: &RunnerSpec{
+				Region:            "region",
+				EnableHibernation: true,
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "enable_hibernation requires encrypted volumes",
+		},
+		{
+			name: "shared_volume on windows",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Windows,
+				},
+				SharedVolume: &SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"},
+			},
+			errString: "shared_volume is only supported on Linux",
+		},
+		{
+			name: "suppressed device in use",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				BlockDeviceMappings: []BlockDeviceMapping{
+					{DeviceName: "/dev/sdb"},
+				},
+				SuppressDevices: []string{"/dev/sdb"},
+			},
+			errString: "device /dev/sdb can not be suppressed and used at the same time",
+		},
+		{
+			name: "ephemeral key with key pair",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				SSHKeyName:      aws.String("shared-key"),
+				EphemeralSSHKey: true,
+			},
+			errString: "ssh_key_name and ephemeral_ssh_key can not be used together",
+		},
+		{
+			name: "invalid license configuration",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+				LicenseSpecifications: []string{"arn:aws:license-manager:us-east-1:123456789012:license-configuration:lic-0123456789abcdef0123456789abcdef", "lic-0123456789abcdef0123456789abcdef"},
+			},
+			errString: `invalid license configuration ARN "lic-0123456789abcdef0123456789abcdef"`,
+		},
+		{
+			name: "user_data_parts on windows",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Windows,
+				},
+				UserDataParts: []UserDataPart{{ContentType: "text/x-shellscript", Content: []byte("echo hello")}},
+			},
+			errString: "user_data_parts is only supported on Linux",
+		},
+		{
+			name: "empty user data part",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				UserDataParts: []UserDataPart{
+					{ContentType: "text/x-shellscript", Content: []byte("echo hello")},
+					{ContentType: "text/cloud-config"},
+				},
+			},
+			errString: "user data part part-2 is empty",
+		},
+		{
+			name: "windows_user_data_wrapper on linux",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				WindowsUserDataPersist: true,
+			},
+			errString: "windows_user_data_wrapper and windows_user_data_persist are only supported on Windows",
+		},
+		{
+			name: "script wrapper without a template",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "name",
+					OSType:     params.Windows,
+					ExtraSpecs: json.RawMessage(`{}`),
+				},
+				WindowsUserDataWrapper: WindowsUserDataWrapperScript,
+			},
+			errString: "windows_user_data_wrapper script requires a runner_install_template that is a batch script",
+		},
+		{
+			name: "script wrapper with bootstrap scripts",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "name",
+					OSType:     params.Windows,
+					ExtraSpecs: json.RawMessage(`{"runner_install_template": "ZWNobyBpbnN0YWxs"}`),
+				},
+				WindowsUserDataWrapper: WindowsUserDataWrapperScript,
+				PreBootstrapScript:     "Write-Host pre",
+			},
+			errString: "windows_user_data_wrapper script can not be used with the windows bootstrap_scripts of the provider config",
+		},
+		{
+			name: "custom_userdata without disable_userdata",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				CustomUserData: []byte("#!/bin/sh"),
+			},
+			errString: "custom_userdata requires disable_userdata",
+		},
+		{
+			name: "custom_userdata too large",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				DisableUserData: true,
+				CustomUserData:  make([]byte, MaxUserDataSize+1),
+			},
+			errString: "custom_userdata is 16385 bytes, over the 16384 byte limit of EC2",
+		},
+		{
+			name: "disable_userdata with user_data_parts",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				DisableUserData: true,
+				UserDataParts:   []UserDataPart{{ContentType: "text/x-shellscript", Content: []byte("echo hello")}},
+			},
+			errString: "user_data_parts can not be used with disable_userdata",
+		},
+		{
+			name: "disable_userdata with a mounted instance store volume",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				DisableUserData:      true,
+				InstanceStoreVolumes: []InstanceStoreVolume{{VirtualName: "ephemeral0", DeviceName: "/dev/sdb", MountPoint: "/mnt/scratch"}},
+			},
+			errString: "instance store volume ephemeral0 can not be mounted with disable_userdata",
+		},
+		{
+			name: "runner_preinstalled_path without runner_preinstalled",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				RunnerPreinstalledPath: "/opt/runner",
+			},
+			errString: "runner_preinstalled_path requires runner_preinstalled",
+		},
+		{
+			name: "runner_preinstalled with disable_userdata",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				DisableUserData:    true,
+				RunnerPreinstalled: true,
+			},
+			errString: "runner_preinstalled can not be used with disable_userdata",
+		},
+		{
+			name: "bootstrap_shell on windows",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Windows,
+				},
+				BootstrapShell: BootstrapShellSh,
+			},
+			errString: "bootstrap_shell is only supported on Linux",
+		},
+		{
+			name: "bootstrap_shell without a template",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "name",
+					OSType:     params.Linux,
+					ExtraSpecs: json.RawMessage(`{}`),
+				},
+				BootstrapShell: BootstrapShellAsh,
+			},
+			errString: "bootstrap_shell ash requires a runner_install_template written for it",
+		},
+		{
+			name: "bootstrap_shell with a shared volume",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "name",
+					OSType:     params.Linux,
+					ExtraSpecs: json.RawMessage(`{"runner_install_template": "ZWNobyBpbnN0YWxs"}`),
+				},
+				BootstrapShell: BootstrapShellSh,
+				SharedVolume:   &SharedVolume{VolumeID: "vol-0123456789abcdef0", MountPoint: "/mnt/mirror"},
+			},
+			errString: "bootstrap_shell sh can not be used to mount instance store volumes or shared_volume",
+		},
+		{
+			name: "shared_volume on the cache device",
+			spec: &RunnerSpec{
+				Region: "region",
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "name",
+					OSType: params.Linux,
+				},
+				CacheSnapshotID: "snap-0123456789abcdef0",
+				CacheDeviceName: "/dev/sdf",
+				SharedVolume:    &SharedVolume{VolumeID: "vol-0123456789abcdef0", DeviceName: "/dev/sdf", MountPoint: "/mnt/mirror"},
+			},
+			errString: "device /dev/sdf is used by more than one volume",
+		},
+		{
+			name: "host_id and host_resource_group_arn",
+			spec: &RunnerSpec{
+				Region:               "region",
+				Tenancy:              "host",
+				HostID:               "h-0a0a0a0a0a0a0a0a0",
+				HostResourceGroupARN: "arn:aws:resource-groups:us-east-1:123456789012:group/mac-hosts",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "host_id and host_resource_group_arn are mutually exclusive",
+		},
+		{
+			name: "host_id with dedicated tenancy",
+			spec: &RunnerSpec{
+				Region:  "region",
+				Tenancy: "dedicated",
+				HostID:  "h-0a0a0a0a0a0a0a0a0",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "host_id and host_resource_group_arn require host tenancy",
+		},
+		{
+			name: "launch_mode fleet with host tenancy",
+			spec: &RunnerSpec{
+				Region:     "region",
+				LaunchMode: LaunchModeFleet,
+				Tenancy:    "host",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "launch_mode fleet can not be used with host tenancy",
+		},
+		{
+			name: "launch_mode fleet with ipv6_address_count",
+			spec: &RunnerSpec{
+				Region:           "region",
+				LaunchMode:       LaunchModeFleet,
+				Ipv6AddressCount: 1,
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+			errString: "launch_mode fleet can not be used with ipv6_address_count",
+		},
+		{
+			name: "valid runner spec",
+			spec: &RunnerSpec{
+				Region:          "region",
+				DisableUpdates:  true,
+				ExtraPackages:   []string{"package1", "package2"},
+				EnableBootDebug: true,
+				Tools: params.RunnerApplicationDownload{
+					OS:           aws.String("linux"),
+					Architecture: aws.String("amd64"),
+					DownloadURL:  aws.String("MockURL"),
+					Filename:     aws.String("garm-runner"),
+				},
+				SubnetID:     "subnet_id",
+				ControllerID: "controller_id",
+				BootstrapParams: params.BootstrapInstance{
+					Name: "name",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
 			} else {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), tt.errString)
@@ -369,23 +1252,63 @@ func TestMergeExtraSpecs(t *testing.T) {
 			extra:    &extraSpecs{},
 			expected: &RunnerSpec{SubnetID: "subnet_id"},
 		},
+		{
+			name: "host_id implies host tenancy",
+			spec: &RunnerSpec{
+				SubnetID: "subnet_id",
+			},
+			extra: &extraSpecs{
+				HostID: aws.String("h-0a0a0a0a0a0a0a0a0"),
+			},
+			expected: &RunnerSpec{
+				SubnetID: "subnet_id",
+				Tenancy:  "host",
+				HostID:   "h-0a0a0a0a0a0a0a0a0",
+			},
+		},
+		{
+			name: "kms_key_id implies encrypted",
+			spec: &RunnerSpec{
+				SubnetID: "subnet_id",
+			},
+			extra: &extraSpecs{
+				KMSKeyID: aws.String("alias/runners"),
+			},
+			expected: &RunnerSpec{
+				SubnetID:  "subnet_id",
+				Encrypted: true,
+				KMSKeyID:  "alias/runners",
+			},
+		},
 		{
 			name: "valid extra specs",
 			spec: &RunnerSpec{
 				SubnetID: "subnet_id",
 			},
 			extra: &extraSpecs{
-				SubnetID:        aws.String("subnet-0a0a0a0a0a0a0a0a0"),
-				SSHKeyName:      aws.String("ssh_key_name"),
-				DisableUpdates:  aws.Bool(true),
-				EnableBootDebug: aws.Bool(true),
-				ExtraPackages:   []string{"package1", "package2"},
+				SubnetID:           aws.String("subnet-0a0a0a0a0a0a0a0a0"),
+				SecurityGroupIDs:   []string{"sg-0a0a0a0a0a0a0a0a0"},
+				SecurityGroupNames: []string{"runners"},
+				SSHKeyName:         aws.String("ssh_key_name"),
+				Ipv6AddressCount:   aws.Int32(1),
+				Tenancy:            aws.String("dedicated"),
+				CacheSnapshotID:    aws.String("snap-0a0a0a0a0a0a0a0a0"),
+				CachePoolSize:      aws.Int32(2),
+				DisableUpdates:     aws.Bool(true),
+				EnableBootDebug:    aws.Bool(true),
+				ExtraPackages:      []string{"package1", "package2"},
 			},
 			expected: &RunnerSpec{
-				SubnetID:        "subnet-0a0a0a0a0a0a0a0a0",
-				SSHKeyName:      aws.String("ssh_key_name"),
-				DisableUpdates:  true,
-				EnableBootDebug: true,
+				SubnetID:           "subnet-0a0a0a0a0a0a0a0a0",
+				SecurityGroupIDs:   []string{"sg-0a0a0a0a0a0a0a0a0"},
+				SecurityGroupNames: []string{"runners"},
+				SSHKeyName:         aws.String("ssh_key_name"),
+				Ipv6AddressCount:   1,
+				Tenancy:            "dedicated",
+				CacheSnapshotID:    "snap-0a0a0a0a0a0a0a0a0",
+				CachePoolSize:      2,
+				DisableUpdates:     true,
+				EnableBootDebug:    true,
 			},
 		},
 	}
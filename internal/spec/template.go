@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/defaults"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// Formats of the runner_install_template.
+const (
+	// TemplateFormatGo renders the template with text/template. This is the
+	// default and matches what garm-provider-common does.
+	TemplateFormatGo = "go"
+	// TemplateFormatJinja renders the template with a jinja compatible
+	// context. Only variable expressions are supported.
+	TemplateFormatJinja = "jinja"
+	// TemplateFormatRaw uses the template as is, without rendering it.
+	TemplateFormatRaw = "raw"
+)
+
+var (
+	jinjaCommentRe  = regexp.MustCompile(`(?s)\{#.*?#\}`)
+	jinjaVariableRe = regexp.MustCompile(`\{\{-?\s*([A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)?)\s*-?\}\}`)
+)
+
+// installRunnerParams mirrors the parameters garm-provider-common passes to
+// the runner install template.
+func installRunnerParams(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, extraContext map[string]string) cloudconfig.InstallRunnerParams {
+	installParams := cloudconfig.InstallRunnerParams{
+		FileName:          tools.GetFilename(),
+		DownloadURL:       tools.GetDownloadURL(),
+		TempDownloadToken: tools.GetTempDownloadToken(),
+		MetadataURL:       bootstrapParams.MetadataURL,
+		RunnerUsername:    defaults.DefaultUser,
+		RunnerGroup:       defaults.DefaultUser,
+		RepoURL:           bootstrapParams.RepoURL,
+		RunnerName:        bootstrapParams.Name,
+		RunnerLabels:      strings.Join(bootstrapParams.Labels, ","),
+		CallbackURL:       bootstrapParams.CallbackURL,
+		CallbackToken:     bootstrapParams.InstanceToken,
+		GitHubRunnerGroup: bootstrapParams.GitHubRunnerGroup,
+		ExtraContext:      extraContext,
+		EnableBootDebug:   bootstrapParams.UserDataOptions.EnableBootDebug,
+		UseJITConfig:      bootstrapParams.JitConfigEnabled,
+	}
+	if len(bootstrapParams.CACertBundle) > 0 {
+		installParams.CABundle = string(bootstrapParams.CACertBundle)
+	}
+	return installParams
+}
+
+// jinjaContext returns the variables available to jinja templates. The names
+// are the snake_case versions of the fields available to Go templates.
+func jinjaContext(installParams cloudconfig.InstallRunnerParams) map[string]any {
+	return map[string]any{
+		"file_name":           installParams.FileName,
+		"download_url":        installParams.DownloadURL,
+		"temp_download_token": installParams.TempDownloadToken,
+		"metadata_url":        installParams.MetadataURL,
+		"runner_username":     installParams.RunnerUsername,
+		"runner_group":        installParams.RunnerGroup,
+		"repo_url":            installParams.RepoURL,
+		"runner_name":         installParams.RunnerName,
+		"runner_labels":       installParams.RunnerLabels,
+		"callback_url":        installParams.CallbackURL,
+		"callback_token":      installParams.CallbackToken,
+		"ca_bundle":           installParams.CABundle,
+		"github_runner_group": installParams.GitHubRunnerGroup,
+		"enable_boot_debug":   strconv.FormatBool(installParams.EnableBootDebug),
+		"use_jit_config":      strconv.FormatBool(installParams.UseJITConfig),
+		"extra_context":       installParams.ExtraContext,
+	}
+}
+
+func lookupJinjaVariable(ctx map[string]any, name string) (string, error) {
+	key, field, nested := strings.Cut(name, ".")
+	val, ok := ctx[key]
+	if !ok {
+		return "", fmt.Errorf("undefined variable %q", name)
+	}
+
+	switch v := val.(type) {
+	case string:
+		if nested {
+			return "", fmt.Errorf("variable %q has no attribute %q", key, field)
+		}
+		return v, nil
+	case map[string]string:
+		if !nested {
+			return "", fmt.Errorf("variable %q is a mapping", key)
+		}
+		// Jinja renders missing keys as empty strings.
+		return v[field], nil
+	}
+	return "", fmt.Errorf("unsupported variable %q", name)
+}
+
+// renderJinjaTemplate expands the variable expressions in tpl. Statements
+// like {% if %} or filters can't be evaluated without a jinja engine, so
+// they are rejected instead of being passed through unrendered.
+func renderJinjaTemplate(tpl string, ctx map[string]any) ([]byte, error) {
+	tpl = jinjaCommentRe.ReplaceAllString(tpl, "")
+
+	var renderErr error
+	rendered := jinjaVariableRe.ReplaceAllStringFunc(tpl, func(expr string) string {
+		if renderErr != nil {
+			return ""
+		}
+		name := jinjaVariableRe.FindStringSubmatch(expr)[1]
+		val, err := lookupJinjaVariable(ctx, name)
+		if err != nil {
+			renderErr = err
+		}
+		return val
+	})
+	if renderErr != nil {
+		return nil, renderErr
+	}
+
+	for _, delim := range []string{"{{", "{%"} {
+		if idx := strings.Index(rendered, delim); idx != -1 {
+			end := min(idx+40, len(rendered))
+			return nil, fmt.Errorf("unsupported jinja expression near %q", rendered[idx:end])
+		}
+	}
+	return []byte(rendered), nil
+}
+
+// runnerInstallScript returns the runner install script, rendered according
+// to the runner_install_template_format extra spec.
+func (r *RunnerSpec) runnerInstallScript(bootstrapParams params.BootstrapInstance) ([]byte, error) {
+	specs, err := cloudconfig.GetSpecs(bootstrapParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cloud config specs: %w", err)
+	}
+	if len(specs.RunnerInstallTemplate) == 0 {
+		return nil, fmt.Errorf("runner_install_template is required when using the %s template format", r.RunnerInstallTemplateFormat)
+	}
+
+	switch r.RunnerInstallTemplateFormat {
+	case TemplateFormatRaw:
+		return specs.RunnerInstallTemplate, nil
+	case TemplateFormatJinja:
+		installParams := installRunnerParams(bootstrapParams, r.Tools, specs.ExtraContext)
+		script, err := renderJinjaTemplate(string(specs.RunnerInstallTemplate), jinjaContext(installParams))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render jinja template: %w", err)
+		}
+		return script, nil
+	}
+	return nil, fmt.Errorf("unsupported template format: %s", r.RunnerInstallTemplateFormat)
+}
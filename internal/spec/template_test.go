@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderJinjaTemplate(t *testing.T) {
+	ctx := map[string]any{
+		"runner_name":   "garm-runner",
+		"extra_context": map[string]string{"go_version": "1.22.4"},
+	}
+
+	tests := []struct {
+		name      string
+		tpl       string
+		expected  string
+		errString string
+	}{
+		{
+			name:     "variables",
+			tpl:      "NAME={{ runner_name }} GO={{extra_context.go_version}} MISSING={{ extra_context.missing }}",
+			expected: "NAME=garm-runner GO=1.22.4 MISSING=",
+		},
+		{
+			name:     "comments are dropped",
+			tpl:      "{# set by garm #}NAME={{- runner_name -}}",
+			expected: "NAME=garm-runner",
+		},
+		{
+			name:     "shell variables are left alone",
+			tpl:      "echo ${HOME} $(id -u)",
+			expected: "echo ${HOME} $(id -u)",
+		},
+		{
+			name:      "undefined variable",
+			tpl:       "{{ runner_group }}",
+			errString: "undefined variable \"runner_group\"",
+		},
+		{
+			name:      "statements are not supported",
+			tpl:       "{% if enable_boot_debug %}set -x{% endif %}",
+			errString: "unsupported jinja expression",
+		},
+		{
+			name:      "filters are not supported",
+			tpl:       "{{ runner_name | upper }}",
+			errString: "unsupported jinja expression",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := renderJinjaTemplate(tt.tpl, ctx)
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(out))
+		})
+	}
+}
+
+func TestComposeUserDataTemplateFormats(t *testing.T) {
+	tools := params.RunnerApplicationDownload{
+		OS:           aws.String("linux"),
+		Architecture: aws.String("amd64"),
+		DownloadURL:  aws.String("https://example.com/runner.tar.gz"),
+		Filename:     aws.String("runner.tar.gz"),
+	}
+
+	tests := []struct {
+		name      string
+		osType    params.OSType
+		format    string
+		tpl       string
+		contains  string
+		errString string
+	}{
+		{
+			name:     "go template",
+			osType:   params.Linux,
+			format:   TemplateFormatGo,
+			tpl:      "echo {{ .RunnerName }} {{ .ExtraContext.key }}",
+			contains: "echo mock-name value",
+		},
+		{
+			name:     "jinja template",
+			osType:   params.Linux,
+			format:   TemplateFormatJinja,
+			tpl:      "echo {{ runner_name }} {{ extra_context.key }}",
+			contains: "echo mock-name value",
+		},
+		{
+			name:     "raw template",
+			osType:   params.Linux,
+			format:   TemplateFormatRaw,
+			tpl:      "echo {{ runner_name }}",
+			contains: "echo {{ runner_name }}",
+		},
+		{
+			name:     "raw template on windows",
+			osType:   params.Windows,
+			format:   TemplateFormatRaw,
+			tpl:      "Write-Host {{ runner_name }}",
+			contains: "<powershell>Write-Host {{ runner_name }}</powershell>",
+		},
+		{
+			name:      "raw without template",
+			osType:    params.Linux,
+			format:    TemplateFormatRaw,
+			errString: "runner_install_template is required when using the raw template format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extraSpecs := `{"extra_context": {"key": "value"}}`
+			if tt.tpl != "" {
+				extraSpecs = fmt.Sprintf(`{"extra_context": {"key": "value"}, "runner_install_template": %q}`, base64.StdEncoding.EncodeToString([]byte(tt.tpl)))
+			}
+			spec := &RunnerSpec{
+				Tools: tools,
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "mock-name",
+					OSType:     tt.osType,
+					ExtraSpecs: json.RawMessage(extraSpecs),
+				},
+				RunnerInstallTemplateFormat: tt.format,
+			}
+
+			udata, err := spec.ComposeUserData()
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+
+			decoded, err := base64.StdEncoding.DecodeString(udata)
+			require.NoError(t, err)
+			if tt.osType == params.Linux {
+				// The install script is embedded base64 encoded in the
+				// cloud-init config.
+				require.Contains(t, string(decoded), base64.StdEncoding.EncodeToString([]byte(tt.contains)))
+			} else {
+				require.Contains(t, string(decoded), tt.contains)
+			}
+		})
+	}
+}
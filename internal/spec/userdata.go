@@ -0,0 +1,328 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// MaxUserDataSize is the most user data EC2 accepts, before base64 encoding.
+const MaxUserDataSize = 16 * 1024
+
+// UserDataPart is a part added to the user data of Linux instances, next to
+// the cloud-init config of the runner.
+type UserDataPart struct {
+	ContentType string `json:"content_type" jsonschema:"required,enum=text/cloud-config,enum=text/x-shellscript,enum=text/cloud-boothook,enum=text/x-include-url,description=The cloud-init content type of the part."`
+	Filename    string `json:"filename,omitempty" jsonschema:"description=The file name of the part. Scripts are run in the order of their file names. Defaults to part-<n> where n is the position of the part."`
+	Content     []byte `json:"content" jsonschema:"required,description=The content of the part\\, base64 encoded."`
+}
+
+// userDataPartContentTypes are the content types a user data part may have.
+var userDataPartContentTypes = []string{
+	"text/cloud-config",
+	"text/x-shellscript",
+	"text/cloud-boothook",
+	"text/x-include-url",
+}
+
+// GetFilename returns the file name of the part at position idx, starting
+// at 1.
+func (p UserDataPart) GetFilename(idx int) string {
+	if p.Filename == "" {
+		return fmt.Sprintf("part-%d", idx)
+	}
+	return p.Filename
+}
+
+// multipartUserData combines the cloud-init config with the user data parts
+// as a multi-part MIME message, which cloud-init splits up again. Cloud-init
+// configs of all parts are merged.
+func (r *RunnerSpec) multipartUserData(cloudConfig []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\nMIME-Version: 1.0\r\n\r\n", w.Boundary())
+
+	// Parts are numbered from 1, after the config of the runner.
+	parts := append([]UserDataPart{{
+		ContentType: "text/cloud-config",
+		Filename:    "garm-runner.cfg",
+		Content:     cloudConfig,
+	}}, r.UserDataParts...)
+	for idx, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", part.GetFilename(idx)))
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add user data part: %w", err)
+		}
+		if _, err := pw.Write(part.Content); err != nil {
+			return nil, fmt.Errorf("failed to add user data part: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multi-part user data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Tags the user data of Windows instances can be wrapped in.
+const (
+	// WindowsUserDataWrapperPowershell runs the user data with PowerShell.
+	// This is the default.
+	WindowsUserDataWrapperPowershell = "powershell"
+	// WindowsUserDataWrapperScript runs the user data with cmd.exe.
+	WindowsUserDataWrapperScript = "script"
+)
+
+// wrapWindowsUserData wraps the install script in the tags EC2Launch looks
+// for.
+func (r *RunnerSpec) wrapWindowsUserData(script []byte) []byte {
+	tag := r.WindowsUserDataWrapper
+	if tag == "" {
+		tag = WindowsUserDataWrapperPowershell
+	}
+	wrapped := fmt.Sprintf("<%s>%s</%s>", tag, script, tag)
+	if r.WindowsUserDataPersist {
+		wrapped += "<persist>true</persist>"
+	}
+	return []byte(wrapped)
+}
+
+// ErrUserDataTooLarge is returned by ComposeUserData when the user data does
+// not fit in EC2, not even compressed.
+var ErrUserDataTooLarge = errors.New("user data is too large")
+
+// userDataSizeError details which parts of the user data take up the space.
+type userDataSizeError struct {
+	msg string
+}
+
+func (e *userDataSizeError) Error() string {
+	return e.msg
+}
+
+func (e *userDataSizeError) Is(target error) bool {
+	return target == ErrUserDataTooLarge
+}
+
+// offloadedUserDataFile is the file the Linux bootstrap script writes the
+// offloaded cloud-init config to. Config in cloud.cfg.d is read by all the
+// cloud-init stages that follow the boothook.
+const offloadedUserDataFile = "/etc/cloud/cloud.cfg.d/99-garm-user-data.cfg"
+
+// offloadedUserDataAttempts is how often the bootstrap script tries to
+// download the user data, 5 seconds apart. The credentials of the instance
+// profile may take a moment to become available after boot.
+const offloadedUserDataAttempts = 60
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powershellQuote quotes s for PowerShell.
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// ComposeOffloadedUserData returns the user data for an instance whose user
+// data was uploaded to S3, at the given bucket and key, instead of being sent
+// to EC2. The returned script downloads the user data with the credentials
+// of the instance profile and runs it. On Linux it is a boothook that needs
+// the AWS CLI, and on Windows a PowerShell script that needs the AWS Tools
+// for PowerShell.
+func (r *RunnerSpec) ComposeOffloadedUserData(region, bucket, key string) (string, error) {
+	var script string
+	switch r.BootstrapParams.OSType {
+	case params.Linux:
+		// The boothook only knows how to install a cloud-init config.
+		if len(r.UserDataParts) > 0 {
+			return "", fmt.Errorf("user data with user_data_parts can not be offloaded")
+		}
+		object := shellQuote(fmt.Sprintf("s3://%s/%s", bucket, key))
+		script = fmt.Sprintf(`#cloud-boothook
+#!/bin/sh
+# Boothooks run on every boot, but the user data is only needed once.
+[ -s %[1]s ] && exit 0
+for attempt in $(seq %[2]d); do
+    if aws s3 cp --region %[3]s %[4]s %[1]s.tmp; then
+        mv %[1]s.tmp %[1]s
+        exit 0
+    fi
+    sleep 5
+done
+echo failed to download user data from %[4]s >&2
+exit 1
+`, offloadedUserDataFile, offloadedUserDataAttempts, shellQuote(region), object)
+	case params.Windows:
+		// The stub itself is always PowerShell, the downloaded install
+		// script is run the way windows_user_data_wrapper says.
+		file, run := "garm-user-data.ps1", "& $path"
+		if r.WindowsUserDataWrapper == WindowsUserDataWrapperScript {
+			file, run = "garm-user-data.cmd", "& cmd.exe /c $path"
+		}
+		script = fmt.Sprintf(`<powershell>
+$ErrorActionPreference = "Stop"
+$path = Join-Path $env:TEMP "%[5]s"
+for ($attempt = 1; $attempt -le %[1]d; $attempt++) {
+    try {
+        Read-S3Object -Region %[2]s -BucketName %[3]s -Key %[4]s -File $path | Out-Null
+        break
+    } catch {
+        if ($attempt -eq %[1]d) { throw }
+        Start-Sleep -Seconds 5
+    }
+}
+%[6]s
+</powershell>`, offloadedUserDataAttempts, powershellQuote(region), powershellQuote(bucket), powershellQuote(key), file, run)
+		if r.WindowsUserDataPersist {
+			script += "<persist>true</persist>"
+		}
+	default:
+		return "", fmt.Errorf("unsupported OS type for cloud config: %s", r.BootstrapParams.OSType)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(script)), nil
+}
+
+// userDataPart is a part of the user data and the number of bytes it takes
+// up in it.
+type userDataPart struct {
+	name string
+	size int
+}
+
+func gzipUserData(udata []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress user data: %w", err)
+	}
+	if _, err := w.Write(udata); err != nil {
+		return nil, fmt.Errorf("failed to compress user data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress user data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fitUserData returns the user data as it should be sent to EC2. Linux user
+// data over the EC2 limit is gzip compressed, which cloud-init detects and
+// unpacks. If it still doesn't fit, the error lists what takes up the space.
+func (r *RunnerSpec) fitUserData(bootstrapParams params.BootstrapInstance, udata []byte) ([]byte, error) {
+	if len(udata) <= MaxUserDataSize {
+		return udata, nil
+	}
+
+	var compressedSize int
+	// EC2Launch on Windows does not unpack compressed user data.
+	if bootstrapParams.OSType == params.Linux {
+		compressed, err := gzipUserData(udata)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) <= MaxUserDataSize {
+			return compressed, nil
+		}
+		compressedSize = len(compressed)
+	}
+
+	var parts []string
+	for _, part := range r.userDataParts(bootstrapParams, len(udata)) {
+		parts = append(parts, fmt.Sprintf("%s: %d", part.name, part.size))
+	}
+	size := fmt.Sprintf("%d bytes", len(udata))
+	if compressedSize > 0 {
+		size = fmt.Sprintf("%d bytes (%d compressed)", len(udata), compressedSize)
+	}
+	return nil, &userDataSizeError{
+		msg: fmt.Sprintf("user data is %s, over the %d byte limit of EC2 (%s); move large pre-install scripts into the image, run them with the ssm_documents extra spec, or configure user_data_offload",
+			size, MaxUserDataSize, strings.Join(parts, ", ")),
+	}
+}
+
+// userDataParts estimates how much of the user data each of its parts takes
+// up. Whatever can't be attributed to a part, like the cloud-init
+// boilerplate, is reported as "other".
+func (r *RunnerSpec) userDataParts(bootstrapParams params.BootstrapInstance, total int) []userDataPart {
+	// Scripts and certificates are base64 encoded in cloud-init configs.
+	encodedLen := base64.StdEncoding.EncodedLen
+	if bootstrapParams.OSType == params.Windows {
+		encodedLen = func(n int) int { return n }
+	}
+
+	var parts []userDataPart
+	if installScript, err := r.installScript(bootstrapParams); err == nil {
+		parts = append(parts, userDataPart{"runner install script", encodedLen(len(installScript))})
+	}
+
+	if bootstrapParams.OSType == params.Linux {
+		withScripts, err := r.withPreInstallScripts(bootstrapParams)
+		if err == nil {
+			bootstrapParams = withScripts
+		}
+		if specs, err := cloudconfig.GetSpecs(bootstrapParams); err == nil {
+			names := make([]string, 0, len(specs.PreInstallScripts))
+			for name := range specs.PreInstallScripts {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				parts = append(parts, userDataPart{"pre-install script " + name, encodedLen(len(specs.PreInstallScripts[name]))})
+			}
+		}
+		for idx, part := range r.UserDataParts {
+			parts = append(parts, userDataPart{"user data part " + part.GetFilename(idx+1), len(part.Content)})
+		}
+		if len(bootstrapParams.CACertBundle) > 0 {
+			parts = append(parts, userDataPart{"CA certificate bundle", encodedLen(len(bootstrapParams.CACertBundle))})
+		}
+		var packages int
+		for _, pkg := range bootstrapParams.UserDataOptions.ExtraPackages {
+			// Every package is a YAML list item on a line of its own.
+			packages += len(pkg) + len("    - \n")
+		}
+		if packages > 0 {
+			parts = append(parts, userDataPart{"extra packages", packages})
+		}
+	}
+
+	if r.PreBootstrapScript != "" && bootstrapParams.OSType == params.Windows {
+		parts = append(parts, userDataPart{"pre bootstrap script", len(r.PreBootstrapScript)})
+	}
+	if r.PostBootstrapScript != "" {
+		parts = append(parts, userDataPart{"post bootstrap script", encodedLen(len(r.PostBootstrapScript))})
+	}
+
+	other := total
+	for _, part := range parts {
+		other -= part.size
+	}
+	return append(parts, userDataPart{"other", max(other, 0)})
+}
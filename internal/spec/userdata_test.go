@@ -0,0 +1,355 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeUserDataSize(t *testing.T) {
+	tools := params.RunnerApplicationDownload{
+		OS:           aws.String("linux"),
+		Architecture: aws.String("amd64"),
+		DownloadURL:  aws.String("https://example.com/runner.tar.gz"),
+		Filename:     aws.String("runner.tar.gz"),
+	}
+
+	random := make([]byte, MaxUserDataSize)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		osType     params.OSType
+		extraSpecs string
+		compressed bool
+		errString  string
+	}{
+		{
+			name:       "fits uncompressed",
+			osType:     params.Linux,
+			extraSpecs: `{}`,
+		},
+		{
+			name:       "compressed to fit",
+			osType:     params.Linux,
+			extraSpecs: fmt.Sprintf(`{"pre_install_scripts": {"10-big": %q}}`, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("echo hello\n", 2000)))),
+			compressed: true,
+		},
+		{
+			name:       "too large when compressed",
+			osType:     params.Linux,
+			extraSpecs: fmt.Sprintf(`{"pre_install_scripts": {"10-random": %q}}`, base64.StdEncoding.EncodeToString(random)),
+			errString:  "pre-install script 10-random: 21848",
+		},
+		{
+			name:       "windows is not compressed",
+			osType:     params.Windows,
+			extraSpecs: fmt.Sprintf(`{"runner_install_template": %q}`, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("Write-Host hello\n", 1000)))),
+			errString:  "user data is 17",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				Tools: tools,
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "mock-name",
+					OSType:     tt.osType,
+					ExtraSpecs: json.RawMessage(tt.extraSpecs),
+				},
+				RunnerInstallTemplateFormat: TemplateFormatRaw,
+			}
+			if tt.osType == params.Linux {
+				spec.RunnerInstallTemplateFormat = ""
+			}
+
+			udata, err := spec.ComposeUserData()
+			if tt.errString != "" {
+				require.ErrorContains(t, err, tt.errString)
+				require.ErrorContains(t, err, "over the 16384 byte limit of EC2")
+				require.ErrorIs(t, err, ErrUserDataTooLarge)
+				return
+			}
+			require.NoError(t, err)
+
+			decoded, err := base64.StdEncoding.DecodeString(udata)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(decoded), MaxUserDataSize)
+			if !tt.compressed {
+				require.True(t, strings.HasPrefix(string(decoded), "#cloud-config"))
+				return
+			}
+
+			r, err := gzip.NewReader(bytes.NewReader(decoded))
+			require.NoError(t, err)
+			uncompressed, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Greater(t, len(uncompressed), MaxUserDataSize)
+			require.True(t, strings.HasPrefix(string(uncompressed), "#cloud-config"))
+		})
+	}
+}
+
+func TestComposeUserDataWindowsWrapper(t *testing.T) {
+	tests := []struct {
+		name     string
+		wrapper  string
+		persist  bool
+		tpl      string
+		expected string
+	}{
+		{
+			name:     "powershell by default",
+			tpl:      "Write-Host hello",
+			expected: "<powershell>Write-Host hello</powershell>",
+		},
+		{
+			name:     "script",
+			wrapper:  WindowsUserDataWrapperScript,
+			tpl:      "echo hello",
+			expected: "<script>echo hello</script>",
+		},
+		{
+			name:     "persist",
+			wrapper:  WindowsUserDataWrapperPowershell,
+			persist:  true,
+			tpl:      "Write-Host hello",
+			expected: "<powershell>Write-Host hello</powershell><persist>true</persist>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:       "mock-name",
+					OSType:     params.Windows,
+					ExtraSpecs: json.RawMessage(fmt.Sprintf(`{"runner_install_template": %q}`, base64.StdEncoding.EncodeToString([]byte(tt.tpl)))),
+				},
+				RunnerInstallTemplateFormat: TemplateFormatRaw,
+				WindowsUserDataWrapper:      tt.wrapper,
+				WindowsUserDataPersist:      tt.persist,
+			}
+
+			udata, err := spec.ComposeUserData()
+			require.NoError(t, err)
+			decoded, err := base64.StdEncoding.DecodeString(udata)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(decoded))
+		})
+	}
+}
+
+func TestComposeUserDataMultipart(t *testing.T) {
+	spec := &RunnerSpec{
+		Tools: params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("https://example.com/runner.tar.gz"),
+			Filename:     aws.String("runner.tar.gz"),
+		},
+		BootstrapParams: params.BootstrapInstance{
+			Name:       "mock-name",
+			OSType:     params.Linux,
+			ExtraSpecs: json.RawMessage(`{}`),
+		},
+		UserDataParts: []UserDataPart{
+			{ContentType: "text/x-shellscript", Filename: "10-proxy.sh", Content: []byte("#!/bin/sh\necho proxy\n")},
+			{ContentType: "text/cloud-boothook", Content: []byte("#!/bin/sh\necho early\n")},
+		},
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(udata)
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(decoded))
+	require.NoError(t, err)
+	mediaType, mediaParams, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+
+	type part struct {
+		contentType, filename, content string
+	}
+	var parts []part
+	r := multipart.NewReader(msg.Body, mediaParams["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(p)
+		require.NoError(t, err)
+		parts = append(parts, part{p.Header.Get("Content-Type"), p.FileName(), string(content)})
+	}
+
+	require.Len(t, parts, 3)
+	require.Equal(t, `text/cloud-config; charset="utf-8"`, parts[0].contentType)
+	require.Equal(t, "garm-runner.cfg", parts[0].filename)
+	require.True(t, strings.HasPrefix(parts[0].content, "#cloud-config"))
+	require.Equal(t, part{`text/x-shellscript; charset="utf-8"`, "10-proxy.sh", "#!/bin/sh\necho proxy\n"}, parts[1])
+	require.Equal(t, part{`text/cloud-boothook; charset="utf-8"`, "part-2", "#!/bin/sh\necho early\n"}, parts[2])
+}
+
+func TestComposeOffloadedUserData(t *testing.T) {
+	tests := []struct {
+		name      string
+		osType    params.OSType
+		wrapper   string
+		persist   bool
+		parts     []UserDataPart
+		contains  []string
+		errString string
+	}{
+		{
+			name:   "linux",
+			osType: params.Linux,
+			contains: []string{
+				"#cloud-boothook\n#!/bin/sh\n",
+				"aws s3 cp --region 'us-east-1' 's3://garm-user-data/runners/it'\\''s' /etc/cloud/cloud.cfg.d/99-garm-user-data.cfg.tmp",
+			},
+		},
+		{
+			name:   "windows",
+			osType: params.Windows,
+			contains: []string{
+				"<powershell>\n",
+				"Read-S3Object -Region 'us-east-1' -BucketName 'garm-user-data' -Key 'runners/it''s' -File $path",
+				"& $path\n</powershell>",
+			},
+		},
+		{
+			name:    "windows batch script",
+			osType:  params.Windows,
+			wrapper: WindowsUserDataWrapperScript,
+			persist: true,
+			contains: []string{
+				"$path = Join-Path $env:TEMP \"garm-user-data.cmd\"",
+				"& cmd.exe /c $path\n</powershell><persist>true</persist>",
+			},
+		},
+		{
+			name:      "linux with user data parts",
+			osType:    params.Linux,
+			parts:     []UserDataPart{{ContentType: "text/x-shellscript", Content: []byte("echo hello")}},
+			errString: "user data with user_data_parts can not be offloaded",
+		},
+		{
+			name:      "unsupported",
+			osType:    params.OSType("plan9"),
+			errString: "unsupported OS type for cloud config: plan9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "mock-name",
+					OSType: tt.osType,
+				},
+				WindowsUserDataWrapper: tt.wrapper,
+				WindowsUserDataPersist: tt.persist,
+				UserDataParts:          tt.parts,
+			}
+
+			udata, err := spec.ComposeOffloadedUserData("us-east-1", "garm-user-data", "runners/it's")
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+
+			decoded, err := base64.StdEncoding.DecodeString(udata)
+			require.NoError(t, err)
+			for _, s := range tt.contains {
+				require.Contains(t, string(decoded), s)
+			}
+		})
+	}
+}
+
+func TestComposeUserDataDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		osType   params.OSType
+		custom   []byte
+		expected string
+	}{
+		{
+			name:     "no user data",
+			osType:   params.Linux,
+			expected: "",
+		},
+		{
+			name:     "custom user data on linux",
+			osType:   params.Linux,
+			custom:   []byte("#!/bin/sh\n/opt/runner/start.sh\n"),
+			expected: "#!/bin/sh\n/opt/runner/start.sh\n",
+		},
+		{
+			name:     "custom user data on windows is not wrapped",
+			osType:   params.Windows,
+			custom:   []byte("<powershell>C:\\runner\\start.ps1</powershell>"),
+			expected: "<powershell>C:\\runner\\start.ps1</powershell>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "mock-name",
+					OSType: tt.osType,
+				},
+				DisableUserData:     true,
+				CustomUserData:      tt.custom,
+				PreBootstrapScript:  "#!/bin/sh\necho pre\n",
+				PostBootstrapScript: "#!/bin/sh\necho post\n",
+			}
+
+			udata, err := spec.ComposeUserData()
+			require.NoError(t, err)
+			decoded, err := base64.StdEncoding.DecodeString(udata)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(decoded))
+
+			payload, err := spec.UserDataPayload()
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(payload))
+		})
+	}
+}
@@ -18,12 +18,145 @@ package util
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/smithy-go"
 	"github.com/cloudbase/garm-provider-common/params"
 )
 
+const (
+	// BootstrapStatusTag is set on an instance by whatever watches over its
+	// bootstrap (an SSM automation, a health check, or the instance itself)
+	// to report the outcome.
+	BootstrapStatusTag = "garm:bootstrap"
+	// BootstrapStatusFailed marks an instance whose bootstrap failed.
+	BootstrapStatusFailed = "failed"
+	// BootstrapFailedAtTag holds the time, in RFC 3339 format, the provider
+	// marked an instance as having failed to bootstrap.
+	BootstrapFailedAtTag = "garm:bootstrap-failed-at"
+	// KeepOnFailureTag holds how long an instance is kept if it fails to
+	// bootstrap, as a Go duration. It is set on instances of pools with
+	// keep_on_failure.
+	KeepOnFailureTag = "garm:keep-on-failure"
+	// GCAfterTag is set on failed instances that are kept for debugging
+	// after GARM deleted them. It holds the time, in RFC 3339 format, after
+	// which they are terminated.
+	GCAfterTag = "garm:gc-after"
+	// DeleteAfterTag is set on instances GARM deleted while a deletion
+	// grace period is configured. It holds the time, in RFC 3339 format,
+	// after which they are terminated. Removing it cancels the termination.
+	DeleteAfterTag = "garm:delete-after"
+	// MaxRuntimeTag holds how long an instance may run before it is
+	// terminated, as a Go duration. It is set on instances of pools with
+	// max_runtime.
+	MaxRuntimeTag = "garm:max_runtime"
+	// EntityTag holds the GitHub entity (repository, organization or
+	// enterprise) an instance was created for, as returned by Entity.
+	EntityTag = "garm:entity"
+	// EphemeralKeyTag holds the name of the key pair generated for an
+	// instance of a pool with ephemeral_ssh_key. The key pair is deleted
+	// along with the instance.
+	EphemeralKeyTag = "garm:ephemeral-key"
+	// UserDataObjectTag holds the S3 URL of the user data of an instance,
+	// if it was offloaded to S3. The object is deleted along with the
+	// instance.
+	UserDataObjectTag = "garm:user-data-object"
+	// SerialConsoleTag holds the host of the EC2 serial console endpoint
+	// of an instance of a pool with serial_console. The serial console of
+	// the instance is reached as the <instance ID>.port0 user of that host.
+	SerialConsoleTag = "garm:serial-console"
+	// CacheSnapshotTag holds the snapshot a cache volume of a pool with
+	// cache_pool_size was created from. Idle cache volumes are only reused
+	// by instances of the pool that still use that snapshot.
+	CacheSnapshotTag = "garm:cache-snapshot"
+)
+
+// Entity returns the path of the GitHub entity repoURL points to, in lower
+// case, for example "owner/repo" for a repository, "owner" for an
+// organization and "enterprises/name" for an enterprise. It returns an empty
+// string if repoURL can't be parsed.
+func Entity(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(u.Path, "/"))
+}
+
+// IsBootstrapFailed returns true if the instance has been tagged as having
+// failed to bootstrap.
+func IsBootstrapFailed(ec2Instance types.Instance) bool {
+	for _, tag := range ec2Instance.Tags {
+		if tag.Key != nil && *tag.Key == BootstrapStatusTag {
+			return tag.Value != nil && *tag.Value == BootstrapStatusFailed
+		}
+	}
+	return false
+}
+
+// BootstrapFailedAt returns the time the instance was marked as having
+// failed to bootstrap. Instances marked by something else than the provider
+// have no such time, and their launch time is returned instead.
+func BootstrapFailedAt(ec2Instance types.Instance) time.Time {
+	if failedAt, ok := timeTag(ec2Instance, BootstrapFailedAtTag); ok {
+		return failedAt
+	}
+	if ec2Instance.LaunchTime != nil {
+		return *ec2Instance.LaunchTime
+	}
+	return time.Time{}
+}
+
+// GCAfter returns the time after which a kept instance is terminated. It
+// returns false if the instance isn't kept.
+func GCAfter(ec2Instance types.Instance) (time.Time, bool) {
+	return timeTag(ec2Instance, GCAfterTag)
+}
+
+// DeleteAfter returns the time after which an instance GARM deleted is
+// terminated. It returns false if the termination of the instance isn't
+// deferred.
+func DeleteAfter(ec2Instance types.Instance) (time.Time, bool) {
+	return timeTag(ec2Instance, DeleteAfterTag)
+}
+
+// timeTag returns the time, in RFC 3339 format, held by the tag with the
+// given key. It returns false if the instance doesn't have the tag.
+func timeTag(ec2Instance types.Instance, key string) (time.Time, bool) {
+	for _, tag := range ec2Instance.Tags {
+		if tag.Key == nil || *tag.Key != key || tag.Value == nil {
+			continue
+		}
+		after, err := time.Parse(time.RFC3339, *tag.Value)
+		if err != nil {
+			// A mangled tag should not keep the instance forever.
+			return time.Time{}, true
+		}
+		return after, true
+	}
+	return time.Time{}, false
+}
+
+// MaxRuntime returns how long the instance may run, if it has a valid
+// MaxRuntimeTag.
+func MaxRuntime(ec2Instance types.Instance) (time.Duration, bool) {
+	for _, tag := range ec2Instance.Tags {
+		if tag.Key == nil || *tag.Key != MaxRuntimeTag || tag.Value == nil {
+			continue
+		}
+		maxRuntime, err := time.ParseDuration(*tag.Value)
+		if err != nil || maxRuntime <= 0 {
+			return 0, false
+		}
+		return maxRuntime, true
+	}
+	return 0, false
+}
+
 func AwsInstanceToParamsInstance(ec2Instance types.Instance) (params.ProviderInstance, error) {
 	if ec2Instance.InstanceId == nil {
 		return params.ProviderInstance{}, fmt.Errorf("instance ID is nil")
@@ -32,6 +165,7 @@ func AwsInstanceToParamsInstance(ec2Instance types.Instance) (params.ProviderIns
 		ProviderID: *ec2Instance.InstanceId,
 	}
 
+	var bootstrapFailed bool
 	for _, tag := range ec2Instance.Tags {
 		if tag.Key == nil || tag.Value == nil {
 			continue
@@ -43,15 +177,31 @@ func AwsInstanceToParamsInstance(ec2Instance types.Instance) (params.ProviderIns
 			details.OSType = params.OSType(*tag.Value)
 		case "OSArch":
 			details.OSArch = params.OSArch(*tag.Value)
+		case BootstrapStatusTag:
+			bootstrapFailed = *tag.Value == BootstrapStatusFailed
 		}
 	}
 
+	// Instances created without the OSType and OSArch tags (or whose tags
+	// were changed by other automation) are described by EC2 itself.
+	if details.OSType == "" {
+		details.OSType = ec2OSType(ec2Instance)
+	}
+	if details.OSArch == "" {
+		details.OSArch = EC2OSArch(ec2Instance.Architecture)
+	}
+
+	details.Addresses = instanceAddresses(ec2Instance)
+
 	switch ec2Instance.State.Name {
+	case types.InstanceStateNamePending:
+		details.Status = params.InstanceCreating
 	case types.InstanceStateNameRunning,
-		types.InstanceStateNameShuttingDown,
 		types.InstanceStateNameStopping:
 
 		details.Status = params.InstanceRunning
+	case types.InstanceStateNameShuttingDown:
+		details.Status = params.InstanceDeleting
 	case types.InstanceStateNameStopped,
 		types.InstanceStateNameTerminated:
 
@@ -59,9 +209,91 @@ func AwsInstanceToParamsInstance(ec2Instance types.Instance) (params.ProviderIns
 	default:
 		details.Status = params.InstanceStatusUnknown
 	}
+
+	// An instance that failed to bootstrap will never become a runner, so
+	// let GARM know it can be replaced.
+	if bootstrapFailed && details.Status == params.InstanceRunning {
+		details.Status = params.InstanceError
+		details.ProviderFault = InstanceFault(ec2Instance, "instance bootstrap failed")
+	}
 	return details, nil
 }
 
+// InstanceFault returns the provider fault reported to GARM for the instance,
+// along with the details needed to tell what the instance was launched as.
+// GARM has no other place for them, so they are only reported along with a
+// fault.
+func InstanceFault(ec2Instance types.Instance, fault string) []byte {
+	var details []string
+	if ec2Instance.Placement != nil && ec2Instance.Placement.AvailabilityZone != nil {
+		details = append(details, "availability zone "+*ec2Instance.Placement.AvailabilityZone)
+	}
+	if ec2Instance.InstanceType != "" {
+		details = append(details, "instance type "+string(ec2Instance.InstanceType))
+	}
+	if ec2Instance.ImageId != nil {
+		details = append(details, "image "+*ec2Instance.ImageId)
+	}
+	if ec2Instance.LaunchTime != nil {
+		details = append(details, "launched "+ec2Instance.LaunchTime.UTC().Format(time.RFC3339))
+	}
+	if len(details) == 0 {
+		return []byte(fault)
+	}
+	return []byte(fmt.Sprintf("%s (%s)", fault, strings.Join(details, ", ")))
+}
+
+// instanceAddresses returns the IP addresses of the instance. IPv6 addresses
+// are globally routable, so they are reported as public.
+func instanceAddresses(ec2Instance types.Instance) []params.Address {
+	var addresses []params.Address
+	add := func(address *string, addressType params.AddressType) {
+		if address == nil || *address == "" {
+			return
+		}
+		if slices.ContainsFunc(addresses, func(a params.Address) bool { return a.Address == *address }) {
+			return
+		}
+		addresses = append(addresses, params.Address{Address: *address, Type: addressType})
+	}
+
+	add(ec2Instance.PrivateIpAddress, params.PrivateAddress)
+	add(ec2Instance.PublicIpAddress, params.PublicAddress)
+	add(ec2Instance.Ipv6Address, params.PublicAddress)
+	for _, iface := range ec2Instance.NetworkInterfaces {
+		for _, address := range iface.Ipv6Addresses {
+			add(address.Ipv6Address, params.PublicAddress)
+		}
+	}
+	return addresses
+}
+
+func ec2OSType(ec2Instance types.Instance) params.OSType {
+	// The API reports "windows", while the SDK enum is "Windows".
+	if strings.EqualFold(string(ec2Instance.Platform), string(types.PlatformValuesWindows)) {
+		return params.Windows
+	}
+	// Platform is only ever set for Windows. PlatformDetails is set for
+	// every instance, so use it to tell Linux apart from unknown.
+	if ec2Instance.PlatformDetails != nil {
+		return params.Linux
+	}
+	return ""
+}
+
+// EC2OSArch maps an EC2 architecture to the GARM architecture it runs.
+func EC2OSArch(arch types.ArchitectureValues) params.OSArch {
+	switch arch {
+	case types.ArchitectureValuesX8664, types.ArchitectureValuesX8664Mac:
+		return params.Amd64
+	case types.ArchitectureValuesArm64, types.ArchitectureValuesArm64Mac:
+		return params.Arm64
+	case types.ArchitectureValuesI386:
+		return params.I386
+	}
+	return ""
+}
+
 func IsEC2NotFoundErr(err error) bool {
 	var apiErr smithy.APIError
 	ok := errors.As(err, &apiErr)
@@ -71,3 +303,81 @@ func IsEC2NotFoundErr(err error) bool {
 	}
 	return false
 }
+
+// IsTerminationProtectedErr returns true if the instance could not be
+// terminated because it has termination protection (the
+// disableApiTermination attribute) enabled.
+func IsTerminationProtectedErr(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "OperationNotPermitted" &&
+		strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "disableapitermination")
+}
+
+// IsEC2CapacityErr returns true if the error indicates that EC2 does not
+// currently have enough capacity to satisfy the request in the requested
+// availability zone.
+func IsEC2CapacityErr(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "InsufficientInstanceCapacity",
+		"InsufficientCapacity",
+		"InsufficientHostCapacity",
+		"InsufficientReservedInstanceCapacity":
+		return true
+	}
+	return false
+}
+
+// IsEC2UnsupportedInZoneErr returns true if the error indicates that the
+// requested instance type is not offered in the requested availability zone.
+func IsEC2UnsupportedInZoneErr(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "Unsupported" &&
+		strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "availability zone")
+}
+
+// IsEC2VolumeInUseErr returns true if the volume could not be attached or
+// deleted because it is attached to an instance, or is no longer available.
+func IsEC2VolumeInUseErr(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "VolumeInUse", "IncorrectState":
+		return true
+	}
+	return false
+}
+
+// IsSSMInvalidInstanceErr returns true if SSM does not (yet) know about the
+// instance. This is the case until the SSM agent on a new instance registers
+// with the service.
+func IsSSMInvalidInstanceErr(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "InvalidInstanceId"
+}
+
+// IsSSMInvocationDoesNotExistErr returns true if SSM does not (yet) know
+// about the invocation of a command on an instance. This is the case for a
+// little while after the command was sent.
+func IsSSMInvocationDoesNotExistErr(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "InvocationDoesNotExist"
+}
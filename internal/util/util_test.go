@@ -18,6 +18,7 @@ package util
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
@@ -102,6 +103,71 @@ func TestAwsInstanceToParamsInstance(t *testing.T) {
 			},
 			errString: "",
 		},
+		{
+			name: "os from instance attributes",
+			ec2Instance: types.Instance{
+				InstanceId:      aws.String("instance_id"),
+				Architecture:    types.ArchitectureValuesArm64,
+				PlatformDetails: aws.String("Linux/UNIX"),
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameRunning,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				OSType:     params.Linux,
+				OSArch:     params.Arm64,
+				Status:     params.InstanceRunning,
+			},
+			errString: "",
+		},
+		{
+			name: "windows from instance attributes",
+			ec2Instance: types.Instance{
+				InstanceId:      aws.String("instance_id"),
+				Architecture:    types.ArchitectureValuesX8664,
+				Platform:        types.PlatformValuesWindows,
+				PlatformDetails: aws.String("Windows"),
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameRunning,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				OSType:     params.Windows,
+				OSArch:     params.Amd64,
+				Status:     params.InstanceRunning,
+			},
+			errString: "",
+		},
+		{
+			name: "tags take precedence over instance attributes",
+			ec2Instance: types.Instance{
+				InstanceId:      aws.String("instance_id"),
+				Architecture:    types.ArchitectureValuesX8664,
+				PlatformDetails: aws.String("Linux/UNIX"),
+				Tags: []types.Tag{
+					{
+						Key:   aws.String("OSType"),
+						Value: aws.String("os_type"),
+					},
+					{
+						Key:   aws.String("OSArch"),
+						Value: aws.String("os_arch"),
+					},
+				},
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameRunning,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				OSType:     params.OSType("os_type"),
+				OSArch:     params.OSArch("os_arch"),
+				Status:     params.InstanceRunning,
+			},
+			errString: "",
+		},
 		{
 			name: "terminated status",
 			ec2Instance: types.Instance{
@@ -133,6 +199,144 @@ func TestAwsInstanceToParamsInstance(t *testing.T) {
 			},
 			errString: "",
 		},
+		{
+			name: "failed bootstrap",
+			ec2Instance: types.Instance{
+				InstanceId: aws.String("instance_id"),
+				Tags: []types.Tag{
+					{
+						Key:   aws.String("Name"),
+						Value: aws.String("name"),
+					},
+					{
+						Key:   aws.String(BootstrapStatusTag),
+						Value: aws.String(BootstrapStatusFailed),
+					},
+				},
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameRunning,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID:    "instance_id",
+				Name:          "name",
+				Status:        params.InstanceError,
+				ProviderFault: []byte("instance bootstrap failed"),
+			},
+			errString: "",
+		},
+		{
+			name: "failed bootstrap with instance details",
+			ec2Instance: types.Instance{
+				InstanceId:   aws.String("instance_id"),
+				InstanceType: types.InstanceTypeT3Small,
+				ImageId:      aws.String("ami-0123456789abcdef0"),
+				LaunchTime:   aws.Time(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+				Placement: &types.Placement{
+					AvailabilityZone: aws.String("us-east-1a"),
+				},
+				Tags: []types.Tag{
+					{
+						Key:   aws.String(BootstrapStatusTag),
+						Value: aws.String(BootstrapStatusFailed),
+					},
+				},
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameRunning,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID:    "instance_id",
+				Status:        params.InstanceError,
+				ProviderFault: []byte("instance bootstrap failed (availability zone us-east-1a, instance type t3.small, image ami-0123456789abcdef0, launched 2024-05-01T12:00:00Z)"),
+			},
+			errString: "",
+		},
+		{
+			name: "addresses",
+			ec2Instance: types.Instance{
+				InstanceId:       aws.String("instance_id"),
+				PrivateIpAddress: aws.String("10.0.0.10"),
+				PublicIpAddress:  aws.String("203.0.113.10"),
+				Ipv6Address:      aws.String("2001:db8::10"),
+				NetworkInterfaces: []types.InstanceNetworkInterface{
+					{
+						Ipv6Addresses: []types.InstanceIpv6Address{
+							{Ipv6Address: aws.String("2001:db8::10")},
+							{Ipv6Address: aws.String("2001:db8::11")},
+						},
+					},
+				},
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameRunning,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				Addresses: []params.Address{
+					{Address: "10.0.0.10", Type: params.PrivateAddress},
+					{Address: "203.0.113.10", Type: params.PublicAddress},
+					{Address: "2001:db8::10", Type: params.PublicAddress},
+					{Address: "2001:db8::11", Type: params.PublicAddress},
+				},
+				Status: params.InstanceRunning,
+			},
+			errString: "",
+		},
+		{
+			name: "failed bootstrap on stopped instance",
+			ec2Instance: types.Instance{
+				InstanceId: aws.String("instance_id"),
+				Tags: []types.Tag{
+					{
+						Key:   aws.String(BootstrapStatusTag),
+						Value: aws.String(BootstrapStatusFailed),
+					},
+				},
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameStopped,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				Status:     params.InstanceStopped,
+			},
+			errString: "",
+		},
+		{
+			name: "pending instance",
+			ec2Instance: types.Instance{
+				InstanceId: aws.String("instance_id"),
+				State: &types.InstanceState{
+					Name: types.InstanceStateNamePending,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				Status:     params.InstanceCreating,
+			},
+			errString: "",
+		},
+		{
+			name: "shutting down instance",
+			ec2Instance: types.Instance{
+				InstanceId: aws.String("instance_id"),
+				Tags: []types.Tag{
+					{
+						Key:   aws.String(BootstrapStatusTag),
+						Value: aws.String(BootstrapStatusFailed),
+					},
+				},
+				State: &types.InstanceState{
+					Name: types.InstanceStateNameShuttingDown,
+				},
+			},
+			want: params.ProviderInstance{
+				ProviderID: "instance_id",
+				Status:     params.InstanceDeleting,
+			},
+			errString: "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -182,3 +386,287 @@ func TestIsEC2NotFoundErr(t *testing.T) {
 		})
 	}
 }
+
+func TestIsEC2CapacityErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "insufficient instance capacity",
+			err: &smithy.GenericAPIError{
+				Code: "InsufficientInstanceCapacity",
+			},
+			want: true,
+		},
+		{
+			name: "insufficient host capacity",
+			err: &smithy.GenericAPIError{
+				Code: "InsufficientHostCapacity",
+			},
+			want: true,
+		},
+		{
+			name: "other api error",
+			err: &smithy.GenericAPIError{
+				Code: "InvalidParameterValue",
+			},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("other error"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsEC2CapacityErr(tt.err)
+			require.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func TestIsEC2UnsupportedInZoneErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "instance type not offered in zone",
+			err: &smithy.GenericAPIError{
+				Code:    "Unsupported",
+				Message: "Your requested instance type (p5.48xlarge) is not supported in your requested Availability Zone (us-east-1e).",
+			},
+			want: true,
+		},
+		{
+			name: "other unsupported request",
+			err: &smithy.GenericAPIError{
+				Code:    "Unsupported",
+				Message: "The requested configuration is currently not supported.",
+			},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("other error"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, IsEC2UnsupportedInZoneErr(tt.err))
+		})
+	}
+}
+
+func TestIsEC2VolumeInUseErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "volume attached to another instance",
+			err: &smithy.GenericAPIError{
+				Code:    "VolumeInUse",
+				Message: "vol-0a0a0a0a0a0a0a0a0 is already attached to an instance",
+			},
+			want: true,
+		},
+		{
+			name: "volume not available",
+			err: &smithy.GenericAPIError{
+				Code:    "IncorrectState",
+				Message: "vol-0a0a0a0a0a0a0a0a0 is not 'available'.",
+			},
+			want: true,
+		},
+		{
+			name: "volume not found",
+			err: &smithy.GenericAPIError{
+				Code:    "InvalidVolume.NotFound",
+				Message: "The volume 'vol-0a0a0a0a0a0a0a0a0' does not exist.",
+			},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("other error"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, IsEC2VolumeInUseErr(tt.err))
+		})
+	}
+}
+
+func TestEntity(t *testing.T) {
+	tests := []struct {
+		repoURL string
+		want    string
+	}{
+		{repoURL: "https://github.com/Owner/Repo", want: "owner/repo"},
+		{repoURL: "https://github.com/owner/", want: "owner"},
+		{repoURL: "https://ghes.example.com/enterprises/example", want: "enterprises/example"},
+		{repoURL: "", want: ""},
+		{repoURL: "://invalid", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repoURL, func(t *testing.T) {
+			require.Equal(t, tt.want, Entity(tt.repoURL))
+		})
+	}
+}
+
+func TestIsTerminationProtectedErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "termination protection",
+			err: &smithy.GenericAPIError{
+				Code:    "OperationNotPermitted",
+				Message: "The instance 'i-1234567890abcdef0' may not be terminated. Modify its 'disableApiTermination' instance attribute and try again.",
+			},
+			want: true,
+		},
+		{
+			name: "other operation not permitted",
+			err: &smithy.GenericAPIError{
+				Code:    "OperationNotPermitted",
+				Message: "The instance is managed by another service.",
+			},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("other error"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsTerminationProtectedErr(tt.err)
+			require.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func TestIsSSMInvalidInstanceErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "invalid instance",
+			err: &smithy.GenericAPIError{
+				Code: "InvalidInstanceId",
+			},
+			want: true,
+		},
+		{
+			name: "other api error",
+			err: &smithy.GenericAPIError{
+				Code: "InvalidDocument",
+			},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("other error"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsSSMInvalidInstanceErr(tt.err)
+			require.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func TestIsSSMInvocationDoesNotExistErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "invocation does not exist",
+			err: &smithy.GenericAPIError{
+				Code: "InvocationDoesNotExist",
+			},
+			want: true,
+		},
+		{
+			name: "other api error",
+			err: &smithy.GenericAPIError{
+				Code: "InvalidInstanceId",
+			},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("other error"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsSSMInvocationDoesNotExistErr(tt.err)
+			require.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func TestBootstrapFailedAt(t *testing.T) {
+	launched := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	failed := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		instance types.Instance
+		expected time.Time
+	}{
+		{
+			name: "marked by the provider",
+			instance: types.Instance{
+				LaunchTime: &launched,
+				Tags: []types.Tag{
+					{Key: aws.String(BootstrapFailedAtTag), Value: aws.String(failed.Format(time.RFC3339))},
+				},
+			},
+			expected: failed,
+		},
+		{
+			name:     "marked by something else",
+			instance: types.Instance{LaunchTime: &launched},
+			expected: launched,
+		},
+		{
+			name:     "no launch time",
+			instance: types.Instance{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.True(t, tt.expected.Equal(BootstrapFailedAt(tt.instance)))
+		})
+	}
+}
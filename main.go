@@ -17,10 +17,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/metrics"
 	"github.com/cloudbase/garm-provider-aws/provider"
 	"github.com/cloudbase/garm-provider-common/execution"
 )
@@ -30,11 +34,40 @@ var signals = []os.Signal{
 	syscall.SIGTERM,
 }
 
+// subcommands are run instead of the provider when their name is the first
+// argument.
+var subcommands = map[string]func(ctx context.Context, args []string) error{
+	"compliance":     runCompliance,
+	"gc":             runGC,
+	"benchmark":      runBenchmark,
+	"healthcheck":    runHealthcheck,
+	"iam-policy":     runIAMPolicy,
+	"schema":         runSchema,
+	"serial-console": runSerialConsole,
+	"ssh-via-eice":   runSSHViaEICE,
+	"status":         runStatus,
+	"version":        runVersion,
+}
+
 func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), signals...)
 	defer stop()
 
+	// Until the provider config is loaded, only the environment configures
+	// logging.
+	slog.SetDefault(config.Logging{}.NewLogger(os.Stderr))
+
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(ctx, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %q\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	executionEnv, err := execution.GetEnvironment()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting environment: %q", err)
@@ -47,12 +80,47 @@ func main() {
 		os.Exit(1)
 	}
 
+	logger := slog.With(
+		"operation", os.Getenv("GARM_COMMAND"),
+		"controller_id", executionEnv.ControllerID,
+		"pool_id", os.Getenv("GARM_POOL_ID"),
+		"instance", os.Getenv("GARM_INSTANCE_ID"),
+	)
+	start := time.Now()
 	result, err := executionEnv.Run(ctx, prov)
+	latency := time.Since(start)
+	recordOperation(os.Getenv("GARM_COMMAND"), latency, err)
+	diagnostics := metrics.Diagnose(latency)
+	if metrics.DiagnosticsEnabled() {
+		// GARM parses stdout strictly, so diagnostics only go to the log.
+		logger.Info("operation diagnostics", "diagnostics", diagnostics)
+	}
 	if err != nil {
+		logger.Error("operation failed", "latency", latency, "aws_calls", diagnostics.AWSCalls, "error", err)
 		fmt.Fprintf(os.Stderr, "failed to run command: %+v\n", err)
 		os.Exit(1)
 	}
+	logger.Debug("operation finished", "latency", latency, "aws_calls", diagnostics.AWSCalls)
 	if len(result) > 0 {
 		fmt.Fprint(os.Stdout, result)
 	}
 }
+
+// recordOperation emits the metrics of an operation GARM ran.
+func recordOperation(operation string, latency time.Duration, err error) {
+	tags := map[string]string{
+		"operation": operation,
+		"outcome":   "success",
+	}
+	if err != nil {
+		tags["outcome"] = "failure"
+		if code := config.ErrorCode(err); code != "" {
+			tags["error_code"] = code
+		}
+	}
+	metrics.Count("operations", 1, tags)
+	metrics.Timing("operation_latency", latency, tags)
+	if err := metrics.Default().Close(); err != nil {
+		slog.Debug("failed to close metrics recorder", "error", err)
+	}
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+	"github.com/cloudbase/garm-provider-aws/internal/util"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// newEnvironmentClients returns a client for every environment of the
+// config, by name.
+func newEnvironmentClients(ctx context.Context, conf *config.Config) (map[string]*client.AwsCli, error) {
+	clients := make(map[string]*client.AwsCli, len(conf.Environments))
+	for _, name := range conf.EnvironmentNames() {
+		envConf, err := conf.ForEnvironment(name)
+		if err != nil {
+			return nil, err
+		}
+		awsCli, err := client.NewAwsCli(ctx, envConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get AWS CLI of environment %s: %w", name, err)
+		}
+		clients[name] = awsCli
+	}
+	return clients, nil
+}
+
+// clientFor returns the client of the environment the extra specs of the
+// pool select.
+func (a *AwsProvider) clientFor(bootstrapParams params.BootstrapInstance) (*client.AwsCli, error) {
+	name, err := spec.GetEnvironment(a.awsCli.Config(), bootstrapParams)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return a.awsCli, nil
+	}
+	awsCli, ok := a.environments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment %q", name)
+	}
+	return awsCli, nil
+}
+
+// clients returns the client of the provider config, followed by the
+// clients of the environments in name order.
+func (a *AwsProvider) clients() []*client.AwsCli {
+	clients := []*client.AwsCli{a.awsCli}
+	for _, name := range a.awsCli.Config().EnvironmentNames() {
+		if awsCli, ok := a.environments[name]; ok {
+			clients = append(clients, awsCli)
+		}
+	}
+	return clients
+}
+
+// findInstance looks for the instance, by ID or name, in every environment,
+// and returns it along with the client of the environment it was found in.
+// If looking in an environment fails for another reason than the instance
+// not being there, the client of that environment is returned with the
+// error.
+func (a *AwsProvider) findInstance(ctx context.Context, instance string) (*client.AwsCli, types.Instance, error) {
+	var notFound error
+	for _, awsCli := range a.clients() {
+		awsInstance, err := awsCli.FindOneInstance(ctx, a.controllerID, instance)
+		if err == nil {
+			return awsCli, awsInstance, nil
+		}
+		// EC2 doesn't know the IDs of instances of other regions or
+		// accounts.
+		if !errors.Is(err, garmErrors.ErrNotFound) && !util.IsEC2NotFoundErr(err) {
+			return awsCli, types.Instance{}, err
+		}
+		if notFound == nil {
+			notFound = err
+		}
+	}
+	return nil, types.Instance{}, notFound
+}
+
+// clientOf returns the client of the environment the instance is in. Without
+// environments, the instance isn't looked up.
+func (a *AwsProvider) clientOf(ctx context.Context, instance string) (*client.AwsCli, error) {
+	if len(a.environments) == 0 {
+		return a.awsCli, nil
+	}
+	awsCli, _, err := a.findInstance(ctx, instance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine instance: %w", err)
+	}
+	return awsCli, nil
+}
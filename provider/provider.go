@@ -17,100 +17,269 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/cloudbase/garm-provider-aws/config"
 	"github.com/cloudbase/garm-provider-aws/internal/client"
+	"github.com/cloudbase/garm-provider-aws/internal/metrics"
 	"github.com/cloudbase/garm-provider-aws/internal/spec"
 	"github.com/cloudbase/garm-provider-aws/internal/util"
 	garmErrors "github.com/cloudbase/garm-provider-common/errors"
-	execution "github.com/cloudbase/garm-provider-common/execution/v0.1.0"
+	execution "github.com/cloudbase/garm-provider-common/execution/v0.1.1"
 	"github.com/cloudbase/garm-provider-common/params"
 )
 
 var _ execution.ExternalProvider = &AwsProvider{}
 
-var Version = "v0.0.0-unknown"
+// interruptedFault prefixes the provider fault of instances whose create was
+// interrupted after they were launched.
+const interruptedFault = "retryable: create interrupted"
 
 func NewAwsProvider(ctx context.Context, configPath, controllerID string) (execution.ExternalProvider, error) {
 	conf, err := config.NewConfig(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("error loading config: %w", err)
 	}
+	if err := spec.ValidateDefaultExtraSpecs(conf); err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	slog.SetDefault(conf.Logging.NewLogger(os.Stderr))
+	recorder, err := conf.Metrics.NewRecorder()
+	if err != nil {
+		return nil, fmt.Errorf("error setting up metrics: %w", err)
+	}
+	metrics.SetDefault(recorder)
+	metrics.SetDiagnostics(conf.Metrics.Diagnostics)
 	awsCli, err := client.NewAwsCli(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get AWS CLI: %w", err)
 	}
+	environments, err := newEnvironmentClients(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
 
 	return &AwsProvider{
 		controllerID: controllerID,
 		awsCli:       awsCli,
+		environments: environments,
 	}, nil
 }
 
 type AwsProvider struct {
 	controllerID string
 	awsCli       *client.AwsCli
+	// environments are the clients of the environments of the config, by
+	// name.
+	environments map[string]*client.AwsCli
 }
 
 func (a *AwsProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
-	spec, err := spec.GetRunnerSpecFromBootstrapParams(a.awsCli.Config(), bootstrapParams, a.controllerID)
+	awsCli, err := a.clientFor(bootstrapParams)
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to get runner spec: %w", err)
+		err = fmt.Errorf("failed to get environment: %w", err)
+		a.awsCli.RecordCreateFailure(bootstrapParams, err)
+		return params.ProviderInstance{}, err
 	}
 
-	instanceID, err := a.awsCli.CreateRunningInstance(ctx, spec)
+	spec, err := spec.GetRunnerSpecFromBootstrapParams(awsCli.Config(), bootstrapParams, a.controllerID)
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create instance: %w", err)
+		err = fmt.Errorf("failed to get runner spec: %w", err)
+		awsCli.RecordCreateFailure(bootstrapParams, err)
+		return params.ProviderInstance{}, err
 	}
 
+	instanceID, err := awsCli.CreateRunningInstance(ctx, spec)
+	if err == nil {
+		if timeout := awsCli.Config().GetWaitForRunning(); timeout > 0 {
+			// GARM deletes instances that failed to be created, by name.
+			err = awsCli.WaitForRunning(ctx, instanceID, timeout)
+		}
+	}
+	if err != nil {
+		if instanceID != "" && errors.Is(err, client.ErrWaitInterrupted) {
+			return a.interruptedInstance(ctx, awsCli, bootstrapParams, instanceID, err), nil
+		}
+		err = fmt.Errorf("failed to create instance: %w", err)
+		awsCli.RecordCreateFailure(bootstrapParams, err)
+		return params.ProviderInstance{}, err
+	}
+
+	slog.InfoContext(ctx, "created instance", "name", spec.BootstrapParams.Name, "instance_id", instanceID, "subnet_id", spec.SubnetID)
+
+	status := params.InstanceRunning
+	if awsCli.Config().GetWaitForRunning() == 0 {
+		status = launchedStatus(ctx, awsCli, instanceID)
+	}
 	instance := params.ProviderInstance{
 		ProviderID: instanceID,
 		Name:       spec.BootstrapParams.Name,
 		OSType:     spec.BootstrapParams.OSType,
 		OSArch:     spec.BootstrapParams.OSArch,
-		Status:     "running",
+		Status:     status,
 	}
 
 	return instance, nil
 
 }
 
+// launchedStatus returns the status of an instance that was created without
+// waiting for it to run, from its EC2 state. A pending instance is reported
+// as pending_create. An instance that can't be described yet was only just
+// launched, so it is pending too.
+func launchedStatus(ctx context.Context, awsCli *client.AwsCli, instanceID string) params.InstanceStatus {
+	details, err := awsCli.GetInstance(ctx, instanceID)
+	if err != nil {
+		slog.DebugContext(ctx, "failed to get state of new instance", "instance_id", instanceID, "error", err)
+		return params.InstancePendingCreate
+	}
+	if details.State == nil || details.State.Name == types.InstanceStateNamePending {
+		return params.InstancePendingCreate
+	}
+	known, err := util.AwsInstanceToParamsInstance(details)
+	if err != nil {
+		return params.InstancePendingCreate
+	}
+	return known.Status
+}
+
+// interruptedInstance returns the instance a create launched before it was
+// interrupted while waiting on it, in its last known state, so that GARM
+// keeps track of it instead of orphaning it. The provider fault marks the
+// create as retryable: creating the instance again picks up a pending or
+// running instance instead of launching another one.
+func (a *AwsProvider) interruptedInstance(ctx context.Context, awsCli *client.AwsCli, bootstrapParams params.BootstrapInstance, instanceID string, cause error) params.ProviderInstance {
+	instance := params.ProviderInstance{
+		ProviderID: instanceID,
+		Name:       bootstrapParams.Name,
+		OSType:     bootstrapParams.OSType,
+		OSArch:     bootstrapParams.OSArch,
+		Status:     params.InstanceCreating,
+	}
+	details, err := awsCli.LastKnownInstance(ctx, instanceID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to get state of interrupted instance", "instance_id", instanceID, "error", err)
+	} else if known, err := util.AwsInstanceToParamsInstance(details); err == nil {
+		instance = known
+	}
+
+	fault := fmt.Sprintf("%s: %s", interruptedFault, cause)
+	if len(instance.ProviderFault) > 0 {
+		fault = fmt.Sprintf("%s; %s", fault, instance.ProviderFault)
+	}
+	instance.ProviderFault = []byte(fault)
+	slog.WarnContext(ctx, "create interrupted, returning instance in its last known state", "instance_id", instanceID, "status", instance.Status)
+	return instance
+}
+
 func (a *AwsProvider) DeleteInstance(ctx context.Context, instance string) error {
 	var inst string
+	var details types.Instance
+	awsCli := a.awsCli
 	if strings.HasPrefix(instance, "i-") {
 		inst = instance
+		// The details are only needed to tell whether a failed instance is
+		// kept or its termination deferred, so failing to get them doesn't
+		// prevent the termination.
+		tmpCli, tmp, err := a.findInstance(ctx, inst)
+		if err != nil && !errors.Is(err, garmErrors.ErrNotFound) && !util.IsEC2NotFoundErr(err) {
+			slog.WarnContext(ctx, "failed to get instance", "instance", inst, "error", err)
+		}
+		if err == nil {
+			awsCli = tmpCli
+		}
+		details = tmp
 	} else {
-		tmp, err := a.awsCli.FindOneInstance(ctx, "", instance)
+		tmpCli, tmp, err := a.findInstance(ctx, instance)
 		if err != nil {
 			if errors.Is(err, garmErrors.ErrNotFound) {
 				return nil
 			}
+			// The duplicates are in the environment that reported them.
+			if errors.Is(err, client.ErrDuplicateInstances) && tmpCli.Config().TerminateDuplicates {
+				return a.deleteDuplicates(ctx, tmpCli, instance)
+			}
 			return fmt.Errorf("failed to determine instance: %w", err)
 		}
 
 		if tmp.InstanceId == nil {
-			return fmt.Errorf("failed to determine instance: %w", err)
+			return nil
 		}
 		inst = *tmp.InstanceId
+		details = tmp
+		awsCli = tmpCli
 	}
 
 	if inst == "" {
 		return nil
 	}
 
-	if err := a.awsCli.TerminateInstance(ctx, inst); err != nil {
+	return a.deleteInstance(ctx, awsCli, inst, details)
+}
+
+// deleteDuplicates deletes every instance of the controller with the given
+// name in the environment of awsCli.
+func (a *AwsProvider) deleteDuplicates(ctx context.Context, awsCli *client.AwsCli, name string) error {
+	instances, err := awsCli.FindDuplicateInstances(ctx, a.controllerID, name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, instance := range instances {
+		if instance.InstanceId == nil {
+			continue
+		}
+		slog.InfoContext(ctx, "deleting duplicate instance", "instance_id", *instance.InstanceId, "name", name, "duplicates", len(instances))
+		if err := a.deleteInstance(ctx, awsCli, *instance.InstanceId, instance); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteInstance terminates the instance, unless it is kept for debugging or
+// its termination is deferred.
+func (a *AwsProvider) deleteInstance(ctx context.Context, awsCli *client.AwsCli, inst string, details types.Instance) error {
+	retained, err := awsCli.RetainFailedInstance(ctx, details)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to keep instance", "instance", inst, "error", err)
+	}
+	if retained {
+		return nil
+	}
+
+	deferred, err := awsCli.DeferTermination(ctx, details)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to defer termination of instance", "instance", inst, "error", err)
+	}
+	if deferred {
+		return nil
+	}
+
+	if err := awsCli.TerminateInstance(ctx, inst, "DeleteInstance requested by GARM"); err != nil {
 		return fmt.Errorf("failed to terminate instance: %w", err)
 	}
+	awsCli.DeleteEphemeralKeyPair(ctx, details)
+	awsCli.DeleteUserDataObject(ctx, details)
+
+	if timeout := awsCli.Config().GetWaitForTerminated(); timeout > 0 {
+		// The instance keeps its name until it is terminated.
+		if err := awsCli.WaitForTerminated(ctx, inst, timeout); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 func (a *AwsProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
-	awsInstance, err := a.awsCli.FindOneInstance(ctx, "", instance)
+	awsCli, awsInstance, err := a.findInstance(ctx, instance)
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to get VM details: %w", err)
 	}
@@ -122,46 +291,157 @@ func (a *AwsProvider) GetInstance(ctx context.Context, instance string) (params.
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to convert instance: %w", err)
 	}
+
+	if providerInstance.Status == params.InstanceRunning {
+		// Not knowing the volume status is no reason to fail the lookup.
+		fault, err := awsCli.RootVolumeFault(ctx, awsInstance)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to check root volume", "instance_id", providerInstance.ProviderID, "error", err)
+		} else if fault != "" {
+			providerInstance.Status = params.InstanceError
+			providerInstance.ProviderFault = util.InstanceFault(awsInstance, fault)
+		}
+	}
 	return providerInstance, nil
 }
 
 func (a *AwsProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
-	awsInstances, err := a.awsCli.ListDescribedInstances(ctx, poolID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list instances: %w", err)
-	}
-
 	var providerInstances []params.ProviderInstance
-	for _, val := range awsInstances {
-		inst, err := util.AwsInstanceToParamsInstance(val)
+	var errs []error
+	clients := a.clients()
+	// Environments may share a region and account.
+	seen := map[string]bool{}
+	for _, awsCli := range clients {
+		awsInstances, err := awsCli.ListDescribedInstances(ctx, poolID)
 		if err != nil {
-			return []params.ProviderInstance{}, fmt.Errorf("failed to convert instance: %w", err)
+			// An environment that can't be reached doesn't hide the
+			// instances of the others.
+			slog.WarnContext(ctx, "failed to list instances", "region", awsCli.Config().Region, "error", err)
+			errs = append(errs, err)
+			continue
 		}
-		providerInstances = append(providerInstances, inst)
+
+		if awsCli.Config().ReapOnList {
+			// Failed instances kept for debugging were already deleted as
+			// far as GARM is concerned.
+			awsInstances = awsCli.ReapRetainedInstances(ctx, awsInstances)
+			// So were instances whose termination is deferred.
+			awsInstances = awsCli.ReapDeletedInstances(ctx, awsInstances)
+			// Runners stuck on a job are recycled once they run out of time.
+			awsInstances = awsCli.EnforceMaxRuntime(ctx, awsInstances)
+		} else {
+			// Listing doesn't terminate anything. Expired instances are
+			// left to the gc command.
+			awsInstances = client.ActiveInstances(awsInstances)
+		}
+
+		for _, val := range awsInstances {
+			inst, err := util.AwsInstanceToParamsInstance(val)
+			if err != nil {
+				return []params.ProviderInstance{}, fmt.Errorf("failed to convert instance: %w", err)
+			}
+			if seen[inst.ProviderID] {
+				continue
+			}
+			seen[inst.ProviderID] = true
+			providerInstances = append(providerInstances, inst)
+		}
+	}
+	if len(errs) == len(clients) {
+		return nil, fmt.Errorf("failed to list instances: %w", errors.Join(errs...))
 	}
 
 	return providerInstances, nil
 }
 
 func (a *AwsProvider) RemoveAllInstances(ctx context.Context) error {
-	return nil
+	var errs []error
+	for _, awsCli := range a.clients() {
+		instances, err := awsCli.ListControllerInstances(ctx, a.controllerID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		terminated, err := awsCli.TerminateInstances(ctx, instances, "RemoveAllInstances requested by GARM")
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, instance := range terminated {
+			awsCli.DeleteEphemeralKeyPair(ctx, instance)
+			awsCli.DeleteUserDataObject(ctx, instance)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (a *AwsProvider) Stop(ctx context.Context, instance string, force bool) error {
-	return a.awsCli.StopInstance(ctx, instance)
+	awsCli, err := a.clientOf(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if err := awsCli.StopInstance(ctx, instance, fmt.Sprintf("Stop requested by GARM (force: %t)", force)); err != nil {
+		return err
+	}
+	if timeout := awsCli.Config().GetWaitForStopped(); timeout > 0 {
+		return awsCli.WaitForStopped(ctx, instance, timeout)
+	}
+	return nil
 }
 
 func (a *AwsProvider) Start(ctx context.Context, instance string) error {
-	awsInstance, err := a.awsCli.FindOneInstance(ctx, "", instance)
+	awsCli, awsInstance, err := a.findInstance(ctx, instance)
 	if err != nil {
 		return fmt.Errorf("failed to determine instance: %w", err)
 	}
 	if awsInstance.State.Name == types.InstanceStateNameStopping {
 		return fmt.Errorf("instance %s cannot be started in %s state", instance, awsInstance.State.Name)
 	}
-	return a.awsCli.StartInstance(ctx, instance)
+	return awsCli.StartInstance(ctx, instance, "Start requested by GARM")
 }
 
 func (a *AwsProvider) GetVersion(ctx context.Context) string {
-	return Version
+	return GetVersionInfo().String()
+}
+
+func (a *AwsProvider) GetSupportedInterfaceVersions(ctx context.Context) []string {
+	return supportedInterfaceVersions
+}
+
+// ValidatePoolInfo makes sure instances can be created for a pool with the
+// given extra specs, image and flavor, before GARM creates or updates the
+// pool. The provider config was loaded when creating the provider.
+func (a *AwsProvider) ValidatePoolInfo(ctx context.Context, image string, flavor string, providerConfig string, extraspecs string) error {
+	extraSpecs := json.RawMessage("{}")
+	if extraspecs != "" {
+		extraSpecs = json.RawMessage(extraspecs)
+	}
+	if err := spec.ValidatePoolExtraSpecs(a.awsCli.Config(), extraSpecs); err != nil {
+		return err
+	}
+
+	awsCli, err := a.clientFor(params.BootstrapInstance{ExtraSpecs: extraSpecs})
+	if err != nil {
+		return err
+	}
+	if flavor != "" {
+		subnets, err := spec.PoolSubnets(awsCli.Config(), extraSpecs)
+		if err != nil {
+			return err
+		}
+		if err := awsCli.ValidatePoolFlavor(ctx, flavor, subnets); err != nil {
+			return err
+		}
+	}
+	if image == "" {
+		return nil
+	}
+	return awsCli.ValidatePoolImage(ctx, image, flavor)
+}
+
+func (a *AwsProvider) GetConfigJSONSchema(ctx context.Context) (string, error) {
+	return config.JSONSchema()
+}
+
+func (a *AwsProvider) GetExtraSpecsJSONSchema(ctx context.Context) (string, error) {
+	return spec.ExtraSpecsJSONSchema()
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 	"github.com/cloudbase/garm-provider-aws/config"
 	"github.com/cloudbase/garm-provider-aws/internal/client"
 	"github.com/cloudbase/garm-provider-aws/internal/spec"
@@ -65,7 +66,7 @@ func TestCreateInstance(t *testing.T) {
 		Name:       "garm-instance",
 		OSType:     "linux",
 		OSArch:     "amd64",
-		Status:     "running",
+		Status:     params.InstancePendingCreate,
 	}
 	provider := &AwsProvider{
 		controllerID: "controllerID",
@@ -87,6 +88,26 @@ func TestCreateInstance(t *testing.T) {
 	provider.awsCli.SetConfig(config)
 	provider.awsCli.SetClient(mockComputeClient)
 
+	mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+	mockComputeClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:    aws.String("ami-12345678"),
+				EnaSupport: aws.Bool(true),
+				State:      types.ImageStateAvailable,
+			},
+		},
+	}, nil)
+	mockComputeClient.On("DescribeInstanceTypes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []types.InstanceTypeInfo{
+			{
+				InstanceType: types.InstanceTypeT2Micro,
+				NetworkInfo: &types.NetworkInfo{
+					EnaSupport: types.EnaSupportRequired,
+				},
+			},
+		},
+	}, nil)
 	mockComputeClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
 		Instances: []types.Instance{
 			{
@@ -99,6 +120,58 @@ func TestCreateInstance(t *testing.T) {
 	assert.Equal(t, expectedInstance, result)
 }
 
+func TestLaunchedStatus(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+
+	tests := []struct {
+		name     string
+		state    types.InstanceStateName
+		expected params.InstanceStatus
+	}{
+		{
+			name:     "pending",
+			state:    types.InstanceStateNamePending,
+			expected: params.InstancePendingCreate,
+		},
+		{
+			name:     "running",
+			state:    types.InstanceStateNameRunning,
+			expected: params.InstanceRunning,
+		},
+		{
+			name:     "not described yet",
+			expected: params.InstancePendingCreate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockComputeClient := new(client.MockComputeClient)
+			awsCli := &client.AwsCli{}
+			awsCli.SetConfig(&config.Config{Region: "us-east-1"})
+			awsCli.SetClient(mockComputeClient)
+
+			resp := &ec2.DescribeInstancesOutput{}
+			if tt.state != "" {
+				resp.Reservations = []types.Reservation{
+					{
+						Instances: []types.Instance{
+							{
+								InstanceId: aws.String(instanceID),
+								State:      &types.InstanceState{Name: tt.state},
+							},
+						},
+					},
+				}
+			}
+			mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(resp, nil)
+
+			assert.Equal(t, tt.expected, launchedStatus(ctx, awsCli, instanceID))
+		})
+	}
+}
+
 func TestCreateInstanceError(t *testing.T) {
 	ctx := context.Background()
 	instanceID := "i-1234567890abcdef0"
@@ -148,6 +221,26 @@ func TestCreateInstanceError(t *testing.T) {
 	provider.awsCli.SetConfig(config)
 	provider.awsCli.SetClient(mockComputeClient)
 
+	mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+	mockComputeClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{
+				ImageId:    aws.String("ami-12345678"),
+				EnaSupport: aws.Bool(true),
+				State:      types.ImageStateAvailable,
+			},
+		},
+	}, nil)
+	mockComputeClient.On("DescribeInstanceTypes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []types.InstanceTypeInfo{
+			{
+				InstanceType: types.InstanceTypeT2Micro,
+				NetworkInfo: &types.NetworkInfo{
+					EnaSupport: types.EnaSupportRequired,
+				},
+			},
+		},
+	}, nil)
 	mockComputeClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
 		Instances: []types.Instance{
 			{
@@ -183,6 +276,17 @@ func TestDeleteInstanceWithID(t *testing.T) {
 	provider.awsCli.SetConfig(config)
 	provider.awsCli.SetClient(mockComputeClient)
 
+	mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+					},
+				},
+			},
+		},
+	}, nil)
 	mockComputeClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
 		InstanceIds: []string{instanceID},
 	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
@@ -190,6 +294,53 @@ func TestDeleteInstanceWithID(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDeleteInstanceKeepsFailedInstance(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       &client.AwsCli{},
+	}
+	config := &config.Config{
+		Region:   "us-east-1",
+		SubnetID: "subnet-123456",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockComputeClient := new(client.MockComputeClient)
+	provider.awsCli.SetConfig(config)
+	provider.awsCli.SetClient(mockComputeClient)
+
+	mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+						Tags: []types.Tag{
+							{Key: aws.String("garm:bootstrap"), Value: aws.String("failed")},
+							{Key: aws.String("garm:keep-on-failure"), Value: aws.String("4h0m0s")},
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockComputeClient.On("CreateTags", ctx, mock.MatchedBy(func(input *ec2.CreateTagsInput) bool {
+		return len(input.Tags) == 1 && aws.ToString(input.Tags[0].Key) == "garm:gc-after"
+	}), mock.Anything).Return(&ec2.CreateTagsOutput{}, nil)
+
+	err := provider.DeleteInstance(ctx, instanceID)
+	assert.NoError(t, err)
+	mockComputeClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestDeleteInstanceWithName(t *testing.T) {
 	ctx := context.Background()
 	instanceID := "i-1234567890abcdef0"
@@ -218,7 +369,7 @@ func TestDeleteInstanceWithName(t *testing.T) {
 		Filters: []types.Filter{
 			{
 				Name:   aws.String("tag:GARM_CONTROLLER_ID"),
-				Values: []string{""},
+				Values: []string{"controllerID"},
 			},
 			{
 				Name:   aws.String("tag:Name"),
@@ -247,6 +398,119 @@ func TestDeleteInstanceWithName(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDeleteInstanceAlreadyGone(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+
+	tests := []struct {
+		name      string
+		instance  string
+		terminate bool
+	}{
+		{
+			name:      "by ID",
+			instance:  instanceID,
+			terminate: true,
+		},
+		{
+			name:     "by name",
+			instance: "garm-instance",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &AwsProvider{
+				controllerID: "controllerID",
+				awsCli:       &client.AwsCli{},
+			}
+			mockComputeClient := new(client.MockComputeClient)
+			provider.awsCli.SetConfig(&config.Config{
+				Region:   "us-east-1",
+				SubnetID: "subnet-123456",
+			})
+			provider.awsCli.SetClient(mockComputeClient)
+
+			// Lookups skip instances that are shutting down or terminated.
+			mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+			mockComputeClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+				InstanceIds: []string{instanceID},
+			}, mock.Anything).Return((*ec2.TerminateInstancesOutput)(nil), &smithy.GenericAPIError{
+				Code: "InvalidInstanceID.NotFound",
+			})
+
+			err := provider.DeleteInstance(ctx, tt.instance)
+			assert.NoError(t, err)
+			if tt.terminate {
+				mockComputeClient.AssertCalled(t, "TerminateInstances", ctx, mock.Anything, mock.Anything)
+			} else {
+				mockComputeClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDeleteInstanceWithDuplicateNames(t *testing.T) {
+	ctx := context.Background()
+	instanceName := "garm-instance"
+
+	tests := []struct {
+		name                string
+		terminateDuplicates bool
+		errString           string
+	}{
+		{
+			name:      "refused",
+			errString: "failed to determine instance: found more than one instance with name garm-instance: duplicate instance name",
+		},
+		{
+			name:                "terminate duplicates",
+			terminateDuplicates: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &AwsProvider{
+				controllerID: "controllerID",
+				awsCli:       &client.AwsCli{},
+			}
+			mockComputeClient := new(client.MockComputeClient)
+			provider.awsCli.SetConfig(&config.Config{
+				Region:              "us-east-1",
+				SubnetID:            "subnet-123456",
+				TerminateDuplicates: tt.terminateDuplicates,
+			})
+			provider.awsCli.SetClient(mockComputeClient)
+
+			mockComputeClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{
+					{
+						Instances: []types.Instance{
+							{InstanceId: aws.String("i-00000000000000001")},
+							{InstanceId: aws.String("i-00000000000000002")},
+						},
+					},
+				},
+			}, nil)
+			mockComputeClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+			err := provider.DeleteInstance(ctx, instanceName)
+			if tt.errString != "" {
+				assert.EqualError(t, err, tt.errString)
+				mockComputeClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			for _, id := range []string{"i-00000000000000001", "i-00000000000000002"} {
+				mockComputeClient.AssertCalled(t, "TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+					InstanceIds: []string{id},
+				}, mock.Anything)
+			}
+		})
+	}
+}
+
 func TestGetInstanceWithID(t *testing.T) {
 	ctx := context.Background()
 	instanceID := "i-1234567890abcdef0"
@@ -354,7 +618,7 @@ func TestGetInstanceWithName(t *testing.T) {
 		Filters: []types.Filter{
 			{
 				Name:   aws.String("tag:GARM_CONTROLLER_ID"),
-				Values: []string{""},
+				Values: []string{"controllerID"},
 			},
 			{
 				Name:   aws.String("tag:Name"),
@@ -398,6 +662,106 @@ func TestGetInstanceWithName(t *testing.T) {
 	assert.Equal(t, result, expectedOutput)
 }
 
+func TestGetInstanceImpairedRootVolume(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+	instanceName := "garm-instance"
+	expectedOutput := params.ProviderInstance{
+		ProviderID:    instanceID,
+		Name:          instanceName,
+		OSType:        "linux",
+		OSArch:        "amd64",
+		Status:        "error",
+		ProviderFault: []byte("root volume vol-1234567890abcdef0 is impaired (io-enabled: failed)"),
+	}
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       &client.AwsCli{},
+	}
+	config := &config.Config{
+		Region:   "us-east-1",
+		SubnetID: "subnet-123456",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeStatic,
+			StaticCredentials: config.StaticCredentials{
+				AccessKeyID:     "AccessKeyID",
+				SecretAccessKey: "SecretAccessKey",
+				SessionToken:    "SessionToken",
+			},
+		},
+	}
+	mockComputeClient := new(client.MockComputeClient)
+	provider.awsCli.SetConfig(config)
+	provider.awsCli.SetClient(mockComputeClient)
+
+	mockComputeClient.On("DescribeInstances", ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping", "stopped"},
+			},
+		},
+	}, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+						Tags: []types.Tag{
+							{
+								Key:   aws.String("Name"),
+								Value: aws.String(instanceName),
+							},
+							{
+								Key:   aws.String("OSType"),
+								Value: aws.String("linux"),
+							},
+							{
+								Key:   aws.String("OSArch"),
+								Value: aws.String("amd64"),
+							},
+						},
+						State: &types.InstanceState{
+							Name: types.InstanceStateNameRunning,
+						},
+						RootDeviceName: aws.String("/dev/xvda"),
+						BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+							{
+								DeviceName: aws.String("/dev/xvda"),
+								Ebs: &types.EbsInstanceBlockDevice{
+									VolumeId: aws.String("vol-1234567890abcdef0"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+	mockComputeClient.On("DescribeVolumeStatus", ctx, &ec2.DescribeVolumeStatusInput{
+		VolumeIds: []string{"vol-1234567890abcdef0"},
+	}, mock.Anything).Return(&ec2.DescribeVolumeStatusOutput{
+		VolumeStatuses: []types.VolumeStatusItem{
+			{
+				VolumeId: aws.String("vol-1234567890abcdef0"),
+				VolumeStatus: &types.VolumeStatusInfo{
+					Status: types.VolumeStatusInfoStatusImpaired,
+					Details: []types.VolumeStatusDetails{
+						{
+							Name:   types.VolumeStatusNameIoEnabled,
+							Status: aws.String("failed"),
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+	result, err := provider.GetInstance(ctx, instanceID)
+	assert.NoError(t, err)
+	assert.Equal(t, result, expectedOutput)
+}
+
 func TestListInstances(t *testing.T) {
 	ctx := context.Background()
 	poolID := "my-pool"
@@ -637,3 +1001,491 @@ func TestStartStoppingInstance(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "instance "+instanceID+" cannot be started in stopping state", err.Error())
 }
+
+func newEnvironmentCli(region string, mockComputeClient *client.MockComputeClient, environments ...string) *client.AwsCli {
+	awsCli := &client.AwsCli{}
+	conf := &config.Config{
+		Region:   region,
+		SubnetID: "subnet-123456",
+		Credentials: config.Credentials{
+			CredentialType: config.AWSCredentialTypeRole,
+		},
+	}
+	for _, name := range environments {
+		if conf.Environments == nil {
+			conf.Environments = map[string]config.Environment{}
+		}
+		conf.Environments[name] = config.Environment{}
+	}
+	awsCli.SetConfig(conf)
+	awsCli.SetClient(mockComputeClient)
+	return awsCli
+}
+
+func TestDeleteInstanceInEnvironment(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+	defaultClient := new(client.MockComputeClient)
+	euClient := new(client.MockComputeClient)
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       newEnvironmentCli("us-east-1", defaultClient, "eu"),
+		environments: map[string]*client.AwsCli{
+			"eu": newEnvironmentCli("eu-west-1", euClient),
+		},
+	}
+
+	defaultClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+	euClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+					},
+				},
+			},
+		},
+	}, nil)
+	euClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	err := provider.DeleteInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	defaultClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+	euClient.AssertExpectations(t)
+}
+
+func TestDeleteDuplicatesInEnvironment(t *testing.T) {
+	ctx := context.Background()
+	defaultClient := new(client.MockComputeClient)
+	euClient := new(client.MockComputeClient)
+	euCli := newEnvironmentCli("eu-west-1", euClient)
+	euCli.Config().TerminateDuplicates = true
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       newEnvironmentCli("us-east-1", defaultClient, "eu"),
+		environments: map[string]*client.AwsCli{
+			"eu": euCli,
+		},
+	}
+
+	defaultClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+	euClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{InstanceId: aws.String("i-00000000000000001")},
+					{InstanceId: aws.String("i-00000000000000002")},
+				},
+			},
+		},
+	}, nil)
+	euClient.On("TerminateInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+
+	err := provider.DeleteInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	for _, id := range []string{"i-00000000000000001", "i-00000000000000002"} {
+		euClient.AssertCalled(t, "TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []string{id},
+		}, mock.Anything)
+	}
+	defaultClient.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestListInstancesAcrossEnvironments(t *testing.T) {
+	ctx := context.Background()
+	instance := func(id, name string) types.Instance {
+		return types.Instance{
+			InstanceId: aws.String(id),
+			Tags: []types.Tag{
+				{Key: aws.String("Name"), Value: aws.String(name)},
+				{Key: aws.String("OSType"), Value: aws.String("linux")},
+				{Key: aws.String("OSArch"), Value: aws.String("amd64")},
+			},
+			State: &types.InstanceState{
+				Name: types.InstanceStateNameRunning,
+			},
+		}
+	}
+	defaultClient := new(client.MockComputeClient)
+	euClient := new(client.MockComputeClient)
+	// Shares the region and account of the provider config.
+	usClient := new(client.MockComputeClient)
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       newEnvironmentCli("us-east-1", defaultClient, "eu", "us"),
+		environments: map[string]*client.AwsCli{
+			"eu": newEnvironmentCli("eu-west-1", euClient),
+			"us": newEnvironmentCli("us-east-1", usClient),
+		},
+	}
+
+	usInstances := &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{Instances: []types.Instance{instance("i-1234567890abcdef0", "garm-instance")}},
+		},
+	}
+	defaultClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(usInstances, nil)
+	usClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(usInstances, nil)
+	euClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{Instances: []types.Instance{instance("i-1234567890abcdef1", "garm-instance1")}},
+		},
+	}, nil)
+
+	result, err := provider.ListInstances(ctx, "my-pool")
+	assert.NoError(t, err)
+	assert.Equal(t, []params.ProviderInstance{
+		{
+			ProviderID: "i-1234567890abcdef0",
+			Name:       "garm-instance",
+			OSType:     "linux",
+			OSArch:     "amd64",
+			Status:     "running",
+		},
+		{
+			ProviderID: "i-1234567890abcdef1",
+			Name:       "garm-instance1",
+			OSType:     "linux",
+			OSArch:     "amd64",
+			Status:     "running",
+		},
+	}, result)
+}
+
+func TestListInstancesSkipsFailingEnvironment(t *testing.T) {
+	ctx := context.Background()
+	defaultClient := new(client.MockComputeClient)
+	euClient := new(client.MockComputeClient)
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       newEnvironmentCli("us-east-1", defaultClient, "eu"),
+		environments: map[string]*client.AwsCli{
+			"eu": newEnvironmentCli("eu-west-1", euClient),
+		},
+	}
+
+	defaultClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String("i-1234567890abcdef0"),
+						Tags: []types.Tag{
+							{Key: aws.String("Name"), Value: aws.String("garm-instance")},
+							{Key: aws.String("OSType"), Value: aws.String("linux")},
+							{Key: aws.String("OSArch"), Value: aws.String("amd64")},
+						},
+						State: &types.InstanceState{
+							Name: types.InstanceStateNameRunning,
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+	euClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return((*ec2.DescribeInstancesOutput)(nil), fmt.Errorf("access denied"))
+
+	result, err := provider.ListInstances(ctx, "my-pool")
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, "i-1234567890abcdef0", result[0].ProviderID)
+}
+
+func TestListInstancesFailsWhenAllEnvironmentsFail(t *testing.T) {
+	ctx := context.Background()
+	defaultClient := new(client.MockComputeClient)
+	euClient := new(client.MockComputeClient)
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       newEnvironmentCli("us-east-1", defaultClient, "eu"),
+		environments: map[string]*client.AwsCli{
+			"eu": newEnvironmentCli("eu-west-1", euClient),
+		},
+	}
+
+	defaultClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return((*ec2.DescribeInstancesOutput)(nil), fmt.Errorf("throttled"))
+	euClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return((*ec2.DescribeInstancesOutput)(nil), fmt.Errorf("access denied"))
+
+	_, err := provider.ListInstances(ctx, "my-pool")
+	assert.ErrorContains(t, err, "throttled")
+	assert.ErrorContains(t, err, "access denied")
+}
+
+func TestRemoveAllInstancesAcrossEnvironments(t *testing.T) {
+	ctx := context.Background()
+	defaultClient := new(client.MockComputeClient)
+	euClient := new(client.MockComputeClient)
+	provider := &AwsProvider{
+		controllerID: "controllerID",
+		awsCli:       newEnvironmentCli("us-east-1", defaultClient, "eu"),
+		environments: map[string]*client.AwsCli{
+			"eu": newEnvironmentCli("eu-west-1", euClient),
+		},
+	}
+
+	for id, mockClient := range map[string]*client.MockComputeClient{
+		"i-1234567890abcdef0": defaultClient,
+		"i-1234567890abcdef1": euClient,
+	} {
+		mockClient.On("DescribeInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+			Reservations: []types.Reservation{
+				{Instances: []types.Instance{{InstanceId: aws.String(id)}}},
+			},
+		}, nil)
+		mockClient.On("TerminateInstances", ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []string{id},
+		}, mock.Anything).Return(&ec2.TerminateInstancesOutput{}, nil)
+	}
+
+	err := provider.RemoveAllInstances(ctx)
+	assert.NoError(t, err)
+	defaultClient.AssertExpectations(t)
+	euClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceWaitForRunning(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "i-1234567890abcdef0"
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           aws.String("linux"),
+			Architecture: aws.String("amd64"),
+			DownloadURL:  aws.String("MockURL"),
+			Filename:     aws.String("garm-runner"),
+		}, nil
+	}
+	bootstrapParams := params.BootstrapInstance{
+		Name:       "garm-instance",
+		Flavor:     "t2.micro",
+		Image:      "ami-12345678",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		PoolID:     "my-pool",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+	isWaiter := mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) > 0
+	})
+	isLookup := mock.MatchedBy(func(input *ec2.DescribeInstancesInput) bool {
+		return len(input.InstanceIds) == 0
+	})
+
+	tests := []struct {
+		name      string
+		state     types.InstanceStateName
+		interrupt bool
+		status    params.InstanceStatus
+		fault     string
+		errString string
+	}{
+		{
+			name:   "running",
+			state:  types.InstanceStateNameRunning,
+			status: params.InstanceRunning,
+		},
+		{
+			name:      "still pending",
+			state:     types.InstanceStateNamePending,
+			errString: "failed to create instance: failed waiting for instance i-1234567890abcdef0 to run",
+		},
+		{
+			name:      "interrupted",
+			state:     types.InstanceStateNamePending,
+			interrupt: true,
+			status:    params.InstanceCreating,
+			fault:     "retryable: create interrupted: stopped waiting for instance i-1234567890abcdef0 to run: wait interrupted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Cancelled like main cancels it on SIGTERM.
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			provider := &AwsProvider{
+				controllerID: "controllerID",
+				awsCli:       &client.AwsCli{},
+			}
+			mockComputeClient := new(client.MockComputeClient)
+			provider.awsCli.SetConfig(&config.Config{
+				Region:         "us-east-1",
+				SubnetID:       "subnet-123456",
+				WaitForRunning: "1s",
+			})
+			provider.awsCli.SetClient(mockComputeClient)
+
+			mockComputeClient.On("DescribeInstances", ctx, isLookup, mock.Anything).Return(&ec2.DescribeInstancesOutput{}, nil)
+			mockComputeClient.On("DescribeInstances", mock.Anything, isWaiter, mock.Anything).Return(&ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{
+					{
+						Instances: []types.Instance{
+							{
+								InstanceId: aws.String(instanceID),
+								State:      &types.InstanceState{Name: tt.state},
+								Tags: []types.Tag{
+									{Key: aws.String("Name"), Value: aws.String("garm-instance")},
+								},
+							},
+						},
+					},
+				},
+			}, nil).Run(func(mock.Arguments) {
+				if tt.interrupt {
+					cancel()
+				}
+			})
+			mockComputeClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+				Images: []types.Image{
+					{
+						ImageId:    aws.String("ami-12345678"),
+						EnaSupport: aws.Bool(true),
+						State:      types.ImageStateAvailable,
+					},
+				},
+			}, nil)
+			mockComputeClient.On("DescribeInstanceTypes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+				InstanceTypes: []types.InstanceTypeInfo{
+					{
+						InstanceType: types.InstanceTypeT2Micro,
+					},
+				},
+			}, nil)
+			mockComputeClient.On("RunInstances", ctx, mock.Anything, mock.Anything).Return(&ec2.RunInstancesOutput{
+				Instances: []types.Instance{
+					{
+						InstanceId: aws.String(instanceID),
+					},
+				},
+			}, nil)
+
+			result, err := provider.CreateInstance(ctx, bootstrapParams)
+			if tt.errString != "" {
+				assert.ErrorContains(t, err, tt.errString)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, instanceID, result.ProviderID)
+			assert.Equal(t, "garm-instance", result.Name)
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.fault, string(result.ProviderFault))
+		})
+	}
+}
+
+func TestValidatePoolInfo(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		image      string
+		flavor     string
+		extraSpecs string
+		imageErr   error
+		errString  string
+	}{
+		{
+			name: "no image",
+		},
+		{
+			name:       "valid",
+			image:      "ami-12345678",
+			flavor:     "t2.micro",
+			extraSpecs: `{"disable_updates": true}`,
+		},
+		{
+			name:       "invalid extra specs",
+			extraSpecs: `{"disable_updates": "yes"}`,
+			errString:  "error loading extra specs: failed to validate extra specs: schema validation failed: [disable_updates: Invalid type. Expected: boolean, given: string]",
+		},
+		{
+			name:       "unknown environment",
+			extraSpecs: `{"environment": "eu"}`,
+			errString:  `unknown environment "eu"`,
+		},
+		{
+			name:      "flavor not offered",
+			flavor:    "p5.48xlarge",
+			errString: "instance type p5.48xlarge is not offered in the availability zones of the subnets of the pool (us-east-1a)",
+		},
+		{
+			name:      "missing image",
+			image:     "ami-12345678",
+			imageErr:  &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound"},
+			errString: "image ami-12345678 does not exist in region us-east-1, or is not shared with this account (images of other regions need to be copied with their own ID): image not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &AwsProvider{
+				controllerID: "controllerID",
+				awsCli:       &client.AwsCli{},
+			}
+			mockComputeClient := new(client.MockComputeClient)
+			provider.awsCli.SetConfig(&config.Config{
+				Region:   "us-east-1",
+				SubnetID: "subnet-123456",
+			})
+			provider.awsCli.SetClient(mockComputeClient)
+
+			mockComputeClient.On("DescribeImages", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeImagesOutput{
+				Images: []types.Image{
+					{
+						ImageId:    aws.String("ami-12345678"),
+						EnaSupport: aws.Bool(true),
+						State:      types.ImageStateAvailable,
+					},
+				},
+			}, tt.imageErr)
+			mockComputeClient.On("DescribeInstanceTypes", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypesOutput{
+				InstanceTypes: []types.InstanceTypeInfo{
+					{
+						InstanceType: types.InstanceTypeT2Micro,
+					},
+				},
+			}, nil)
+			mockComputeClient.On("DescribeSubnets", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeSubnetsOutput{
+				Subnets: []types.Subnet{
+					{
+						SubnetId:           aws.String("subnet-123456"),
+						AvailabilityZone:   aws.String("us-east-1a"),
+						AvailabilityZoneId: aws.String("use1-az1"),
+					},
+				},
+			}, nil)
+			mockComputeClient.On("DescribeInstanceTypeOfferings", ctx, mock.Anything, mock.Anything).Return(&ec2.DescribeInstanceTypeOfferingsOutput{
+				InstanceTypeOfferings: []types.InstanceTypeOffering{
+					{
+						InstanceType: types.InstanceTypeT2Micro,
+						Location:     aws.String("use1-az1"),
+					},
+				},
+			}, nil)
+
+			err := provider.ValidatePoolInfo(ctx, tt.image, tt.flavor, "", tt.extraSpecs)
+			if tt.errString != "" {
+				assert.EqualError(t, err, tt.errString)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetSchemas(t *testing.T) {
+	provider := &AwsProvider{}
+
+	configSchema, err := provider.GetConfigJSONSchema(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, json.Valid([]byte(configSchema)))
+	assert.Contains(t, configSchema, `"subnet_id"`)
+
+	extraSpecsSchema, err := provider.GetExtraSpecsJSONSchema(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, json.Valid([]byte(extraSpecsSchema)))
+	assert.Contains(t, extraSpecsSchema, `"disable_updates"`)
+
+	assert.Equal(t, []string{"v0.1.0", "v0.1.1"}, provider.GetSupportedInterfaceVersions(context.Background()))
+}
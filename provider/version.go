@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/execution/common"
+)
+
+// Version, Commit and BuildDate are set at build time, with -ldflags. The
+// commit is read from the build info if it isn't set.
+var (
+	Version   = "v0.0.0-unknown"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// awsSDKModule is the module the version of the AWS SDK is read from.
+const awsSDKModule = "github.com/aws/aws-sdk-go-v2"
+
+// supportedInterfaceVersions are the versions of the GARM external provider
+// interface the provider implements.
+var supportedInterfaceVersions = []string{common.Version010, common.Version011}
+
+// VersionInfo describes the build of the provider.
+type VersionInfo struct {
+	Version           string   `json:"version"`
+	Commit            string   `json:"commit,omitempty"`
+	BuildDate         string   `json:"build_date,omitempty"`
+	GoVersion         string   `json:"go_version"`
+	AWSSDKVersion     string   `json:"aws_sdk_version,omitempty"`
+	InterfaceVersions []string `json:"interface_versions"`
+}
+
+// GetVersionInfo returns the version information of the running binary.
+func GetVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:           Version,
+		Commit:            Commit,
+		BuildDate:         BuildDate,
+		GoVersion:         runtime.Version(),
+		InterfaceVersions: supportedInterfaceVersions,
+	}
+
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range buildInfo.Settings {
+		if setting.Key == "vcs.revision" && info.Commit == "" {
+			info.Commit = setting.Value
+		}
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path == awsSDKModule {
+			info.AWSSDKVersion = dep.Version
+		}
+	}
+	return info
+}
+
+// String returns the version, followed by the details of the build, if
+// known.
+func (v VersionInfo) String() string {
+	var details []string
+	if v.Commit != "" {
+		details = append(details, "commit "+v.Commit[:min(len(v.Commit), 12)])
+	}
+	if v.BuildDate != "" {
+		details = append(details, "built "+v.BuildDate)
+	}
+	if v.AWSSDKVersion != "" {
+		details = append(details, "aws-sdk-go-v2 "+v.AWSSDKVersion)
+	}
+	if len(details) == 0 {
+		return v.Version
+	}
+	return fmt.Sprintf("%s (%s)", v.Version, strings.Join(details, ", "))
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionInfoString(t *testing.T) {
+	tests := []struct {
+		name string
+		info VersionInfo
+		want string
+	}{
+		{
+			name: "version only",
+			info: VersionInfo{Version: "v0.1.0"},
+			want: "v0.1.0",
+		},
+		{
+			name: "full",
+			info: VersionInfo{
+				Version:       "v0.1.0",
+				Commit:        "0123456789abcdef0123456789abcdef01234567",
+				BuildDate:     "2024-05-01T12:00:00Z",
+				AWSSDKVersion: "v1.30.0",
+			},
+			want: "v0.1.0 (commit 0123456789ab, built 2024-05-01T12:00:00Z, aws-sdk-go-v2 v1.30.0)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.info.String())
+		})
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	defer func(version, commit, buildDate string) {
+		Version, Commit, BuildDate = version, commit, buildDate
+	}(Version, Commit, BuildDate)
+	Version = "v0.1.0"
+	Commit = "0123456789abcdef"
+	BuildDate = "2024-05-01T12:00:00Z"
+
+	info := GetVersionInfo()
+	require.Equal(t, "v0.1.0", info.Version)
+	require.Equal(t, "0123456789abcdef", info.Commit)
+	require.Equal(t, supportedInterfaceVersions, info.InterfaceVersions)
+	require.NotEmpty(t, info.GoVersion)
+
+	provider := &AwsProvider{}
+	require.Equal(t, info.String(), provider.GetVersion(context.Background()))
+}
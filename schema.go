@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/spec"
+)
+
+// runSchema writes the JSON schema of the extra specs of pools, or of the
+// provider config, to stdout.
+func runSchema(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	kind := flags.String("type", "extra-specs", "the schema to write, either extra-specs or config")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var schema string
+	var err error
+	switch *kind {
+	case "extra-specs":
+		schema, err = spec.ExtraSpecsJSONSchema()
+	case "config":
+		schema, err = config.JSONSchema()
+	default:
+		return fmt.Errorf("unknown schema type: %s", *kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(schema)
+	return nil
+}
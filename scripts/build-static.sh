@@ -14,6 +14,9 @@ cd $GARM_SOURCE
 
 OUTPUT_DIR="/build/output"
 VERSION=$(git describe --tags --match='v[0-9]*' --dirty --always)
+COMMIT=$(git rev-parse HEAD)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+VERSION_FLAGS="-X github.com/cloudbase/garm-provider-aws/provider.Version=$VERSION -X github.com/cloudbase/garm-provider-aws/provider.Commit=$COMMIT -X github.com/cloudbase/garm-provider-aws/provider.BuildDate=$BUILD_DATE"
 BUILD_DIR="$OUTPUT_DIR/$VERSION"
 
 
@@ -31,18 +34,18 @@ cd $GARM_SOURCE
 GOOS=linux GOARCH=amd64 go build -mod vendor \
     -o $BUILD_DIR/linux/amd64/$GARM_PROVIDER_NAME \
     -tags osusergo,netgo,sqlite_omit_load_extension \
-    -ldflags "-extldflags '-static' -s -w -X github.com/cloudbase/garm-provider-aws/provider.Version=$VERSION" .
+    -ldflags "-extldflags '-static' -s -w $VERSION_FLAGS" .
 GOOS=linux GOARCH=arm64 CC=aarch64-linux-musl-gcc go build \
     -mod vendor \
     -o $BUILD_DIR/linux/arm64/$GARM_PROVIDER_NAME \
     -tags osusergo,netgo,sqlite_omit_load_extension \
-    -ldflags "-extldflags '-static' -s -w -X github.com/cloudbase/garm-provider-aws/provider.Version=$VERSION" .
+    -ldflags "-extldflags '-static' -s -w $VERSION_FLAGS" .
 
 # Windows
 GOOS=windows GOARCH=amd64 CC=x86_64-w64-mingw32-cc go build -mod vendor \
     -o $BUILD_DIR/windows/amd64/$GARM_PROVIDER_NAME.exe \
     -tags osusergo,netgo,sqlite_omit_load_extension \
-    -ldflags "-s -w -X github.com/cloudbase/garm-provider-aws/provider.Version=$VERSION" .
+    -ldflags "-s -w $VERSION_FLAGS" .
 
 git checkout $CURRENT_BRANCH || true
 chown $USER_ID:$USER_GROUP -R "$OUTPUT_DIR"
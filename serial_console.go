@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+)
+
+// serialConsoleTarget identifies the serial console of an instance.
+type serialConsoleTarget struct {
+	InstanceID string `json:"instance_id"`
+	SerialPort int    `json:"serial_port"`
+	Host       string `json:"host"`
+	User       string `json:"user"`
+}
+
+// runSerialConsole connects to the serial console of a runner over SSH. The
+// public key is pushed with the AWS CLI, which is given the credentials of
+// the provider config.
+func runSerialConsole(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("serial-console", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	controllerID := flags.String("controller-id", "", "the ID of the GARM controller, used to look up runners by name")
+	identity := flags.String("identity", "", "path to the private key to connect with; the public key is read from the same path with a .pub suffix")
+	port := flags.Int("port", 0, "the serial port to connect to")
+	printOnly := flags.Bool("print", false, "write the serial console identifiers of the instance as JSON, instead of connecting")
+	awsCLI := flags.String("aws-cli", "aws", "path to the AWS CLI, used to push the public key")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s serial-console [flags] <instance ID or name>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("missing instance")
+	}
+	if *identity == "" && !*printOnly {
+		return fmt.Errorf("missing -identity")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	awsCli, err := client.NewAwsCli(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS CLI: %w", err)
+	}
+
+	var instance types.Instance
+	if name := flags.Arg(0); strings.HasPrefix(name, "i-") {
+		instance, err = awsCli.GetInstance(ctx, name)
+	} else {
+		instance, err = awsCli.FindOneInstance(ctx, *controllerID, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find instance: %w", err)
+	}
+
+	instanceID := aws.ToString(instance.InstanceId)
+	target := serialConsoleTarget{
+		InstanceID: instanceID,
+		SerialPort: *port,
+		Host:       client.SerialConsoleHost(conf.Region),
+		User:       client.SerialConsoleUser(instanceID, *port),
+	}
+	if *printOnly {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(target); err != nil {
+			return fmt.Errorf("failed to write serial console identifiers: %w", err)
+		}
+		return nil
+	}
+
+	awsCfg, err := conf.GetAWSConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS config: %w", err)
+	}
+	creds, err := awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	env := append(os.Environ(),
+		"AWS_REGION="+conf.Region,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+	)
+	if creds.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+creds.SessionToken)
+	}
+
+	// The key is accepted for 60 seconds.
+	push := exec.CommandContext(ctx, *awsCLI, "ec2-instance-connect", "send-serial-console-ssh-public-key",
+		"--instance-id", instanceID,
+		"--serial-port", fmt.Sprint(*port),
+		"--ssh-public-key", "file://"+*identity+".pub")
+	push.Stderr = os.Stderr
+	push.Env = env
+	if err := push.Run(); err != nil {
+		return fmt.Errorf("failed to push public key: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "ssh", "-i", *identity, target.User+"@"+target.Host)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
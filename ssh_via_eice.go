@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//	Licensed under the Apache License, Version 2.0 (the "License"); you may
+//	not use this file except in compliance with the License. You may obtain
+//	a copy of the License at
+//
+//	     http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//	WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//	License for the specific language governing permissions and limitations
+//	under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudbase/garm-provider-aws/config"
+	"github.com/cloudbase/garm-provider-aws/internal/client"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+)
+
+// runSSHViaEICE opens an SSH session to a runner through an EC2 Instance
+// Connect Endpoint in its VPC. The tunnel is opened by the AWS CLI, which
+// is given the credentials of the provider config.
+func runSSHViaEICE(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ssh-via-eice", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the provider config file")
+	controllerID := flags.String("controller-id", "", "the ID of the GARM controller, used to look up runners by name")
+	user := flags.String("user", "", "the user to log in as")
+	createEndpoint := flags.Bool("create-endpoint", false, "create an instance connect endpoint in the subnet of the runner if its VPC has none")
+	awsCLI := flags.String("aws-cli", "aws", "path to the AWS CLI, used to open the tunnel")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s ssh-via-eice [flags] <instance ID or name> [ssh arguments]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath == "" {
+		return fmt.Errorf("missing -config")
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("missing instance")
+	}
+
+	conf, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	awsCli, err := client.NewAwsCli(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS CLI: %w", err)
+	}
+
+	var instance types.Instance
+	if name := flags.Arg(0); strings.HasPrefix(name, "i-") {
+		instance, err = awsCli.GetInstance(ctx, name)
+	} else {
+		instance, err = awsCli.FindOneInstance(ctx, *controllerID, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find instance: %w", err)
+	}
+
+	vpcID := aws.ToString(instance.VpcId)
+	endpoint, err := awsCli.FindInstanceConnectEndpoint(ctx, vpcID)
+	if err != nil {
+		if !errors.Is(err, garmErrors.ErrNotFound) || !*createEndpoint {
+			return err
+		}
+		endpoint, err = awsCli.CreateInstanceConnectEndpoint(ctx, aws.ToString(instance.SubnetId), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	awsCfg, err := conf.GetAWSConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS config: %w", err)
+	}
+	creds, err := awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+
+	instanceID := aws.ToString(instance.InstanceId)
+	proxyCommand := fmt.Sprintf("%s ec2-instance-connect open-tunnel --instance-id %s --instance-connect-endpoint-id %s",
+		*awsCLI, instanceID, aws.ToString(endpoint.InstanceConnectEndpointId))
+	sshArgs := []string{"-o", "ProxyCommand=" + proxyCommand}
+	if *user != "" {
+		sshArgs = append(sshArgs, "-l", *user)
+	}
+	// The instance ID is only used as the host name ssh records the host
+	// key under, as the tunnel decides where to connect.
+	sshArgs = append(sshArgs, instanceID)
+	sshArgs = append(sshArgs, flags.Args()[1:]...)
+
+	cmd := exec.CommandContext(ctx, "ssh", sshArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"AWS_REGION="+conf.Region,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+	)
+	if creds.SessionToken != "" {
+		cmd.Env = append(cmd.Env, "AWS_SESSION_TOKEN="+creds.SessionToken)
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-aws/provider"
+)
+
+// runVersion writes the version of the provider, and the details of its
+// build, to stdout.
+func runVersion(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "write the version information as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	info := provider.GetVersionInfo()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(info); err != nil {
+			return fmt.Errorf("failed to write version: %w", err)
+		}
+		return nil
+	}
+
+	fmt.Printf("Version:            %s\n", info.Version)
+	fmt.Printf("Commit:             %s\n", valueOrUnknown(info.Commit))
+	fmt.Printf("Build date:         %s\n", valueOrUnknown(info.BuildDate))
+	fmt.Printf("Go version:         %s\n", info.GoVersion)
+	fmt.Printf("AWS SDK version:    %s\n", valueOrUnknown(info.AWSSDKVersion))
+	fmt.Printf("Interface versions: %s\n", strings.Join(info.InterfaceVersions, ", "))
+	return nil
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}